@@ -0,0 +1,210 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// activeRecordKeyIterations is ActiveSupport::KeyGenerator's default
+	// iteration count, used by ActiveRecord encryption to derive its keys.
+	activeRecordKeyIterations = 1 << 16
+	// activeRecordCompressionThreshold is the length over which Rails
+	// compresses the values before encrypting them.
+	activeRecordCompressionThreshold = 140
+)
+
+// ActiveRecordEncryptionConfig mirrors the config.active_record.encryption
+// settings (usually the active_record_encryption credentials) of a Rails 7
+// app using ActiveRecord encryption (`encrypts :email`).
+type ActiveRecordEncryptionConfig struct {
+	// PrimaryKeys are the primary_key values, several of them when keys are
+	// rotated: the last one encrypts and all of them are tried to decrypt.
+	PrimaryKeys []string
+	// DeterministicKey is the deterministic_key used by the attributes
+	// encrypted with `deterministic: true`.
+	DeterministicKey string
+	// KeyDerivationSalt is the key_derivation_salt.
+	KeyDerivationSalt string
+	// HashDigest is the hash used to derive the keys (hash_digest_class),
+	// SHA256 (the Rails 7.1 default) when nil. Apps using the Rails 7.0
+	// defaults derive their keys with sha1.New.
+	HashDigest func() hash.Hash
+}
+
+// Encryptor returns an encryptor for the attributes encrypted with random
+// IVs, Rails' default.
+func (c ActiveRecordEncryptionConfig) Encryptor() (*ActiveRecordEncryptor, error) {
+	if c.KeyDerivationSalt == "" {
+		return nil, configError("empty key derivation salt")
+	}
+	if len(c.PrimaryKeys) == 0 {
+		return nil, configError("no primary key")
+	}
+	e := &ActiveRecordEncryptor{}
+	for _, k := range c.PrimaryKeys {
+		if k == "" {
+			return nil, configError("empty primary key")
+		}
+		e.keys = append(e.keys, c.deriveKey(k))
+	}
+	return e, nil
+}
+
+// DeterministicEncryptor returns an encryptor for the attributes encrypted
+// with `deterministic: true`, which always encrypt a value the same way so
+// they can be queried.
+func (c ActiveRecordEncryptionConfig) DeterministicEncryptor() (*ActiveRecordEncryptor, error) {
+	if c.KeyDerivationSalt == "" {
+		return nil, configError("empty key derivation salt")
+	}
+	if c.DeterministicKey == "" {
+		return nil, configError("empty deterministic key")
+	}
+	return &ActiveRecordEncryptor{keys: [][]byte{c.deriveKey(c.DeterministicKey)}, deterministic: true}, nil
+}
+
+// deriveKey derives a key from a password like ActiveRecord's
+// DerivedSecretKeyProvider.
+func (c ActiveRecordEncryptionConfig) deriveKey(password string) []byte {
+	hasher := c.HashDigest
+	if hasher == nil {
+		hasher = sha256.New
+	}
+	return pbkdf2.Key([]byte(password), []byte(c.KeyDerivationSalt), activeRecordKeyIterations, 32, hasher)
+}
+
+// ActiveRecordEncryptor encrypts and decrypts the values of the attributes
+// encrypted by ActiveRecord encryption, as they are stored in the database:
+// a JSON envelope holding the AES-256-GCM encrypted value and its headers
+// (ie: `{"p":"...","h":{"iv":"...","at":"..."}}`).
+//
+// Use ActiveRecordEncryptionConfig to get one, it can be shared between
+// goroutines. Values encrypted with envelope encryption (a "k" header) or
+// a custom key provider aren't supported.
+type ActiveRecordEncryptor struct {
+	// MaxInflatedLen is the length over which compressed values are
+	// rejected with ErrMessageTooLarge once inflated. It defaults to
+	// DefaultMaxInflatedLen, a negative value disables the limit.
+	MaxInflatedLen int
+	// keys are tried in order to decrypt, the last one encrypts.
+	keys          [][]byte
+	deterministic bool
+}
+
+type activeRecordMessage struct {
+	Payload []byte              `json:"p"`
+	Headers activeRecordHeaders `json:"h"`
+}
+
+type activeRecordHeaders struct {
+	IV         []byte `json:"iv"`
+	AuthTag    []byte `json:"at"`
+	Compressed bool   `json:"c,omitempty"`
+	// EncryptedDataKey is set by envelope encryption.
+	EncryptedDataKey json.RawMessage `json:"k,omitempty"`
+}
+
+// Encrypt encrypts a value like Rails does before storing it: values over
+// 140 bytes are compressed and deterministic encryptors derive the IV from
+// the value. Note that Go's zlib doesn't compress exactly like Ruby's so
+// compressed deterministic values don't match the ones Rails would store,
+// they can still be decrypted by both.
+func (e *ActiveRecordEncryptor) Encrypt(clearText string) (string, error) {
+	data := []byte(clearText)
+	compressed := false
+	if len(data) > activeRecordCompressionThreshold {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return "", err
+		}
+		if err := zw.Close(); err != nil {
+			return "", err
+		}
+		data, compressed = buf.Bytes(), true
+	}
+
+	key := e.keys[len(e.keys)-1]
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if e.deterministic {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+		copy(iv, mac.Sum(nil))
+	} else if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, data, nil)
+	tagStart := len(sealed) - gcm.Overhead()
+
+	out, err := json.Marshal(activeRecordMessage{
+		Payload: sealed[:tagStart],
+		Headers: activeRecordHeaders{IV: iv, AuthTag: sealed[tagStart:], Compressed: compressed},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Decrypt decrypts a value stored by Rails (or Encrypt). Values which can't
+// be decrypted, including values which aren't encrypted, fail with
+// ErrInvalidMessage.
+func (e *ActiveRecordEncryptor) Decrypt(value string) (string, error) {
+	var msg activeRecordMessage
+	if err := json.Unmarshal([]byte(value), &msg); err != nil {
+		return "", ErrInvalidMessage
+	}
+	if msg.Headers.EncryptedDataKey != nil {
+		return "", &messageError{msg: "Invalid message - envelope encryption isn't supported", kind: ErrInvalidMessage}
+	}
+	// Rails rejects truncated auth tags, which would be easier to forge.
+	if len(msg.Headers.IV) != 12 || len(msg.Headers.AuthTag) != 16 {
+		return "", ErrInvalidMessage
+	}
+	sealed := append(msg.Payload, msg.Headers.AuthTag...)
+	for _, key := range e.keys {
+		gcm, err := newGCM(key)
+		if err != nil {
+			return "", err
+		}
+		data, err := gcm.Open(nil, msg.Headers.IV, sealed, nil)
+		if err != nil {
+			continue
+		}
+		if msg.Headers.Compressed {
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return "", ErrInvalidMessage
+			}
+			data, err = readAllLimited(zr, e.MaxInflatedLen)
+			if err != nil {
+				return "", err
+			}
+		}
+		return string(data), nil
+	}
+	return "", ErrInvalidMessage
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,181 @@
+package crypto
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestActiveRecordEncryption(t *testing.T) {
+	g := Goblin(t)
+
+	// The keys are the examples of the Rails guides. The fixtures weren't
+	// produced by a Rails app: they were computed following ActiveRecord's
+	// algorithm with Python's hashlib/zlib and an AES-GCM built on the
+	// openssl command line tool, independently from this package.
+	config := ActiveRecordEncryptionConfig{
+		PrimaryKeys:       []string{"EGY8WhulUOXixybod7ZWwMIL68R9o5kC"},
+		DeterministicKey:  "aPA5XyALhf75NNnMzaspW7akTfZp0lPY",
+		KeyDerivationSalt: "xEY0dt6TZcAMg52K7O84wYzkjvbA62Hz",
+	}
+	sha1Config := config
+	sha1Config.HashDigest = sha1.New
+
+	fixtures := []struct {
+		config                            ActiveRecordEncryptionConfig
+		primaryKey, deterministicKey      string
+		random, deterministic, compressed string
+		deterministicEmpty                string
+	}{
+		{
+			config:             config,
+			primaryKey:         "fa93ae5ad24a1b6ca6552023279f39c23ee2099e98be06a905f8e58567452195",
+			deterministicKey:   "8d1e24fd9cabc56e65c959daa26b360249c4bdd3579eb9efbaac28a6242a6655",
+			random:             `{"p":"5ulphwpomlRuw9y3KLRbem0=","h":{"iv":"AQIDBAUGBwgJCgsM","at":"k31SKXybxqGTfOPWY7XmMQ=="}}`,
+			deterministic:      `{"p":"PZnB7lOLdyv0X6UK0520G4U=","h":{"iv":"QH6xqpusCo7O/t7a","at":"NevZpbppelmu8a364oesCA=="}}`,
+			deterministicEmpty: `{"p":"","h":{"iv":"27+p/gLgWmOYyypV","at":"hyEg9kQUFiBsm4adEjlokw=="}}`,
+			compressed:         `{"p":"+JMAHcCs/zjFzXTJhyZWfYAvPvrJQBIGhKnU1hEyl/EbQUTw3h5N","h":{"iv":"AAECAwQFBgcICQoL","at":"Y8bQOGlnkJSd/PnjGv1w3g==","c":true}}`,
+		},
+		{
+			config:             sha1Config,
+			primaryKey:         "423c09a30911506cc97ad874f6d332ba99548f11c069123707c2f941d86f9320",
+			deterministicKey:   "e06b09108bfa2c304ed8c506c91b1378271565683b7c639e2982c4020a56c2ef",
+			random:             `{"p":"xtPsxiggulbuIrCCaSQXeN0=","h":{"iv":"AQIDBAUGBwgJCgsM","at":"lHkVyMDjRhXOImrfaQAqBQ=="}}`,
+			deterministic:      `{"p":"QvWCz5S7QnvDx2j8QPCYcNs=","h":{"iv":"AD1uA6LfbvwiJZ+G","at":"JZdy02qDP3sNj4fWZQcDpw=="}}`,
+			deterministicEmpty: `{"p":"","h":{"iv":"ETImKFZd7E7lBQtY","at":"G61pgOo6VGz8KIoXL9IYMg=="}}`,
+			compressed:         `{"p":"nvaN7h+rXNzyqS/c06stWrBlh1SLZbky/9fnO1aeKt9o8eHj6yZu","h":{"iv":"AAECAwQFBgcICQoL","at":"znRLcCx5kIomG4Vgfp1FRA==","c":true}}`,
+		},
+	}
+	lorem := strings.Repeat("Lorem ipsum dolor sit amet, ", 8)
+
+	g.Describe("ActiveRecordEncryptionConfig", func() {
+		g.It("derives the keys like ActiveRecord", func() {
+			for _, f := range fixtures {
+				e, err := f.config.Encryptor()
+				g.Assert(err).Eql(nil)
+				g.Assert(hex.EncodeToString(e.keys[0])).Eql(f.primaryKey)
+				d, err := f.config.DeterministicEncryptor()
+				g.Assert(err).Eql(nil)
+				g.Assert(hex.EncodeToString(d.keys[0])).Eql(f.deterministicKey)
+			}
+		})
+
+		g.It("needs keys and a salt", func() {
+			for _, c := range []ActiveRecordEncryptionConfig{
+				{PrimaryKeys: config.PrimaryKeys},
+				{KeyDerivationSalt: config.KeyDerivationSalt},
+				{PrimaryKeys: []string{""}, KeyDerivationSalt: config.KeyDerivationSalt},
+			} {
+				_, err := c.Encryptor()
+				g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			}
+			_, err := ActiveRecordEncryptionConfig{KeyDerivationSalt: config.KeyDerivationSalt}.DeterministicEncryptor()
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+
+	g.Describe("ActiveRecordEncryptor", func() {
+		g.It("decrypts values encrypted with random IVs", func() {
+			for _, f := range fixtures {
+				e, _ := f.config.Encryptor()
+				clear, err := e.Decrypt(f.random)
+				g.Assert(err).Eql(nil)
+				g.Assert(clear).Eql("jorge@example.com")
+			}
+		})
+
+		g.It("encrypts deterministic values like ActiveRecord", func() {
+			for _, f := range fixtures {
+				d, _ := f.config.DeterministicEncryptor()
+				for clear, expected := range map[string]string{"jorge@example.com": f.deterministic, "": f.deterministicEmpty} {
+					value, err := d.Encrypt(clear)
+					g.Assert(err).Eql(nil)
+					g.Assert(value).Eql(expected)
+					out, err := d.Decrypt(value)
+					g.Assert(err).Eql(nil)
+					g.Assert(out).Eql(clear)
+				}
+			}
+		})
+
+		g.It("uses random IVs by default", func() {
+			e, _ := config.Encryptor()
+			v1, _ := e.Encrypt("jorge@example.com")
+			v2, _ := e.Encrypt("jorge@example.com")
+			g.Assert(v1 == v2).IsFalse()
+			for _, v := range []string{v1, v2} {
+				clear, err := e.Decrypt(v)
+				g.Assert(err).Eql(nil)
+				g.Assert(clear).Eql("jorge@example.com")
+			}
+		})
+
+		g.It("handles compressed values", func() {
+			for _, f := range fixtures {
+				e, _ := f.config.Encryptor()
+				clear, err := e.Decrypt(f.compressed)
+				g.Assert(err).Eql(nil)
+				g.Assert(clear).Eql(lorem)
+
+				value, _ := e.Encrypt(lorem)
+				g.Assert(strings.Contains(value, `"c":true`)).IsTrue()
+				g.Assert(len(value) < len(f.compressed)+16).IsTrue()
+				clear, err = e.Decrypt(value)
+				g.Assert(err).Eql(nil)
+				g.Assert(clear).Eql(lorem)
+				short, _ := e.Encrypt(lorem[:140])
+				g.Assert(strings.Contains(short, `"c"`)).IsFalse()
+			}
+		})
+
+		g.It("limits the inflated length", func() {
+			e, _ := config.Encryptor()
+			value, _ := e.Encrypt(strings.Repeat("a", 2<<20))
+			_, err := e.Decrypt(value)
+			g.Assert(errors.Is(err, ErrMessageTooLarge)).IsTrue()
+			e.MaxInflatedLen = -1
+			clear, err := e.Decrypt(value)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(clear)).Eql(2 << 20)
+		})
+
+		g.It("encrypts with the last primary key and decrypts with all of them", func() {
+			old, _ := config.Encryptor()
+			oldValue, _ := old.Encrypt("jorge@example.com")
+			rotated := config
+			rotated.PrimaryKeys = append([]string{}, config.PrimaryKeys...)
+			rotated.PrimaryKeys = append(rotated.PrimaryKeys, "4B0mFR8hXAs4AqZf8hRh8EvkYiFRDgpR")
+			e, _ := rotated.Encryptor()
+			clear, err := e.Decrypt(oldValue)
+			g.Assert(err).Eql(nil)
+			g.Assert(clear).Eql("jorge@example.com")
+			value, _ := e.Encrypt("jorge@example.com")
+			_, err = old.Decrypt(value)
+			g.Assert(err).Eql(ErrInvalidMessage)
+		})
+
+		g.It("rejects tampered and unencrypted values", func() {
+			e, _ := config.Encryptor()
+			f := fixtures[0]
+			for _, bad := range []string{
+				"",
+				"jorge@example.com",
+				`{"p":"5ulphwpomlRuw9y3KLRbem0="}`,
+				strings.Replace(f.random, "5ulp", "5ulq", 1),
+				strings.Replace(f.random, "k31SKXybxqGTfOPWY7XmMQ==", "k31SKXybxqGTfOPW", 1),
+				strings.Replace(f.random, "AQIDBAUGBwgJCgsM", "AQIDBAUGBwgJCgsN", 1),
+				f.deterministic,
+			} {
+				_, err := e.Decrypt(bad)
+				g.Assert(err).Eql(ErrInvalidMessage)
+			}
+			_, err := e.Decrypt(`{"p":"","h":{"iv":"AQIDBAUGBwgJCgsM","at":"k31SKXybxqGTfOPWY7XmMQ==","k":{"p":""}}}`)
+			g.Assert(errors.Is(err, ErrInvalidMessage)).IsTrue()
+			g.Assert(err.Error()).Eql("Invalid message - envelope encryption isn't supported")
+		})
+	})
+}
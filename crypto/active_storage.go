@@ -0,0 +1,92 @@
+package crypto
+
+import "time"
+
+// ActiveStorageVerifierName is the name of ActiveStorage's verifier,
+// Rails.application.message_verifier("ActiveStorage"), its key being
+// derived with it as salt.
+const ActiveStorageVerifierName = "ActiveStorage"
+
+// The model and purpose ActiveStorage::Blob#signed_id combines.
+const (
+	activeStorageBlobModel   = "ActiveStorage::Blob"
+	activeStorageBlobPurpose = "blob_id"
+	activeStorageVariation   = "variation"
+)
+
+// ActiveStorageVerifier returns a verifier set like ActiveStorage.verifier
+// for the passed secret_key_base, which signs the variation keys. The key is
+// derived like DeriveRailsCookieKeys derives the cookies' with the same
+// options (ie: WithRailsVersion("7.0") for SHA-256) and messages are
+// serialized with Marshal like the verifiers of Rails before 7.1.
+func ActiveStorageVerifier(secretKeyBase string, opts ...RailsCookieOption) (*MessageVerifier, error) {
+	kg, err := railsKeyGenerator(secretKeyBase, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageVerifier{
+		Secret:     kg.Generate([]byte(ActiveStorageVerifierName), 64),
+		Serializer: RubyMarshalSerializer{},
+	}, nil
+}
+
+// ActiveStorageSignedID returns the signed id of the blob with the passed id
+// like `blob.signed_id(expires_in: expiresIn)`, which Rails' ActiveStorage
+// routes (ie: /rails/active_storage/blobs/redirect/:signed_id/*filename)
+// accept. Blobs' signed ids are ActiveRecord signed ids (see SignedID) with
+// the "blob_id" purpose, expiresIn being optional.
+func ActiveStorageSignedID(secretKeyBase string, blobID interface{}, expiresIn time.Duration, opts ...RailsCookieOption) (string, error) {
+	kg, err := railsKeyGenerator(secretKeyBase, opts)
+	if err != nil {
+		return "", err
+	}
+	return SignedID(signedIDVerifier(kg), blobID, activeStorageBlobModel, activeStorageBlobPurpose, expiresIn)
+}
+
+// VerifyActiveStorageSignedID returns the id of the blob a signed id was
+// generated for, like ActiveStorage::Blob.find_signed before it looks the
+// blob up. See VerifySignedID.
+func VerifyActiveStorageSignedID(secretKeyBase, signedID string, opts ...RailsCookieOption) (string, error) {
+	kg, err := railsKeyGenerator(secretKeyBase, opts)
+	if err != nil {
+		return "", err
+	}
+	return VerifySignedID(signedIDVerifier(kg), signedID, activeStorageBlobModel, activeStorageBlobPurpose)
+}
+
+// ActiveStorageVariationKey returns the variation key of the passed
+// transformations (ie: {"resize_to_limit": []interface{}{100, 100}}) like
+// ActiveStorage::Variation.encode, for the variant routes (ie:
+// /rails/active_storage/representations/redirect/:signed_blob_id/:variation_key/*filename).
+// The transformations' names are serialized as Symbols, like Rails does.
+func ActiveStorageVariationKey(v *MessageVerifier, transformations map[string]interface{}) (string, error) {
+	symbolized := make(map[RubySymbol]interface{}, len(transformations))
+	for name, value := range transformations {
+		symbolized[RubySymbol(name)] = value
+	}
+	return v.GenerateWithOptions(symbolized, MessageOptions{Purpose: activeStorageVariation})
+}
+
+// DecodeActiveStorageVariationKey returns the transformations of a
+// variation key, like ActiveStorage::Variation.decode.
+func DecodeActiveStorageVariationKey(v *MessageVerifier, key string) (map[string]interface{}, error) {
+	var transformations SessionHash
+	err := v.VerifyWithOptions(key, &transformations, MessageOptions{Purpose: activeStorageVariation})
+	if err != nil {
+		return nil, err
+	}
+	return transformations, nil
+}
+
+// railsKeyGenerator returns the key generator of a Rails app with the
+// passed secret_key_base, set by opts like DeriveRailsCookieKeys.
+func railsKeyGenerator(secretKeyBase string, opts []RailsCookieOption) (KeyGenerator, error) {
+	if secretKeyBase == "" {
+		return KeyGenerator{}, configError("empty secret_key_base")
+	}
+	o, err := applyRailsCookieOptions(opts)
+	if err != nil {
+		return KeyGenerator{}, err
+	}
+	return KeyGenerator{Secret: secretKeyBase, Digest: o.digest}, nil
+}
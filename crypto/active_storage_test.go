@@ -0,0 +1,105 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+// What a Rails 7.0 app (with the 7.0 defaults, so SHA-256 derived keys)
+// using sessionFixtureSecretKeyBase generates, computed following Rails'
+// algorithm with python.
+const (
+	// `ActiveStorage::Blob.find(42).signed_id`
+	activeStorageBlobToken = "eyJfcmFpbHMiOnsibWVzc2FnZSI6Ik5EST0iLCJleHAiOm51bGwsInB1ciI6ImFjdGl2ZV9zdG9yYWdlL2Jsb2IvYmxvYl9pZCJ9fQ==--e84dbd31c0188cdabd504ad0fe6b9fe6e61de97f5fb6784fffb84bc421235b06"
+	// `blob.signed_id(expires_in: 5.minutes)` at 2024-06-01 00:00 UTC
+	activeStorageExpiringBlobToken = "eyJfcmFpbHMiOnsibWVzc2FnZSI6Ik5EST0iLCJleHAiOiIyMDI0LTA2LTAxVDAwOjA1OjAwLjAwMFoiLCJwdXIiOiJhY3RpdmVfc3RvcmFnZS9ibG9iL2Jsb2JfaWQifX0=--2665fc74129cced419ae8eb790e86b99afcd86f54e7ec29f7c90a3aa5144e32b"
+	// `ActiveStorage::Variation.encode(resize_to_limit: [100, 100])`
+	activeStorageVariationKey = "eyJfcmFpbHMiOnsibWVzc2FnZSI6IkJBaDdCam9VY21WemFYcGxYM1J2WDJ4cGJXbDBXd2RwYVdscCIsImV4cCI6bnVsbCwicHVyIjoidmFyaWF0aW9uIn19--976fc3a18a28e6670e202efdb2ff5177c69fd08b"
+	// `ActiveStorage::Variation.encode(resize_to_fill: [64, 64], format: "webp")`
+	activeStorageFormatVariationKey = "eyJfcmFpbHMiOnsibWVzc2FnZSI6IkJBaDdCem9UY21WemFYcGxYM1J2WDJacGJHeGJCMmxGYVVVNkMyWnZjbTFoZEVraUNYZGxZbkFHT2daRlZBPT0iLCJleHAiOm51bGwsInB1ciI6InZhcmlhdGlvbiJ9fQ==--ded5d9aa6236d63d34073d95a4e3d70e118cf8de"
+	// the blob signed id of a Rails 6.1 app (SHA-1 derived keys).
+	activeStorageBlobToken61 = "eyJfcmFpbHMiOnsibWVzc2FnZSI6Ik5EST0iLCJleHAiOm51bGwsInB1ciI6ImFjdGl2ZV9zdG9yYWdlL2Jsb2IvYmxvYl9pZCJ9fQ==--7a918583e0bc26888d4d76a0005b2b534f415ac82b41e85d1d3f483c1a6704c1"
+)
+
+func TestActiveStorage(t *testing.T) {
+	g := Goblin(t)
+	secret := sessionFixtureSecretKeyBase
+	rails7 := WithRailsVersion("7.0")
+
+	g.Describe("ActiveStorage signed ids", func() {
+		g.It("are generated like Rails", func() {
+			token, err := ActiveStorageSignedID(secret, 42, 0, rails7)
+			g.Assert(err).Eql(nil)
+			g.Assert(token).Eql(activeStorageBlobToken)
+			token, _ = ActiveStorageSignedID(secret, 42, 0, WithRailsVersion("6.1"))
+			g.Assert(token).Eql(activeStorageBlobToken61)
+		})
+
+		g.It("are verified like Rails", func() {
+			id, err := VerifyActiveStorageSignedID(secret, activeStorageBlobToken, rails7)
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql("42")
+			id, err = VerifyActiveStorageSignedID(secret, activeStorageBlobToken61)
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql("42")
+			_, err = VerifyActiveStorageSignedID(secret, activeStorageBlobToken)
+			g.Assert(err).Eql(ErrInvalidSignature)
+		})
+
+		g.It("expire", func() {
+			_, err := VerifyActiveStorageSignedID(secret, activeStorageExpiringBlobToken, rails7)
+			g.Assert(err).Eql(ErrMessageExpired)
+
+			token, err := ActiveStorageSignedID(secret, 42, time.Minute, rails7)
+			g.Assert(err).Eql(nil)
+			id, err := VerifyActiveStorageSignedID(secret, token, rails7)
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql("42")
+		})
+
+		g.It("aren't valid for other records", func() {
+			v := signedIDVerifier(KeyGenerator{Secret: secret})
+			token, _ := SignedID(v, 42, "User", "blob_id", 0)
+			_, err := VerifyActiveStorageSignedID(secret, token)
+			g.Assert(err).Eql(ErrInvalidPurpose)
+		})
+
+		g.It("fail without a secret", func() {
+			_, err := ActiveStorageSignedID("", 42, 0)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			_, err = VerifyActiveStorageSignedID(secret, activeStorageBlobToken, WithRailsVersion("x"))
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+
+	g.Describe("ActiveStorage variation keys", func() {
+		v, err := ActiveStorageVerifier(secret, rails7)
+
+		g.It("are generated like Rails", func() {
+			g.Assert(err).Eql(nil)
+			key, err := ActiveStorageVariationKey(v, map[string]interface{}{"resize_to_limit": []interface{}{100, 100}})
+			g.Assert(err).Eql(nil)
+			g.Assert(key).Eql(activeStorageVariationKey)
+		})
+
+		g.It("are decoded like Rails", func() {
+			transformations, err := DecodeActiveStorageVariationKey(v, activeStorageFormatVariationKey)
+			g.Assert(err).Eql(nil)
+			g.Assert(transformations).Eql(map[string]interface{}{
+				"resize_to_fill": []interface{}{int64(64), int64(64)},
+				"format":         "webp",
+			})
+		})
+
+		g.It("aren't valid for other purposes", func() {
+			token, _ := v.Generate(map[RubySymbol]interface{}{"resize_to_limit": []interface{}{100, 100}})
+			_, err := DecodeActiveStorageVariationKey(v, token)
+			g.Assert(err).Eql(ErrInvalidPurpose)
+			_, err = ActiveStorageVerifier("")
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/cipher"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// newAEAD returns the authenticated cipher used by the AEAD modes
+// (aes-256-gcm, aes-128-gcm and the chacha20-poly1305 ones).
+func (crypt *MessageEncryptor) newAEAD() (cipher.AEAD, error) {
+	k, err := crypt.cipherKey()
+	if err != nil {
+		return nil, err
+	}
+	switch crypt.Cipher {
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(k)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(k)
+	}
+	return newGCM(k)
+}
+
+func (crypt *MessageEncryptor) aeadEncrypt(plaintext, aad []byte) (string, error) {
+	aead, err := crypt.newAEAD()
+	if err != nil {
+		return "", err
+	}
+
+	iv, err := crypt.newNonce(aead.NonceSize())
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext := aead.Seal(nil, iv, plaintext, aad)
+
+	// Rails stores the GCM auth tag separately from the encrypted data,
+	// unlike the cipher package, so a little munging is required.
+	// Luckily aead.Overhead() is the tag size (which is 16), the chacha
+	// modes use the same framing.
+	tagStart := len(ciphertext) - aead.Overhead()
+	tag := ciphertext[tagStart:]
+	enc := ciphertext[:tagStart]
+
+	encoding := crypt.encoding()
+	vectors := [][]byte{enc, iv, tag}
+	for i, vec := range vectors {
+		dst := make([]byte, encoding.EncodedLen(len(vec)))
+		encoding.Encode(dst, vec)
+		vectors[i] = dst
+	}
+
+	output := string(bytes.Join(vectors, []byte("--")))
+	return output, nil
+}
+
+func (crypt *MessageEncryptor) aeadDecrypt(encryptedMsg string, aad []byte) ([]byte, error) {
+	aead, err := crypt.newAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	// All the failures are reported with the same error so they can't be
+	// told apart by an attacker.
+	// Rails rejects truncated auth tags, which would be easier to forge:
+	// the nonce and the tag must have their full sizes.
+	vectors, ok := splitEncryptedMessage(encryptedMsg, aead.NonceSize(), aead.Overhead())
+	if !ok {
+		return nil, ErrInvalidMessage
+	}
+
+	enc := vectors[0]
+	nonce := vectors[1]
+	tag := vectors[2]
+	// Rails splits the auth tag into a separate vector, which is unnecessary really, but fine.
+	enc = append(enc, tag...)
+
+	plain, err := aead.Open(nil, nonce, enc, aad)
+	if err != nil {
+		return nil, ErrInvalidMessage
+	}
+	return plain, nil
+}
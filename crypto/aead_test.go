@@ -0,0 +1,113 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+var aeadCiphers = []string{AES256GCM, ChaCha20Poly1305, XChaCha20Poly1305}
+
+func TestAEADCiphers(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("MessageEncryptor using chacha20-poly1305", func() {
+		for _, cipher := range []string{ChaCha20Poly1305, XChaCha20Poly1305} {
+			cipher := cipher
+			e := MessageEncryptor{Key: GenerateRandomKey(32), Cipher: cipher}
+
+			g.It(cipher+" round trips", func() {
+				data := testStruct{Foo: "foo", Bar: 42}
+				msg, err := e.EncryptAndSign(data)
+				g.Assert(err).Eql(nil)
+				g.Assert(len(strings.Split(msg, "--"))).Eql(3)
+				var output testStruct
+				g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+				g.Assert(output).Eql(data)
+			})
+
+			g.It(cipher+" round trips large and empty payloads", func() {
+				raw := MessageEncryptor{Key: e.Key, Cipher: cipher, Serializer: NullMsgSerializer{}, MaxMessageLen: -1}
+				for _, data := range []string{"", strings.Repeat("large payload ", 1<<16)} {
+					msg, err := raw.EncryptAndSign(data)
+					g.Assert(err).Eql(nil)
+					var output string
+					g.Assert(raw.DecryptAndVerify(msg, &output)).Eql(nil)
+					g.Assert(output == data).IsTrue()
+				}
+			})
+
+			g.It(cipher+" uses its nonce size", func() {
+				msg, _ := e.EncryptAndSign("foo")
+				nonce, _ := base64.StdEncoding.DecodeString(strings.Split(msg, "--")[1])
+				size := 12
+				if cipher == XChaCha20Poly1305 {
+					size = 24
+				}
+				g.Assert(len(nonce)).Eql(size)
+			})
+
+			g.It(cipher+" rejects tampered messages", func() {
+				msg, _ := e.EncryptAndSign("foo")
+				vectors := strings.Split(msg, "--")
+				tag, _ := base64.StdEncoding.DecodeString(vectors[2])
+				tag[0] ^= 0x01
+				var output string
+				tampered := vectors[0] + "--" + vectors[1] + "--" + base64.StdEncoding.EncodeToString(tag)
+				g.Assert(e.DecryptAndVerify(tampered, &output)).Eql(ErrDecryptFail)
+				g.Assert(e.DecryptAndVerifyWithAAD(msg, &output, []byte("aad"))).Eql(ErrDecryptFail)
+			})
+
+			g.It(cipher+" needs a 32 bytes key", func() {
+				_, err := NewMessageEncryptor(GenerateRandomKey(16), WithCipher(cipher))
+				g.Assert(errors.Is(err, ErrInvalidKeyLength)).IsTrue()
+				e, err := NewMessageEncryptor(GenerateRandomKey(32), WithCipher(cipher))
+				g.Assert(err).Eql(nil)
+				g.Assert(e.Cipher).Eql(cipher)
+			})
+		}
+
+		g.It("can't decrypt messages from the other ciphers", func() {
+			key := GenerateRandomKey(32)
+			for _, from := range aeadCiphers {
+				msg, _ := (&MessageEncryptor{Key: key, Cipher: from}).EncryptAndSign("foo")
+				for _, to := range aeadCiphers {
+					if to == from {
+						continue
+					}
+					var output string
+					g.Assert((&MessageEncryptor{Key: key, Cipher: to}).DecryptAndVerify(msg, &output)).Eql(ErrDecryptFail)
+				}
+			}
+		})
+	})
+}
+
+func benchmarkAEAD(b *testing.B, cipher string, size int) {
+	e := MessageEncryptor{Key: GenerateRandomKey(32), Cipher: cipher, Serializer: NullMsgSerializer{}, MaxMessageLen: -1}
+	data := strings.Repeat("a", size)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg, _ := e.EncryptAndSign(data)
+		var output string
+		if err := e.DecryptAndVerify(msg, &output); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAEAD(b *testing.B) {
+	for _, cipher := range aeadCiphers {
+		for _, size := range []int{64, 16 << 10} {
+			cipher, size := cipher, size
+			b.Run(cipher+"/"+strconv.Itoa(size), func(b *testing.B) {
+				benchmarkAEAD(b, cipher, size)
+			})
+		}
+	}
+}
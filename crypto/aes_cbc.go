@@ -1,49 +1,29 @@
 package crypto
 
 import (
-	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
-	"encoding/base64"
-	"errors"
-	"io"
-	"strings"
 )
 
-func (crypt *MessageEncryptor) aesCbcEncrypt(value interface{}) (string, error) {
-	// TODO: check the crypt is properly initiated
-	k := crypt.Key
-	// The longest accepted key is 32 byte long,
-	// instead of rejecting a long key, we truncate it.
-	// This is how openssl in Ruby works.
-	if len(k) > 32 {
-		k = crypt.Key[:32]
-	}
-	block, err := aes.NewCipher(k)
+func (crypt *MessageEncryptor) aesCbcEncrypt(plaintext []byte) (string, error) {
+	k, err := crypt.cipherKey()
 	if err != nil {
 		return "", err
 	}
-
-	// Set a default serializer if not already set
-	if crypt.Serializer == nil {
-		crypt.Serializer = JsonMsgSerializer{}
-	}
-	splaintext, err := crypt.Serializer.Serialize(value)
+	block, err := aes.NewCipher(k)
 	if err != nil {
 		return "", err
 	}
-	plaintext := []byte(splaintext)
 
-	// CBC mode works on blocks so plaintexts may need to be padded to the
+	// CBC mode works on blocks so plaintexts need to be padded to the
 	// next whole block. See
 	// http://tools.ietf.org/html/rfc5652#section-6.3
-	plaintext = PKCS7Pad(plaintext)
+	plaintext = pkcs7Pad(plaintext)
 
 	// The IV needs to be unique, but not secure, it is included in the
 	// cypher text.
-	iv := make([]byte, aes.BlockSize)
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	iv, err := crypt.newNonce(aes.BlockSize)
+	if err != nil {
 		return "", err
 	}
 
@@ -53,56 +33,68 @@ func (crypt *MessageEncryptor) aesCbcEncrypt(value interface{}) (string, error)
 	mode.CryptBlocks(ciphertext, plaintext)
 
 	// base64 the cipher text + the iv and join by "--"
-	output := base64.StdEncoding.EncodeToString(ciphertext) + "--" + base64.StdEncoding.EncodeToString(iv)
+	encoding := crypt.encoding()
+	output := encoding.EncodeToString(ciphertext) + "--" + encoding.EncodeToString(iv)
 	return output, nil
 }
 
-func (crypt *MessageEncryptor) aesCbcDecrypt(encryptedMsg string, target interface{}) error {
-	k := crypt.Key
-	// The longest accepted key is 32 byte long,
-	// instead of rejecting a long key, we truncate it.
-	// This is how openssl in Ruby works.
-	if len(k) > 32 {
-		k = crypt.Key[:32]
+func (crypt *MessageEncryptor) aesCbcDecrypt(encryptedMsg string) ([]byte, error) {
+	k, err := crypt.cipherKey()
+	if err != nil {
+		return nil, err
 	}
 
 	block, err := aes.NewCipher(k)
 	if err != nil {
-		return err
-	}
-
-	// split the msg and decode each part
-	splitMsg := strings.Split(encryptedMsg, "--")
-	if len(splitMsg) != 2 {
-		return errors.New("bad data (--)")
+		return nil, err
 	}
 
-	ciphertext, err := base64.StdEncoding.DecodeString(splitMsg[0])
-	if err != nil {
-		return err
-	}
-	iv, err := base64.StdEncoding.DecodeString(splitMsg[1])
-	if err != nil {
-		return err
+	// All the failures are reported with the same error so they can't be
+	// told apart by an attacker.
+	parts, ok := splitEncryptedMessage(encryptedMsg, aes.BlockSize)
+	if !ok {
+		return nil, ErrInvalidMessage
 	}
+	ciphertext, iv := parts[0], parts[1]
 
-	if len(ciphertext) < aes.BlockSize {
-		return errors.New("bad data, ciphertext too short")
-	}
-	if len(ciphertext)%aes.BlockSize != 0 {
-		return errors.New("bad data, ciphertext is not a multiple of the block size")
+	if len(ciphertext) < aes.BlockSize || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, ErrInvalidMessage
 	}
 
 	mode := cipher.NewCBCDecrypter(block, iv)
 	mode.CryptBlocks(ciphertext, ciphertext)
-	unPaddedCiphertext := PKCS7Unpad(ciphertext)
+	return pkcs7Unpad(ciphertext), nil
+}
 
-	// In some cases, Rails sends us messages padded with 0x10 (while this package only pads with 0x01-0x0f).
-	// For now, we handle this case here when the Serializer is JSON (so we know that 0x10 is actually a padding
-	// and not valid data - because this is an invalid json character).
-	if _, ok := crypt.Serializer.(JsonMsgSerializer); ok {
-		unPaddedCiphertext = bytes.TrimRight(unPaddedCiphertext, "\x10")
+// pkcs7Pad pads data to the next whole block like OpenSSL does: unlike
+// PKCS7Pad, data already a multiple of the block size gets a full block of
+// padding so it can be told apart from the padding. data isn't modified.
+func pkcs7Pad(data []byte) []byte {
+	n := aes.BlockSize - len(data)%aes.BlockSize
+	padded := make([]byte, len(data)+n)
+	copy(padded, data)
+	for i := len(data); i < len(padded); i++ {
+		padded[i] = byte(n)
 	}
+	return padded
+}
 
-	return crypt.Serializer.Unserialize(string(unPaddedCiphertext), target)
+// pkcs7Unpad removes the padding added by pkcs7Pad (and Rails, which pads
+// aligned messages with a block of 0x10). Data without a valid
+// padding is returned untouched: the messages PKCS7Pad used to encrypt
+// aren't padded when their length is a multiple of the block size.
+func pkcs7Unpad(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	n := int(data[len(data)-1])
+	if n == 0 || n > aes.BlockSize || n > len(data) {
+		return data
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return data
+		}
+	}
+	return data[:len(data)-n]
 }
@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// aes-256-ctr-hmac messages are framed like the ones of a MessageVerifier,
+// base64(iv || ciphertext)--hex(hmac), but the HMAC is computed over the
+// raw iv and ciphertext rather than their base64 encoding. Additional data,
+// when there is any, is authenticated after them followed by its length so
+// the messages without any stay readable by the legacy systems using this
+// mode.
+
+func (crypt *MessageEncryptor) aesCtrEncrypt(plaintext, aad []byte) (string, error) {
+	k, err := crypt.cipherKey()
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return "", err
+	}
+	iv, err := crypt.newNonce(aes.BlockSize)
+	if err != nil {
+		return "", err
+	}
+
+	data := make([]byte, aes.BlockSize+len(plaintext))
+	copy(data, iv)
+	cipher.NewCTR(block, iv).XORKeyStream(data[aes.BlockSize:], plaintext)
+	return crypt.encoding().EncodeToString(data) + "--" + hex.EncodeToString(crypt.ctrMAC(data, aad)), nil
+}
+
+func (crypt *MessageEncryptor) aesCtrDecrypt(encryptedMsg string, aad []byte) ([]byte, error) {
+	k, err := crypt.cipherKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+
+	// All the failures are reported with the same error so they can't be
+	// told apart by an attacker.
+	encoded, digest, err := splitSignedMessage(encryptedMsg, "--")
+	if err != nil {
+		return nil, ErrInvalidMessage
+	}
+	data, err := decodeBase64(encoded)
+	if err != nil || len(data) < aes.BlockSize {
+		return nil, ErrInvalidMessage
+	}
+	mac, err := hex.DecodeString(digest)
+	if err != nil || !SecureCompareBytes(mac, crypt.ctrMAC(data, aad)) {
+		return nil, ErrInvalidMessage
+	}
+
+	plain := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCTR(block, data[:aes.BlockSize]).XORKeyStream(plain, data[aes.BlockSize:])
+	return plain, nil
+}
+
+// ctrMAC returns the HMAC of the iv and ciphertext, and of aad if any,
+// keyed with SignKey or, when it isn't set, Key.
+func (crypt *MessageEncryptor) ctrMAC(data, aad []byte) []byte {
+	hasher := crypt.MACHasher
+	if hasher == nil {
+		hasher = sha256.New
+	}
+	signKey := crypt.SignKey
+	if len(signKey) == 0 {
+		signKey = crypt.Key
+	}
+	mac := hmac.New(hasher, signKey)
+	mac.Write(data)
+	if len(aad) > 0 {
+		mac.Write(aad)
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(aad)))
+		mac.Write(n[:])
+	}
+	return mac.Sum(nil)
+}
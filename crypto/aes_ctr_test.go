@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestAESCTRHMAC(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("aes-256-ctr-hmac", func() {
+		// Known answers computed with the openssl CLI (aes-256-ctr) and
+		// HMAC over iv || ciphertext, the way the legacy systems do.
+		key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+		signKey, _ := hex.DecodeString("202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f")
+		iv, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+		const (
+			sha256Msg = "8PHy8/T19vf4+fr7/P3+/+kiuP5G5N+iPkvcYHIeQWalMx5iywhVpQPOOwM3--54f619197b2fd1975c211a5eb04b8a78fe1c3d579034b1e7def7a08e8441e4af"
+			sha512Msg = "8PHy8/T19vf4+fr7/P3+/+kiuP5G5N+iPkvcYHIeQWalMx5iywhVpQPOOwM3--5b64294ac622923b272e0de4b863a51b986bc7969c0654ae3c2cee7d727be510bc8d82f049b085217bb330199b050f9e818d350c2c862f41d52a27f9c177f0c1"
+			keyMsg    = "8PHy8/T19vf4+fr7/P3+/+kiuP5G5N+iPkvcYHIeQWalMx5iywhVpQPOOwM3--6fcf3e3419559c734bd4d2972556517d6320044ce3b0ebca1f11b85a254b3627"
+		)
+		type token struct {
+			UserID int    `json:"user_id"`
+			Role   string `json:"role"`
+		}
+		expected := token{UserID: 42, Role: "admin"}
+
+		g.It("decrypts the legacy messages", func() {
+			e := &MessageEncryptor{Key: key, SignKey: signKey, Cipher: AES256CTRHMAC}
+			var output token
+			g.Assert(e.DecryptAndVerify(sha256Msg, &output)).Eql(nil)
+			g.Assert(output).Eql(expected)
+
+			e = &MessageEncryptor{Key: key, SignKey: signKey, Cipher: AES256CTRHMAC, MACHasher: sha512.New}
+			output = token{}
+			g.Assert(e.DecryptAndVerify(sha512Msg, &output)).Eql(nil)
+			g.Assert(output).Eql(expected)
+
+			// the MAC is keyed with Key when SignKey isn't set.
+			e = &MessageEncryptor{Key: key, Cipher: AES256CTRHMAC}
+			output = token{}
+			g.Assert(e.DecryptAndVerify(keyMsg, &output)).Eql(nil)
+			g.Assert(output).Eql(expected)
+		})
+
+		g.It("encrypts like the legacy systems", func() {
+			e := &MessageEncryptor{Key: key, SignKey: signKey, Cipher: AES256CTRHMAC, RandReader: bytes.NewReader(iv)}
+			msg, err := e.EncryptAndSign(expected)
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql(sha256Msg)
+		})
+
+		g.It("round trips", func() {
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256CTRHMAC}
+			for _, data := range []string{"", "foo", strings.Repeat("bar", 100)} {
+				msg, err := e.EncryptAndSign(data)
+				g.Assert(err).Eql(nil)
+				var output string
+				g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+				g.Assert(output).Eql(data)
+			}
+		})
+
+		g.It("rejects tampered messages", func() {
+			e := &MessageEncryptor{Key: key, SignKey: signKey, Cipher: AES256CTRHMAC}
+			data, digest, _ := splitSignedMessage(sha256Msg, "--")
+			for _, msg := range []string{
+				"",
+				data,
+				data + "--",
+				"--" + digest,
+				"AAAA" + data[4:] + "--" + digest,
+				data + "--" + digest[:len(digest)-2],
+				data + "--" + strings.ToUpper(digest[:2]) + "zz" + digest[4:],
+				"8PHy8/T19vf4+fr7/P3+--" + digest,
+				sha512Msg,
+			} {
+				var output token
+				g.Assert(e.DecryptAndVerify(msg, &output)).Eql(ErrInvalidMessage)
+			}
+			other := &MessageEncryptor{Key: key, SignKey: GenerateRandomKey(32), Cipher: AES256CTRHMAC}
+			var output token
+			g.Assert(other.DecryptAndVerify(sha256Msg, &output)).Eql(ErrInvalidMessage)
+		})
+
+		g.It("authenticates additional data", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256CTRHMAC}
+			msg, err := e.EncryptAndSignWithAAD("foo", []byte("user:1"))
+			g.Assert(err).Eql(nil)
+			var output string
+			g.Assert(e.DecryptAndVerifyWithAAD(msg, &output, []byte("user:1"))).Eql(nil)
+			g.Assert(output).Eql("foo")
+			g.Assert(e.DecryptAndVerifyWithAAD(msg, &output, []byte("user:2"))).Eql(ErrInvalidMessage)
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(ErrInvalidMessage)
+		})
+
+		g.It("needs a 32 bytes key", func() {
+			e := &MessageEncryptor{Key: key[:16], Cipher: AES256CTRHMAC}
+			_, err := e.EncryptAndSign("foo")
+			g.Assert(errors.Is(err, ErrInvalidKeyLength)).IsTrue()
+			_, err = NewMessageEncryptor(key, WithCipher(AES256CTRHMAC))
+			g.Assert(err).Eql(nil)
+		})
+	})
+}
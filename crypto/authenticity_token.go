@@ -0,0 +1,156 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// authenticityTokenLen is the length of Rails' CSRF tokens,
+// AUTHENTICITY_TOKEN_LENGTH.
+const authenticityTokenLen = 32
+
+// globalCSRFTokenID is the identifier Rails 6.1+ derive the global CSRF
+// token from with csrfTokenHMAC, which is what the forms embed instead of
+// the session's token.
+const globalCSRFTokenID = "!real_csrf_token"
+
+var errInvalidCSRFToken = errors.New("crypto: invalid session CSRF token")
+
+// ValidAuthenticityToken reports whether requestToken, the authenticity
+// token of a request (ie: its authenticity_token param or X-CSRF-Token
+// header), is valid for sessionToken, the session's _csrf_token, like
+// Rails' valid_authenticity_token? does. It accepts:
+//
+//   - the masked tokens Rails' forms embed, the XOR of the token with a one
+//     time pad preceded by the pad, of the session's token or of the global
+//     token Rails 6.1+ derive from it;
+//   - the unmasked tokens of Rails before 4.2.
+//
+// The tokens can be encoded with either base64 alphabet, padded or not.
+// Per form tokens aren't supported. It returns false on any invalid input.
+func ValidAuthenticityToken(sessionToken, requestToken string) bool {
+	real, ok := decodeCSRFToken(sessionToken)
+	if !ok || len(real) != authenticityTokenLen {
+		return false
+	}
+	token, ok := decodeCSRFToken(requestToken)
+	if !ok {
+		return false
+	}
+	switch len(token) {
+	case authenticityTokenLen:
+		return SecureCompareBytes(token, real)
+	case 2 * authenticityTokenLen:
+		token = unmaskCSRFToken(token)
+		return SecureCompareBytes(token, real) || SecureCompareBytes(token, csrfTokenHMAC(real, globalCSRFTokenID))
+	}
+	return false
+}
+
+// ValidPerFormToken is like ValidAuthenticityToken but also accepts the per
+// form tokens of Rails apps with per_form_csrf_tokens enabled, which are
+// scoped to the action and method of the form they're embedded in. action
+// is the path of the request (or a URL, whose path is used) and method its
+// HTTP method.
+func ValidPerFormToken(sessionToken, requestToken, action, method string) bool {
+	if ValidAuthenticityToken(sessionToken, requestToken) {
+		return true
+	}
+	real, ok := decodeCSRFToken(sessionToken)
+	if !ok || len(real) != authenticityTokenLen {
+		return false
+	}
+	token, ok := decodeCSRFToken(requestToken)
+	if !ok || len(token) != 2*authenticityTokenLen {
+		return false
+	}
+	return SecureCompareBytes(unmaskCSRFToken(token), perFormCSRFToken(real, action, method))
+}
+
+// MaskedAuthenticityToken returns a masked authenticity token of
+// sessionToken that Rails accepts, for Go forms submitting to a Rails app.
+// The one time pad is read from randReader, crypto/rand.Reader if nil.
+// The session's token is masked (not the global token Rails 6.1+ mask) and
+// the token is encoded with the standard base64 alphabet so every Rails
+// version since 4.2 accepts it.
+func MaskedAuthenticityToken(sessionToken string, randReader io.Reader) (string, error) {
+	real, ok := decodeCSRFToken(sessionToken)
+	if !ok || len(real) != authenticityTokenLen {
+		return "", errInvalidCSRFToken
+	}
+	return maskCSRFToken(real, randReader)
+}
+
+// MaskedPerFormToken returns a masked per form token of sessionToken for a
+// form submitted to action with method, which Rails apps with
+// per_form_csrf_tokens enabled accept for this form only. See
+// MaskedAuthenticityToken.
+func MaskedPerFormToken(sessionToken, action, method string, randReader io.Reader) (string, error) {
+	real, ok := decodeCSRFToken(sessionToken)
+	if !ok || len(real) != authenticityTokenLen {
+		return "", errInvalidCSRFToken
+	}
+	return maskCSRFToken(perFormCSRFToken(real, action, method), randReader)
+}
+
+// maskCSRFToken XORs token with a one time pad read from randReader and
+// returns them encoded.
+func maskCSRFToken(token []byte, randReader io.Reader) (string, error) {
+	if randReader == nil {
+		randReader = rand.Reader
+	}
+	masked := make([]byte, 2*authenticityTokenLen)
+	pad := masked[:authenticityTokenLen]
+	if _, err := io.ReadFull(randReader, pad); err != nil {
+		return "", err
+	}
+	for i, b := range token {
+		masked[authenticityTokenLen+i] = b ^ pad[i]
+	}
+	return base64.StdEncoding.EncodeToString(masked), nil
+}
+
+// decodeCSRFToken decodes a token encoded with either base64 alphabet,
+// which Rails 7.0+ accept, padded or not.
+func decodeCSRFToken(s string) ([]byte, bool) {
+	s = strings.TrimRight(s, "=")
+	s = strings.NewReplacer("-", "+", "_", "/").Replace(s)
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	return b, err == nil && len(b) > 0
+}
+
+// unmaskCSRFToken XORs the second half of a masked token with its first,
+// the one time pad.
+func unmaskCSRFToken(masked []byte) []byte {
+	pad, encrypted := masked[:authenticityTokenLen], masked[authenticityTokenLen:]
+	token := make([]byte, authenticityTokenLen)
+	for i := range token {
+		token[i] = pad[i] ^ encrypted[i]
+	}
+	return token
+}
+
+// csrfTokenHMAC derives the token of identifier from the session's token,
+// like Rails' csrf_token_hmac.
+func csrfTokenHMAC(real []byte, identifier string) []byte {
+	mac := hmac.New(sha256.New, real)
+	mac.Write([]byte(identifier))
+	return mac.Sum(nil)
+}
+
+// perFormCSRFToken derives the token of the forms submitted to action with
+// method. Like Rails, the identifier is the path of action without its
+// trailing slash and the downcased method joined by "#" (ie:
+// "/posts#post").
+func perFormCSRFToken(real []byte, action, method string) []byte {
+	if u, err := url.Parse(action); err == nil {
+		action = u.Path
+	}
+	return csrfTokenHMAC(real, strings.TrimSuffix(action, "/")+"#"+strings.ToLower(method))
+}
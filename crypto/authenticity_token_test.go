@@ -0,0 +1,134 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	. "github.com/franela/goblin"
+)
+
+// Authenticity tokens computed following Rails' algorithm with python, the
+// session's token being the bytes 0x10 to 0x2f and the one time pad the
+// bytes 0xa0 to 0xbf.
+const (
+	// session[:_csrf_token] as Rails 4.2 to 6.1 (SecureRandom.base64) and
+	// 7.0+ (SecureRandom.urlsafe_base64) set it.
+	csrfSessionToken    = "EBESExQVFhcYGRobHB0eHyAhIiMkJSYnKCkqKywtLi8="
+	csrfSessionTokenURL = "EBESExQVFhcYGRobHB0eHyAhIiMkJSYnKCkqKywtLi8"
+	// the session's token masked, encoded like Rails 4.2 to 6.0.
+	csrfMaskedToken = "oKGio6SlpqeoqaqrrK2ur7CxsrO0tba3uLm6u7y9vr+wsLCwsLCwsLCwsLCwsLCwkJCQkJCQkJCQkJCQkJCQkA=="
+	// the global token masked, encoded like Rails 7.0+.
+	csrfMaskedGlobalToken = "oKGio6SlpqeoqaqrrK2ur7CxsrO0tba3uLm6u7y9vr-I7Cgmt70H0g4DFS-PuZ98ZW4CSTbvWsmKIjDHKoCmEg"
+	// the per form tokens of a form posting to /posts, encoded like Rails
+	// 4.2 to 6.0, and of one patching /posts/42/comments, encoded like Rails
+	// 7.0+.
+	csrfPostPostsToken     = "oKGio6SlpqeoqaqrrK2ur7CxsrO0tba3uLm6u7y9vr+LwVNozYJlEUFJcOOpMvul/JWYBSPyj3dGbGmMcw61VA=="
+	csrfPatchCommentsToken = "oKGio6SlpqeoqaqrrK2ur7CxsrO0tba3uLm6u7y9vr-ofZoJcLUplpTav7pAAoI2Og4E0WnNLsjf0tFo9msrOA"
+)
+
+func TestAuthenticityToken(t *testing.T) {
+	g := Goblin(t)
+	pad := func() *bytes.Reader {
+		b := make([]byte, 32)
+		for i := range b {
+			b[i] = byte(0xa0 + i)
+		}
+		return bytes.NewReader(b)
+	}
+
+	g.Describe("ValidAuthenticityToken", func() {
+		g.It("accepts the masked tokens of Rails forms", func() {
+			for _, session := range []string{csrfSessionToken, csrfSessionTokenURL} {
+				g.Assert(ValidAuthenticityToken(session, csrfMaskedToken)).IsTrue()
+				g.Assert(ValidAuthenticityToken(session, csrfMaskedGlobalToken)).IsTrue()
+			}
+		})
+
+		g.It("accepts unmasked legacy tokens", func() {
+			g.Assert(ValidAuthenticityToken(csrfSessionToken, csrfSessionToken)).IsTrue()
+			g.Assert(ValidAuthenticityToken(csrfSessionToken, csrfSessionTokenURL)).IsTrue()
+		})
+
+		g.It("rejects the tokens of other sessions", func() {
+			other, _ := MaskedAuthenticityToken("ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8=", nil)
+			g.Assert(ValidAuthenticityToken(csrfSessionToken, other)).IsFalse()
+			g.Assert(ValidAuthenticityToken(csrfSessionToken, "ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8=")).IsFalse()
+			tampered := "oKGio6SlpqeoqaqrrK2ur7CxsrO0tba3uLm6u7y9vr+wsLCwsLCwsLCwsLCwsLCwkJCQkJCQkJCQkJCQkJCQkQ=="
+			g.Assert(ValidAuthenticityToken(csrfSessionToken, tampered)).IsFalse()
+		})
+
+		g.It("rejects invalid input without panicking", func() {
+			for _, token := range []string{"", "=", "not base64!", "AAAA", csrfMaskedToken[:40], csrfMaskedToken + "AAAA", strings.Repeat("A", 1000)} {
+				g.Assert(ValidAuthenticityToken(csrfSessionToken, token)).IsFalse()
+				g.Assert(ValidAuthenticityToken(token, csrfMaskedToken)).IsFalse()
+			}
+			g.Assert(ValidAuthenticityToken("", "")).IsFalse()
+		})
+	})
+
+	g.Describe("MaskedAuthenticityToken", func() {
+		g.It("masks the session's token like Rails", func() {
+			token, err := MaskedAuthenticityToken(csrfSessionToken, pad())
+			g.Assert(err).Eql(nil)
+			g.Assert(token).Eql(csrfMaskedToken)
+			token, _ = MaskedAuthenticityToken(csrfSessionTokenURL, pad())
+			g.Assert(token).Eql(csrfMaskedToken)
+		})
+
+		g.It("uses a new pad for every token", func() {
+			a, _ := MaskedAuthenticityToken(csrfSessionToken, nil)
+			b, _ := MaskedAuthenticityToken(csrfSessionToken, nil)
+			g.Assert(a == b).IsFalse()
+			g.Assert(ValidAuthenticityToken(csrfSessionToken, a)).IsTrue()
+			g.Assert(ValidAuthenticityToken(csrfSessionToken, b)).IsTrue()
+		})
+
+		g.It("fails with invalid session tokens or pads", func() {
+			_, err := MaskedAuthenticityToken("AAAA", nil)
+			g.Assert(err != nil).IsTrue()
+			errRand := errors.New("no entropy")
+			_, err = MaskedAuthenticityToken(csrfSessionToken, iotest.ErrReader(errRand))
+			g.Assert(err).Eql(errRand)
+		})
+
+	})
+
+	g.Describe("Per form tokens", func() {
+		g.It("are valid for their form only", func() {
+			g.Assert(ValidPerFormToken(csrfSessionToken, csrfPostPostsToken, "/posts", "POST")).IsTrue()
+			g.Assert(ValidPerFormToken(csrfSessionTokenURL, csrfPatchCommentsToken, "/posts/42/comments", "PATCH")).IsTrue()
+
+			g.Assert(ValidPerFormToken(csrfSessionToken, csrfPostPostsToken, "/posts/42/comments", "POST")).IsFalse()
+			g.Assert(ValidPerFormToken(csrfSessionToken, csrfPostPostsToken, "/posts", "PATCH")).IsFalse()
+			g.Assert(ValidPerFormToken(csrfSessionToken, csrfPatchCommentsToken, "/posts", "POST")).IsFalse()
+			g.Assert(ValidAuthenticityToken(csrfSessionToken, csrfPostPostsToken)).IsFalse()
+		})
+
+		g.It("normalize the action like Rails", func() {
+			for _, action := range []string{"/posts/", "/posts?page=2", "https://example.com/posts"} {
+				g.Assert(ValidPerFormToken(csrfSessionToken, csrfPostPostsToken, action, "post")).IsTrue()
+			}
+		})
+
+		g.It("accept the global tokens too", func() {
+			g.Assert(ValidPerFormToken(csrfSessionToken, csrfMaskedToken, "/posts", "POST")).IsTrue()
+			g.Assert(ValidPerFormToken(csrfSessionToken, csrfMaskedGlobalToken, "/posts", "POST")).IsTrue()
+			g.Assert(ValidPerFormToken(csrfSessionToken, csrfSessionToken, "/posts", "POST")).IsTrue()
+			g.Assert(ValidPerFormToken(csrfSessionToken, "", "/posts", "POST")).IsFalse()
+			g.Assert(ValidPerFormToken("", csrfPostPostsToken, "/posts", "POST")).IsFalse()
+		})
+
+		g.It("are generated like Rails", func() {
+			token, err := MaskedPerFormToken(csrfSessionToken, "/posts", "POST", pad())
+			g.Assert(err).Eql(nil)
+			g.Assert(token).Eql(csrfPostPostsToken)
+			token, _ = MaskedPerFormToken(csrfSessionToken, "/posts/42/comments/", "patch", nil)
+			g.Assert(ValidPerFormToken(csrfSessionToken, token, "/posts/42/comments", "PATCH")).IsTrue()
+			_, err = MaskedPerFormToken("AAAA", "/posts", "POST", nil)
+			g.Assert(err != nil).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,22 @@
+package crypto
+
+import (
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+)
+
+// Blake2bMAC is a MessageVerifier MACFactory using BLAKE2b-256 in keyed mode
+// instead of HMAC. The key can't be longer than 64 bytes.
+// Messages signed this way can't be verified by Rails.
+func Blake2bMAC(key []byte) (hash.Hash, error) {
+	return blake2b.New256(key)
+}
+
+// Blake2sMAC is a MessageVerifier MACFactory using BLAKE2s-256 in keyed mode
+// instead of HMAC. The key can't be longer than 32 bytes.
+// Messages signed this way can't be verified by Rails.
+func Blake2sMAC(key []byte) (hash.Hash, error) {
+	return blake2s.New256(key)
+}
@@ -0,0 +1,81 @@
+package crypto
+
+import (
+	"crypto/sha1"
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestBlake2MAC(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("MessageVerifier using keyed BLAKE2", func() {
+		data := testStruct{Foo: "foo", Bar: 42}
+
+		g.Describe("with BLAKE2b", func() {
+			v := MessageVerifier{
+				Secret:     []byte("Hey, I'm a secret!"),
+				MACFactory: Blake2bMAC,
+				Serializer: JsonMsgSerializer{},
+			}
+
+			g.It("properly digests a string", func() {
+				g.Assert(v.DigestFor("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==")).Eql("046154c2a557057ea1c2dd7a6acdfc282ed93f97e8d684f889419d7e45aef5c3")
+			})
+
+			g.It("can do a round trip verification", func() {
+				msg, err := v.Generate(data)
+				g.Assert(err).Eql(nil)
+				g.Assert(msg).Eql("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--046154c2a557057ea1c2dd7a6acdfc282ed93f97e8d684f889419d7e45aef5c3")
+				var verified testStruct
+				err = v.Verify(msg, &verified)
+				g.Assert(err).Eql(nil)
+				g.Assert(verified).Eql(data)
+			})
+
+			g.It("doesn't verify HMAC signed messages", func() {
+				var verified testStruct
+				err := v.Verify("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5", &verified)
+				g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+			})
+
+			g.It("reports keys that are too long", func() {
+				vv := MessageVerifier{Secret: make([]byte, 65), MACFactory: Blake2bMAC, Serializer: JsonMsgSerializer{}}
+				_, err := vv.Generate(data)
+				g.Assert(err != nil).IsTrue()
+				g.Assert(vv.Valid("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--046154c2")).IsFalse()
+			})
+		})
+
+		g.Describe("with BLAKE2s", func() {
+			v := MessageVerifier{
+				Secret:     []byte("Hey, I'm a secret!"),
+				MACFactory: Blake2sMAC,
+				Serializer: JsonMsgSerializer{},
+			}
+
+			g.It("can do a round trip verification", func() {
+				msg, err := v.Generate(data)
+				g.Assert(err).Eql(nil)
+				g.Assert(msg).Eql("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--f56bc786511037b11b7b7463042042e11d1960368d71be9f01bdcc9c5fdc2dbc")
+				var verified testStruct
+				err = v.Verify(msg, &verified)
+				g.Assert(err).Eql(nil)
+				g.Assert(verified).Eql(data)
+			})
+		})
+
+		g.It("leaves the HMAC path untouched without a MACFactory", func() {
+			v := MessageVerifier{
+				Secret:     []byte("Hey, I'm a secret!"),
+				Hasher:     sha1.New,
+				Serializer: JsonMsgSerializer{},
+			}
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5")
+		})
+	})
+}
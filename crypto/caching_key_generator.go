@@ -0,0 +1,72 @@
+package crypto
+
+import "sync"
+
+// CachingKeyGenerator memoizes the keys derived by its KeyGenerator like
+// ActiveSupport::CachingKeyGenerator, so deriving the keys of every request
+// only runs PBKDF2 once per salt and key size:
+//
+//	kg := &CachingKeyGenerator{KeyGenerator: KeyGenerator{Secret: secretKeyBase}}
+//	key := kg.Generate([]byte("authenticated encrypted cookie"), 32)
+//
+// It is safe for concurrent use, a key requested by several goroutines at
+// once being derived a single time while they wait for it. Each call
+// returns a copy of the cached key, which the caller is free to modify.
+type CachingKeyGenerator struct {
+	// KeyGenerator derives the keys, it must not be changed once the
+	// generator is in use, see Invalidate.
+	KeyGenerator KeyGenerator
+
+	mu   sync.Mutex
+	keys map[cachedKeyID]*cachedKey
+}
+
+type cachedKeyID struct {
+	salt    string
+	keySize int
+}
+
+// cachedKey is a key derived once by the first goroutine requesting it.
+type cachedKey struct {
+	once sync.Once
+	gen  KeyGenerator
+	key  []byte
+}
+
+// Generate returns the key derived from salt, deriving it on the first call.
+// Like KeyGenerator.Generate, it panics if keySize or the Iterations are
+// invalid, nothing being cached then.
+func (c *CachingKeyGenerator) Generate(salt []byte, keySize int) []byte {
+	id := cachedKeyID{salt: string(salt), keySize: keySize}
+	c.mu.Lock()
+	// checked before the key is cached, so the entry of a key which can't
+	// be derived never holds a nil key.
+	if err := c.KeyGenerator.check(keySize); err != nil {
+		c.mu.Unlock()
+		panic(err.Error())
+	}
+	if c.keys == nil {
+		c.keys = map[cachedKeyID]*cachedKey{}
+	}
+	entry, ok := c.keys[id]
+	if !ok {
+		entry = &cachedKey{gen: c.KeyGenerator}
+		c.keys[id] = entry
+	}
+	c.mu.Unlock()
+
+	// the derivation runs outside the lock so the keys for other salts
+	// aren't held up.
+	entry.once.Do(func() { entry.key = entry.gen.Generate(salt, keySize) })
+	return append([]byte(nil), entry.key...)
+}
+
+// Invalidate forgets the cached keys and derives the next ones from secret,
+// for apps rotating their secret_key_base at runtime. The keys being
+// derived concurrently are still derived from the previous secret.
+func (c *CachingKeyGenerator) Invalidate(secret string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.KeyGenerator.Secret = secret
+	c.keys = nil
+}
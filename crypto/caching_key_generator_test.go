@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+const cachingKeyGeneratorSecret = "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"
+
+func TestCachingKeyGenerator(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("a caching key generator", func() {
+		salt := []byte("authenticated encrypted cookie")
+
+		g.It("derives the same keys as its KeyGenerator", func() {
+			kg := &CachingKeyGenerator{KeyGenerator: KeyGenerator{Secret: cachingKeyGeneratorSecret}}
+			key := kg.Generate(salt, 32)
+			g.Assert(key).Eql((&KeyGenerator{Secret: cachingKeyGeneratorSecret}).Generate(salt, 32))
+			g.Assert(kg.Generate(salt, 32)).Eql(key)
+			g.Assert(kg.Generate(salt, 64)[:32]).Eql(key)
+			g.Assert(bytes.Equal(kg.Generate([]byte("signed cookie"), 32), key)).IsFalse()
+			g.Assert(kg.KeyGenerator.Iterations).Eql(0)
+		})
+
+		g.It("derives each key once when hammered from many goroutines", func() {
+			kg := &CachingKeyGenerator{KeyGenerator: KeyGenerator{Secret: cachingKeyGeneratorSecret}}
+			salts := [][]byte{salt, []byte("signed cookie"), []byte("encrypted cookie")}
+			keys := make([][]byte, 100)
+			var wg sync.WaitGroup
+			for i := range keys {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					keys[i] = kg.Generate(salts[i%len(salts)], 32)
+				}(i)
+			}
+			wg.Wait()
+			g.Assert(len(kg.keys)).Eql(len(salts))
+			for i := range keys {
+				g.Assert(keys[i]).Eql(keys[i%len(salts)])
+			}
+		})
+
+		g.It("returns copies of its keys", func() {
+			kg := &CachingKeyGenerator{KeyGenerator: KeyGenerator{Secret: cachingKeyGeneratorSecret}}
+			key := kg.Generate(salt, 32)
+			wipe(key)
+			g.Assert(kg.Generate(salt, 32)).Eql((&KeyGenerator{Secret: cachingKeyGeneratorSecret}).Generate(salt, 32))
+		})
+
+		g.It("doesn't cache the keys it fails to derive", func() {
+			kg := &CachingKeyGenerator{KeyGenerator: KeyGenerator{Secret: cachingKeyGeneratorSecret, Iterations: -1}}
+			for i := 0; i < 2; i++ {
+				func() {
+					defer func() { g.Assert(recover() != nil).IsTrue() }()
+					kg.Generate(salt, 32)
+				}()
+			}
+			func() {
+				defer func() { g.Assert(recover() != nil).IsTrue() }()
+				kg.Generate(salt, 0)
+			}()
+			g.Assert(len(kg.keys)).Eql(0)
+		})
+
+		g.It("can be invalidated to rotate its secret", func() {
+			kg := &CachingKeyGenerator{KeyGenerator: KeyGenerator{Secret: cachingKeyGeneratorSecret}}
+			old := kg.Generate(salt, 32)
+			kg.Invalidate("new secret")
+			g.Assert(kg.Generate(salt, 32)).Eql((&KeyGenerator{Secret: "new secret"}).Generate(salt, 32))
+			g.Assert(bytes.Equal(kg.Generate(salt, 32), old)).IsFalse()
+		})
+	})
+}
+
+func BenchmarkKeyGenerator(b *testing.B) {
+	kg := &KeyGenerator{Secret: cachingKeyGeneratorSecret}
+	for i := 0; i < b.N; i++ {
+		kg.Generate([]byte("authenticated encrypted cookie"), 32)
+	}
+}
+
+func BenchmarkCachingKeyGenerator(b *testing.B) {
+	kg := &CachingKeyGenerator{KeyGenerator: KeyGenerator{Secret: cachingKeyGeneratorSecret}}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			kg.Generate([]byte("authenticated encrypted cookie"), 32)
+		}
+	})
+}
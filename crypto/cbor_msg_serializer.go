@@ -0,0 +1,38 @@
+package crypto
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborEncMode encodes CBOR with the core deterministic encoding of RFC 8949
+// (sorted map keys, smallest integers and lengths...) and times as tagged
+// RFC 3339 strings keeping their nanoseconds.
+var cborEncMode = func() cbor.EncMode {
+	opts := cbor.CoreDetEncOptions()
+	opts.Time = cbor.TimeRFC3339Nano
+	opts.TimeTag = cbor.EncTagRequired
+	mode, err := opts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// CborMsgSerializer serializes values with CBOR, which stores []byte as is
+// where JSON needs base64. The encoding is deterministic so a given value
+// always generates the same message.
+// Struct fields are named by their cbor tag, or their json tag if they don't
+// have one.
+type CborMsgSerializer struct{}
+
+func (s CborMsgSerializer) Serialize(v interface{}) (string, error) {
+	b, err := cborEncMode.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (s CborMsgSerializer) Unserialize(data string, v interface{}) error {
+	return cbor.Unmarshal([]byte(data), v)
+}
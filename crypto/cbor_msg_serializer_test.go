@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestCborMsgSerializer(t *testing.T) {
+	g := Goblin(t)
+	serializer := CborMsgSerializer{}
+
+	type Reading struct {
+		Device  string                    `cbor:"device"`
+		Payload []byte                    `cbor:"payload"`
+		At      time.Time                 `cbor:"at"`
+		Meta    map[string]map[string]int `cbor:"meta"`
+	}
+	at := time.Date(2021, 3, 4, 5, 6, 7, 890, time.UTC)
+	data := Reading{
+		Device:  "sensor-1",
+		Payload: []byte{0, 1, 2, 0xff},
+		At:      at,
+		Meta:    map[string]map[string]int{"b": {"y": 2, "x": 1}, "a": {"z": 3}},
+	}
+
+	g.Describe("a cbor serialized struct", func() {
+		g.It("can be deserialized", func() {
+			output, err := serializer.Serialize(data)
+			g.Assert(err).Eql(nil)
+			var o Reading
+			g.Assert(serializer.Unserialize(output, &o)).Eql(nil)
+			g.Assert(o.Device).Eql(data.Device)
+			g.Assert(o.Payload).Eql(data.Payload)
+			g.Assert(o.At.Equal(at)).IsTrue()
+			g.Assert(o.Meta).Eql(data.Meta)
+		})
+
+		g.It("stores bytes as is", func() {
+			output, err := serializer.Serialize([]byte{0, 1, 2, 0xff})
+			g.Assert(err).Eql(nil)
+			g.Assert(hex.EncodeToString([]byte(output))).Eql("44000102ff")
+		})
+
+		g.It("is deterministic", func() {
+			output, _ := serializer.Serialize(map[string]int{"b": 2, "a": 1, "c": 3})
+			g.Assert(hex.EncodeToString([]byte(output))).Eql("a3616101616202616303")
+
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: serializer}
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			for i := 0; i < 10; i++ {
+				again, err := v.Generate(data)
+				g.Assert(err).Eql(nil)
+				g.Assert(again).Eql(msg)
+			}
+			var o Reading
+			g.Assert(v.Verify(msg, &o)).Eql(nil)
+			g.Assert(o.Payload).Eql(data.Payload)
+		})
+	})
+}
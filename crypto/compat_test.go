@@ -0,0 +1,30 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestRailsCompatibility(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("RailsVectors", func() {
+		g.It("are all read and generated identically", func() {
+			g.Assert(VerifyRailsCompatibility()).Eql(nil)
+		})
+
+		g.It("report the vectors which don't match", func() {
+			vec := RailsVectors[len(RailsVectors)-1]
+			vec.Purpose = "cookie.other"
+			err := vec.Check()
+			g.Assert(errors.Is(err, ErrRailsIncompatible)).IsTrue()
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+
+			vec = RailsVectors[0]
+			vec.Message = vec.Message[:len(vec.Message)-2] + "00"
+			g.Assert(errors.Is(vec.Check(), ErrRailsIncompatible)).IsTrue()
+		})
+	})
+}
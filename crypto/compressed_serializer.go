@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+)
+
+// compressedMagic prefixes the data of a CompressedSerializer. Text
+// serializers never produce a NUL byte so the data they produced before
+// compression was enabled can be told apart.
+const compressedMagic = "\x00gz"
+
+// CompressedSerializer gzips the data of another serializer, which works
+// with MessageVerifier as well as MessageEncryptor and, unlike the
+// encryptor's Compress, doesn't change the messages' format. Data without
+// its magic prefix is passed to the inner serializer as is so the messages
+// generated before compression was enabled keep verifying.
+// Compressing confidential data along with attacker controlled data can leak
+// the former through the length of the messages (see CRIME and BREACH).
+type CompressedSerializer struct {
+	// Inner is the serializer whose data is compressed.
+	Inner MsgSerializer
+	// Level is the gzip compression level, gzip.DefaultCompression if 0.
+	Level int
+	// MaxInflatedLen is the length over which data is rejected with
+	// ErrMessageTooLarge once inflated. It defaults to
+	// DefaultMaxInflatedLen, a negative value disables the limit.
+	MaxInflatedLen int
+}
+
+// NewCompressedSerializer returns a CompressedSerializer compressing the
+// data of inner at the given gzip level, failing with ErrInvalidConfig if
+// inner is nil or the level isn't valid.
+func NewCompressedSerializer(inner MsgSerializer, level int) (CompressedSerializer, error) {
+	if inner == nil {
+		return CompressedSerializer{}, configError("nil serializer")
+	}
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return CompressedSerializer{}, configError("invalid gzip level " + strconv.Itoa(level))
+	}
+	return CompressedSerializer{Inner: inner, Level: level}, nil
+}
+
+func (s CompressedSerializer) Serialize(v interface{}) (string, error) {
+	data, err := serializeWith(s.Inner, v)
+	if err != nil {
+		return "", err
+	}
+	level := s.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	buf.WriteString(compressedMagic)
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return "", err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (s CompressedSerializer) Unserialize(data string, v interface{}) error {
+	if !strings.HasPrefix(data, compressedMagic) {
+		return s.Inner.Unserialize(data, v)
+	}
+	zr, err := gzip.NewReader(strings.NewReader(data[len(compressedMagic):]))
+	if err != nil {
+		return err
+	}
+	inflated, err := readAllLimited(zr, s.MaxInflatedLen)
+	if err != nil {
+		return err
+	}
+	defer wipe(inflated)
+	if ss, ok := s.Inner.(StreamSerializer); ok {
+		return ss.UnserializeFrom(bytes.NewReader(inflated), v)
+	}
+	return s.Inner.Unserialize(string(inflated), v)
+}
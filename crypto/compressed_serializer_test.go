@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"compress/gzip"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestCompressedSerializer(t *testing.T) {
+	g := Goblin(t)
+
+	type Cart struct {
+		Items []string `json:"items"`
+	}
+	data := Cart{Items: make([]string, 100)}
+	for i := range data.Items {
+		data.Items[i] = "sku-0001"
+	}
+
+	g.Describe("a compressed serializer", func() {
+		serializer, err := NewCompressedSerializer(JsonMsgSerializer{}, gzip.BestCompression)
+		g.Assert(err).Eql(nil)
+
+		g.It("round trips", func() {
+			output, err := serializer.Serialize(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(strings.HasPrefix(output, "\x00gz")).IsTrue()
+			var o Cart
+			g.Assert(serializer.Unserialize(output, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+		})
+
+		g.It("shrinks repetitive payloads", func() {
+			output, _ := serializer.Serialize(data)
+			raw, _ := JsonMsgSerializer{}.Serialize(data)
+			g.Assert(len(raw)).Eql(1111)
+			g.Assert(len(output) < len(raw)/10).IsTrue()
+		})
+
+		g.It("reads the legacy payloads", func() {
+			legacy := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: JsonMsgSerializer{}}
+			msg, _ := legacy.Generate(data)
+			v := &MessageVerifier{Secret: legacy.Secret, Serializer: serializer}
+			var o Cart
+			g.Assert(v.Verify(msg, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+
+			compressed, _ := v.Generate(data)
+			g.Assert(len(compressed) < len(msg)/5).IsTrue()
+		})
+
+		g.It("works with encryptors", func() {
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM, Serializer: serializer}
+			msg, err := e.EncryptAndSign(data)
+			g.Assert(err).Eql(nil)
+			var o Cart
+			g.Assert(e.DecryptAndVerify(msg, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+		})
+
+		g.It("rejects decompression bombs", func() {
+			bomb, _ := (CompressedSerializer{Inner: NullMsgSerializer{}}).Serialize(strings.Repeat("a", DefaultMaxInflatedLen+1))
+			g.Assert(len(bomb) < 5000).IsTrue()
+			var o string
+			g.Assert(errors.Is(serializer.Unserialize(bomb, &o), ErrMessageTooLarge)).IsTrue()
+
+			unlimited := CompressedSerializer{Inner: NullMsgSerializer{}, MaxInflatedLen: -1}
+			g.Assert(unlimited.Unserialize(bomb, &o)).Eql(nil)
+			g.Assert(len(o)).Eql(DefaultMaxInflatedLen + 1)
+		})
+	})
+
+	g.Describe("NewCompressedSerializer", func() {
+		g.It("checks its arguments", func() {
+			_, err := NewCompressedSerializer(nil, gzip.DefaultCompression)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			_, err = NewCompressedSerializer(JsonMsgSerializer{}, 42)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+}
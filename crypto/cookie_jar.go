@@ -0,0 +1,164 @@
+package crypto
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SignedCookieJar reads and writes signed cookies over net/http like Rails'
+// cookies.signed, so they round trip with a Rails app sharing the same
+// secret_key_base:
+//
+//	jar, err := NewSignedCookieJar(secretKeyBase, WithRailsVersion("7.0"))
+//	...
+//	err = jar.Set(w, "user_id", 42, http.Cookie{MaxAge: 3600})
+//	...
+//	var userID int
+//	err = jar.Get(r, "user_id", &userID)
+//
+// The cookies are bound to their name and their expiry is embedded in them
+// when set, like Rails 6.0+ does. A SignedCookieJar is safe for concurrent
+// use by multiple goroutines as long as its fields aren't modified once it's
+// in use.
+type SignedCookieJar struct {
+	Verifier *MessageVerifier
+	// LegacyCookies writes and reads the cookies without metadata, like
+	// Rails before 6.0 (or with use_cookies_with_metadata disabled): they
+	// aren't bound to their name and their expiry is only the browser's.
+	LegacyCookies bool
+}
+
+// NewSignedCookieJar returns a SignedCookieJar signing the cookies with the
+// key Rails derives from secretKeyBase, serializing them with a
+// HybridMsgSerializer. LegacyCookies is set if WithRailsVersion sets a
+// version before 6.0.
+func NewSignedCookieJar(secretKeyBase string, opts ...RailsCookieOption) (*SignedCookieJar, error) {
+	o, err := applyRailsCookieOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := deriveRailsCookieKeys(secretKeyBase, o)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedCookieJar{
+		Verifier:      &MessageVerifier{Secret: keys.SignedCookieKey, Serializer: HybridMsgSerializer{}},
+		LegacyCookies: o.legacyCookies(),
+	}, nil
+}
+
+// Get verifies the name cookie of r into dest. It returns http.ErrNoCookie
+// if r doesn't have the cookie, ErrMessageExpired if it expired and an
+// error of the Verifier if it can't be verified, ie: ErrInvalidSignature.
+func (j *SignedCookieJar) Get(r *http.Request, name string, dest interface{}) error {
+	msg, err := cookieMessage(r, name)
+	if err != nil {
+		return err
+	}
+	return j.Verifier.VerifyWithOptions(msg, dest, cookieOptions(name, http.Cookie{}, j.LegacyCookies))
+}
+
+// Set signs value as the name cookie and adds it to w's headers, with the
+// attributes of opts (Path defaults to "/" like Rails). The expiry of opts,
+// MaxAge or Expires, is embedded in the cookie so it can't be extended
+// without resigning it.
+func (j *SignedCookieJar) Set(w http.ResponseWriter, name string, value interface{}, opts http.Cookie) error {
+	msg, err := j.Verifier.GenerateWithOptions(value, cookieOptions(name, opts, j.LegacyCookies))
+	if err != nil {
+		return err
+	}
+	setCookie(w, name, msg, opts)
+	return nil
+}
+
+// EncryptedCookieJar reads and writes encrypted cookies over net/http like
+// Rails' cookies.encrypted, see SignedCookieJar.
+type EncryptedCookieJar struct {
+	Encryptor *MessageEncryptor
+	// LegacyCookies writes and reads the cookies without metadata, see
+	// SignedCookieJar.
+	LegacyCookies bool
+}
+
+// NewEncryptedCookieJar returns an EncryptedCookieJar encrypting the cookies
+// like Rails does with secretKeyBase (with aes-256-gcm since 5.2,
+// aes-256-cbc before), serializing them with a HybridMsgSerializer.
+// LegacyCookies is set if WithRailsVersion sets a version before 6.0.
+func NewEncryptedCookieJar(secretKeyBase string, opts ...RailsCookieOption) (*EncryptedCookieJar, error) {
+	o, err := applyRailsCookieOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := deriveRailsCookieKeys(secretKeyBase, o)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedCookieJar{
+		Encryptor:     railsCookieEncryptor(keys, o, HybridMsgSerializer{}),
+		LegacyCookies: o.legacyCookies(),
+	}, nil
+}
+
+// Get decrypts the name cookie of r into dest. It returns http.ErrNoCookie
+// if r doesn't have the cookie, ErrMessageExpired if it expired and an
+// error of the Encryptor if it can't be decrypted, ie: ErrInvalidMessage.
+func (j *EncryptedCookieJar) Get(r *http.Request, name string, dest interface{}) error {
+	msg, err := cookieMessage(r, name)
+	if err != nil {
+		return err
+	}
+	return j.Encryptor.DecryptAndVerifyWithOptions(msg, dest, cookieOptions(name, http.Cookie{}, j.LegacyCookies))
+}
+
+// Set encrypts value as the name cookie and adds it to w's headers, see
+// SignedCookieJar.Set.
+func (j *EncryptedCookieJar) Set(w http.ResponseWriter, name string, value interface{}, opts http.Cookie) error {
+	msg, err := j.Encryptor.EncryptAndSignWithOptions(value, cookieOptions(name, opts, j.LegacyCookies))
+	if err != nil {
+		return err
+	}
+	setCookie(w, name, msg, opts)
+	return nil
+}
+
+// legacyCookies reports whether the version was set to one before Rails
+// 6.0, whose cookies don't have metadata.
+func (o railsCookieOptions) legacyCookies() bool {
+	return o.versioned && !o.atLeast(6, 0)
+}
+
+// cookieOptions returns the metadata of the name cookie set with the
+// attributes of c.
+func cookieOptions(name string, c http.Cookie, legacy bool) MessageOptions {
+	if legacy {
+		return MessageOptions{}
+	}
+	opts := MessageOptions{Purpose: "cookie." + name}
+	switch {
+	case c.MaxAge > 0:
+		opts.ExpiresIn = time.Duration(c.MaxAge) * time.Second
+	case !c.Expires.IsZero():
+		opts.ExpiresAt = c.Expires
+	}
+	return opts
+}
+
+// cookieMessage returns the unescaped value of the name cookie of r.
+func cookieMessage(r *http.Request, name string) (string, error) {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return unescapeCookie(c.Value)
+}
+
+func setCookie(w http.ResponseWriter, name, msg string, opts http.Cookie) {
+	c := opts
+	c.Name = name
+	c.Value = url.QueryEscape(msg)
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	http.SetCookie(w, &c)
+}
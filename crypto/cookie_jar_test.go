@@ -0,0 +1,159 @@
+package crypto
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+// cookieJar is implemented by both jars.
+type cookieJar interface {
+	Get(r *http.Request, name string, dest interface{}) error
+	Set(w http.ResponseWriter, name string, value interface{}, opts http.Cookie) error
+}
+
+// jarRoundTrip sets value as the name cookie in a response and returns a
+// request sending the cookies back.
+func jarRoundTrip(jar cookieJar, name string, value interface{}, opts http.Cookie) (*http.Request, error) {
+	rec := httptest.NewRecorder()
+	if err := jar.Set(rec, name, value, opts); err != nil {
+		return nil, err
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r, nil
+}
+
+func TestCookieJar(t *testing.T) {
+	g := Goblin(t)
+	secret := sessionFixtureSecretKeyBase
+
+	g.Describe("Cookie jars", func() {
+		signed, _ := NewSignedCookieJar(secret)
+		encrypted, _ := NewEncryptedCookieJar(secret)
+		jars := map[string]cookieJar{"signed": signed, "encrypted": encrypted}
+
+		g.It("get the cookies they set", func() {
+			for _, jar := range jars {
+				r, err := jarRoundTrip(jar, "user_id", 42, http.Cookie{HttpOnly: true})
+				g.Assert(err).Eql(nil)
+				var userID int
+				g.Assert(jar.Get(r, "user_id", &userID)).Eql(nil)
+				g.Assert(userID).Eql(42)
+
+				var missing int
+				g.Assert(jar.Get(r, "other", &missing)).Eql(http.ErrNoCookie)
+			}
+		})
+
+		g.It("set the cookies' attributes", func() {
+			rec := httptest.NewRecorder()
+			g.Assert(signed.Set(rec, "user_id", 42, http.Cookie{Name: "ignored", Domain: "example.com", Secure: true, MaxAge: 60})).Eql(nil)
+			c := rec.Result().Cookies()[0]
+			g.Assert(c.Name).Eql("user_id")
+			g.Assert(c.Path).Eql("/")
+			g.Assert(c.Domain).Eql("example.com")
+			g.Assert(c.Secure).IsTrue()
+			g.Assert(c.MaxAge).Eql(60)
+		})
+
+		g.It("bind the cookies to their name like Rails 6.0+", func() {
+			for _, jar := range jars {
+				r, _ := jarRoundTrip(jar, "user_id", 42, http.Cookie{})
+				c, _ := r.Cookie("user_id")
+				r = httptest.NewRequest("GET", "/", nil)
+				r.AddCookie(&http.Cookie{Name: "admin_id", Value: c.Value})
+				var id int
+				g.Assert(errors.Is(jar.Get(r, "admin_id", &id), ErrInvalidPurpose)).IsTrue()
+			}
+
+			// like Rails' cookies.signed[:user_id] = 42
+			keys, _ := DeriveRailsCookieKeys(secret)
+			v := &MessageVerifier{Secret: keys.SignedCookieKey, Serializer: JsonMsgSerializer{}}
+			msg, _ := v.GenerateWithOptions(42, MessageOptions{Purpose: "cookie.user_id"})
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(&http.Cookie{Name: "user_id", Value: url.QueryEscape(msg)})
+			var userID int
+			g.Assert(signed.Get(r, "user_id", &userID)).Eql(nil)
+			g.Assert(userID).Eql(42)
+		})
+
+		g.It("read and write the cookies of Rails before 6.0", func() {
+			legacy, err := NewEncryptedCookieJar(secret, WithRailsVersion("4.2"))
+			g.Assert(err).Eql(nil)
+			g.Assert(legacy.LegacyCookies).IsTrue()
+			g.Assert(legacy.Encryptor.Cipher).Eql(AESCBC)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(&http.Cookie{Name: "_myapp_session", Value: cbcSessionCookie42JSON})
+			var session SessionHash
+			g.Assert(legacy.Get(r, "_myapp_session", &session)).Eql(nil)
+			id, _ := session.GetString("session_id")
+			g.Assert(id).Eql(sessionFixtureID)
+
+			signedLegacy, _ := NewSignedCookieJar(secret, WithRailsVersion("5.2"))
+			g.Assert(signedLegacy.LegacyCookies).IsTrue()
+			r, _ = jarRoundTrip(signedLegacy, "user_id", 42, http.Cookie{MaxAge: 60})
+			c, _ := r.Cookie("user_id")
+			msg, _ := url.QueryUnescape(c.Value)
+			var userID int
+			g.Assert(signedLegacy.Verifier.Verify(msg, &userID)).Eql(nil)
+			g.Assert(userID).Eql(42)
+		})
+
+		g.It("reject tampered cookies", func() {
+			for kind, jar := range jars {
+				r, _ := jarRoundTrip(jar, "user_id", 42, http.Cookie{})
+				c, _ := r.Cookie("user_id")
+				r = httptest.NewRequest("GET", "/", nil)
+				r.AddCookie(&http.Cookie{Name: "user_id", Value: "x" + c.Value})
+				var id int
+				err := jar.Get(r, "user_id", &id)
+				if kind == "signed" {
+					g.Assert(err).Eql(ErrInvalidSignature)
+				} else {
+					g.Assert(err != nil).IsTrue()
+				}
+				g.Assert(id).Eql(0)
+			}
+		})
+
+		g.It("reject expired cookies", func() {
+			now := time.Now()
+			clock := func() time.Time { return now }
+			signed, _ := NewSignedCookieJar(secret)
+			signed.Verifier.Now = clock
+			encrypted, _ := NewEncryptedCookieJar(secret)
+			encrypted.Encryptor.Now = clock
+			for _, jar := range []cookieJar{signed, encrypted} {
+				r, _ := jarRoundTrip(jar, "max_age", 42, http.Cookie{MaxAge: 60})
+				jarR, _ := jarRoundTrip(jar, "expires", 42, http.Cookie{Expires: now.Add(time.Hour)})
+				c, _ := jarR.Cookie("expires")
+				r.AddCookie(c)
+
+				var id int
+				now = now.Add(59 * time.Second)
+				g.Assert(jar.Get(r, "max_age", &id)).Eql(nil)
+				now = now.Add(time.Second)
+				g.Assert(jar.Get(r, "max_age", &id)).Eql(ErrMessageExpired)
+				g.Assert(jar.Get(r, "expires", &id)).Eql(nil)
+				now = now.Add(time.Hour)
+				g.Assert(jar.Get(r, "expires", &id)).Eql(ErrMessageExpired)
+				now = time.Now()
+			}
+		})
+
+		g.It("fail to be created without a secret", func() {
+			_, err := NewSignedCookieJar("")
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			_, err = NewEncryptedCookieJar(secret, WithRailsVersion("x"))
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+}
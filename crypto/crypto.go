@@ -1,8 +1,14 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 type MsgSerializer interface {
@@ -10,6 +16,112 @@ type MsgSerializer interface {
 	Unserialize(data string, v interface{}) error
 }
 
+// StreamSerializer is an optional interface for the serializers able to
+// write to and read from streams, which MessageVerifier and MessageEncryptor
+// prefer as it saves copying the payloads to strings. It must produce the
+// same data as the serializer's Serialize method.
+type StreamSerializer interface {
+	SerializeTo(w io.Writer, v interface{}) error
+	UnserializeFrom(r io.Reader, v interface{}) error
+}
+
+// serializeWith serializes v with s, using its StreamSerializer methods if
+// it has some.
+func serializeWith(s MsgSerializer, v interface{}) ([]byte, error) {
+	ss, ok := s.(StreamSerializer)
+	if !ok {
+		data, err := s.Serialize(v)
+		return []byte(data), err
+	}
+	var buf bytes.Buffer
+	if err := ss.SerializeTo(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unserializeWith unserializes data into v with s, using its
+// StreamSerializer methods if it has some. Failures are reported with an
+// UnserializeError previewing up to previewLen bytes of data.
+func unserializeWith(s MsgSerializer, data []byte, v interface{}, previewLen int) error {
+	var err error
+	if ss, ok := s.(StreamSerializer); ok {
+		err = ss.UnserializeFrom(bytes.NewReader(data), v)
+	} else {
+		err = s.Unserialize(string(data), v)
+	}
+	if err == nil {
+		return nil
+	}
+	name := serializerName(s)
+	if name == "" {
+		name = fmt.Sprintf("%T", s)
+	}
+	return &UnserializeError{Serializer: name, Preview: payloadPreview(data, previewLen), Err: err}
+}
+
+// DefaultErrorPreviewLen is the number of bytes of the payloads
+// UnserializeError previews by default.
+const DefaultErrorPreviewLen = 64
+
+// UnserializeError is returned, and can be retrieved with errors.As, when a
+// message is authentic but its payload can't be unserialized, ie: when its
+// schema changed between two versions of an app.
+type UnserializeError struct {
+	// Serializer is the name of the serializer ("json", "xml"...), or its
+	// type for custom serializers.
+	Serializer string
+	// Preview is the beginning of the payload, quoted with its binary and
+	// non printable bytes escaped, or empty if disabled.
+	Preview string
+	// Err is the error returned by the serializer.
+	Err error
+}
+
+func (e *UnserializeError) Error() string {
+	msg := "Unserialize failed (" + e.Serializer + ") - " + e.Err.Error()
+	if e.Preview != "" {
+		msg += " - payload: " + e.Preview
+	}
+	return msg
+}
+
+func (e *UnserializeError) Unwrap() error { return e.Err }
+
+// payloadPreview returns the first n bytes of data quoted, followed by an
+// ellipsis if data is longer, or nothing if n isn't positive.
+func payloadPreview(data []byte, n int) string {
+	if n <= 0 || len(data) == 0 {
+		return ""
+	}
+	if len(data) <= n {
+		return strconv.Quote(string(data))
+	}
+	return strconv.Quote(string(data[:n])) + "..."
+}
+
+// Signer is implemented by types generating tamper proof messages, such as
+// MessageVerifier and MessageEncryptor. Code generating messages can depend on
+// it instead of a concrete type to make it easy to swap implementations or to
+// use a FakeVerifier in tests.
+type Signer interface {
+	Generate(value interface{}) (string, error)
+}
+
+// Verifier is implemented by types checking messages generated by a Signer
+// and extracting their content in the target, such as MessageVerifier and
+// MessageEncryptor.
+type Verifier interface {
+	Verify(msg string, target interface{}) error
+}
+
+var (
+	_ Signer   = (*MessageVerifier)(nil)
+	_ Verifier = (*MessageVerifier)(nil)
+	_ Signer   = (*MessageEncryptor)(nil)
+	_ Verifier = (*MessageEncryptor)(nil)
+)
+
 // Generates a random key of the passed length.
 // As a reminder, for AES keys of length 16, 24, or 32 bytes are expected for AES-128, AES-192, or AES-256.
 func GenerateRandomKey(strength int) []byte {
@@ -19,3 +131,76 @@ func GenerateRandomKey(strength int) []byte {
 	}
 	return k
 }
+
+// decodeBase64 decodes data encoded with the standard padded base64
+// alphabet or with the URL safe one without padding, the encodings messages
+// are generated with here and by Rails. The newline wrapped base64 of
+// Ruby's Base64.encode64, which older Rails versions used, is accepted too.
+// Any other padding or whitespace is rejected, so that a message has a
+// single encoding and can't be altered without changing its bytes.
+func decodeBase64(data string) ([]byte, error) {
+	if strings.Contains(data, "\n") {
+		return decodeWrappedBase64(data)
+	}
+	// a standard base64 string without '+', '/' or padding decodes the same
+	// with the URL safe alphabet.
+	if strings.ContainsAny(data, "+/=") {
+		return base64.StdEncoding.Strict().DecodeString(data)
+	}
+	return base64.RawURLEncoding.Strict().DecodeString(data)
+}
+
+// encode64LineLen is the length of the lines of Ruby's Base64.encode64.
+const encode64LineLen = 60
+
+// decodeWrappedBase64 decodes the output of Ruby's Base64.encode64: padded
+// standard base64 whose lines are 60 characters long, each followed by a
+// newline.
+func decodeWrappedBase64(data string) ([]byte, error) {
+	b, err := base64.StdEncoding.Strict().DecodeString(strings.ReplaceAll(data, "\n", ""))
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(b)
+	var wrapped strings.Builder
+	for len(encoded) > encode64LineLen {
+		wrapped.WriteString(encoded[:encode64LineLen] + "\n")
+		encoded = encoded[encode64LineLen:]
+	}
+	wrapped.WriteString(encoded + "\n")
+	if wrapped.String() != data {
+		return nil, errors.New("illegal base64 line wrapping")
+	}
+	return b, nil
+}
+
+// splitEncryptedMessage splits an encrypted message into its decoded parts:
+// its data followed by parts of the passed sizes, all joined by "--". Like
+// Rails 7.1 does, the trailing parts are split off by their encoded length
+// since the separator can occur in URL safe base64. Each part can use
+// either alphabet.
+func splitEncryptedMessage(msg string, sizes ...int) ([][]byte, bool) {
+	parts := make([][]byte, len(sizes)+1)
+	for i := len(sizes) - 1; i >= 0; i-- {
+		var part string
+		// unpadded first: there is no '-' in the standard alphabet for the
+		// shorter length to match within a padded part.
+		for _, n := range []int{base64.RawStdEncoding.EncodedLen(sizes[i]), base64.StdEncoding.EncodedLen(sizes[i])} {
+			if len(msg) >= n+2 && msg[len(msg)-n-2:len(msg)-n] == "--" {
+				part, msg = msg[len(msg)-n:], msg[:len(msg)-n-2]
+				break
+			}
+		}
+		b, err := decodeBase64(part)
+		if part == "" || err != nil || len(b) != sizes[i] {
+			return nil, false
+		}
+		parts[i+1] = b
+	}
+	data, err := decodeBase64(msg)
+	if err != nil {
+		return nil, false
+	}
+	parts[0] = data
+	return parts, true
+}
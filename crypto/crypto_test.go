@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestUnserializeError(t *testing.T) {
+	g := Goblin(t)
+
+	type Person struct {
+		Id   int    `json:"id" xml:"id"`
+		Name string `json:"name" xml:"name"`
+	}
+
+	g.Describe("UnserializeError", func() {
+		g.It("wraps the JSON failures", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: JsonMsgSerializer{}}
+			msg, _ := v.Generate(map[string]string{"id": "13"})
+			var o Person
+			err := v.Verify(msg, &o)
+			var uerr *UnserializeError
+			g.Assert(errors.As(err, &uerr)).IsTrue()
+			g.Assert(uerr.Serializer).Eql("json")
+			g.Assert(uerr.Preview).Eql(`"{\"id\":\"13\"}"`)
+			var typeErr *json.UnmarshalTypeError
+			g.Assert(errors.As(err, &typeErr)).IsTrue()
+			g.Assert(typeErr.Field).Eql("id")
+			g.Assert(strings.HasSuffix(err.Error(), ` - payload: "{\"id\":\"13\"}"`)).IsTrue()
+		})
+
+		g.It("wraps the XML failures", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: XMLMsgSerializer{}}
+			msg, _ := v.GenerateRaw([]byte("<Person><id>nope</id>" + strings.Repeat("<name>John</name>", 10) + "</Person>"))
+			var o Person
+			err := v.Verify(msg, &o)
+			var uerr *UnserializeError
+			g.Assert(errors.As(err, &uerr)).IsTrue()
+			g.Assert(uerr.Serializer).Eql("xml")
+			g.Assert(uerr.Preview).Eql(`"<Person><id>nope</id><name>John</name><name>John</name><name>Joh"...`)
+		})
+
+		g.It("escapes and truncates the preview", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: JsonMsgSerializer{}, ErrorPreviewLen: 4}
+			msg, _ := v.GenerateRaw([]byte("\x00\xff\n\"rest of the payload"))
+			var o Person
+			var uerr *UnserializeError
+			g.Assert(errors.As(v.Verify(msg, &o), &uerr)).IsTrue()
+			g.Assert(uerr.Preview).Eql(`"\x00\xff\n\""...`)
+
+			v.ErrorPreviewLen = -1
+			g.Assert(errors.As(v.Verify(msg, &o), &uerr)).IsTrue()
+			g.Assert(uerr.Preview).Eql("")
+			g.Assert(strings.Contains(uerr.Error(), "payload")).IsFalse()
+		})
+
+		g.It("doesn't preview decrypted payloads unless asked to", func() {
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+			msg, _ := e.EncryptAndSign("secret")
+			var o Person
+			var uerr *UnserializeError
+			g.Assert(errors.As(e.DecryptAndVerify(msg, &o), &uerr)).IsTrue()
+			g.Assert(uerr.Preview).Eql("")
+
+			e.ErrorPreviewLen = 64
+			g.Assert(errors.As(e.DecryptAndVerify(msg, &o), &uerr)).IsTrue()
+			g.Assert(uerr.Preview).Eql(`"\"secret\""`)
+		})
+
+		g.It("names custom serializers by their type", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: stringSerializer{JsonMsgSerializer{}}}
+			msg, _ := v.GenerateRaw([]byte("{"))
+			var o Person
+			var uerr *UnserializeError
+			g.Assert(errors.As(v.Verify(msg, &o), &uerr)).IsTrue()
+			g.Assert(uerr.Serializer).Eql("crypto.stringSerializer")
+		})
+	})
+}
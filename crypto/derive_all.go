@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// DeriveAll derives the keys of keyLen bytes of the passed salts from
+// secretKeyBase, like a KeyGenerator with Rails' defaults, and returns them
+// by salt. The derivations are run in parallel across GOMAXPROCS goroutines,
+// which shortens the startup of apps setting up many verifiers and
+// encryptors. See DeriveAllFrom to use another KeyDeriver.
+func DeriveAll(secretKeyBase string, salts []string, keyLen int) (map[string][]byte, error) {
+	return DeriveAllContext(context.Background(), secretKeyBase, salts, keyLen)
+}
+
+// DeriveAllContext is like DeriveAll but stops deriving keys and returns the
+// context's error once ctx is done.
+func DeriveAllContext(ctx context.Context, secretKeyBase string, salts []string, keyLen int) (map[string][]byte, error) {
+	return DeriveAllFrom(ctx, &KeyGenerator{Secret: secretKeyBase}, salts, keyLen)
+}
+
+// DeriveAllFrom is like DeriveAllContext with the keys derived by kd, which
+// must be safe for concurrent use.
+// The salts whose derivation failed (ie: kd panicked on invalid parameters)
+// are reported by index in a *BatchError, in which case no key is returned.
+// It fails with ErrInvalidKeyLength if keyLen isn't positive.
+func DeriveAllFrom(ctx context.Context, kd KeyDeriver, salts []string, keyLen int) (map[string][]byte, error) {
+	if kd == nil {
+		return nil, configError("nil key deriver")
+	}
+	if keyLen < 1 {
+		return nil, keySizeError(keyLen)
+	}
+
+	// the salts are derived once, the duplicates reusing the first result.
+	first := make(map[string]int, len(salts))
+	var todo []int
+	for i, salt := range salts {
+		if _, ok := first[salt]; !ok {
+			first[salt] = i
+			todo = append(todo, i)
+		}
+	}
+	keys := make([][]byte, len(salts))
+	errs := make([]error, len(salts))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(todo) {
+		workers = len(todo)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if ctx.Err() != nil {
+					continue
+				}
+				keys[i], errs[i] = deriveKey(kd, salts[i], keyLen)
+			}
+		}()
+	}
+feed:
+	for _, i := range todo {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, salt := range salts {
+		errs[i] = errs[first[salt]]
+	}
+	if err := batchError(errs); err != nil {
+		return nil, err
+	}
+	derived := make(map[string][]byte, len(todo))
+	for _, i := range todo {
+		derived[salts[i]] = keys[i]
+	}
+	return derived, nil
+}
+
+// deriveKey derives a key, turning a panic of kd into an error since it
+// can't be recovered from the caller's goroutine.
+func deriveKey(kd KeyDeriver, salt string, keyLen int) (key []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &messageError{msg: fmt.Sprintf("Key derivation failed - salt %q: %v", salt, r)}
+		}
+	}()
+	return kd.Generate([]byte(salt), keyLen), nil
+}
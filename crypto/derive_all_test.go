@@ -0,0 +1,114 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+// cancelingDeriver cancels its context once it derived after keys and
+// counts the keys it derived.
+type cancelingDeriver struct {
+	KeyDeriver
+	after  int
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	calls  int
+}
+
+func (d *cancelingDeriver) Generate(salt []byte, keySize int) []byte {
+	d.mu.Lock()
+	d.calls++
+	if d.calls == d.after {
+		d.cancel()
+	}
+	d.mu.Unlock()
+	return d.KeyDeriver.Generate(salt, keySize)
+}
+
+func TestDeriveAll(t *testing.T) {
+	g := Goblin(t)
+	secret := "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd"
+	salts := []string{SignedCookieSalt, EncryptedCookieSalt, EncryptedSignedCookieSalt, AuthenticatedEncryptedCookieSalt, "active_storage", SignedIDSalt}
+
+	g.Describe("DeriveAll", func() {
+		g.It("derives the keys of the salts like a KeyGenerator", func() {
+			keys, err := DeriveAll(secret, salts, 64)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(keys)).Eql(len(salts))
+			kg := KeyGenerator{Secret: secret}
+			for _, salt := range salts {
+				g.Assert(keys[salt]).Eql(kg.Generate([]byte(salt), 64))
+			}
+		})
+
+		g.It("derives duplicate salts once", func() {
+			d := &cancelingDeriver{KeyDeriver: &KeyGenerator{Secret: secret}, cancel: func() {}}
+			keys, err := DeriveAllFrom(context.Background(), d, []string{"a", "b", "a"}, 32)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(keys)).Eql(2)
+			g.Assert(d.calls).Eql(2)
+		})
+
+		g.It("returns an empty map without salts", func() {
+			keys, err := DeriveAll(secret, nil, 64)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(keys)).Eql(0)
+		})
+
+		g.It("rejects invalid key lengths", func() {
+			_, err := DeriveAll(secret, salts, 0)
+			g.Assert(errors.Is(err, ErrInvalidKeyLength)).IsTrue()
+			_, err = DeriveAllFrom(context.Background(), nil, salts, 64)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+
+		g.It("reports the failed derivations by index", func() {
+			kd := &ScryptKeyGenerator{Secret: secret, N: 3}
+			_, err := DeriveAllFrom(context.Background(), kd, []string{"a", "b", "a"}, 32)
+			var batchErr *BatchError
+			g.Assert(errors.As(err, &batchErr)).IsTrue()
+			g.Assert(len(batchErr.Errors)).Eql(3)
+			for _, err := range batchErr.Errors {
+				g.Assert(err != nil).IsTrue()
+			}
+			g.Assert(strings.HasPrefix(batchErr.Errors[2].Error(), `Key derivation failed - salt "a": `)).IsTrue()
+		})
+
+		g.It("stops once the context is canceled", func() {
+			many := make([]string, 200)
+			for i := range many {
+				many[i] = "salt " + strconv.Itoa(i)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			d := &cancelingDeriver{KeyDeriver: &KeyGenerator{Secret: secret}, after: 2, cancel: cancel}
+			keys, err := DeriveAllFrom(ctx, d, many, 64)
+			g.Assert(err).Eql(context.Canceled)
+			g.Assert(keys == nil).IsTrue()
+			g.Assert(d.calls < len(many)/2).IsTrue()
+		})
+	})
+}
+
+var deriveAllSalts = []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+func BenchmarkDeriveSerially(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		kg := KeyGenerator{Secret: "secret"}
+		for _, salt := range deriveAllSalts {
+			kg.Generate([]byte(salt), 64)
+		}
+	}
+}
+
+func BenchmarkDeriveAll(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		DeriveAll("secret", deriveAllSalts, 64)
+	}
+}
@@ -0,0 +1,183 @@
+package crypto
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeviseRememberCookie is the value of the signed remember_<scope>_token
+// cookie Devise's Rememberable sets for "remember me", serialized by Devise
+// as `[[id], rememberable_value, generated_at]`:
+//
+//	jar, err := NewSignedCookieJar(secretKeyBase, WithRailsVersion("7.0"))
+//	...
+//	c, err := ReadDeviseRememberCookie(jar, r, "user")
+//	...
+//	user := findUser(c.UserID)
+//	if !c.RememberMe(user.AuthenticatableSalt(), user.RememberCreatedAt, 2*7*24*time.Hour, time.Now()) {
+//		...
+//	}
+//
+// The cookies set by Devise before 3.5 don't have the generated_at
+// timestamp and the ones set with the Marshal cookies serializer until 4.1
+// have it as a Time rather than a string, both are read.
+type DeviseRememberCookie struct {
+	// UserID is the id of the record, numeric ids are in their decimal
+	// form.
+	UserID string
+	// Salt is the record's rememberable_value: its remember_token or the
+	// first 29 characters of its encrypted_password (authenticatable_salt).
+	Salt string
+	// GeneratedAt is when the cookie was set, it is zero for the cookies
+	// of Devise before 3.5.
+	GeneratedAt time.Time
+}
+
+// DeviseRememberCookieName returns the name of the remember cookie of the
+// Devise scope, ie: remember_user_token for "user".
+func DeviseRememberCookieName(scope string) string {
+	return "remember_" + scope + "_token"
+}
+
+// ReadDeviseRememberCookie verifies and decodes the remember cookie of the
+// Devise scope of r, with either the JSON or the Marshal cookies serializer
+// if the jar's Verifier uses a HybridMsgSerializer. It returns the errors of
+// SignedCookieJar.Get, and one matching ErrMalformedMessage if the cookie
+// isn't a remember cookie.
+func ReadDeviseRememberCookie(jar *SignedCookieJar, r *http.Request, scope string) (DeviseRememberCookie, error) {
+	var payload interface{}
+	if err := jar.Get(r, DeviseRememberCookieName(scope), &payload); err != nil {
+		return DeviseRememberCookie{}, err
+	}
+	return parseDeviseRememberCookie(payload)
+}
+
+// SetDeviseRememberCookie signs c as the remember cookie of the Devise scope
+// like Devise's remember_me does, with the attributes of opts (HttpOnly is
+// always set). GeneratedAt defaults to the current time of the jar's
+// Verifier, and the UserIDs which are integers are written as such so the
+// cookie is the same as Devise's for integer primary keys.
+// opts should expire the cookie when Devise would, remember_for after the
+// record's remember_created_at.
+func SetDeviseRememberCookie(jar *SignedCookieJar, w http.ResponseWriter, scope string, c DeviseRememberCookie, opts http.Cookie) error {
+	if c.GeneratedAt.IsZero() {
+		c.GeneratedAt = jar.Verifier.now()
+	}
+	var id interface{} = c.UserID
+	if n, err := strconv.ParseInt(c.UserID, 10, 64); err == nil {
+		id = n
+	}
+	opts.HttpOnly = true
+	payload := []interface{}{[]interface{}{id}, c.Salt, deviseTimestamp(c.GeneratedAt)}
+	return jar.Set(w, DeviseRememberCookieName(scope), payload, opts)
+}
+
+// RememberMe reports whether the cookie still remembers the record whose
+// rememberable_value, remember_created_at and remember_for are passed, like
+// Devise's remember_me?: the cookie must have been generated after
+// rememberCreatedAt (now if zero), less than rememberFor ago, and its Salt
+// must be rememberableValue.
+func (c DeviseRememberCookie) RememberMe(rememberableValue string, rememberCreatedAt time.Time, rememberFor time.Duration, now time.Time) bool {
+	if c.GeneratedAt.IsZero() {
+		return false
+	}
+	if rememberCreatedAt.IsZero() {
+		rememberCreatedAt = now
+	}
+	return c.GeneratedAt.After(now.Add(-rememberFor)) &&
+		c.GeneratedAt.After(rememberCreatedAt) &&
+		SecureCompare(c.Salt, rememberableValue)
+}
+
+// parseDeviseRememberCookie reads the `[[id], salt, generated_at]` payload
+// of a remember cookie, generated_at being optional.
+func parseDeviseRememberCookie(payload interface{}) (DeviseRememberCookie, error) {
+	var c DeviseRememberCookie
+	values, _ := payload.([]interface{})
+	if len(values) != 2 && len(values) != 3 {
+		return c, invalidRememberCookie("not [[id], salt, generated_at]")
+	}
+	key, _ := values[0].([]interface{})
+	if len(key) != 1 {
+		return c, invalidRememberCookie("bad id")
+	}
+	switch id := key[0].(type) {
+	case string:
+		c.UserID = id
+	case int64:
+		c.UserID = strconv.FormatInt(id, 10)
+	case float64:
+		c.UserID = strconv.FormatFloat(id, 'f', -1, 64)
+	case json.Number:
+		c.UserID = id.String()
+	default:
+		return c, invalidRememberCookie("bad id")
+	}
+	var ok bool
+	if c.Salt, ok = values[1].(string); !ok {
+		return c, invalidRememberCookie("bad salt")
+	}
+	if len(values) == 2 {
+		return c, nil
+	}
+	switch t := values[2].(type) {
+	case time.Time:
+		c.GeneratedAt = t
+	case string:
+		if c.GeneratedAt, ok = parseDeviseTimestamp(t); !ok {
+			return c, invalidRememberCookie("bad generated_at " + strconv.Quote(t))
+		}
+	default:
+		return c, invalidRememberCookie("bad generated_at")
+	}
+	return c, nil
+}
+
+func invalidRememberCookie(reason string) error {
+	return &messageError{msg: "Invalid remember cookie - " + reason, kind: ErrMalformedMessage}
+}
+
+// deviseTimestamp formats t like Devise does, as the String of the Float
+// `Time.now.utc.to_f`.
+func deviseTimestamp(t time.Time) string {
+	s := strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// parseDeviseTimestamp parses the generated_at of a cookie like Devise's
+// time_from_json: the Float timestamps it writes, or the ISO 8601 times the
+// JSON serializer turned the Times into. The timestamps are parsed exactly
+// rather than through a float.
+func parseDeviseTimestamp(s string) (time.Time, bool) {
+	if sec, frac, found := strings.Cut(s, "."); found && isDigits(sec) && isDigits(frac) {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		n, err := strconv.ParseInt(sec, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		nsec, _ := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		return time.Unix(n, nsec).UTC(), true
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package crypto
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+// The remember_user_token cookies of a Rails 7.0 app (signed cookies with
+// the default digests, expiring at deviseFixtureExpires) for user 42 with
+// deviseFixtureSalt. They were computed following Devise's and Rails'
+// algorithms with python rather than by this package.
+const (
+	deviseFixtureSecretKeyBase = "c7f8a2d9e1b04f6a8d3e5c2b7a9f1e0d4c6b8a2f3e5d7c9b1a0f2e4d6c8b0a1f3e5d7c9b1a0f2e4d6c8b0a1f3e5d7c9b1a0f2e4d6c8b0a1f3e5d7c9b1a0f2e4"
+	deviseFixtureSalt          = "$2a$12$Kz9mQ3vX7pL2nR8sT4wY1u"
+	// [[42], salt, "1717200000.123456"] with the JSON serializer.
+	deviseJSONCookie = "eyJfcmFpbHMiOnsibWVzc2FnZSI6IlcxczBNbDBzSWlReVlTUXhNaVJMZWpsdFVUTjJXRGR3VERKdVVqaHpWRFIzV1RGMUlpd2lNVGN4TnpJd01EQXdNQzR4TWpNME5UWWlYUT09IiwiZXhwIjoiMjAyNC0wNi0xNVQwMDowMDowMC4xMjNaIiwicHVyIjoiY29va2llLnJlbWVtYmVyX3VzZXJfdG9rZW4ifX0%3D--c9441e4f24b786f799c0aaeb298272ed4f5c955b"
+	// the same with the Marshal serializer.
+	deviseMarshalCookie = "eyJfcmFpbHMiOnsibWVzc2FnZSI6IkJBaGJDRnNHYVM5SklpSWtNbUVrTVRJa1MzbzViVkV6ZGxnM2NFd3libEk0YzFRMGQxa3hkUVk2QmtWVVNTSVdNVGN4TnpJd01EQXdNQzR4TWpNME5UWUdPd0JVIiwiZXhwIjoiMjAyNC0wNi0xNVQwMDowMDowMC4xMjNaIiwicHVyIjoiY29va2llLnJlbWVtYmVyX3VzZXJfdG9rZW4ifX0%3D--b39affef4bcde4fef77bcb569924d4f970060515"
+	// generated_at as a Marshal Time, like Devise before 4.1.
+	deviseMarshalTimeCookie = "eyJfcmFpbHMiOnsibWVzc2FnZSI6IkJBaGJDRnNHYVM5SklpSWtNbUVrTVRJa1MzbzViVkV6ZGxnM2NFd3libEk0YzFRMGQxa3hkUVk2QmtWVVNYVTZDVlJwYldVTklCUWZ3RURpQVFBR09nbDZiMjVsU1NJSVZWUkRCanNBUmc9PSIsImV4cCI6IjIwMjQtMDYtMTVUMDA6MDA6MDAuMTIzWiIsInB1ciI6ImNvb2tpZS5yZW1lbWJlcl91c2VyX3Rva2VuIn19--e36d2c2f2ed330daeb5be1e3458194fb24f15855"
+	// [[42], salt] without generated_at, like Devise before 3.5.
+	deviseNoTimestampCookie = "eyJfcmFpbHMiOnsibWVzc2FnZSI6IlcxczBNbDBzSWlReVlTUXhNaVJMZWpsdFVUTjJXRGR3VERKdVVqaHpWRFIzV1RGMUlsMD0iLCJleHAiOiIyMDI0LTA2LTE1VDAwOjAwOjAwLjEyM1oiLCJwdXIiOiJjb29raWUucmVtZW1iZXJfdXNlcl90b2tlbiJ9fQ%3D%3D--df79de57c21be5d2c97d85d5317e6bc720fc602a"
+)
+
+var (
+	deviseFixtureGeneratedAt = time.Date(2024, 6, 1, 0, 0, 0, 123456000, time.UTC)
+	deviseFixtureExpires     = time.Date(2024, 6, 15, 0, 0, 0, 123000000, time.UTC)
+)
+
+func deviseCookieRequest(value string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "remember_user_token", Value: value})
+	return r
+}
+
+func TestDeviseRememberCookie(t *testing.T) {
+	g := Goblin(t)
+	twoWeeks := 14 * 24 * time.Hour
+
+	g.Describe("Devise remember cookies", func() {
+		jar, _ := NewSignedCookieJar(deviseFixtureSecretKeyBase, WithRailsVersion("7.0"))
+		jar.Verifier.Now = func() time.Time { return time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC) }
+		fixture := DeviseRememberCookie{UserID: "42", Salt: deviseFixtureSalt, GeneratedAt: deviseFixtureGeneratedAt}
+
+		g.It("are read with either serializer", func() {
+			for _, value := range []string{deviseJSONCookie, deviseMarshalCookie, deviseMarshalTimeCookie} {
+				c, err := ReadDeviseRememberCookie(jar, deviseCookieRequest(value), "user")
+				g.Assert(err).Eql(nil)
+				g.Assert(c.UserID).Eql("42")
+				g.Assert(c.Salt).Eql(deviseFixtureSalt)
+				g.Assert(c.GeneratedAt.Equal(deviseFixtureGeneratedAt)).IsTrue()
+			}
+		})
+
+		g.It("are read without generated_at", func() {
+			c, err := ReadDeviseRememberCookie(jar, deviseCookieRequest(deviseNoTimestampCookie), "user")
+			g.Assert(err).Eql(nil)
+			g.Assert(c.UserID).Eql("42")
+			g.Assert(c.GeneratedAt.IsZero()).IsTrue()
+			g.Assert(c.RememberMe(deviseFixtureSalt, time.Time{}, twoWeeks, jar.Verifier.now())).IsFalse()
+		})
+
+		g.It("are set like Devise does", func() {
+			rec := httptest.NewRecorder()
+			err := SetDeviseRememberCookie(jar, rec, "user", fixture, http.Cookie{Expires: deviseFixtureExpires})
+			g.Assert(err).Eql(nil)
+			c := rec.Result().Cookies()[0]
+			g.Assert(c.Name).Eql("remember_user_token")
+			g.Assert(c.Value).Eql(deviseJSONCookie)
+			g.Assert(c.HttpOnly).IsTrue()
+		})
+
+		g.It("default generated_at to the current time", func() {
+			rec := httptest.NewRecorder()
+			err := SetDeviseRememberCookie(jar, rec, "admin", DeviseRememberCookie{UserID: "a1b2", Salt: "token"}, http.Cookie{})
+			g.Assert(err).Eql(nil)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(rec.Result().Cookies()[0])
+			c, err := ReadDeviseRememberCookie(jar, r, "admin")
+			g.Assert(err).Eql(nil)
+			g.Assert(c).Eql(DeviseRememberCookie{UserID: "a1b2", Salt: "token", GeneratedAt: jar.Verifier.now()})
+		})
+
+		g.It("are bound to their scope", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(&http.Cookie{Name: "remember_admin_token", Value: deviseJSONCookie})
+			_, err := ReadDeviseRememberCookie(jar, r, "admin")
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+		})
+
+		g.It("reject other signed values", func() {
+			rec := httptest.NewRecorder()
+			g.Assert(jar.Set(rec, "remember_user_token", []interface{}{42, "salt"}, http.Cookie{})).Eql(nil)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(rec.Result().Cookies()[0])
+			_, err := ReadDeviseRememberCookie(jar, r, "user")
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+		})
+
+		g.It("remember the record like Devise's remember_me?", func() {
+			now := deviseFixtureGeneratedAt.Add(time.Hour)
+			created := deviseFixtureGeneratedAt.Add(-time.Second)
+			g.Assert(fixture.RememberMe(deviseFixtureSalt, created, twoWeeks, now)).IsTrue()
+			g.Assert(fixture.RememberMe("$2a$12$other", created, twoWeeks, now)).IsFalse()
+			g.Assert(fixture.RememberMe(deviseFixtureSalt, created, twoWeeks, now.Add(twoWeeks))).IsFalse()
+			g.Assert(fixture.RememberMe(deviseFixtureSalt, now, twoWeeks, now)).IsFalse()
+			g.Assert(fixture.RememberMe(deviseFixtureSalt, time.Time{}, twoWeeks, now)).IsFalse()
+		})
+
+		g.It("parse the timestamps of generated_at", func() {
+			for s, want := range map[string]time.Time{
+				"1717200000.123456":        deviseFixtureGeneratedAt,
+				"1717200000.0":             time.Unix(1717200000, 0),
+				"2024-06-01T00:00:00.123Z": time.Date(2024, 6, 1, 0, 0, 0, 123000000, time.UTC),
+			} {
+				got, ok := parseDeviseTimestamp(s)
+				g.Assert(ok).IsTrue()
+				g.Assert(got.Equal(want)).IsTrue()
+			}
+			_, ok := parseDeviseTimestamp("yesterday")
+			g.Assert(ok).IsFalse()
+			g.Assert(deviseTimestamp(deviseFixtureGeneratedAt)).Eql("1717200000.123456")
+			g.Assert(deviseTimestamp(time.Unix(1717200000, 0))).Eql("1717200000.0")
+		})
+	})
+}
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// deviseKeyIterations is the PBKDF2 iteration count of the key generator of
+// Devise's token generator, ActiveSupport::KeyGenerator's default.
+const deviseKeyIterations = 1 << 16
+
+// deviseFriendlyTokenReplacer replaces the characters Devise.friendly_token
+// leaves out since they are easily mistaken for others.
+var deviseFriendlyTokenReplacer = strings.NewReplacer("l", "s", "I", "x", "O", "y", "0", "z")
+
+// DeviseTokenGenerator generates and digests the tokens Devise's
+// Recoverable and Lockable modules email to users, like
+// Devise.token_generator: the records store the HMAC-SHA256 digest of the
+// token, keyed with a key derived with "Devise <column>" as salt, and the
+// emailed links the raw token. So a Go endpoint can validate a password
+// reset link sent by Rails:
+//
+//	tokens, err := NewDeviseTokenGenerator(secretKeyBase)
+//	...
+//	digest := tokens.Digest("reset_password_token", r.FormValue("reset_password_token"))
+//	user := findUserBy("reset_password_token", digest)
+//
+// Since Devise 4 Confirmable stores its tokens as is, see
+// DeviseFriendlyToken.
+// A DeviseTokenGenerator is safe for concurrent use by multiple goroutines
+// as long as its fields aren't modified once it's in use.
+type DeviseTokenGenerator struct {
+	// KeyDeriver derives the HMAC key of each column, it must be set.
+	KeyDeriver KeyDeriver
+}
+
+// NewDeviseTokenGenerator returns the token generator of a Rails app whose
+// Devise.secret_key (its secret_key_base unless set) is secretKey. The keys
+// are derived with 2^16 iterations of PBKDF2 and cached, with SHA-1 unless
+// the options set a Rails version deriving its keys with SHA-256 (ie:
+// WithRailsVersion("7.0")) or WithKeyDigest.
+func NewDeviseTokenGenerator(secretKey string, opts ...RailsCookieOption) (*DeviseTokenGenerator, error) {
+	kg, err := railsKeyGenerator(secretKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	kg.Iterations = deviseKeyIterations
+	return &DeviseTokenGenerator{KeyDeriver: &CachingKeyGenerator{KeyGenerator: kg}}, nil
+}
+
+// Digest returns the digest of token stored in column, like
+// Devise.token_generator.digest. An empty token has an empty digest.
+func (g *DeviseTokenGenerator) Digest(column, token string) string {
+	if token == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, g.KeyDeriver.Generate([]byte("Devise "+column), 64))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Generate returns a new raw token to email and its digest to store in
+// column, like Devise.token_generator.generate. Unlike Devise it doesn't
+// check that no record has the digest already, which is very unlikely.
+func (g *DeviseTokenGenerator) Generate(column string) (raw, digest string, err error) {
+	raw, err = DeviseFriendlyToken(20)
+	if err != nil {
+		return "", "", err
+	}
+	return raw, g.Digest(column, raw), nil
+}
+
+// DeviseFriendlyToken returns a random token of about length characters,
+// like Devise.friendly_token: URL safe base64 without the l, I, O and 0
+// characters. Devise's tokens are 20 characters long.
+func DeviseFriendlyToken(length int) (string, error) {
+	token, err := RandomBase64URL(length * 3 / 4)
+	if err != nil {
+		return "", err
+	}
+	return deviseFriendlyTokenReplacer.Replace(token), nil
+}
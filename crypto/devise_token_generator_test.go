@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestDeviseTokenGenerator(t *testing.T) {
+	g := Goblin(t)
+
+	// the digests Devise.token_generator.digest(User, column, token) stores
+	// for the token, computed following Devise's algorithm with python's
+	// hashlib and hmac, for an app deriving its keys with SHA-1 and one
+	// with SHA-256.
+	const token = "sz7Rf3xWq9yKpLmN2vBc"
+	digests := map[string][2]string{
+		"reset_password_token": {"4ff6cff456e38c8a57a377d0b7516f0d7a26a420cd2ab1355392e47c5c3afc37", "acb6b8de675908e5f70e05cc62d8cf849c5e3c0dc3105827b14aca786d4f968a"},
+		"unlock_token":         {"d0c92b1d9c9adbca74c184f22bb1ae17faaf62f541edbdd924a135dd4f6bbb2b", "772ab80743d4c1ef751eee345b509abb2af762bf9e20720843d6e1a708636f49"},
+	}
+	sha1Tokens, err := NewDeviseTokenGenerator(deviseFixtureSecretKeyBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256Tokens, err := NewDeviseTokenGenerator(deviseFixtureSecretKeyBase, WithRailsVersion("7.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.Describe("DeviseTokenGenerator", func() {
+		g.It("digests the tokens like Devise", func() {
+			for column, digest := range digests {
+				g.Assert(sha1Tokens.Digest(column, token)).Eql(digest[0])
+				g.Assert(sha256Tokens.Digest(column, token)).Eql(digest[1])
+			}
+			g.Assert(sha1Tokens.Digest("reset_password_token", "")).Eql("")
+		})
+
+		g.It("generates tokens and their digest", func() {
+			raw, digest, err := sha1Tokens.Generate("reset_password_token")
+			g.Assert(err).Eql(nil)
+			g.Assert(len(raw)).Eql(20)
+			g.Assert(strings.ContainsAny(raw, "lIO0+/=")).IsFalse()
+			g.Assert(digest).Eql(sha1Tokens.Digest("reset_password_token", raw))
+			g.Assert(digest == sha1Tokens.Digest("unlock_token", raw)).IsFalse()
+		})
+
+		g.It("fails without a secret", func() {
+			_, err := NewDeviseTokenGenerator("")
+			g.Assert(err != nil).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,50 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// DigestEncoding is the way a MessageVerifier encodes the digests it appends
+// to messages.
+type DigestEncoding int
+
+const (
+	// DigestHex encodes digests in lowercase hexadecimal, like Rails.
+	DigestHex DigestEncoding = iota
+	// DigestBase64 encodes digests using standard, padded, base64.
+	DigestBase64
+	// DigestBase64URL encodes digests using the URL safe base64 alphabet
+	// without padding.
+	DigestBase64URL
+)
+
+// digestEncodings are the encodings digests are looked for in by Verify.
+var digestEncodings = []DigestEncoding{DigestHex, DigestBase64, DigestBase64URL}
+
+func (e DigestEncoding) valid() bool {
+	return e >= DigestHex && e <= DigestBase64URL
+}
+
+// encodedLen returns the length of the encoding of a n bytes long digest.
+func (e DigestEncoding) encodedLen(n int) int {
+	switch e {
+	case DigestBase64:
+		return base64.StdEncoding.EncodedLen(n)
+	case DigestBase64URL:
+		return base64.RawURLEncoding.EncodedLen(n)
+	}
+	return hex.EncodedLen(n)
+}
+
+// encode encodes src into dst, which must be encodedLen(len(src)) long.
+func (e DigestEncoding) encode(dst, src []byte) {
+	switch e {
+	case DigestBase64:
+		base64.StdEncoding.Encode(dst, src)
+	case DigestBase64URL:
+		base64.RawURLEncoding.Encode(dst, src)
+	default:
+		hex.Encode(dst, src)
+	}
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestDigestEncoding(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Digest encodings", func() {
+		secret := []byte("Hey, I'm a secret!")
+		data := testStruct{Foo: "foo", Bar: 42}
+		newVerifier := func(enc DigestEncoding) *MessageVerifier {
+			return &MessageVerifier{Secret: secret, Serializer: JsonMsgSerializer{}, DigestEncoding: enc}
+		}
+
+		g.It("default to hex like Rails", func() {
+			msg, err := newVerifier(DigestHex).Generate(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5")
+		})
+
+		g.It("can be base64", func() {
+			v := newVerifier(DigestBase64)
+			g.Assert(v.DigestFor("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==")).Eql("sb250rNy8Z3MqADlmJ7nUC8bcqU=")
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--sb250rNy8Z3MqADlmJ7nUC8bcqU=")
+		})
+
+		g.It("can be URL safe base64", func() {
+			v := newVerifier(DigestBase64URL)
+			g.Assert(v.DigestFor("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==")).Eql("sb250rNy8Z3MqADlmJ7nUC8bcqU")
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--sb250rNy8Z3MqADlmJ7nUC8bcqU")
+		})
+
+		g.It("are all accepted by Verify", func() {
+			for _, genEnc := range digestEncodings {
+				msg, _ := newVerifier(genEnc).Generate(data)
+				for _, enc := range digestEncodings {
+					var verified testStruct
+					g.Assert(newVerifier(enc).Verify(msg, &verified)).Eql(nil)
+					g.Assert(verified).Eql(data)
+				}
+			}
+		})
+
+		g.It("are checked against the right digest", func() {
+			other := &MessageVerifier{Secret: []byte("Hey, I'm another secret!"), Serializer: JsonMsgSerializer{}}
+			for _, enc := range digestEncodings {
+				other.DigestEncoding = enc
+				msg, _ := other.Generate(data)
+				var verified testStruct
+				err := newVerifier(DigestHex).Verify(msg, &verified)
+				g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+			}
+		})
+
+		g.It("are found when they contain the separator", func() {
+			v := newVerifier(DigestBase64URL)
+			msg, err := v.Generate("265")
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql("IjI2NSI=--jgB1Ni9Mi-GYMnx8gY--rZhT360")
+			for _, enc := range digestEncodings {
+				var verified string
+				g.Assert(newVerifier(enc).Verify(msg, &verified)).Eql(nil)
+				g.Assert(verified).Eql("265")
+			}
+			g.Assert(v.Valid(strings.Replace(msg, "gY--rZ", "gY--rY", 1))).IsFalse()
+		})
+
+		g.It("are used by rotations and other hashes", func() {
+			v := &MessageVerifier{Secret: secret, Hasher: sha256.New, Serializer: JsonMsgSerializer{}, DigestEncoding: DigestBase64}
+			v.Rotate([]byte("Hey, I'm an old secret!"), nil, nil)
+			g.Assert(v.Rotations[0].DigestEncoding).Eql(DigestBase64)
+			msg, _ := v.Rotations[0].Generate(data)
+			var verified testStruct
+			g.Assert(v.Verify(msg, &verified)).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("are used by streams", func() {
+			v := newVerifier(DigestBase64URL)
+			payload := []byte(`"265"`)
+			var buf bytes.Buffer
+			w, _ := v.NewSignWriter(&buf)
+			w.Write(payload)
+			g.Assert(w.Close()).Eql(nil)
+			expected, _ := v.GenerateRaw(payload)
+			g.Assert(buf.String()).Eql(expected)
+
+			r, _ := v.NewVerifyReader(&buf)
+			out, err := io.ReadAll(r)
+			g.Assert(err).Eql(nil)
+			g.Assert(out).Eql(payload)
+		})
+
+		g.It("are checked by NewMessageVerifier", func() {
+			v, err := NewMessageVerifier(secret, WithDigestEncoding(DigestBase64))
+			g.Assert(err).Eql(nil)
+			g.Assert(v.DigestEncoding).Eql(DigestBase64)
+			_, err = NewMessageVerifier(secret, WithDigestEncoding(DigestEncoding(42)))
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+}
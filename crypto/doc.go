@@ -8,6 +8,7 @@
 Package crypto ports some of Ruby on Rails' crypto:
   * version 4+: encrypted & signed messages (aes-cbc)
   * version 5.2+: encrypted & authenticated messages (aes-256-gcm)
+  * version 7+: ActiveRecord encrypted attributes (ActiveRecordEncryptor)
 Messages can be shared between a Ruby app and a Go app. That said, this
 library is useful to anyone wanting to encrypt/sign/authenticate data.
 
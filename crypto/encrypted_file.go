@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MasterKeyEnv is the environment variable Rails reads the master key of
+// its credentials from, before config/master.key.
+const MasterKeyEnv = "RAILS_MASTER_KEY"
+
+// EncryptedFile reads and writes the files of ActiveSupport::EncryptedFile,
+// such as config/credentials.yml.enc, so Go tools can share the credentials
+// of a Rails app:
+//
+//	key, err := ReadMasterKey("config/master.key")
+//	...
+//	contents, err := EncryptedFile{}.Read("config/credentials.yml.enc", key)
+//	...
+//	credentials, err := ParseCredentials(contents)
+//
+// The contents are serialized as a Ruby String with Marshal and encrypted
+// with aes-128-gcm and the hex encoded key, the file holding the
+// `data--iv--tag` message. The zero value is ready to use.
+type EncryptedFile struct {
+	// RandReader is the source of the IVs, crypto/rand.Reader if not set.
+	RandReader io.Reader
+}
+
+// Read decrypts the file at path with the hex encoded key, like
+// EncryptedFile#read. It fails with ErrInvalidConfig if the key isn't 32
+// hex characters and ErrInvalidMessage if the file wasn't encrypted with
+// it.
+func (f EncryptedFile) Read(path, key string) ([]byte, error) {
+	e, err := f.encryptor(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var contents interface{}
+	if err := e.DecryptAndVerify(strings.TrimSpace(string(data)), &contents); err != nil {
+		return nil, err
+	}
+	s, ok := contents.(string)
+	if !ok {
+		return nil, &messageError{msg: "Invalid message - the encrypted file doesn't hold a String", kind: ErrInvalidMessage}
+	}
+	return []byte(s), nil
+}
+
+// Write encrypts contents into the file at path with the hex encoded key,
+// like EncryptedFile#write. The file is replaced atomically, through a
+// temporary file in the same directory, and keeps its permissions.
+func (f EncryptedFile) Write(path, key string, contents []byte) error {
+	e, err := f.encryptor(key)
+	if err != nil {
+		return err
+	}
+	msg, err := e.EncryptAndSign(string(contents))
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(msg); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f EncryptedFile) encryptor(key string) (*MessageEncryptor, error) {
+	k, err := hex.DecodeString(key)
+	if err != nil || len(k) != 16 {
+		return nil, configError("the encryption key must be 32 hex characters")
+	}
+	return &MessageEncryptor{Key: k, Cipher: AES128GCM, Serializer: RubyMarshalSerializer{}, RandReader: f.RandReader}, nil
+}
+
+// ReadMasterKey returns the master key of a Rails app like its credentials
+// do: the RAILS_MASTER_KEY environment variable if set, else the content of
+// the key file at keyPath (ie: config/master.key), without whitespace.
+func ReadMasterKey(keyPath string) (string, error) {
+	if key := os.Getenv(MasterKeyEnv); key != "" {
+		return strings.TrimSpace(key), nil
+	}
+	b, err := os.ReadFile(keyPath)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(b)), nil
+}
+
+// ParseCredentials parses the decrypted YAML of credentials, whose top
+// level must be a Hash like Rails requires. Empty credentials are an empty
+// map.
+func ParseCredentials(contents []byte) (map[string]interface{}, error) {
+	var credentials map[string]interface{}
+	if err := (YamlMsgSerializer{}).Unserialize(string(contents), &credentials); err != nil {
+		return nil, errors.New("invalid credentials: " + err.Error())
+	}
+	if credentials == nil {
+		credentials = map[string]interface{}{}
+	}
+	return credentials, nil
+}
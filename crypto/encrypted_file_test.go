@@ -0,0 +1,119 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+// A credentials.yml.enc of encryptedFileContents encrypted with
+// encryptedFileKey and the IV encryptedFileIV, as `rails credentials:edit`
+// writes it. It was computed following ActiveSupport::EncryptedFile's
+// algorithm with Go's standard library rather than by this package.
+const (
+	encryptedFileKey      = "0f1e2d3c4b5a69788796a5b4c3d2e1f0"
+	encryptedFileIV       = "a1a2a3a4a5a6a7a8a9aaabac"
+	encryptedFileContents = "aws:\n  access_key_id: AKIAEXAMPLE\n  secret_access_key: wJalrXUtnFEMI\nsecret_key_base: 3f9a\n"
+	encryptedFileFixture  = "OlHaQXRWKF2M9eqwB5+ljrHG7u5S7PH02cUmteegdPeMQaSu8HxciHNx56nBNYqUJdk38et60Vr0bRZxzpJRgBwctp/SKOsUHgjAsn+bRJSHFW6+lh46Jecn34xmsRxSyi3Df2g=--oaKjpKWmp6ipqqus--Q3t1/NlWVCugNKkJ0s+W7g=="
+)
+
+func TestEncryptedFile(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("EncryptedFile", func() {
+		var dir, path string
+		g.BeforeEach(func() {
+			dir, _ = os.MkdirTemp("", "encrypted_file")
+			path = filepath.Join(dir, "credentials.yml.enc")
+		})
+		g.AfterEach(func() { os.RemoveAll(dir) })
+
+		g.It("reads the files of Rails", func() {
+			g.Assert(os.WriteFile(path, []byte(encryptedFileFixture+"\n"), 0644)).Eql(nil)
+			contents, err := EncryptedFile{}.Read(path, encryptedFileKey)
+			g.Assert(err).Eql(nil)
+			g.Assert(string(contents)).Eql(encryptedFileContents)
+		})
+
+		g.It("writes the files like Rails", func() {
+			iv, _ := hex.DecodeString(encryptedFileIV)
+			f := EncryptedFile{RandReader: bytes.NewReader(iv)}
+			g.Assert(f.Write(path, encryptedFileKey, []byte(encryptedFileContents))).Eql(nil)
+			data, err := os.ReadFile(path)
+			g.Assert(err).Eql(nil)
+			g.Assert(string(data)).Eql(encryptedFileFixture)
+		})
+
+		g.It("replaces the files keeping their permissions", func() {
+			g.Assert(os.WriteFile(path, []byte(encryptedFileFixture), 0600)).Eql(nil)
+			g.Assert(EncryptedFile{}.Write(path, encryptedFileKey, []byte("updated: true\n"))).Eql(nil)
+			contents, err := EncryptedFile{}.Read(path, encryptedFileKey)
+			g.Assert(err).Eql(nil)
+			g.Assert(string(contents)).Eql("updated: true\n")
+			info, _ := os.Stat(path)
+			g.Assert(info.Mode().Perm()).Eql(os.FileMode(0600))
+			entries, _ := os.ReadDir(dir)
+			g.Assert(len(entries)).Eql(1)
+		})
+
+		g.It("fails with other keys", func() {
+			g.Assert(os.WriteFile(path, []byte(encryptedFileFixture), 0644)).Eql(nil)
+			_, err := EncryptedFile{}.Read(path, "00000000000000000000000000000000")
+			g.Assert(errors.Is(err, ErrInvalidMessage)).IsTrue()
+			for _, key := range []string{"", "0f1e2d3c", encryptedFileKey + "00", "not hex not hex not hex not hex!"} {
+				_, err = EncryptedFile{}.Read(path, key)
+				g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+				g.Assert(errors.Is(EncryptedFile{}.Write(path, key, nil), ErrInvalidConfig)).IsTrue()
+			}
+		})
+	})
+
+	g.Describe("ReadMasterKey", func() {
+		g.It("reads the environment then the key file", func() {
+			dir, _ := os.MkdirTemp("", "master_key")
+			defer os.RemoveAll(dir)
+			keyPath := filepath.Join(dir, "master.key")
+			g.Assert(os.WriteFile(keyPath, []byte(encryptedFileKey+"\n"), 0600)).Eql(nil)
+
+			old, set := os.LookupEnv(MasterKeyEnv)
+			defer func() {
+				if set {
+					os.Setenv(MasterKeyEnv, old)
+				} else {
+					os.Unsetenv(MasterKeyEnv)
+				}
+			}()
+			os.Unsetenv(MasterKeyEnv)
+			key, err := ReadMasterKey(keyPath)
+			g.Assert(err).Eql(nil)
+			g.Assert(key).Eql(encryptedFileKey)
+
+			os.Setenv(MasterKeyEnv, "00112233445566778899aabbccddeeff")
+			key, err = ReadMasterKey(keyPath)
+			g.Assert(err).Eql(nil)
+			g.Assert(key).Eql("00112233445566778899aabbccddeeff")
+		})
+	})
+
+	g.Describe("ParseCredentials", func() {
+		g.It("parses the YAML into a map", func() {
+			credentials, err := ParseCredentials([]byte(encryptedFileContents))
+			g.Assert(err).Eql(nil)
+			g.Assert(credentials["secret_key_base"]).Eql("3f9a")
+			aws, _ := credentials["aws"].(map[string]interface{})
+			g.Assert(aws["access_key_id"]).Eql("AKIAEXAMPLE")
+
+			credentials, err = ParseCredentials(nil)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(credentials)).Eql(0)
+
+			_, err = ParseCredentials([]byte("- not\n- a hash\n"))
+			g.Assert(err == nil).IsFalse()
+		})
+	})
+}
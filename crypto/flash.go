@@ -0,0 +1,151 @@
+package crypto
+
+import "sort"
+
+// FlashSessionKey is the session key ActionDispatch::Flash stores the flash
+// under.
+const FlashSessionKey = "flash"
+
+// Flash is the flash of a Rails session, the messages (ie: notice and
+// alert) a request leaves for the next one to render:
+//
+//	flash := session.Flash()
+//	alert := flash.Alert()
+//	...
+//	flash.Add("notice", "Signed in successfully.")
+//	session.SetFlash(flash)
+//	cookie.Value, err = codec.Encode(cookie.Name, session)
+//
+// Like in Rails the messages of a flash read from a session are discarded
+// at the end of the request, unless kept or set again, while the messages
+// added are kept for the next request. The zero value is an empty flash.
+type Flash struct {
+	flashes map[string]interface{}
+	discard map[string]bool
+}
+
+// Get returns the message of kind.
+func (f *Flash) Get(kind string) (interface{}, bool) {
+	msg, ok := f.flashes[kind]
+	return msg, ok
+}
+
+// Alert returns the alert message, if it's a string.
+func (f *Flash) Alert() string {
+	s, _ := f.flashes["alert"].(string)
+	return s
+}
+
+// Notice returns the notice message, if it's a string.
+func (f *Flash) Notice() string {
+	s, _ := f.flashes["notice"].(string)
+	return s
+}
+
+// Kinds returns the kinds of the messages of the flash, sorted.
+func (f *Flash) Kinds() []string {
+	kinds := make([]string, 0, len(f.flashes))
+	for kind := range f.flashes {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Add sets the message of kind for the next request, like flash[kind] = msg.
+// msg must be serializable by the session's serializer, usually a string.
+func (f *Flash) Add(kind string, msg interface{}) {
+	if f.flashes == nil {
+		f.flashes = make(map[string]interface{})
+	}
+	f.flashes[kind] = msg
+	delete(f.discard, kind)
+}
+
+// Now sets the message of kind for the current request only, like
+// flash.now[kind] = msg.
+func (f *Flash) Now(kind string, msg interface{}) {
+	f.Add(kind, msg)
+	f.Discard(kind)
+}
+
+// Discard marks the messages of the passed kinds, or all of them if none is
+// passed, to be dropped at the end of the request like flash.discard.
+func (f *Flash) Discard(kinds ...string) {
+	if len(kinds) == 0 {
+		kinds = f.Kinds()
+	}
+	if f.discard == nil {
+		f.discard = make(map[string]bool)
+	}
+	for _, kind := range kinds {
+		f.discard[kind] = true
+	}
+}
+
+// Keep keeps the messages of the passed kinds, or all of them if none is
+// passed, for the next request like flash.keep.
+func (f *Flash) Keep(kinds ...string) {
+	if len(kinds) == 0 {
+		f.discard = nil
+		return
+	}
+	for _, kind := range kinds {
+		delete(f.discard, kind)
+	}
+}
+
+// sessionValue returns the flash as ActionDispatch::Flash stores it in the
+// session, {"discard" => [], "flashes" => {...}} with the messages which
+// aren't discarded, or nil if there are none.
+func (f *Flash) sessionValue() map[string]interface{} {
+	flashes := make(map[string]interface{}, len(f.flashes))
+	for kind, msg := range f.flashes {
+		if !f.discard[kind] {
+			flashes[kind] = msg
+		}
+	}
+	if len(flashes) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"discard": []interface{}{}, "flashes": flashes}
+}
+
+// Flash returns the flash of the session the way a Rails request loads it:
+// without the messages discarded by the previous request, and with the
+// others marked to be discarded at the end of this one. A session without a
+// flash, or with one of a format before Rails 4.0, has an empty flash.
+func (h SessionHash) Flash() *Flash {
+	f := &Flash{}
+	value, _ := stringKeyed(h[FlashSessionKey])
+	flashes, _ := stringKeyed(value["flashes"])
+	if len(flashes) == 0 {
+		return f
+	}
+	discarded := map[string]bool{}
+	if discard, ok := value["discard"].([]interface{}); ok {
+		for _, kind := range discard {
+			if kind, ok := kind.(string); ok {
+				discarded[kind] = true
+			}
+		}
+	}
+	for kind, msg := range flashes {
+		if !discarded[kind] {
+			f.Add(kind, msg)
+		}
+	}
+	f.Discard()
+	return f
+}
+
+// SetFlash stores f in the session like ActionDispatch::Flash does at the
+// end of a request: the messages which aren't discarded are stored under
+// FlashSessionKey, which is deleted when there are none.
+func (h SessionHash) SetFlash(f *Flash) {
+	if value := f.sessionValue(); value != nil {
+		h[FlashSessionKey] = value
+		return
+	}
+	delete(h, FlashSessionKey)
+}
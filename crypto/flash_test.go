@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFlash(t *testing.T) {
+	g := Goblin(t)
+
+	// the session of a request following `redirect_to root_path, alert:
+	// "Invalid email or password."`, whose notice was rendered by
+	// flash.now and discarded.
+	const railsSession = `{"session_id":"7f2a9c4e1b8d3f6a0c5e2b9d4f7a1c3e","flash":{"discard":["notice"],"flashes":{"alert":"Invalid email or password.","notice":"Welcome!"}}}`
+
+	g.Describe("Flash", func() {
+		g.It("is read from a session like Rails loads it", func() {
+			var session SessionHash
+			g.Assert(json.Unmarshal([]byte(railsSession), &session)).Eql(nil)
+			flash := session.Flash()
+			g.Assert(flash.Alert()).Eql("Invalid email or password.")
+			g.Assert(flash.Notice()).Eql("")
+			g.Assert(flash.Kinds()).Eql([]string{"alert"})
+
+			// rendered, the alert is dropped at the end of the request.
+			session.SetFlash(flash)
+			_, ok := session[FlashSessionKey]
+			g.Assert(ok).IsFalse()
+		})
+
+		g.It("is empty without a flash in the session", func() {
+			session := SessionHash{"user_id": 42}
+			flash := session.Flash()
+			g.Assert(flash.Kinds()).Eql([]string{})
+			_, ok := flash.Get("alert")
+			g.Assert(ok).IsFalse()
+			session.SetFlash(flash)
+			g.Assert(session).Eql(SessionHash{"user_id": 42})
+		})
+
+		g.It("keeps the messages added or kept for the next request", func() {
+			var session SessionHash
+			json.Unmarshal([]byte(railsSession), &session)
+			flash := session.Flash()
+			flash.Keep("alert")
+			flash.Add("notice", "Signed in successfully.")
+			flash.Now("info", "Not for the next request.")
+			session.SetFlash(flash)
+
+			b, err := json.Marshal(session[FlashSessionKey])
+			g.Assert(err).Eql(nil)
+			g.Assert(string(b)).Eql(`{"discard":[],"flashes":{"alert":"Invalid email or password.","notice":"Signed in successfully."}}`)
+		})
+
+		g.It("discards the messages", func() {
+			flash := &Flash{}
+			flash.Add("alert", "a")
+			flash.Add("notice", "n")
+			flash.Discard("alert")
+			g.Assert(flash.sessionValue()["flashes"]).Eql(map[string]interface{}{"notice": "n"})
+			flash.Discard()
+			g.Assert(flash.sessionValue() == nil).IsTrue()
+			flash.Keep()
+			g.Assert(len(flash.sessionValue()["flashes"].(map[string]interface{}))).Eql(2)
+		})
+
+		g.It("round trips through the session cookie", func() {
+			for _, serializer := range []MsgSerializer{nil, RubyMarshalSerializer{}} {
+				c := &RailsSessionCodec{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "7.0", Serializer: serializer}
+				session := SessionHash{"session_id": sessionFixtureID}
+				flash := session.Flash()
+				flash.Add("alert", "Invalid email or password.")
+				session.SetFlash(flash)
+				cookie, err := c.Encode("_myapp_session", session)
+				g.Assert(err).Eql(nil)
+
+				decoded, err := c.Decode("_myapp_session", cookie)
+				g.Assert(err).Eql(nil)
+				flash = decoded.Flash()
+				g.Assert(flash.Alert()).Eql("Invalid email or password.")
+				g.Assert(flash.Kinds()).Eql([]string{"alert"})
+			}
+		})
+	})
+}
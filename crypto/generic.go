@@ -0,0 +1,30 @@
+package crypto
+
+// Verify verifies a message using the passed verifier and returns its
+// content as a T. It saves having to declare and pass a pointer:
+//
+//	user, err := crypto.Verify[SessionUser](v, cookie)
+func Verify[T any](v *MessageVerifier, msg string) (T, error) {
+	var target T
+	if err := v.Verify(msg, &target); err != nil {
+		var zero T
+		return zero, err
+	}
+	return target, nil
+}
+
+// Decrypt decrypts and verifies a message using the passed encryptor and
+// returns its content as a T. Like with DecryptAndVerify, a message which
+// can't be authenticated fails with ErrInvalidMessage while an authentic
+// message which doesn't fit in a T fails with the serializer's error (ie: a
+// *json.UnmarshalTypeError).
+//
+//	session, err := crypto.Decrypt[map[string]interface{}](e, cookie)
+func Decrypt[T any](e *MessageEncryptor, msg string) (T, error) {
+	var target T
+	if err := e.DecryptAndVerify(msg, &target); err != nil {
+		var zero T
+		return zero, err
+	}
+	return target, nil
+}
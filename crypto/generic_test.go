@@ -0,0 +1,141 @@
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestGenericHelpers(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Verifying a message with Verify[T]", func() {
+		v := &MessageVerifier{
+			Secret:     []byte("Hey, I'm a secret!"),
+			Serializer: JsonMsgSerializer{},
+		}
+
+		g.It("returns a struct", func() {
+			data := testStruct{Foo: "foo", Bar: 42, Baz: []string{"baz"}}
+			msg, _ := v.Generate(data)
+			verified, err := Verify[testStruct](v, msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("returns a map", func() {
+			msg, _ := v.Generate(map[string]int{"foo": 1, "bar": 2})
+			verified, err := Verify[map[string]int](v, msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(map[string]int{"foo": 1, "bar": 2})
+		})
+
+		g.It("returns a slice", func() {
+			msg, _ := v.Generate([]string{"foo", "bar"})
+			verified, err := Verify[[]string](v, msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql([]string{"foo", "bar"})
+		})
+
+		g.It("returns a string", func() {
+			msg, _ := v.Generate("foo")
+			verified, err := Verify[string](v, msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql("foo")
+		})
+
+		g.It("returns the zero value on failure", func() {
+			msg, _ := v.Generate(testStruct{Foo: "foo", Bar: 42})
+			verified, err := Verify[testStruct](v, msg+"0")
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+			g.Assert(verified).Eql(testStruct{})
+		})
+	})
+
+	g.Describe("Decrypting a message with Decrypt[T]", func() {
+		e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: "aes-256-gcm"}
+
+		g.It("returns a struct", func() {
+			data := testStruct{Foo: "foo", Bar: 42}
+			msg, err := e.EncryptAndSign(data)
+			g.Assert(err).Eql(nil)
+			decrypted, err := Decrypt[testStruct](e, msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(decrypted).Eql(data)
+		})
+
+		g.It("returns a map", func() {
+			msg, _ := e.EncryptAndSign(map[string]string{"foo": "bar"})
+			decrypted, err := Decrypt[map[string]string](e, msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(decrypted).Eql(map[string]string{"foo": "bar"})
+		})
+
+		g.It("returns a slice", func() {
+			msg, _ := e.EncryptAndSign([]int{1, 2, 3})
+			decrypted, err := Decrypt[[]int](e, msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(decrypted).Eql([]int{1, 2, 3})
+		})
+
+		g.It("returns a string", func() {
+			msg, _ := e.EncryptAndSign("foo")
+			decrypted, err := Decrypt[string](e, msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(decrypted).Eql("foo")
+		})
+
+		g.It("returns the zero value on failure", func() {
+			decrypted, err := Decrypt[string](e, "garbage")
+			g.Assert(err != nil).IsTrue()
+			g.Assert(decrypted).Eql("")
+		})
+
+		g.It("tells serializer errors from authenticity errors", func() {
+			msg, _ := e.EncryptAndSign("foo")
+			_, err := Decrypt[int](e, msg)
+			var typeErr *json.UnmarshalTypeError
+			g.Assert(errors.As(err, &typeErr)).IsTrue()
+			g.Assert(errors.Is(err, ErrInvalidMessage)).IsFalse()
+
+			_, err = Decrypt[string](e, msg[:len(msg)-4]+"AAA=")
+			g.Assert(err).Eql(ErrInvalidMessage)
+		})
+	})
+}
+
+func ExampleDecrypt() {
+	type Session struct {
+		UserID int `json:"user_id"`
+	}
+	e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+	cookie, err := e.EncryptAndSign(Session{UserID: 42})
+	if err != nil {
+		panic(err)
+	}
+
+	// instead of:
+	//
+	//	var session Session
+	//	err := e.DecryptAndVerify(cookie, &session)
+	session, err := Decrypt[Session](e, cookie)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(session.UserID)
+
+	// it works the same with maps and primitive types.
+	flash, _ := e.EncryptAndSign(map[string]string{"notice": "Saved!"})
+	notice, err := Decrypt[map[string]string](e, flash)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(notice["notice"])
+
+	// Output:
+	// 42
+	// Saved!
+}
@@ -0,0 +1,37 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+)
+
+// GobMsgSerializer serializes values with encoding/gob, which keeps their
+// exact Go types (ints vs floats, time.Time precision and zone...) where
+// JSON doesn't. It is meant for messages exchanged between
+// Go services only, Rails can't read gob.
+// Interface values must be registered with gob.Register and the messages of
+// values holding maps aren't stable, gob encoding maps in random order.
+type GobMsgSerializer struct{}
+
+func (s GobMsgSerializer) Serialize(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Unserialize decodes data into v, which must be a non-nil pointer to a
+// value of a type compatible with the serialized one.
+func (s GobMsgSerializer) Unserialize(data string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &messageError{msg: fmt.Sprintf("gob: can't unserialize into %T, a non-nil pointer is needed", v)}
+	}
+	if err := gob.NewDecoder(bytes.NewBufferString(data)).Decode(v); err != nil {
+		return &messageError{msg: fmt.Sprintf("gob: can't unserialize into %T: %v", v, err), err: err}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestGobMsgSerializer(t *testing.T) {
+	g := Goblin(t)
+	serializer := GobMsgSerializer{}
+
+	type Address struct {
+		City string
+	}
+	type Person struct {
+		ID      int64
+		Score   float64
+		Born    time.Time
+		Address Address
+		Tags    map[string]int
+	}
+	born := time.Date(1984, 6, 1, 12, 30, 15, 123456789, time.FixedZone("CEST", 2*3600))
+	data := Person{ID: 1 << 60, Score: 1, Born: born, Address: Address{City: "Paris"}, Tags: map[string]int{"admin": 1}}
+
+	g.Describe("a gob serialized struct", func() {
+		g.It("can be deserialized with its exact types", func() {
+			output, err := serializer.Serialize(data)
+			g.Assert(err).Eql(nil)
+			var o Person
+			g.Assert(serializer.Unserialize(output, &o)).Eql(nil)
+			g.Assert(o.ID).Eql(data.ID)
+			g.Assert(o.Score).Eql(1.0)
+			g.Assert(o.Born.Equal(born)).IsTrue()
+			g.Assert(o.Born.Nanosecond()).Eql(123456789)
+			_, offset := o.Born.Zone()
+			g.Assert(offset).Eql(2 * 3600)
+			g.Assert(o.Address).Eql(data.Address)
+			g.Assert(o.Tags).Eql(data.Tags)
+		})
+
+		g.It("can be signed", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: serializer}
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			var o Person
+			g.Assert(v.Verify(msg, &o)).Eql(nil)
+			g.Assert(o.Address.City).Eql("Paris")
+
+			// without maps, the messages are stable.
+			data := data
+			data.Tags = nil
+			msg, _ = v.Generate(data)
+			again, _ := v.Generate(data)
+			g.Assert(again).Eql(msg)
+		})
+	})
+
+	g.Describe("unserializing", func() {
+		output, _ := serializer.Serialize(data)
+
+		g.It("needs a pointer", func() {
+			var o Person
+			err := serializer.Unserialize(output, o)
+			g.Assert(err != nil).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "non-nil pointer")).IsTrue()
+			g.Assert(strings.Contains(serializer.Unserialize(output, nil).Error(), "non-nil pointer")).IsTrue()
+			var p *Person
+			g.Assert(serializer.Unserialize(output, p) != nil).IsTrue()
+		})
+
+		g.It("needs a compatible type", func() {
+			var o string
+			err := serializer.Unserialize(output, &o)
+			g.Assert(err != nil).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "*string")).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,55 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// KeyDeriver is implemented by the types deriving keys from a secret, such
+// as KeyGenerator, CachingKeyGenerator and HKDFKeyGenerator, so VerifierFrom
+// and EncryptorFrom accept any of them.
+type KeyDeriver interface {
+	// Generate derives a key of keySize bytes, the salt telling apart the
+	// keys of different uses.
+	Generate(salt []byte, keySize int) []byte
+}
+
+var (
+	_ KeyDeriver = (*KeyGenerator)(nil)
+	_ KeyDeriver = (*CachingKeyGenerator)(nil)
+	_ KeyDeriver = (*HKDFKeyGenerator)(nil)
+)
+
+// HKDFKeyGenerator derives keys with HKDF (RFC 5869), which is cheap enough
+// to run on every request and better suited than PBKDF2 when the secret is
+// already random, such as a secret_key_base. Rails can't derive the same
+// keys so it's meant for new tokens only, use a KeyGenerator to share
+// messages with Rails.
+//
+// The salt passed to Generate is used as HKDF's info, the context the key
+// is bound to, since Rails' salts are labels (ie: "signed cookie") rather
+// than random values. HKDF's own salt is the optional Salt.
+type HKDFKeyGenerator struct {
+	Secret []byte
+	// Salt is HKDF's extract salt, optional.
+	Salt []byte
+	// Hash is the hash of the HMAC HKDF uses, SHA-256 if nil.
+	Hash func() hash.Hash
+}
+
+// Generate derives a key of keySize bytes for the salt. It panics if
+// keySize is more than 255 times the size of the hash, HKDF's limit.
+func (g *HKDFKeyGenerator) Generate(salt []byte, keySize int) []byte {
+	h := g.Hash
+	if h == nil {
+		h = sha256.New
+	}
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(h, g.Secret, g.Salt, salt), key); err != nil {
+		panic("crypto: HKDF can't derive " + err.Error())
+	}
+	return key
+}
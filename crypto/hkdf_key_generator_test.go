@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestHKDFKeyGenerator(t *testing.T) {
+	g := Goblin(t)
+
+	unhex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			panic(err)
+		}
+		return b
+	}
+	seq := func(from, to byte) []byte {
+		var b []byte
+		for c := from; ; c++ {
+			b = append(b, c)
+			if c == to {
+				return b
+			}
+		}
+	}
+
+	g.Describe("an HKDF key generator", func() {
+		g.It("matches the RFC 5869 test vectors", func() {
+			ikm := bytes.Repeat([]byte{0x0b}, 22)
+			for _, tc := range []struct {
+				gen  HKDFKeyGenerator
+				info []byte
+				okm  string
+			}{
+				// A.1, A.2 and A.3 with SHA-256.
+				{HKDFKeyGenerator{Secret: ikm, Salt: seq(0x00, 0x0c)}, seq(0xf0, 0xf9),
+					"3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"},
+				{HKDFKeyGenerator{Secret: seq(0x00, 0x4f), Salt: seq(0x60, 0xaf)}, seq(0xb0, 0xff),
+					"b11e398dc80327a1c8e7f78c596a49344f012eda2d4efad8a050cc4c19afa97c59045a99cac7827271cb41c65e590e09da3275600c2f09b8367793a9aca3db71cc30c58179ec3e87c14c01d5c1f3434f1d87"},
+				{HKDFKeyGenerator{Secret: ikm}, nil,
+					"8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8"},
+				// A.4 with SHA-1.
+				{HKDFKeyGenerator{Secret: ikm[:11], Salt: seq(0x00, 0x0c), Hash: sha1.New}, seq(0xf0, 0xf9),
+					"085a01ea1b10f36933068b56efa5ad81a4f14b822f5b091568a9cdd4f155fda2c22e422478d305f3f896"},
+			} {
+				okm := unhex(tc.okm)
+				g.Assert(tc.gen.Generate(tc.info, len(okm))).Eql(okm)
+			}
+		})
+
+		g.It("derives different keys for different salts", func() {
+			gen := &HKDFKeyGenerator{Secret: GenerateRandomKey(64)}
+			g.Assert(bytes.Equal(gen.Generate([]byte("signed cookie"), 32), gen.Generate([]byte("encrypted cookie"), 32))).IsFalse()
+		})
+	})
+
+	g.Describe("a key deriver", func() {
+		secret := "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"
+
+		g.It("can be any of the key generators", func() {
+			for _, kd := range []KeyDeriver{
+				&KeyGenerator{Secret: secret},
+				&CachingKeyGenerator{KeyGenerator: KeyGenerator{Secret: secret}},
+				&HKDFKeyGenerator{Secret: []byte(secret)},
+			} {
+				v, err := VerifierFrom(kd, "signed cookie")
+				g.Assert(err).Eql(nil)
+				g.Assert(v.Secret).Eql(kd.Generate([]byte("signed cookie"), 64))
+				msg, _ := v.Generate("hello")
+				var o string
+				g.Assert(v.Verify(msg, &o)).Eql(nil)
+
+				e, err := EncryptorFrom(kd, "authenticated encrypted cookie", WithCipher(AES256GCM))
+				g.Assert(err).Eql(nil)
+				msg, err = e.EncryptAndSign("hello")
+				g.Assert(err).Eql(nil)
+				g.Assert(e.DecryptAndVerify(msg, &o)).Eql(nil)
+				g.Assert(o).Eql("hello")
+			}
+		})
+
+		g.It("derives the secret of VerifierFor", func() {
+			v, _ := VerifierFor(secret, "signed cookie")
+			fromKG, _ := VerifierFrom(&KeyGenerator{Secret: secret}, "signed cookie")
+			g.Assert(v.Secret).Eql(fromKG.Secret)
+		})
+
+		g.It("must be set", func() {
+			_, err := VerifierFrom(nil, "signed cookie")
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			_, err = EncryptorFrom(nil, "encrypted cookie")
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+}
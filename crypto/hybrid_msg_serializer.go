@@ -0,0 +1,31 @@
+package crypto
+
+import "strings"
+
+// HybridMsgSerializer is the equivalent of Rails' :hybrid cookies serializer
+// (JsonWithMarshalFallback), used to migrate an app from Marshal to JSON
+// without invalidating the existing cookies: values are serialized as JSON
+// but the data starting with the Marshal signature ("\x04\x08"), which JSON
+// can't, are unserialized with a RubyMarshalSerializer.
+type HybridMsgSerializer struct {
+	// JSON is the serializer used for everything but Marshal data.
+	JSON JsonMsgSerializer
+	// OnFallback, if set, is called every time Marshal data is
+	// unserialized, ie: to measure how many users still have Marshal
+	// cookies. It must be safe for concurrent use.
+	OnFallback func()
+}
+
+func (s HybridMsgSerializer) Serialize(v interface{}) (string, error) {
+	return s.JSON.Serialize(v)
+}
+
+func (s HybridMsgSerializer) Unserialize(data string, v interface{}) error {
+	if !strings.HasPrefix(data, marshalVersion) {
+		return s.JSON.Unserialize(data, v)
+	}
+	if s.OnFallback != nil {
+		s.OnFallback()
+	}
+	return RubyMarshalSerializer{}.Unserialize(data, v)
+}
@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"sync/atomic"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestHybridMsgSerializer(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("a hybrid serializer", func() {
+		var fallbacks int32
+		serializer := HybridMsgSerializer{OnFallback: func() { atomic.AddInt32(&fallbacks, 1) }}
+		g.BeforeEach(func() { atomic.StoreInt32(&fallbacks, 0) })
+
+		g.It("serializes as JSON", func() {
+			output, err := serializer.Serialize(map[string]int{"user_id": 42})
+			g.Assert(err).Eql(nil)
+			g.Assert(output).Eql(`{"user_id":42}`)
+		})
+
+		g.It("reads JSON and Marshal data", func() {
+			var o map[string]interface{}
+			g.Assert(serializer.Unserialize(`{"user_id":42}`, &o)).Eql(nil)
+			g.Assert(o["user_id"]).Eql(float64(42))
+			g.Assert(atomic.LoadInt32(&fallbacks)).Eql(int32(0))
+
+			o = nil
+			g.Assert(serializer.Unserialize("\x04\b{\x06I\"\fuser_id\x06:\x06ETi/", &o)).Eql(nil)
+			g.Assert(o["user_id"]).Eql(float64(42))
+			g.Assert(atomic.LoadInt32(&fallbacks)).Eql(int32(1))
+		})
+
+		g.It("tells scalars apart by their first bytes", func() {
+			for data, value := range map[string]interface{}{
+				"true":                     true,
+				"\x04\bT":                  true,
+				"42":                       float64(42),
+				"\x04\bi/":                 int64(42),
+				`"\u0004\b"`:               "\x04\b",
+				"\x04\bI\"\a42\x06:\x06ET": "42",
+			} {
+				var o interface{}
+				g.Assert(serializer.Unserialize(data, &o)).Eql(nil)
+				g.Assert(o).Eql(value)
+			}
+			g.Assert(atomic.LoadInt32(&fallbacks)).Eql(int32(3))
+		})
+
+		g.It("lets encryptors migrate their cookies", func() {
+			key := GenerateRandomKey(32)
+			old := &MessageEncryptor{Key: key, Cipher: AES256GCM, Serializer: RubyMarshalSerializer{}}
+			current := &MessageEncryptor{Key: key, Cipher: AES256GCM, Serializer: serializer}
+			for _, e := range []*MessageEncryptor{old, current} {
+				msg, err := e.EncryptAndSign("hello")
+				g.Assert(err).Eql(nil)
+				var o string
+				g.Assert(current.DecryptAndVerify(msg, &o)).Eql(nil)
+				g.Assert(o).Eql("hello")
+			}
+			g.Assert(atomic.LoadInt32(&fallbacks)).Eql(int32(1))
+		})
+	})
+}
@@ -0,0 +1,227 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: crypto/internal/testpb/token.proto
+
+package testpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Token struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId int64  `protobuf:"varint,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Scope  string `protobuf:"bytes,2,opt,name=scope,proto3" json:"scope,omitempty"`
+	Nonce  []byte `protobuf:"bytes,3,opt,name=nonce,proto3" json:"nonce,omitempty"`
+}
+
+func (x *Token) Reset() {
+	*x = Token{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crypto_internal_testpb_token_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Token) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Token) ProtoMessage() {}
+
+func (x *Token) ProtoReflect() protoreflect.Message {
+	mi := &file_crypto_internal_testpb_token_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Token.ProtoReflect.Descriptor instead.
+func (*Token) Descriptor() ([]byte, []int) {
+	return file_crypto_internal_testpb_token_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Token) GetUserId() int64 {
+	if x != nil {
+		return x.UserId
+	}
+	return 0
+}
+
+func (x *Token) GetScope() string {
+	if x != nil {
+		return x.Scope
+	}
+	return ""
+}
+
+func (x *Token) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+type Other struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (x *Other) Reset() {
+	*x = Other{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_crypto_internal_testpb_token_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Other) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Other) ProtoMessage() {}
+
+func (x *Other) ProtoReflect() protoreflect.Message {
+	mi := &file_crypto_internal_testpb_token_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Other.ProtoReflect.Descriptor instead.
+func (*Other) Descriptor() ([]byte, []int) {
+	return file_crypto_internal_testpb_token_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Other) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+var File_crypto_internal_testpb_token_proto protoreflect.FileDescriptor
+
+var file_crypto_internal_testpb_token_proto_rawDesc = []byte{
+	0x0a, 0x22, 0x63, 0x72, 0x79, 0x70, 0x74, 0x6f, 0x2f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61,
+	0x6c, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x70, 0x62, 0x2f, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x12, 0x16, 0x67, 0x6f, 0x52, 0x61, 0x69, 0x6c, 0x73, 0x59, 0x6f, 0x75,
+	0x72, 0x73, 0x65, 0x6c, 0x66, 0x2e, 0x74, 0x65, 0x73, 0x74, 0x70, 0x62, 0x22, 0x4c, 0x0a, 0x05,
+	0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x17, 0x0a, 0x07, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x75, 0x73, 0x65, 0x72, 0x49, 0x64, 0x12, 0x14,
+	0x0a, 0x05, 0x73, 0x63, 0x6f, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x73,
+	0x63, 0x6f, 0x70, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65, 0x22, 0x1b, 0x0a, 0x05, 0x4f, 0x74,
+	0x68, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x42, 0x3c, 0x5a, 0x3a, 0x67, 0x69, 0x74, 0x68, 0x75,
+	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6d, 0x61, 0x74, 0x74, 0x65, 0x74, 0x74, 0x69, 0x2f, 0x67,
+	0x6f, 0x52, 0x61, 0x69, 0x6c, 0x73, 0x59, 0x6f, 0x75, 0x72, 0x73, 0x65, 0x6c, 0x66, 0x2f, 0x63,
+	0x72, 0x79, 0x70, 0x74, 0x6f, 0x2f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x74,
+	0x65, 0x73, 0x74, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_crypto_internal_testpb_token_proto_rawDescOnce sync.Once
+	file_crypto_internal_testpb_token_proto_rawDescData = file_crypto_internal_testpb_token_proto_rawDesc
+)
+
+func file_crypto_internal_testpb_token_proto_rawDescGZIP() []byte {
+	file_crypto_internal_testpb_token_proto_rawDescOnce.Do(func() {
+		file_crypto_internal_testpb_token_proto_rawDescData = protoimpl.X.CompressGZIP(file_crypto_internal_testpb_token_proto_rawDescData)
+	})
+	return file_crypto_internal_testpb_token_proto_rawDescData
+}
+
+var file_crypto_internal_testpb_token_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_crypto_internal_testpb_token_proto_goTypes = []any{
+	(*Token)(nil), // 0: goRailsYourself.testpb.Token
+	(*Other)(nil), // 1: goRailsYourself.testpb.Other
+}
+var file_crypto_internal_testpb_token_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_crypto_internal_testpb_token_proto_init() }
+func file_crypto_internal_testpb_token_proto_init() {
+	if File_crypto_internal_testpb_token_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_crypto_internal_testpb_token_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*Token); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_crypto_internal_testpb_token_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*Other); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_crypto_internal_testpb_token_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_crypto_internal_testpb_token_proto_goTypes,
+		DependencyIndexes: file_crypto_internal_testpb_token_proto_depIdxs,
+		MessageInfos:      file_crypto_internal_testpb_token_proto_msgTypes,
+	}.Build()
+	File_crypto_internal_testpb_token_proto = out.File
+	file_crypto_internal_testpb_token_proto_rawDesc = nil
+	file_crypto_internal_testpb_token_proto_goTypes = nil
+	file_crypto_internal_testpb_token_proto_depIdxs = nil
+}
@@ -1,10 +1,27 @@
 package crypto
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
 )
 
 type JsonMsgSerializer struct {
+	// UseNumber makes Unserialize decode numbers into interface values as
+	// json.Number instead of float64, which can't hold integers above 2^53
+	// (ie: 64-bit ids) without losing precision.
+	UseNumber bool
+	// DisallowUnknownFields makes Unserialize fail when an object has a key
+	// that doesn't match any field of the struct it's decoded into.
+	DisallowUnknownFields bool
+	// RailsTimes makes Unserialize decode into time.Time values the times
+	// in any of the formats ParseRailsTime accepts, rather than only
+	// RFC 3339, so the JSON written by Rails apps with non default time
+	// settings can be read.
+	RailsTimes bool
 }
 
 func (s JsonMsgSerializer) Serialize(v interface{}) (string, error) {
@@ -15,6 +32,66 @@ func (s JsonMsgSerializer) Serialize(v interface{}) (string, error) {
 	return string(b), nil
 }
 
+// SerializeTo writes the same data as Serialize to w.
+func (s JsonMsgSerializer) SerializeTo(w io.Writer, v interface{}) error {
+	return json.NewEncoder(newlineTrimmer{w}).Encode(v)
+}
+
 func (s JsonMsgSerializer) Unserialize(data string, v interface{}) error {
-	return json.Unmarshal([]byte(data), v)
+	if !s.UseNumber && !s.DisallowUnknownFields && !s.RailsTimes {
+		return json.Unmarshal([]byte(data), v)
+	}
+	return s.UnserializeFrom(strings.NewReader(data), v)
+}
+
+// UnserializeFrom decodes the JSON value read from r into v, which must be
+// all r holds.
+func (s JsonMsgSerializer) UnserializeFrom(r io.Reader, v interface{}) error {
+	if s.RailsTimes {
+		// the times are rewritten before decoding into v, the
+		// UnmarshalJSON method of time.Time being the only way it's
+		// decoded.
+		var generic interface{}
+		if err := (JsonMsgSerializer{UseNumber: true}).UnserializeFrom(r, &generic); err != nil {
+			return err
+		}
+		b, err := json.Marshal(normalizeRailsTimes(generic, reflect.TypeOf(v)))
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	dec := json.NewDecoder(r)
+	if s.UseNumber {
+		dec.UseNumber()
+	}
+	if s.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	// like json.Unmarshal, reject anything following the value.
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("json: invalid data after top-level value")
+	}
+	return nil
+}
+
+// newlineTrimmer drops the newline json.Encoder ends its values with, so
+// they are the same as json.Marshal's. The encoder writes a value at once
+// and, without indentation, a newline can only be the last byte.
+type newlineTrimmer struct {
+	w io.Writer
+}
+
+func (t newlineTrimmer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n > 0 && p[n-1] == '\n' {
+		p = p[:n-1]
+	}
+	if _, err := t.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
 }
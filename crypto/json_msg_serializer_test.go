@@ -1,7 +1,10 @@
 package crypto
 
 import (
+	"bytes"
+	"encoding/json"
 	. "github.com/franela/goblin"
+	"strings"
 	"testing"
 )
 
@@ -41,4 +44,106 @@ func TestJsonMsgSerializerSerializer(t *testing.T) {
 		})
 	})
 
+	g.Describe("a json serializer using numbers", func() {
+		serializer := JsonMsgSerializer{UseNumber: true}
+
+		g.It("doesn't lose the precision of large integers", func() {
+			output, err := serializer.Serialize(map[string]interface{}{"id": int64(9007199254740995)})
+			g.Assert(err).Eql(nil)
+			g.Assert(output).Eql(`{"id":9007199254740995}`)
+
+			var o map[string]interface{}
+			err = serializer.Unserialize(output, &o)
+			g.Assert(err).Eql(nil)
+			g.Assert(o["id"]).Eql(json.Number("9007199254740995"))
+			id, err := o["id"].(json.Number).Int64()
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql(int64(9007199254740995))
+
+			var lossy map[string]interface{}
+			JsonMsgSerializer{}.Unserialize(output, &lossy)
+			g.Assert(lossy["id"] == float64(9007199254740995)).IsTrue()
+			g.Assert(int64(lossy["id"].(float64)) == 9007199254740995).IsFalse()
+		})
+
+		g.It("round trips through a verifier", func() {
+			v := MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: serializer}
+			msg, err := v.Generate(map[string]interface{}{"id": json.Number("9007199254740995")})
+			g.Assert(err).Eql(nil)
+			var o map[string]interface{}
+			g.Assert(v.Verify(msg, &o)).Eql(nil)
+			g.Assert(o["id"]).Eql(json.Number("9007199254740995"))
+		})
+
+		g.It("rejects trailing data", func() {
+			var o interface{}
+			g.Assert(serializer.Unserialize(`{"id":1} {}`, &o) != nil).IsTrue()
+			g.Assert(serializer.Unserialize(`{"id":1} `, &o)).Eql(nil)
+		})
+	})
+
+	g.Describe("a json serializer disallowing unknown fields", func() {
+		type Person struct {
+			Id   int    `json:"id"`
+			Name string `json:"name"`
+		}
+		serializer := JsonMsgSerializer{DisallowUnknownFields: true}
+
+		g.It("decodes known fields", func() {
+			var o Person
+			err := serializer.Unserialize(`{"id":13,"name":"John"}`, &o)
+			g.Assert(err).Eql(nil)
+			g.Assert(o).Eql(Person{Id: 13, Name: "John"})
+		})
+
+		g.It("rejects unknown fields", func() {
+			var o Person
+			err := serializer.Unserialize(`{"id":13,"name":"John","admin":true}`, &o)
+			g.Assert(err != nil).IsTrue()
+			g.Assert(JsonMsgSerializer{}.Unserialize(`{"id":13,"name":"John","admin":true}`, &o)).Eql(nil)
+		})
+	})
+
+	g.Describe("a streamed json serializer", func() {
+		values := []interface{}{"a <b> & c", 42, map[string]interface{}{"b": []int{1, 2}, "a": nil}, struct {
+			Name string `json:"name"`
+		}{"John"}}
+
+		g.It("writes the same data as Serialize", func() {
+			for _, v := range values {
+				data, err := serializer.Serialize(v)
+				g.Assert(err).Eql(nil)
+				var buf bytes.Buffer
+				g.Assert(serializer.SerializeTo(&buf, v)).Eql(nil)
+				g.Assert(buf.String()).Eql(data)
+			}
+		})
+
+		g.It("reads the same data as Unserialize", func() {
+			for _, s := range []JsonMsgSerializer{{}, {UseNumber: true}} {
+				var o, streamed interface{}
+				g.Assert(s.Unserialize(`{"id":9007199254740995,"name":"John"}`, &o)).Eql(nil)
+				g.Assert(s.UnserializeFrom(strings.NewReader(`{"id":9007199254740995,"name":"John"}`), &streamed)).Eql(nil)
+				g.Assert(streamed).Eql(o)
+			}
+			var o interface{}
+			g.Assert(serializer.UnserializeFrom(strings.NewReader(`{"id":1} {}`), &o) != nil).IsTrue()
+		})
+
+		g.It("is used by verifiers", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: serializer}
+			std := &MessageVerifier{Secret: v.Secret, Serializer: stringSerializer{serializer}}
+			for _, value := range values {
+				msg, err := v.Generate(value)
+				g.Assert(err).Eql(nil)
+				expected, _ := std.Generate(value)
+				g.Assert(msg).Eql(expected)
+			}
+		})
+	})
+}
+
+// stringSerializer hides the StreamSerializer methods of a serializer.
+type stringSerializer struct {
+	MsgSerializer
 }
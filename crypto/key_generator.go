@@ -1,9 +1,12 @@
 package crypto
 
 import (
-	"golang.org/x/crypto/pbkdf2"
 	"crypto/sha1"
 	"fmt"
+	"hash"
+	"strconv"
+
+	"golang.org/x/crypto/pbkdf2"
 )
 
 // KeyGenerator is a simple wrapper around a PBKDF2 implementation.
@@ -11,12 +14,34 @@ import (
 // This lets applications have a single secure secret, but avoid reusing that
 // key in multiple incompatible contexts.
 type KeyGenerator struct {
-	Secret     string
+	Secret string
+	// Iterations is the PBKDF2 iteration count, Rails' 1000 if 0.
 	Iterations int
-	cache      map[string][]byte
+	// Digest is the hash PBKDF2 uses, SHA1 if nil like Rails until 7.0.
+	// Rails 7.0+ apps with key_generator_hash_digest_class set to
+	// OpenSSL::Digest::SHA256 (the 7.0 defaults) use sha256.New.
+	Digest func() hash.Hash
+	cache  map[string][]byte
+}
+
+// NewKeyGenerator returns a KeyGenerator deriving keys from secret with the
+// passed iteration count and digest, failing with ErrInvalidConfig if the
+// secret is empty, iterations is less than 1 or digest is nil.
+func NewKeyGenerator(secret string, iterations int, digest func() hash.Hash) (*KeyGenerator, error) {
+	switch {
+	case secret == "":
+		return nil, configError("empty secret")
+	case iterations < 1:
+		return nil, configError("invalid PBKDF2 iterations " + strconv.Itoa(iterations))
+	case digest == nil:
+		return nil, configError("nil digest")
+	}
+	return &KeyGenerator{Secret: secret, Iterations: iterations, Digest: digest}, nil
 }
 
 // CacheGenerate() write through cache used to save generated keys.
+// It isn't safe for concurrent use, see CachingKeyGenerator. It panics like
+// Generate, caching nothing then.
 func (g *KeyGenerator) CacheGenerate(salt []byte, keySize int) []byte {
 	key := fmt.Sprintf("%s%d", salt, keySize)
 	if g.cache == nil {
@@ -29,10 +54,66 @@ func (g *KeyGenerator) CacheGenerate(salt []byte, keySize int) []byte {
 }
 
 // Generates a derived key based on a salt. rails default key size is 64.
+// It panics if keySize isn't positive (ErrInvalidKeyLength) or if
+// Iterations is negative (ErrInvalidConfig), which would weaken the keys.
+// GenerateKeys returns these errors instead.
 func (g *KeyGenerator) Generate(salt []byte, keySize int) []byte {
-	// set a default
+	if err := g.check(keySize); err != nil {
+		panic(err.Error())
+	}
+	// set a default, without writing it so concurrent calls don't race.
+	iterations := g.iterations()
+	digest := g.Digest
+	if digest == nil {
+		digest = sha1.New
+	}
+	return pbkdf2.Key([]byte(g.Secret), salt, iterations, keySize, digest)
+}
+
+// check returns the error Generate panics with for keySize.
+func (g KeyGenerator) check(keySize int) error {
+	if keySize < 1 {
+		return keySizeError(keySize)
+	}
+	if iterations := g.iterations(); iterations < 1 {
+		return configError("invalid PBKDF2 iterations " + strconv.Itoa(iterations))
+	}
+	return nil
+}
+
+// iterations returns the Iterations or their default.
+func (g KeyGenerator) iterations() int {
 	if g.Iterations == 0 {
-		g.Iterations = 1000 // rails 4 default when setting the session.
+		return 1000 // rails 4 default when setting the session.
+	}
+	return g.Iterations
+}
+
+// GenerateKeys derives keys of the passed lengths in a single PBKDF2 run of
+// their total length, whose output is sliced in order. The first key is the
+// one Generate derives for its length and the next ones the following bytes
+// of the longer keys, like the two halves of a 64 bytes key.
+// It fails with ErrInvalidKeyLength if no length is passed or if one isn't
+// positive, and with ErrInvalidConfig if Iterations is negative.
+func (g *KeyGenerator) GenerateKeys(salt []byte, lengths ...int) ([][]byte, error) {
+	if len(lengths) == 0 {
+		return nil, &messageError{msg: "Invalid key length - no key length", kind: ErrInvalidKeyLength}
+	}
+	total := 0
+	for _, n := range lengths {
+		if err := g.check(n); err != nil {
+			return nil, err
+		}
+		total += n
+	}
+	out := g.Generate(salt, total)
+	keys := make([][]byte, len(lengths))
+	for i, n := range lengths {
+		keys[i], out = out[:n:n], out[n:]
 	}
-	return pbkdf2.Key([]byte(g.Secret), salt, g.Iterations, keySize, sha1.New)
+	return keys, nil
+}
+
+func keySizeError(n int) error {
+	return &messageError{msg: "Invalid key length - can't derive a key of " + strconv.Itoa(n) + " bytes", kind: ErrInvalidKeyLength}
 }
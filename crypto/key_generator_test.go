@@ -1,6 +1,10 @@
 package crypto
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	. "github.com/franela/goblin"
 	"testing"
@@ -39,4 +43,84 @@ func TestKegenerator_Generate(t *testing.T) {
 		})
 	})
 
+	g.Describe("Known answers", func() {
+		// computed with `openssl kdf -kdfopt iter:1000 PBKDF2`, which
+		// OpenSSL::PKCS5 and so Rails' KeyGenerator use.
+		secret := "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"
+		vectors := []struct {
+			gen  KeyGenerator
+			salt string
+			size int
+			key  string
+		}{
+			{KeyGenerator{Secret: secret}, "signed cookie", 64, "f389ee2fa5134ba6d76f909b705d8761c8d8880d0289ad5331cc4c50ca0c72f6733393d2d2581e585154a9e8b217fcd9af01ea209eb020a8bc741ddbd8325a7c"},
+			{KeyGenerator{Secret: secret, Iterations: 1000, Digest: sha1.New}, "authenticated encrypted cookie", 32, "e49d93dd8e7fb3c3a30ca095826fd13df9a219ff0801d3212d1e1e979a8f792a"},
+			{KeyGenerator{Secret: secret, Digest: sha256.New}, "signed cookie", 64, "302350f3c4daede679056ac64821d93a86b967a398974d35843906189cec0099dc049862292fcb38679b10dd57931c53350f8cd0c66b714f3c4c45fb74629bda"},
+			{KeyGenerator{Secret: secret, Iterations: 1000, Digest: sha256.New}, "authenticated encrypted cookie", 32, "22efaebfaed888c30f6e49d6ec39201862c24647d511575dd46649721825b02e"},
+		}
+		g.It("matches OpenSSL's PBKDF2", func() {
+			for _, v := range vectors {
+				g.Assert(hex.EncodeToString(v.gen.Generate([]byte(v.salt), v.size))).Eql(v.key)
+			}
+		})
+	})
+
+	g.Describe("Generating several keys", func() {
+		gen := KeyGenerator{Secret: "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"}
+		salt := []byte("encrypted cookie")
+
+		g.It("slices a single derived key", func() {
+			keys, err := gen.GenerateKeys(salt, 32, 32)
+			g.Assert(err).Eql(nil)
+			full := gen.Generate(salt, 64)
+			g.Assert(keys).Eql([][]byte{full[:32], full[32:]})
+			g.Assert(keys[0]).Eql(gen.Generate(salt, 32))
+
+			keys, _ = gen.GenerateKeys(salt, 16, 8, 40)
+			g.Assert(keys).Eql([][]byte{full[:16], full[16:24], full[24:]})
+			// the keys don't share their capacity.
+			keys[0] = append(keys[0], 0)
+			g.Assert(keys[1]).Eql(full[16:24])
+		})
+
+		g.It("rejects invalid lengths", func() {
+			for _, lengths := range [][]int{nil, {0}, {32, -1}} {
+				_, err := gen.GenerateKeys(salt, lengths...)
+				g.Assert(errors.Is(err, ErrInvalidKeyLength)).IsTrue()
+			}
+			for _, n := range []int{0, -1} {
+				func() {
+					defer func() { g.Assert(recover() != nil).IsTrue() }()
+					gen.Generate(salt, n)
+				}()
+			}
+		})
+
+		g.It("reports negative iterations as an error", func() {
+			_, err := (&KeyGenerator{Secret: "secret", Iterations: -1}).GenerateKeys(salt, 32)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+
+	g.Describe("NewKeyGenerator", func() {
+		g.It("checks its arguments", func() {
+			kg, err := NewKeyGenerator("secret", 1000, sha256.New)
+			g.Assert(err).Eql(nil)
+			g.Assert(kg.Generate([]byte("salt"), 32)).Eql((&KeyGenerator{Secret: "secret", Digest: sha256.New}).Generate([]byte("salt"), 32))
+			for _, args := range []struct {
+				secret     string
+				iterations int
+			}{{"", 1000}, {"secret", 0}, {"secret", -1}} {
+				_, err = NewKeyGenerator(args.secret, args.iterations, sha256.New)
+				g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			}
+			_, err = NewKeyGenerator("secret", 1000, nil)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+
+		g.It("rejects negative iterations", func() {
+			defer func() { g.Assert(recover() != nil).IsTrue() }()
+			(&KeyGenerator{Secret: "secret", Iterations: -1}).Generate([]byte("salt"), 32)
+		})
+	})
 }
@@ -0,0 +1,105 @@
+package crypto
+
+import "context"
+
+// KeyProvider provides the keys of a MessageEncryptor or MessageVerifier, ie:
+// data keys fetched from a KMS or an HSM, instead of their Key, SignKey and
+// Secret fields. The keys are requested for every operation so they don't
+// have to stay in memory, a provider can cache them if it wants to.
+type KeyProvider interface {
+	// EncryptionKey returns the key used by a MessageEncryptor's cipher.
+	EncryptionKey(ctx context.Context) ([]byte, error)
+	// SigningKey returns the secret of a MessageVerifier, or the key
+	// signing the messages of an aes-cbc MessageEncryptor.
+	SigningKey(ctx context.Context) ([]byte, error)
+}
+
+// StaticKeyProvider provides keys which never change, it works the same as
+// setting the Key, SignKey or Secret fields.
+type StaticKeyProvider struct {
+	Key []byte
+	// SignKey defaults to Key, like a MessageEncryptor's SignKey.
+	SignKey []byte
+}
+
+// EncryptionKey returns Key.
+func (p StaticKeyProvider) EncryptionKey(ctx context.Context) ([]byte, error) {
+	return p.Key, nil
+}
+
+// SigningKey returns SignKey, or Key when SignKey isn't set.
+func (p StaticKeyProvider) SigningKey(ctx context.Context) ([]byte, error) {
+	if len(p.SignKey) == 0 {
+		return p.Key, nil
+	}
+	return p.SignKey, nil
+}
+
+func keyProviderError(err error) error {
+	return &messageError{msg: "Key provider failed - " + err.Error(), err: err}
+}
+
+// withKeys returns the encryptor itself when KeyProvider isn't set,
+// otherwise a copy set with the keys it provides. The copy isn't stored so
+// the keys don't outlive the operation.
+func (crypt *MessageEncryptor) withKeys(ctx context.Context) (*MessageEncryptor, error) {
+	if crypt == nil || crypt.KeyProvider == nil {
+		return crypt, nil
+	}
+	e := *crypt
+	e.KeyProvider = nil
+	key, err := crypt.KeyProvider.EncryptionKey(ctx)
+	if err != nil {
+		return nil, keyProviderError(err)
+	}
+	e.Key = key
+	// only aes-cbc (by the Verifier if set) and aes-256-ctr-hmac messages
+	// are signed.
+	if (e.withVerifier() && e.Verifier == nil) || e.Cipher == AES256CTRHMAC {
+		signKey, err := crypt.KeyProvider.SigningKey(ctx)
+		if err != nil {
+			return nil, keyProviderError(err)
+		}
+		e.SignKey = signKey
+	}
+	return &e, nil
+}
+
+// withKeys returns the verifier itself when KeyProvider isn't set (or a
+// MACer is), otherwise a copy whose Secret is the signing key it provides.
+func (crypt *MessageVerifier) withKeys(ctx context.Context) (*MessageVerifier, error) {
+	if crypt == nil || crypt.KeyProvider == nil || crypt.MACer != nil {
+		return crypt, nil
+	}
+	secret, err := crypt.KeyProvider.SigningKey(ctx)
+	if err != nil {
+		return nil, keyProviderError(err)
+	}
+	v := *crypt
+	v.KeyProvider = nil
+	v.Secret = secret
+	return &v, nil
+}
+
+// withRotationKeys is like withKeys but also sets the Rotations with their
+// keys, so a batch resolves them once.
+func (crypt *MessageVerifier) withRotationKeys(ctx context.Context) (*MessageVerifier, error) {
+	v, err := crypt.withKeys(ctx)
+	if err != nil || v == nil {
+		return v, err
+	}
+	rotations := make([]*MessageVerifier, len(v.Rotations))
+	resolved := false
+	for i, r := range v.Rotations {
+		if rotations[i], err = r.withKeys(ctx); err != nil {
+			return nil, err
+		}
+		resolved = resolved || rotations[i] != r
+	}
+	if !resolved {
+		return v, nil
+	}
+	c := *v
+	c.Rotations = rotations
+	return &c, nil
+}
@@ -0,0 +1,193 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+// countingKeyProvider provides fixed keys and counts how many times they
+// were requested.
+type countingKeyProvider struct {
+	StaticKeyProvider
+	encryptionCalls, signingCalls int
+	ctx                           context.Context
+}
+
+func (p *countingKeyProvider) EncryptionKey(ctx context.Context) ([]byte, error) {
+	p.encryptionCalls++
+	p.ctx = ctx
+	return p.StaticKeyProvider.EncryptionKey(ctx)
+}
+
+func (p *countingKeyProvider) SigningKey(ctx context.Context) ([]byte, error) {
+	p.signingCalls++
+	p.ctx = ctx
+	return p.StaticKeyProvider.SigningKey(ctx)
+}
+
+// failingKeyProvider fails to provide any key.
+type failingKeyProvider struct{ err error }
+
+func (p failingKeyProvider) EncryptionKey(ctx context.Context) ([]byte, error) { return nil, p.err }
+func (p failingKeyProvider) SigningKey(ctx context.Context) ([]byte, error)    { return nil, p.err }
+
+type ctxKey struct{}
+
+func TestKeyProvider(t *testing.T) {
+	g := Goblin(t)
+	key := GenerateRandomKey(32)
+	errKMS := errors.New("KMS unavailable")
+
+	g.Describe("MessageEncryptor KeyProvider", func() {
+		g.It("provides the keys for each operation", func() {
+			for _, cipher := range []string{AESCBC, AES256GCM} {
+				p := &countingKeyProvider{StaticKeyProvider: StaticKeyProvider{Key: key}}
+				e := &MessageEncryptor{KeyProvider: p, Cipher: cipher}
+				msg, err := e.EncryptAndSign("foo")
+				g.Assert(err).Eql(nil)
+				g.Assert(p.encryptionCalls).Eql(1)
+				var output string
+				g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+				g.Assert(output).Eql("foo")
+				g.Assert(p.encryptionCalls).Eql(2)
+				g.Assert(e.Key == nil).IsTrue()
+
+				// the keys are the ones the fields would set.
+				static := &MessageEncryptor{Key: key, Cipher: cipher}
+				g.Assert(static.DecryptAndVerify(msg, &output)).Eql(nil)
+				msg, _ = static.EncryptAndSign("bar")
+				g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+				g.Assert(output).Eql("bar")
+			}
+		})
+
+		g.It("only asks for the signing key when messages are signed", func() {
+			p := &countingKeyProvider{StaticKeyProvider: StaticKeyProvider{Key: key}}
+			e := &MessageEncryptor{KeyProvider: p, Cipher: AES256GCM}
+			e.EncryptAndSign("foo")
+			g.Assert(p.signingCalls).Eql(0)
+
+			signKey := GenerateRandomKey(64)
+			p = &countingKeyProvider{StaticKeyProvider: StaticKeyProvider{Key: key, SignKey: signKey}}
+			e = &MessageEncryptor{KeyProvider: p, Cipher: AESCBC}
+			msg, _ := e.EncryptAndSign("foo")
+			g.Assert(p.signingCalls).Eql(1)
+			var output string
+			g.Assert((&MessageEncryptor{Key: key, SignKey: signKey}).DecryptAndVerify(msg, &output)).Eql(nil)
+		})
+
+		g.It("passes the context", func() {
+			p := &countingKeyProvider{StaticKeyProvider: StaticKeyProvider{Key: key}}
+			e := &MessageEncryptor{KeyProvider: p, Cipher: AES256GCM}
+			ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+			msg, _ := e.EncryptAndSignContext(ctx, "foo")
+			g.Assert(p.ctx.Value(ctxKey{})).Eql("request")
+			p.ctx = nil
+			var output string
+			g.Assert(e.DecryptAndVerifyContext(ctx, msg, &output)).Eql(nil)
+			g.Assert(p.ctx.Value(ctxKey{})).Eql("request")
+		})
+
+		g.It("are used by the rotations", func() {
+			oldKey := GenerateRandomKey(32)
+			msg, _ := (&MessageEncryptor{Key: oldKey, Cipher: AES256GCM}).EncryptAndSign("foo")
+			p := &countingKeyProvider{StaticKeyProvider: StaticKeyProvider{Key: oldKey}}
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM,
+				Rotations: []*MessageEncryptor{{KeyProvider: p, Cipher: AES256GCM}}}
+			var output string
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("foo")
+			g.Assert(p.encryptionCalls).Eql(1)
+
+			// the rotations aren't resolved when they aren't needed.
+			msg, _ = e.EncryptAndSign("bar")
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(p.encryptionCalls).Eql(1)
+		})
+
+		g.It("reports the provider failures", func() {
+			e := &MessageEncryptor{KeyProvider: failingKeyProvider{errKMS}, Cipher: AES256GCM}
+			_, err := e.EncryptAndSign("foo")
+			g.Assert(errors.Is(err, errKMS)).IsTrue()
+			g.Assert(err.Error()).Eql("Key provider failed - KMS unavailable")
+			_, err = e.EncryptAndSignBytes([]byte("foo"))
+			g.Assert(errors.Is(err, errKMS)).IsTrue()
+
+			msg, _ := (&MessageEncryptor{Key: key, Cipher: AES256GCM}).EncryptAndSign("foo")
+			var output string
+			g.Assert(errors.Is(e.DecryptAndVerify(msg, &output), errKMS)).IsTrue()
+			_, err = e.NewDecryptReader(nil)
+			g.Assert(errors.Is(err, errKMS)).IsTrue()
+
+			// a failing rotation isn't skipped.
+			r := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM,
+				Rotations: []*MessageEncryptor{{KeyProvider: failingKeyProvider{errKMS}, Cipher: AES256GCM}}}
+			g.Assert(errors.Is(r.DecryptAndVerify(msg, &output), errKMS)).IsTrue()
+		})
+	})
+
+	g.Describe("MessageVerifier KeyProvider", func() {
+		g.It("provides the secret for each operation", func() {
+			p := &countingKeyProvider{StaticKeyProvider: StaticKeyProvider{Key: key}}
+			v := &MessageVerifier{KeyProvider: p, Serializer: JsonMsgSerializer{}}
+			msg, err := v.Generate("foo")
+			g.Assert(err).Eql(nil)
+			var output string
+			g.Assert(v.Verify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("foo")
+			g.Assert(v.Valid(msg)).IsTrue()
+			g.Assert(p.signingCalls).Eql(3)
+			g.Assert(p.encryptionCalls).Eql(0)
+
+			static := &MessageVerifier{Secret: key, Serializer: JsonMsgSerializer{}}
+			staticMsg, _ := static.Generate("foo")
+			g.Assert(msg).Eql(staticMsg)
+			g.Assert(v.DigestFor("foo")).Eql(static.DigestFor("foo"))
+		})
+
+		g.It("resolves the keys once per batch", func() {
+			p := &countingKeyProvider{StaticKeyProvider: StaticKeyProvider{Key: key}}
+			v := &MessageVerifier{KeyProvider: p, Serializer: JsonMsgSerializer{}}
+			msgs, err := v.GenerateAll([]interface{}{"a", "b", "c"})
+			g.Assert(err).Eql(nil)
+			var results []string
+			g.Assert(v.VerifyAll(msgs, &results)).Eql(nil)
+			g.Assert(results).Eql([]string{"a", "b", "c"})
+			g.Assert(p.signingCalls).Eql(2)
+		})
+
+		g.It("passes the context", func() {
+			p := &countingKeyProvider{StaticKeyProvider: StaticKeyProvider{Key: key}}
+			v := &MessageVerifier{KeyProvider: p, Serializer: JsonMsgSerializer{}}
+			ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+			msg, _ := v.GenerateContext(ctx, "foo")
+			g.Assert(p.ctx.Value(ctxKey{})).Eql("request")
+			p.ctx = nil
+			var output string
+			g.Assert(v.VerifyContext(ctx, msg, &output)).Eql(nil)
+			g.Assert(p.ctx.Value(ctxKey{})).Eql("request")
+		})
+
+		g.It("reports the provider failures", func() {
+			v := &MessageVerifier{KeyProvider: failingKeyProvider{errKMS}, Serializer: JsonMsgSerializer{}}
+			_, err := v.Generate("foo")
+			g.Assert(errors.Is(err, errKMS)).IsTrue()
+			msg, _ := (&MessageVerifier{Secret: key, Serializer: JsonMsgSerializer{}}).Generate("foo")
+			var output string
+			g.Assert(errors.Is(v.Verify(msg, &output), errKMS)).IsTrue()
+			g.Assert(v.Valid(msg)).IsFalse()
+			_, err = v.NewSignWriter(nil)
+			g.Assert(errors.Is(err, errKMS)).IsTrue()
+			g.Assert(v.DigestFor("foo")).Eql("")
+		})
+
+		g.It("fails like a missing secret when the key is empty", func() {
+			v := &MessageVerifier{KeyProvider: StaticKeyProvider{}, Serializer: JsonMsgSerializer{}}
+			_, err := v.Generate("foo")
+			g.Assert(errors.Is(err, ErrNoSecret)).IsTrue()
+		})
+	})
+}
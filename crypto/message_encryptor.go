@@ -1,11 +1,69 @@
 package crypto
 
 import (
+	"context"
 	"crypto/sha1"
+	"encoding/base64"
 	"errors"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Ciphers supported by MessageEncryptor.
+const (
+	// AESCBC is AES-256 in CBC mode, signed by a MessageVerifier. It was
+	// Rails' default until 5.2.
+	AESCBC = "aes-cbc"
+	// AES256GCM is AES-256 in GCM mode, an authenticated encryption mode
+	// which doesn't need a verifier. It is Rails' default since 5.2.
+	AES256GCM = "aes-256-gcm"
+	// AES128GCM is AES-128 in GCM mode, the cipher of Rails' encrypted
+	// files such as config/credentials.yml.enc (see EncryptedFile).
+	AES128GCM = "aes-128-gcm"
+	// ChaCha20Poly1305 is an authenticated encryption mode much faster than
+	// AES-GCM on CPUs without AES instructions. It isn't supported by Rails.
+	ChaCha20Poly1305 = "chacha20-poly1305"
+	// XChaCha20Poly1305 is ChaCha20Poly1305 with 24 bytes nonces, long
+	// enough to be picked at random without worrying about collisions.
+	XChaCha20Poly1305 = "xchacha20-poly1305"
+	// AES256CTRHMAC is AES-256 in CTR mode authenticated by an HMAC of the
+	// IV and ciphertext (SHA256 unless MACHasher is set), for interop with
+	// legacy systems. It isn't supported by Rails.
+	AES256CTRHMAC = "aes-256-ctr-hmac"
+)
+
+// ErrInvalidMessage is returned by DecryptAndVerify when a message can't be
+// decrypted or verified, whatever the reason: malformed, tampered with,
+// signed or encrypted with another key. Like Rails' InvalidMessage, the
+// failures can't be told apart so they can't be used as a padding oracle.
+var ErrInvalidMessage = errors.New("Invalid message")
+
+// ErrDecryptFail is the same error as ErrInvalidMessage.
 //
+// Deprecated: use ErrInvalidMessage.
+var ErrDecryptFail = ErrInvalidMessage
+
+// ErrInvalidKeyLength is returned when the encryptor's key can't be used by
+// its cipher.
+var ErrInvalidKeyLength = errors.New("Invalid key length")
+
+// noKeyError reports a key which isn't set, matching both ErrNoSecret and
+// ErrInvalidKeyLength.
+func noKeyError(err error) error {
+	return &messageError{msg: err.Error(), kind: ErrNoSecret, err: err}
+}
+
+func keyLengthError(cipher, expected string, n int) error {
+	return &messageError{
+		msg: "Invalid key length - " + cipher + " needs a " + expected + " bytes key, got " +
+			strconv.Itoa(n) + " bytes, use a KeyGenerator to derive a key of the right size from your secret",
+		kind: ErrInvalidKeyLength,
+	}
+}
+
 // MessageEncryptor is a simple way to encrypt values which get stored
 // somewhere you don't trust.
 //
@@ -16,24 +74,125 @@ import (
 // where you don't want users to be able to determine the value of the payload.
 //
 // Different kind of ciphers are supported:
-//  - aes-cbc - Rails' default until 5.2, requires a verifier
-//  - aes-256-gcm - Rails 5.2+ default, ignores verifier.
+//   - aes-cbc - Rails' default until 5.2, requires a verifier
+//   - aes-256-gcm - Rails 5.2+ default, ignores verifier.
+//   - chacha20-poly1305 and xchacha20-poly1305 - not supported by Rails,
+//     ignore verifier.
+//   - aes-256-ctr-hmac - not supported by Rails, authenticated by its own
+//     HMAC, ignores verifier.
 //
 // Note: The old Rails default serializer, Marshal is neither safe or
 // portable across langauges, use the JSON serializer.
 type MessageEncryptor struct {
+	// Key is the encryption key, 16, 24 or 32 bytes long for aes-cbc
+	// (longer keys are truncated to 32 bytes like Ruby's openssl used to)
+	// and 32 bytes long for the AEAD ciphers.
 	Key []byte
-	// optional property used to automatically set the
-	// verifier if not already set.
-	SignKey    []byte
-	Cipher     string
-	Verifier   *MessageVerifier
+	// optional property used to sign aes-cbc messages when the verifier
+	// isn't set, defaults to Key like in Rails.
+	SignKey []byte
+	// KeyProvider, if set, provides the keys for each operation instead of
+	// Key and SignKey.
+	KeyProvider KeyProvider
+	// Cipher is either AESCBC (the default), AES256GCM, AES128GCM,
+	// ChaCha20Poly1305, XChaCha20Poly1305 or AES256CTRHMAC.
+	Cipher string
+	// MACHasher is the hash of the aes-256-ctr-hmac HMAC, keyed with
+	// SignKey (or Key), SHA256 by default.
+	MACHasher func() hash.Hash
+	Verifier  *MessageVerifier
+	// Serializer defaults to JSON, like in Rails, unless Strict is set.
 	Serializer MsgSerializer
+	// Strict makes the encryptor fail with ErrNoSerializer when Serializer
+	// isn't set instead of using JSON.
+	Strict bool
+	// URLSafe makes the encryptor encode the parts of its messages using
+	// the URL safe base64 alphabet without padding, like Rails' url_safe
+	// option, so they can be used in URLs as is. Messages using either
+	// alphabet are decrypted regardless.
+	URLSafe bool
+	// UseMessageSerializerForMetadata makes the encryptor serialize the
+	// values and their metadata together, like Rails 7.1 does by default,
+	// see MessageVerifier.UseMessageSerializerForMetadata.
+	UseMessageSerializerForMetadata bool
+	// MaxMessageLen is the length over which messages are rejected with
+	// ErrMessageTooLarge before being decoded. It defaults to
+	// DefaultMaxMessageLen, a negative value disables the limit.
+	MaxMessageLen int
+	// Compress makes the encryptor gzip the serialized payloads when it
+	// makes them shorter. Compressed messages can't be read by Rails but
+	// they are decrypted whether Compress is set or not, so it can be
+	// toggled without breaking the messages already out there. The bytes
+	// API (EncryptAndSignBytes and DecryptAndVerifyBytes) only compresses
+	// and inflates the payloads when it is set.
+	Compress bool
+	// EmitVersion makes the encryptor prefix its messages with a header
+	// naming their cipher and serializer, so they are decrypted with them
+	// whatever the Cipher and Serializer of the decrypting encryptor are
+	// and ciphers can be changed without keeping Rotations around.
+	// Versioned messages can't be read by Rails, they are decrypted whether
+	// EmitVersion is set or not. Encrypt and EncryptAndSignWithAAD don't
+	// version their messages.
+	EmitVersion bool
+	// MaxInflatedLen is the length over which compressed messages are
+	// rejected with ErrMessageTooLarge once inflated (a decompression bomb
+	// guard). It defaults to DefaultMaxInflatedLen, a negative value
+	// disables the limit.
+	MaxInflatedLen int
+	// Now returns the current time used to set and check message expiry,
+	// defaults to time.Now.
+	Now func() time.Time
+	// ErrorPreviewLen is the number of bytes of the decrypted payload an
+	// UnserializeError previews. Unlike with MessageVerifier there is no
+	// preview unless it is set, the payload being confidential.
+	ErrorPreviewLen int
+	// RandReader is the source of the IVs and nonces, crypto/rand.Reader
+	// by default.
+	RandReader io.Reader
+	// NonceGuard, if set, makes the encryptor fail with ErrDuplicateNonce
+	// rather than use a nonce it already used. See NewNonceGuard.
+	NonceGuard *NonceGuard
+	// Rotations are encryptors set with previous keys (and/or ciphers and
+	// serializers) that are tried in order when a message can't be
+	// decrypted and verified by the encryptor. Messages are always
+	// encrypted by the encryptor itself. See Rotate().
+	Rotations []*MessageEncryptor
+	// OnRotation is called, if set, with the index in Rotations of the
+	// encryptor that decrypted a message so it can be reissued.
+	OnRotation func(index int)
+}
+
+// Rotate adds an encryptor for an old key to the encryptor's Rotations, like
+// Rails' `encryptor.rotate old_secret, cipher: "aes-256-cbc"`. An empty
+// cipher defaults to the encryptor's cipher, signKey is only used by
+// aes-cbc and the rest of the configuration (serializer, encoding,
+// compression, limits...) is the encryptor's.
+func (crypt *MessageEncryptor) Rotate(key, signKey []byte, cipher string) {
+	if cipher == "" {
+		cipher = crypt.Cipher
+	}
+	crypt.Rotations = append(crypt.Rotations, &MessageEncryptor{
+		Key:             key,
+		SignKey:         signKey,
+		Cipher:          cipher,
+		MACHasher:       crypt.MACHasher,
+		Serializer:      crypt.Serializer,
+		Strict:          crypt.Strict,
+		URLSafe:         crypt.URLSafe,
+		MaxMessageLen:   crypt.MaxMessageLen,
+		Compress:        crypt.Compress,
+		EmitVersion:     crypt.EmitVersion,
+		MaxInflatedLen:  crypt.MaxInflatedLen,
+		Now:             crypt.Now,
+		ErrorPreviewLen: crypt.ErrorPreviewLen,
+
+		UseMessageSerializerForMetadata: crypt.UseMessageSerializerForMetadata,
+	})
 }
 
 func (crypt *MessageEncryptor) withVerifier() bool {
 	switch crypt.Cipher {
-	case "aes-256-gcm":
+	case AES256GCM, AES128GCM, ChaCha20Poly1305, XChaCha20Poly1305, AES256CTRHMAC:
 		return false
 	}
 	return true
@@ -50,34 +209,91 @@ func (crypt *MessageEncryptor) withVerifier() bool {
 // The output string can be converted back using DecryptAndVerify() and is
 // encoded using base64.
 func (crypt *MessageEncryptor) EncryptAndSign(value interface{}) (string, error) {
+	return crypt.EncryptAndSignContext(context.Background(), value)
+}
+
+// EncryptAndSignContext is like EncryptAndSign, ctx being passed to the
+// KeyProvider.
+func (crypt *MessageEncryptor) EncryptAndSignContext(ctx context.Context, value interface{}) (string, error) {
+	return crypt.encryptAndSignValue(ctx, value, MessageOptions{})
+}
+
+// EncryptAndSignWithOptions works like EncryptAndSign but embeds the passed
+// options in the encrypted payload using the same metadata envelope as
+// Rails 5.2+ (ie: the purpose and expiry of encrypted cookies). See
+// DecryptAndVerifyWithOptions() to decrypt such a message.
+func (crypt *MessageEncryptor) EncryptAndSignWithOptions(value interface{}, opts MessageOptions) (string, error) {
+	return crypt.encryptAndSignValue(context.Background(), value, opts)
+}
+
+func (crypt *MessageEncryptor) encryptAndSignValue(ctx context.Context, value interface{}, opts MessageOptions) (string, error) {
 	if crypt == nil {
 		return "", errors.New("can't call EncryptAndSign on a nil *MessageEncryptor")
 	}
+	crypt, err := crypt.withKeys(ctx)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := crypt.serialize(value, opts)
+	if err != nil {
+		return "", err
+	}
+	defer wipe(plaintext)
+	return crypt.encryptAndSign(plaintext)
+}
 
-	if !crypt.withVerifier() {
-		return crypt.Encrypt(value)
+// EncryptAndSignBytes is like EncryptAndSign for data which is already
+// serialized (ie: protobuf bytes): data is encrypted as is and the
+// Serializer doesn't need to be set. The message can be converted back
+// using DecryptAndVerifyBytes().
+func (crypt *MessageEncryptor) EncryptAndSignBytes(data []byte) (string, error) {
+	if crypt == nil {
+		return "", errors.New("can't call EncryptAndSignBytes on a nil *MessageEncryptor")
 	}
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := crypt.compress(data)
+	if err != nil {
+		return "", err
+	}
+	return crypt.encryptAndSign(plaintext)
+}
 
-	// Set a default verifier if a signature key was given instead of setting the verifier directly.
-	if crypt.Verifier == nil && crypt.SignKey != nil {
-		crypt.Verifier = &MessageVerifier{
-			Secret:     crypt.SignKey,
-			Hasher:     sha1.New,
-			Serializer: NullMsgSerializer{},
+// encryptAndSign encrypts the plaintext and signs it when the cipher isn't
+// authenticated, along with the version header if any.
+func (crypt *MessageEncryptor) encryptAndSign(plaintext []byte) (string, error) {
+	// report a bad key rather than a missing sign key.
+	if _, err := crypt.cipherKey(); err != nil {
+		return "", err
+	}
+	header := crypt.versionHeader()
+	if !crypt.withVerifier() {
+		encryptedMsg, err := crypt.encryptPlaintext(plaintext, []byte(header))
+		if err != nil {
+			return "", err
 		}
+		return header + encryptedMsg, nil
 	}
-	if crypt.Verifier == nil {
+
+	verifier := crypt.verifier()
+	if verifier == nil {
 		return "", errors.New("Verifier and/or signature key not set: ")
 	}
-	vvalid, err := crypt.Verifier.IsValid()
+	vvalid, err := verifier.IsValid()
 	if !vvalid {
 		return "", errors.New("Verifier not properly set: " + err.Error())
 	}
-	encryptedMsg, err := crypt.Encrypt(value)
+	encryptedMsg, err := crypt.encryptPlaintext(plaintext, nil)
+	if err != nil {
+		return "", err
+	}
+	signedMsg, err := verifier.Generate(header + encryptedMsg)
 	if err != nil {
 		return "", err
 	}
-	return crypt.Verifier.Generate(encryptedMsg)
+	return header + signedMsg, nil
 }
 
 // DecryptAndVerify decrypts and either authenticates or verifies the signature
@@ -85,41 +301,294 @@ func (crypt *MessageEncryptor) EncryptAndSign(value interface{}) (string, error)
 // either signed or authenticated (GCM) on top of being encrypted in order to
 // avoid padding attacks. Reference: http://www.limited-entropy.com/padding-oracle-attacks.
 // The serializer will populate the pointer you are passing as second argument.
+// Messages which can't be authenticated fail with ErrInvalidMessage, other
+// errors come from the configuration (ie: ErrInvalidKeyLength) or, once the
+// message is authenticated, from the serializer.
+// Messages which can't be decrypted are tried with the Rotations. Messages
+// encrypted for a purpose fail with ErrInvalidPurpose, see
+// DecryptAndVerifyWithOptions().
 func (crypt *MessageEncryptor) DecryptAndVerify(msg string, target interface{}) error {
+	return crypt.DecryptAndVerifyContext(context.Background(), msg, target)
+}
 
-	if !crypt.withVerifier() {
-		return crypt.Decrypt(msg, target)
+// DecryptAndVerifyContext is like DecryptAndVerify, ctx being passed to the
+// KeyProvider of the encryptor and of the Rotations tried.
+func (crypt *MessageEncryptor) DecryptAndVerifyContext(ctx context.Context, msg string, target interface{}) error {
+	return crypt.decryptAndVerifyValue(ctx, msg, target, MessageOptions{})
+}
+
+// DecryptAndVerifyWithOptions works like DecryptAndVerify but also checks
+// the metadata embedded in the message by EncryptAndSignWithOptions (or by
+// Rails) against opts, like MessageVerifier.VerifyWithOptions does: a
+// message encrypted for another purpose fails with ErrInvalidPurpose and
+// a message past its expiry with ErrMessageExpired.
+func (crypt *MessageEncryptor) DecryptAndVerifyWithOptions(msg string, target interface{}, opts MessageOptions) error {
+	return crypt.decryptAndVerifyValue(context.Background(), msg, target, opts)
+}
+
+func (crypt *MessageEncryptor) decryptAndVerifyValue(ctx context.Context, msg string, target interface{}, opts MessageOptions) error {
+	var now time.Time
+	if crypt != nil {
+		now = crypt.now()
 	}
+	return crypt.withRotations(ctx, func(e *MessageEncryptor) error {
+		return e.decryptAndVerify(msg, target, opts, now)
+	})
+}
 
-	// Set a default verifier if a signature key was given instead of setting the verifier directly.
-	if crypt.Verifier == nil && crypt.SignKey != nil {
-		crypt.Verifier = &MessageVerifier{
-			Secret:     crypt.SignKey,
-			Hasher:     sha1.New,
-			Serializer: NullMsgSerializer{},
+// DecryptAndVerifyBytes is like DecryptAndVerify for messages encrypted by
+// EncryptAndSignBytes: it returns the data as it was encrypted, without
+// unserializing it, and the Serializer doesn't need to be set.
+// Compressed payloads are only inflated when Compress is set.
+func (crypt *MessageEncryptor) DecryptAndVerifyBytes(msg string) ([]byte, error) {
+	var data []byte
+	err := crypt.withRotations(context.Background(), func(e *MessageEncryptor) error {
+		e, plaintext, err := e.openMessage(msg)
+		if err != nil || !e.Compress {
+			data = plaintext
+			return err
 		}
+		data, err = e.inflate(plaintext)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (crypt *MessageEncryptor) decryptAndVerify(msg string, target interface{}, opts MessageOptions, now time.Time) error {
+	e, plaintext, err := crypt.openMessage(msg)
+	if err != nil {
+		return err
+	}
+	return e.unserialize(plaintext, target, opts, now)
+}
+
+// decryptAndVerifyPlaintext verifies and decrypts a message and returns its
+// plaintext, checking that it was encrypted along with the version header.
+func (crypt *MessageEncryptor) decryptAndVerifyPlaintext(msg, header string) ([]byte, error) {
+	if err := checkMessageLen(msg, crypt.MaxMessageLen); err != nil {
+		return nil, err
+	}
+	// report a bad key rather than a verification failure.
+	if _, err := crypt.cipherKey(); err != nil {
+		return nil, err
+	}
+
+	if !crypt.withVerifier() {
+		return crypt.decryptPlaintext(msg, []byte(header))
 	}
+
 	var base64Msg string
 	// verify the data and get the encoded data out.
-	err := crypt.Verifier.Verify(msg, &base64Msg)
+	err := crypt.verifier().Verify(msg, &base64Msg)
+	if errors.Is(err, ErrInvalidSignature) || errors.Is(err, ErrMalformedMessage) {
+		return nil, ErrInvalidMessage
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(base64Msg, header) {
+		return nil, ErrInvalidMessage
+	}
+	return crypt.decryptPlaintext(base64Msg[len(header):], nil)
+}
+
+// EncryptAndSignWithAAD is like EncryptAndSign but also authenticates aad,
+// additional data binding the message to a context (ie: a user id or a
+// cookie name) without being part of it. The same aad has to be passed to
+// DecryptAndVerifyWithAAD. Only the AEAD ciphers and aes-256-ctr-hmac
+// support additional data, an empty aad is the same as calling
+// EncryptAndSign.
+func (crypt *MessageEncryptor) EncryptAndSignWithAAD(value interface{}, aad []byte) (string, error) {
+	if len(aad) == 0 {
+		return crypt.EncryptAndSign(value)
+	}
+	if crypt.withVerifier() {
+		return "", aadError(crypt.Cipher)
+	}
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return crypt.encrypt(value, aad)
+}
+
+// DecryptAndVerifyWithAAD is like DecryptAndVerify for messages encrypted by
+// EncryptAndSignWithAAD. A message encrypted with another aad fails with
+// ErrInvalidMessage, like any other tampered message.
+func (crypt *MessageEncryptor) DecryptAndVerifyWithAAD(msg string, target interface{}, aad []byte) error {
+	if len(aad) == 0 {
+		return crypt.DecryptAndVerify(msg, target)
+	}
+	if crypt.withVerifier() {
+		return aadError(crypt.Cipher)
+	}
+	return crypt.withRotations(context.Background(), func(e *MessageEncryptor) error {
+		// skip the rotations which can't have encrypted the message.
+		if e.withVerifier() {
+			return ErrInvalidMessage
+		}
+		return e.decrypt(msg, target, aad)
+	})
+}
+
+// withRotations calls fn with the encryptor and, as long as the message
+// can't be decrypted or verified, with each of its Rotations, set with the
+// keys of their KeyProvider.
+func (crypt *MessageEncryptor) withRotations(ctx context.Context, fn func(e *MessageEncryptor) error) error {
+	e, err := crypt.withKeys(ctx)
 	if err != nil {
-		return errors.New("Verification failed: " + err.Error())
+		return err
+	}
+	err = fn(e)
+	if !rotatable(err) {
+		return err
+	}
+	for i, rotation := range crypt.Rotations {
+		r, rerr := rotation.withKeys(ctx)
+		if rerr != nil {
+			return rerr
+		}
+		rerr = fn(r)
+		if rotatable(rerr) {
+			continue
+		}
+		if rerr == nil && crypt.OnRotation != nil {
+			crypt.OnRotation(i)
+		}
+		return rerr
+	}
+	return err
+}
+
+// rotatable reports whether err means that the message might have been
+// encrypted by another encryptor.
+func rotatable(err error) bool {
+	return errors.Is(err, ErrInvalidMessage)
+}
+
+func aadError(cipher string) error {
+	if cipher == "" {
+		cipher = AESCBC
+	}
+	return configError(cipher + " doesn't support additional authenticated data")
+}
+
+// verifier returns the verifier signing aes-cbc messages: Verifier if set,
+// otherwise one using SignKey or, like Rails, Key when SignKey isn't set.
+// The default verifier isn't stored so the encryptor can be shared between
+// goroutines.
+func (crypt *MessageEncryptor) verifier() *MessageVerifier {
+	if crypt.Verifier != nil {
+		return crypt.Verifier
+	}
+	signKey := crypt.SignKey
+	if len(signKey) == 0 {
+		signKey = crypt.Key
 	}
-	return crypt.Decrypt(base64Msg, target)
+	if len(signKey) == 0 {
+		return nil
+	}
+	return &MessageVerifier{
+		Secret:        signKey,
+		Hasher:        sha1.New,
+		Serializer:    NullMsgSerializer{},
+		URLSafe:       crypt.URLSafe,
+		MaxMessageLen: crypt.MaxMessageLen,
+	}
+}
+
+// encoding returns the base64 encoding of the parts of the messages.
+func (crypt *MessageEncryptor) encoding() *base64.Encoding {
+	if crypt.URLSafe {
+		return base64.RawURLEncoding
+	}
+	return base64.StdEncoding
+}
+
+// cipherKey returns the key to use with the cipher, checking its length so
+// a bad key is reported with ErrInvalidKeyLength on first use.
+func (crypt *MessageEncryptor) cipherKey() ([]byte, error) {
+	k := crypt.Key
+	switch crypt.Cipher {
+	case AES256GCM, ChaCha20Poly1305, XChaCha20Poly1305, AES256CTRHMAC:
+		if k == nil {
+			return nil, noKeyError(keyLengthError(crypt.Cipher, "32", 0))
+		}
+		if len(k) != 32 {
+			return nil, keyLengthError(crypt.Cipher, "32", len(k))
+		}
+	case AES128GCM:
+		if k == nil {
+			return nil, noKeyError(keyLengthError(crypt.Cipher, "16", 0))
+		}
+		if len(k) != 16 {
+			return nil, keyLengthError(crypt.Cipher, "16", len(k))
+		}
+	case AESCBC, "":
+		if k == nil {
+			return nil, noKeyError(keyLengthError(AESCBC, "16, 24 or 32", 0))
+		}
+		// Rails 4 used 64 bytes keys which Ruby's openssl truncated, so
+		// do we.
+		if len(k) > 32 {
+			return k[:32], nil
+		}
+		switch len(k) {
+		case 16, 24, 32:
+		default:
+			return nil, keyLengthError(AESCBC, "16, 24 or 32", len(k))
+		}
+	}
+	return k, nil
+}
+
+// Generate is an alias of EncryptAndSign so a MessageEncryptor can be used as a
+// Signer.
+func (crypt *MessageEncryptor) Generate(value interface{}) (string, error) {
+	return crypt.EncryptAndSign(value)
+}
+
+// Verify is an alias of DecryptAndVerify so a MessageEncryptor can be used as a
+// Verifier.
+func (crypt *MessageEncryptor) Verify(msg string, target interface{}) error {
+	return crypt.DecryptAndVerify(msg, target)
 }
 
 // Encrypt encrypts a message using the set cipher and the secret.
 // The returned value is a base 64 encoded string of the encrypted data + IV joined by "--".
 // An encrypted message isn't safe unless it's signed!
 func (crypt *MessageEncryptor) Encrypt(value interface{}) (string, error) {
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return crypt.encrypt(value, nil)
+}
+
+// encrypt encrypts value, authenticating aad with the AEAD ciphers.
+func (crypt *MessageEncryptor) encrypt(value interface{}, aad []byte) (string, error) {
+	plaintext, err := crypt.serialize(value, MessageOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer wipe(plaintext)
+	return crypt.encryptPlaintext(plaintext, aad)
+}
+
+func (crypt *MessageEncryptor) encryptPlaintext(plaintext, aad []byte) (string, error) {
 	switch crypt.Cipher {
-	case "aes-cbc":
-		return crypt.aesCbcEncrypt(value)
-	case "aes-256-gcm":
-		return crypt.aesGCMEncrypt(value)
+	case AESCBC:
+		return crypt.aesCbcEncrypt(plaintext)
+	case AES256GCM, AES128GCM, ChaCha20Poly1305, XChaCha20Poly1305:
+		return crypt.aeadEncrypt(plaintext, aad)
+	case AES256CTRHMAC:
+		return crypt.aesCtrEncrypt(plaintext, aad)
 	case "":
 		// using a default if not set
-		return crypt.aesCbcEncrypt(value)
+		return crypt.aesCbcEncrypt(plaintext)
 	}
 	return "", errors.New("cipher not set or not supported")
 }
@@ -127,17 +596,87 @@ func (crypt *MessageEncryptor) Encrypt(value interface{}) (string, error) {
 // Decrypt decrypts a message using the set cipher and the secret.
 // The passed value is expected to be a base 64 encoded string of the encrypted data + IV joined by "--"
 func (crypt *MessageEncryptor) Decrypt(value string, target interface{}) error {
-	if crypt.Serializer == nil {
-		crypt.Serializer = JsonMsgSerializer{}
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return err
+	}
+	return crypt.decrypt(value, target, nil)
+}
+
+// decrypt decrypts value, authenticating aad with the AEAD ciphers.
+func (crypt *MessageEncryptor) decrypt(value string, target interface{}, aad []byte) error {
+	plaintext, err := crypt.decryptPlaintext(value, aad)
+	if err != nil {
+		return err
+	}
+	return crypt.unserialize(plaintext, target, MessageOptions{}, crypt.now())
+}
+
+func (crypt *MessageEncryptor) decryptPlaintext(value string, aad []byte) ([]byte, error) {
+	if err := checkMessageLen(value, crypt.MaxMessageLen); err != nil {
+		return nil, err
 	}
 	switch crypt.Cipher {
-	case "aes-cbc":
-		return crypt.aesCbcDecrypt(value, target)
-	case "aes-256-gcm":
-		return crypt.aesGCMDecrypt(value, target)
+	case AESCBC:
+		return crypt.aesCbcDecrypt(value)
+	case AES256GCM, AES128GCM, ChaCha20Poly1305, XChaCha20Poly1305:
+		return crypt.aeadDecrypt(value, aad)
+	case AES256CTRHMAC:
+		return crypt.aesCtrDecrypt(value, aad)
 	case "":
 		// using a default if not set
-		return crypt.aesCbcDecrypt(value, target)
+		return crypt.aesCbcDecrypt(value)
+	}
+	return nil, errors.New("cipher not set or not supported")
+}
+
+// serializer returns the Serializer or, unless the encryptor is Strict, the
+// JSON one when it isn't set. The default isn't stored so the encryptor can
+// be shared between goroutines.
+func (crypt *MessageEncryptor) serializer() (MsgSerializer, error) {
+	if crypt.Serializer != nil {
+		return crypt.Serializer, nil
+	}
+	if crypt.Strict {
+		return nil, ErrNoSerializer
+	}
+	return JsonMsgSerializer{}, nil
+}
+
+// serialize returns the plaintext of value: serialized, wrapped in the
+// metadata envelope if opts has any and compressed.
+func (crypt *MessageEncryptor) serialize(value interface{}, opts MessageOptions) ([]byte, error) {
+	serializer, err := crypt.serializer()
+	if err != nil {
+		return nil, err
+	}
+	data, err := serializeWithMetadata(serializer, value, opts, crypt.now(), crypt.UseMessageSerializerForMetadata)
+	if err != nil {
+		return nil, err
+	}
+	return crypt.compress(data)
+}
+
+// unserialize inflates a decrypted plaintext, checks its metadata against
+// opts and unserializes it into target.
+func (crypt *MessageEncryptor) unserialize(plaintext []byte, target interface{}, opts MessageOptions, now time.Time) error {
+	defer wipe(plaintext)
+	serializer, err := crypt.serializer()
+	if err != nil {
+		return err
+	}
+	data, err := crypt.inflate(plaintext)
+	if err != nil {
+		return err
+	}
+	defer wipe(data)
+	_, err = unserializeWithMetadata(serializer, data, target, opts, now, crypt.ErrorPreviewLen)
+	return err
+}
+
+func (crypt *MessageEncryptor) now() time.Time {
+	if crypt.Now == nil {
+		return time.Now()
 	}
-	return errors.New("cipher not set or not supported")
+	return crypt.Now()
 }
@@ -0,0 +1,77 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strconv"
+)
+
+// Compressed messages are a Go extension which Rails can't read: their
+// plaintext is gzipMagic followed by the gzip data. It starts with a NUL
+// byte, which the JSON and XML serializers never produce, and the gzip data
+// with its own magic bytes, so the plaintexts which don't start with both
+// are taken as is: the messages of Rails and the uncompressed ones are
+// never altered.
+const gzipMagic = "\x00GRYgz"
+
+// gzipHeader is the beginning of gzip data, its magic bytes.
+const gzipHeader = "\x1f\x8b"
+
+// DefaultMaxInflatedLen is the default length over which compressed
+// messages are rejected once inflated.
+const DefaultMaxInflatedLen = 1 << 20
+
+// compress returns the plaintext to encrypt for the serialized data,
+// compressed if Compress is set and it makes it shorter.
+func (crypt *MessageEncryptor) compress(data []byte) ([]byte, error) {
+	if !crypt.Compress {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString(gzipMagic)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(data) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// inflate returns the serialized data of a decrypted plaintext, whether it
+// was compressed or not.
+func (crypt *MessageEncryptor) inflate(plain []byte) ([]byte, error) {
+	if !bytes.HasPrefix(plain, []byte(gzipMagic+gzipHeader)) {
+		return plain, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(plain[len(gzipMagic):]))
+	if err != nil {
+		return nil, err
+	}
+	return readAllLimited(zr, crypt.MaxInflatedLen)
+}
+
+// readAllLimited reads r, usually a decompressor, until EOF and fails with
+// ErrMessageTooLarge past max bytes: DefaultMaxInflatedLen when max is 0, no
+// limit when it's negative.
+func readAllLimited(r io.Reader, max int) ([]byte, error) {
+	if max == 0 {
+		max = DefaultMaxInflatedLen
+	}
+	if max > 0 {
+		r = io.LimitReader(r, int64(max)+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && len(data) > max {
+		return nil, &messageError{msg: "Message too large - over " + strconv.Itoa(max) + " bytes once inflated", kind: ErrMessageTooLarge}
+	}
+	return data, nil
+}
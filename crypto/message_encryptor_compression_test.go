@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestMessageEncryptorCompression(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Compressed messages", func() {
+		key := GenerateRandomKey(32)
+		session := map[string]string{"flash": strings.Repeat("Your report is ready. ", 200)}
+
+		g.It("round trip and are shorter", func() {
+			for _, cipher := range []string{AESCBC, AES256GCM, ChaCha20Poly1305} {
+				plain := &MessageEncryptor{Key: key, Cipher: cipher}
+				compressed := &MessageEncryptor{Key: key, Cipher: cipher, Compress: true}
+				msg, err := compressed.EncryptAndSign(session)
+				g.Assert(err).Eql(nil)
+				uncompressedMsg, _ := plain.EncryptAndSign(session)
+				g.Assert(len(msg) < len(uncompressedMsg)/4).IsTrue()
+
+				var output map[string]string
+				g.Assert(compressed.DecryptAndVerify(msg, &output)).Eql(nil)
+				g.Assert(output).Eql(session)
+			}
+		})
+
+		g.It("aren't compressed when it doesn't help", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM, Compress: true, Serializer: NullMsgSerializer{}}
+			msg, _ := e.EncryptAndSign("foo")
+			raw := &MessageEncryptor{Key: key, Cipher: AES256GCM, Serializer: NullMsgSerializer{}}
+			rawMsg, _ := raw.EncryptAndSign("foo")
+			g.Assert(len(msg)).Eql(len(rawMsg))
+		})
+
+		g.It("are read whether Compress is set or not", func() {
+			plain := &MessageEncryptor{Key: key}
+			compressed := &MessageEncryptor{Key: key, Compress: true}
+			oldMsg, _ := plain.EncryptAndSign(session)
+			newMsg, _ := compressed.EncryptAndSign(session)
+			for _, e := range []*MessageEncryptor{plain, compressed} {
+				for _, msg := range []string{oldMsg, newMsg} {
+					var output map[string]string
+					g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+					g.Assert(output).Eql(session)
+				}
+			}
+		})
+
+		g.It("keep uncompressed JSON messages compatible with Rails", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM}
+			msg, _ := e.EncryptAndSign("foo")
+			raw := &MessageEncryptor{Key: key, Cipher: AES256GCM, Serializer: NullMsgSerializer{}}
+			var output string
+			g.Assert(raw.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql(`"foo"`)
+		})
+
+		g.It("leave raw payloads starting with a NUL byte alone", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM, Serializer: NullMsgSerializer{}}
+			for _, data := range []string{"\x00", "\x01", "\x00\x01binary", "\x01\x1f\x8b", gzipMagic, gzipMagic + "\x1f"} {
+				msg, err := e.EncryptAndSign(data)
+				g.Assert(err).Eql(nil)
+				var output string
+				g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+				g.Assert(output).Eql(data)
+			}
+		})
+
+		g.It("don't alter the raw payloads of Rails", func() {
+			// `ActiveSupport::MessageEncryptor.new(key, cipher: "aes-256-gcm",
+			// serializer: ActiveSupport::MessageEncryptor::NullSerializer)
+			// .encrypt_and_sign(payload)` with a fixed nonce, computed
+			// following ActiveSupport's algorithm with Go's standard library.
+			railsKey := []byte("0123456789abcdef0123456789abcdef")
+			railsMessages := map[string]string{
+				"\x00\x01\x02 binary":         "Njzs9PbRsC4X7w==--cmFpbHNub25jZTEy--J+IL+WioObibzKq77QI/Rw==",
+				"\x01\x1f\x8b\x08\x00 binary": "NyJl3JSYvCYL92ZZ--cmFpbHNub25jZTEy--IZTczE42oD6gpSXagJFV/A==",
+			}
+			for _, compress := range []bool{false, true} {
+				e := &MessageEncryptor{Key: railsKey, Cipher: AES256GCM, Compress: compress, Serializer: NullMsgSerializer{}}
+				for payload, msg := range railsMessages {
+					out, err := e.DecryptAndVerifyBytes(msg)
+					g.Assert(err).Eql(nil)
+					g.Assert(string(out)).Eql(payload)
+					var output string
+					g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+					g.Assert(output).Eql(payload)
+
+					for _, cipher := range []string{AESCBC, AES256GCM, AES256CTRHMAC} {
+						ee := &MessageEncryptor{Key: railsKey, Cipher: cipher, Compress: compress}
+						msg, err := ee.EncryptAndSignBytes([]byte(payload))
+						g.Assert(err).Eql(nil)
+						out, err := ee.DecryptAndVerifyBytes(msg)
+						g.Assert(err).Eql(nil)
+						g.Assert(string(out)).Eql(payload)
+					}
+				}
+			}
+		})
+
+		g.It("are only inflated by the bytes API when Compress is set", func() {
+			compressed := &MessageEncryptor{Key: key, Compress: true}
+			data := []byte(strings.Repeat("compress me ", 100))
+			msg, _ := compressed.EncryptAndSignBytes(data)
+			out, err := compressed.DecryptAndVerifyBytes(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(string(out)).Eql(string(data))
+			out, err = (&MessageEncryptor{Key: key}).DecryptAndVerifyBytes(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(strings.HasPrefix(string(out), gzipMagic+gzipHeader)).IsTrue()
+		})
+
+		g.It("are rejected when they inflate over MaxInflatedLen", func() {
+			bomb := strings.Repeat("0", 10<<20)
+			unlimited := &MessageEncryptor{Key: key, Cipher: AES256GCM, Compress: true, MaxInflatedLen: -1, Serializer: NullMsgSerializer{}}
+			msg, err := unlimited.EncryptAndSign(bomb)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(msg) < 64<<10).IsTrue()
+
+			var output string
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM, Serializer: NullMsgSerializer{}}
+			err = e.DecryptAndVerify(msg, &output)
+			g.Assert(errors.Is(err, ErrMessageTooLarge)).IsTrue()
+			g.Assert(err.Error()).Eql("Message too large - over 1048576 bytes once inflated")
+
+			e.MaxInflatedLen = 10 << 20
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(len(output)).Eql(10 << 20)
+			e.MaxInflatedLen = 10<<20 - 1
+			g.Assert(errors.Is(e.DecryptAndVerify(msg, &output), ErrMessageTooLarge)).IsTrue()
+		})
+	})
+}
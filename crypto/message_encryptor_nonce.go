@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"container/list"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrDuplicateNonce is returned when an encryptor's NonceGuard sees a nonce
+// (or IV) generated twice, which with GCM would let an attacker recover the
+// authentication key and forge messages. It means that RandReader is broken.
+var ErrDuplicateNonce = errors.New("Duplicate nonce")
+
+// NonceGuard remembers the last nonces generated by an encryptor to detect
+// a nonce generated twice. Random nonces don't collide in practice, the
+// guard is a safety net against a broken random source (ie: a VM snapshot
+// restored twice or a bad RandReader). It is safe for concurrent use. The
+// zero value remembers the last DefaultNonceGuardSize nonces.
+type NonceGuard struct {
+	size int
+	mu   sync.Mutex
+	// lru holds the nonces, the most recent first.
+	lru    *list.List
+	nonces map[string]*list.Element
+}
+
+// DefaultNonceGuardSize is the number of nonces a zero NonceGuard
+// remembers.
+const DefaultNonceGuardSize = 1024
+
+// NewNonceGuard returns a guard remembering the last size nonces, the older
+// ones being forgotten. Each nonce uses about 100 bytes of memory.
+func NewNonceGuard(size int) *NonceGuard {
+	if size < 1 {
+		size = 1
+	}
+	return &NonceGuard{size: size, lru: list.New(), nonces: make(map[string]*list.Element, size)}
+}
+
+// check records nonce, failing with ErrDuplicateNonce if it was already
+// seen.
+func (g *NonceGuard) check(nonce []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.nonces == nil {
+		if g.size < 1 {
+			g.size = DefaultNonceGuardSize
+		}
+		g.lru, g.nonces = list.New(), make(map[string]*list.Element, g.size)
+	}
+	if e, ok := g.nonces[string(nonce)]; ok {
+		g.lru.MoveToFront(e)
+		return ErrDuplicateNonce
+	}
+	g.nonces[string(nonce)] = g.lru.PushFront(string(nonce))
+	if g.lru.Len() > g.size {
+		oldest := g.lru.Back()
+		g.lru.Remove(oldest)
+		delete(g.nonces, oldest.Value.(string))
+	}
+	return nil
+}
+
+// newNonce returns n bytes read from RandReader to be used as a nonce or IV,
+// checked by the NonceGuard if set.
+func (crypt *MessageEncryptor) newNonce(n int) ([]byte, error) {
+	r := crypt.RandReader
+	if r == nil {
+		r = rand.Reader
+	}
+	nonce := make([]byte, n)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, err
+	}
+	if crypt.NonceGuard != nil {
+		if err := crypt.NonceGuard.check(nonce); err != nil {
+			return nil, err
+		}
+	}
+	return nonce, nil
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+// countingReader returns the bytes 0, 1, 2... wrapping around at 256.
+type countingReader struct{ n byte }
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.n
+		r.n++
+	}
+	return len(p), nil
+}
+
+func TestMessageEncryptorNonce(t *testing.T) {
+	g := Goblin(t)
+	key := GenerateRandomKey(32)
+
+	g.Describe("RandReader", func() {
+		g.It("is the source of the GCM nonces", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM, RandReader: &countingReader{}}
+			msg, err := e.EncryptAndSign("foo")
+			g.Assert(err).Eql(nil)
+			vectors := strings.Split(msg, "--")
+			g.Assert(vectors[1]).Eql(base64.StdEncoding.EncodeToString([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}))
+			msg, _ = e.EncryptAndSign("foo")
+			g.Assert(strings.Split(msg, "--")[1]).Eql(base64.StdEncoding.EncodeToString([]byte{12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23}))
+
+			var output string
+			g.Assert((&MessageEncryptor{Key: key, Cipher: AES256GCM}).DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("foo")
+		})
+
+		g.It("is the source of the aes-cbc IVs", func() {
+			e := &MessageEncryptor{Key: key, RandReader: &countingReader{n: 100}}
+			msg, err := e.EncryptAndSign("foo")
+			g.Assert(err).Eql(nil)
+			payload, _, err := ParseSignedMessage(msg)
+			g.Assert(err).Eql(nil)
+			iv := make([]byte, 16)
+			for i := range iv {
+				iv[i] = byte(100 + i)
+			}
+			g.Assert(strings.Split(string(payload), "--")[1]).Eql(base64.StdEncoding.EncodeToString(iv))
+		})
+
+		g.It("is the source of the stream nonce prefixes", func() {
+			e := &MessageEncryptor{Key: key, Cipher: XChaCha20Poly1305, RandReader: &countingReader{}}
+			var buf bytes.Buffer
+			w, err := e.NewEncryptWriter(&buf)
+			g.Assert(err).Eql(nil)
+			g.Assert(w.Close()).Eql(nil)
+			prefix := buf.Bytes()[streamHeaderLen : streamHeaderLen+24-streamNonceSuffixLen]
+			g.Assert(prefix[0]).Eql(byte(0))
+			g.Assert(prefix[len(prefix)-1]).Eql(byte(len(prefix) - 1))
+		})
+
+		g.It("reports its failures", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM, RandReader: bytes.NewReader([]byte{1, 2, 3})}
+			_, err := e.EncryptAndSign("foo")
+			g.Assert(err != nil).IsTrue()
+		})
+	})
+
+	g.Describe("NonceGuard", func() {
+		g.It("rejects a nonce generated twice", func() {
+			for _, cipher := range []string{AESCBC, AES256GCM, ChaCha20Poly1305} {
+				stuck := bytes.Repeat([]byte{42}, 64)
+				e := &MessageEncryptor{Key: key, Cipher: cipher, RandReader: bytes.NewReader(stuck), NonceGuard: NewNonceGuard(10)}
+				_, err := e.EncryptAndSign("foo")
+				g.Assert(err).Eql(nil)
+				_, err = e.EncryptAndSign("foo")
+				g.Assert(err).Eql(ErrDuplicateNonce)
+			}
+		})
+
+		g.It("doesn't get in the way of unique nonces", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM, NonceGuard: NewNonceGuard(10)}
+			for i := 0; i < 100; i++ {
+				_, err := e.EncryptAndSign("foo")
+				g.Assert(err).Eql(nil)
+			}
+		})
+
+		g.It("only remembers the last nonces", func() {
+			guard := NewNonceGuard(2)
+			g.Assert(guard.check([]byte("a"))).Eql(nil)
+			g.Assert(guard.check([]byte("b"))).Eql(nil)
+			g.Assert(guard.check([]byte("a"))).Eql(ErrDuplicateNonce)
+			// "a" was seen last, "b" is forgotten.
+			g.Assert(guard.check([]byte("c"))).Eql(nil)
+			g.Assert(guard.check([]byte("b"))).Eql(nil)
+			g.Assert(guard.check([]byte("a"))).Eql(nil)
+			g.Assert(guard.lru.Len()).Eql(2)
+		})
+
+		g.It("can be used as a zero value", func() {
+			stuck := bytes.Repeat([]byte{42}, 64)
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM, RandReader: bytes.NewReader(stuck), NonceGuard: &NonceGuard{}}
+			_, err := e.EncryptAndSign("foo")
+			g.Assert(err).Eql(nil)
+			_, err = e.EncryptAndSign("foo")
+			g.Assert(err).Eql(ErrDuplicateNonce)
+			g.Assert(e.NonceGuard.size).Eql(DefaultNonceGuardSize)
+		})
+	})
+}
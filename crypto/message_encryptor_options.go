@@ -0,0 +1,164 @@
+package crypto
+
+import (
+	"io"
+	"strconv"
+)
+
+// EncryptorOption configures a MessageEncryptor built by NewMessageEncryptor.
+type EncryptorOption func(crypt *MessageEncryptor) error
+
+// WithCipher sets the cipher, AESCBC being used by default.
+func WithCipher(cipher string) EncryptorOption {
+	return func(crypt *MessageEncryptor) error {
+		switch cipher {
+		case AESCBC, AES256GCM, AES128GCM, ChaCha20Poly1305, XChaCha20Poly1305, AES256CTRHMAC:
+		default:
+			return configError("unsupported cipher " + cipher)
+		}
+		crypt.Cipher = cipher
+		return nil
+	}
+}
+
+// WithSignSecret sets the key signing the messages (SignKey), which
+// defaults to the encryption key. Only aes-cbc and aes-256-ctr-hmac
+// messages are signed.
+func WithSignSecret(signKey []byte) EncryptorOption {
+	return func(crypt *MessageEncryptor) error {
+		if len(signKey) == 0 {
+			return configError("empty sign secret")
+		}
+		crypt.SignKey = signKey
+		return nil
+	}
+}
+
+// WithEncryptorSerializer sets the serializer, JSON being used by default.
+// It's the encryptor's WithSerializer.
+func WithEncryptorSerializer(serializer MsgSerializer) EncryptorOption {
+	return func(crypt *MessageEncryptor) error {
+		if serializer == nil {
+			return configError("nil serializer")
+		}
+		crypt.Serializer = serializer
+		return nil
+	}
+}
+
+// WithEncryptorURLSafe makes the encryptor generate URL safe messages, see
+// URLSafe. It's the encryptor's WithURLSafe.
+func WithEncryptorURLSafe() EncryptorOption {
+	return func(crypt *MessageEncryptor) error {
+		crypt.URLSafe = true
+		return nil
+	}
+}
+
+// WithEncryptorMessageSerializerForMetadata makes the encryptor embed the
+// metadata of its messages like Rails 7.1, see
+// UseMessageSerializerForMetadata. It's the encryptor's
+// WithMessageSerializerForMetadata.
+func WithEncryptorMessageSerializerForMetadata() EncryptorOption {
+	return func(crypt *MessageEncryptor) error {
+		crypt.UseMessageSerializerForMetadata = true
+		return nil
+	}
+}
+
+// WithEncryptorRotations sets encryptors for previous keys, see Rotations.
+// Like with Rotate, the rotations without a cipher or serializer use the
+// ones of the encryptor being built. The passed encryptors aren't modified.
+// It's the encryptor's WithRotations.
+func WithEncryptorRotations(rotations ...*MessageEncryptor) EncryptorOption {
+	return func(crypt *MessageEncryptor) error {
+		for _, r := range rotations {
+			if r == nil {
+				return configError("nil rotation")
+			}
+			rotation := *r
+			crypt.Rotations = append(crypt.Rotations, &rotation)
+		}
+		return nil
+	}
+}
+
+// WithRand sets the source of the IVs and nonces, see RandReader.
+func WithRand(r io.Reader) EncryptorOption {
+	return func(crypt *MessageEncryptor) error {
+		if r == nil {
+			return configError("nil rand reader")
+		}
+		crypt.RandReader = r
+		return nil
+	}
+}
+
+// NewMessageEncryptor returns an encryptor using the passed key, configured
+// with the passed options and checked up front so a bad key is caught when
+// the app starts. A key which doesn't fit the cipher is reported with
+// ErrInvalidKeyLength, other errors match ErrInvalidConfig.
+// The serializer defaults to JSON and the cipher to aes-cbc.
+//
+// Constructing a MessageEncryptor as a struct literal still works, its key
+// being checked on first use.
+func NewMessageEncryptor(key []byte, opts ...EncryptorOption) (*MessageEncryptor, error) {
+	crypt := &MessageEncryptor{Key: key, Serializer: JsonMsgSerializer{}}
+	for _, opt := range opts {
+		if err := opt(crypt); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range crypt.Rotations {
+		if r.Cipher == "" {
+			r.Cipher = crypt.Cipher
+		}
+		if r.Serializer == nil {
+			r.Serializer = crypt.Serializer
+		}
+	}
+
+	if err := crypt.checkConfig(""); err != nil {
+		return nil, err
+	}
+	for i, r := range crypt.Rotations {
+		if err := r.checkConfig("rotation " + strconv.Itoa(i) + ": "); err != nil {
+			return nil, err
+		}
+	}
+	return crypt, nil
+}
+
+// EncryptorFrom returns an encryptor whose 32 bytes key is derived by kd
+// from the passed salt, which fits every cipher. Options are applied like
+// with NewMessageEncryptor, aes-cbc messages being signed with the key
+// unless WithSignSecret is passed.
+func EncryptorFrom(kd KeyDeriver, salt string, opts ...EncryptorOption) (*MessageEncryptor, error) {
+	if kd == nil {
+		return nil, configError("nil key deriver")
+	}
+	return NewMessageEncryptor(kd.Generate([]byte(salt), 32), opts...)
+}
+
+// checkConfig checks that the encryptor can encrypt and decrypt messages,
+// the error message being prefixed with prefix.
+func (crypt *MessageEncryptor) checkConfig(prefix string) error {
+	switch crypt.Cipher {
+	case "", AESCBC, AES256GCM, AES128GCM, ChaCha20Poly1305, XChaCha20Poly1305, AES256CTRHMAC:
+	default:
+		return configError(prefix + "unsupported cipher " + crypt.Cipher)
+	}
+	if len(crypt.SignKey) > 0 && !crypt.withVerifier() && crypt.Cipher != AES256CTRHMAC {
+		return configError(prefix + crypt.Cipher + " doesn't use a sign secret")
+	}
+	if crypt.KeyProvider != nil {
+		return nil
+	}
+	if _, err := crypt.cipherKey(); err != nil {
+		if prefix != "" {
+			return &messageError{msg: "Invalid configuration - " + prefix + err.Error(), kind: ErrInvalidConfig, err: err}
+		}
+		return err
+	}
+	return nil
+}
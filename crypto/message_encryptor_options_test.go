@@ -0,0 +1,177 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestNewMessageEncryptor(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("NewMessageEncryptor", func() {
+		data := testStruct{Foo: "foo", Bar: 42}
+
+		g.It("accepts 16, 24 and 32 bytes keys for aes-cbc", func() {
+			for _, n := range []int{16, 24, 32} {
+				e, err := NewMessageEncryptor(GenerateRandomKey(n))
+				g.Assert(err).Eql(nil)
+				msg, err := e.EncryptAndSign(data)
+				g.Assert(err).Eql(nil)
+				var decrypted testStruct
+				g.Assert(e.DecryptAndVerify(msg, &decrypted)).Eql(nil)
+				g.Assert(decrypted).Eql(data)
+			}
+		})
+
+		g.It("truncates longer aes-cbc keys like Ruby's openssl did", func() {
+			key := GenerateRandomKey(64)
+			e, err := NewMessageEncryptor(key, WithCipher(AESCBC))
+			g.Assert(err).Eql(nil)
+			msg, _ := e.Encrypt(data)
+			truncated := &MessageEncryptor{Key: key[:32]}
+			var decrypted testStruct
+			g.Assert(truncated.Decrypt(msg, &decrypted)).Eql(nil)
+			g.Assert(decrypted).Eql(data)
+		})
+
+		g.It("accepts a 32 bytes key for aes-256-gcm", func() {
+			e, err := NewMessageEncryptor(GenerateRandomKey(32), WithCipher(AES256GCM))
+			g.Assert(err).Eql(nil)
+			msg, err := e.EncryptAndSign(data)
+			g.Assert(err).Eql(nil)
+			var decrypted testStruct
+			g.Assert(e.DecryptAndVerify(msg, &decrypted)).Eql(nil)
+			g.Assert(decrypted).Eql(data)
+		})
+
+		g.It("rejects bad key lengths", func() {
+			cases := []struct {
+				cipher string
+				sizes  []int
+				msg    string
+			}{
+				{AESCBC, []int{0, 8, 15, 20, 31}, "Invalid key length - aes-cbc needs a 16, 24 or 32 bytes key, got "},
+				{AES256GCM, []int{0, 8, 16, 20, 24, 31, 33, 64}, "Invalid key length - aes-256-gcm needs a 32 bytes key, got "},
+			}
+			for _, c := range cases {
+				for _, n := range c.sizes {
+					e, err := NewMessageEncryptor(make([]byte, n), WithCipher(c.cipher))
+					g.Assert(e == nil).IsTrue()
+					g.Assert(errors.Is(err, ErrInvalidKeyLength)).IsTrue()
+					g.Assert(strings.HasPrefix(err.Error(), c.msg)).IsTrue()
+					g.Assert(strings.Contains(err.Error(), "KeyGenerator")).IsTrue()
+				}
+			}
+		})
+
+		g.It("rejects unknown ciphers", func() {
+			_, err := NewMessageEncryptor(GenerateRandomKey(32), WithCipher("rot13"))
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			g.Assert(err.Error()).Eql("Invalid configuration - unsupported cipher rot13")
+		})
+
+		g.It("defaults to JSON and aes-cbc", func() {
+			e, err := NewMessageEncryptor(GenerateRandomKey(32))
+			g.Assert(err).Eql(nil)
+			g.Assert(e.Serializer).Eql(JsonMsgSerializer{})
+			g.Assert(e.Cipher).Eql("")
+		})
+
+		g.It("sets the sign secret, serializer and rand reader", func() {
+			key, signKey := GenerateRandomKey(32), GenerateRandomKey(64)
+			e, err := NewMessageEncryptor(key,
+				WithSignSecret(signKey),
+				WithEncryptorSerializer(NullMsgSerializer{}),
+				WithRand(&countingReader{}))
+			g.Assert(err).Eql(nil)
+			msg, err := e.EncryptAndSign("foo")
+			g.Assert(err).Eql(nil)
+			payload, _, _ := ParseSignedMessage(msg)
+			g.Assert(strings.HasSuffix(string(payload), "--AAECAwQFBgcICQoLDA0ODw==")).IsTrue()
+
+			var output string
+			literal := &MessageEncryptor{Key: key, SignKey: signKey, Serializer: NullMsgSerializer{}}
+			g.Assert(literal.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("foo")
+		})
+
+		g.It("sets the rotations", func() {
+			oldKey := GenerateRandomKey(32)
+			old := &MessageEncryptor{Key: oldKey, Cipher: AES256GCM, Serializer: XMLMsgSerializer{}}
+			oldMsg, _ := old.EncryptAndSign("foo")
+			e, err := NewMessageEncryptor(GenerateRandomKey(32),
+				WithCipher(ChaCha20Poly1305),
+				WithEncryptorRotations(old, &MessageEncryptor{Key: oldKey}))
+			g.Assert(err).Eql(nil)
+			var output string
+			g.Assert(e.DecryptAndVerify(oldMsg, &output)).Eql(nil)
+			g.Assert(output).Eql("foo")
+			// the rotations default to the encryptor's settings, the
+			// passed encryptors aren't modified.
+			g.Assert(e.Rotations[1].Cipher).Eql(ChaCha20Poly1305)
+			g.Assert(e.Rotations[1].Serializer).Eql(JsonMsgSerializer{})
+			g.Assert(e.Rotations[0] != old).IsTrue()
+		})
+
+		g.It("rejects invalid combinations", func() {
+			key := GenerateRandomKey(32)
+			cases := []struct {
+				key  []byte
+				opts []EncryptorOption
+				kind error
+				msg  string
+			}{
+				{key, []EncryptorOption{WithCipher(AES256GCM), WithSignSecret(key)}, ErrInvalidConfig,
+					"Invalid configuration - aes-256-gcm doesn't use a sign secret"},
+				{key, []EncryptorOption{WithSignSecret(key), WithCipher(ChaCha20Poly1305)}, ErrInvalidConfig,
+					"Invalid configuration - chacha20-poly1305 doesn't use a sign secret"},
+				{key[:16], []EncryptorOption{WithCipher(AES256GCM)}, ErrInvalidKeyLength,
+					"Invalid key length - aes-256-gcm needs a 32 bytes key, got 16 bytes"},
+				{key, []EncryptorOption{WithSignSecret(nil)}, ErrInvalidConfig,
+					"Invalid configuration - empty sign secret"},
+				{key, []EncryptorOption{WithEncryptorSerializer(nil)}, ErrInvalidConfig,
+					"Invalid configuration - nil serializer"},
+				{key, []EncryptorOption{WithRand(nil)}, ErrInvalidConfig,
+					"Invalid configuration - nil rand reader"},
+				{key, []EncryptorOption{WithEncryptorRotations(nil)}, ErrInvalidConfig,
+					"Invalid configuration - nil rotation"},
+				{key, []EncryptorOption{WithEncryptorRotations(&MessageEncryptor{Key: key, Cipher: "rot13"})}, ErrInvalidConfig,
+					"Invalid configuration - rotation 0: unsupported cipher rot13"},
+				{key, []EncryptorOption{WithCipher(AES256GCM), WithEncryptorRotations(&MessageEncryptor{Key: key[:16]})}, ErrInvalidKeyLength,
+					"Invalid configuration - rotation 0: Invalid key length - aes-256-gcm needs a 32 bytes key, got 16 bytes"},
+			}
+			for _, c := range cases {
+				e, err := NewMessageEncryptor(c.key, c.opts...)
+				g.Assert(e == nil).IsTrue()
+				g.Assert(errors.Is(err, c.kind)).IsTrue()
+				g.Assert(strings.HasPrefix(err.Error(), c.msg)).IsTrue()
+			}
+		})
+	})
+
+	g.Describe("MessageEncryptor key checks", func() {
+		g.It("happen on first use", func() {
+			for _, cipher := range []string{"", AESCBC, AES256GCM} {
+				e := &MessageEncryptor{Key: make([]byte, 20), Cipher: cipher}
+				_, err := e.EncryptAndSign("foo")
+				g.Assert(errors.Is(err, ErrInvalidKeyLength)).IsTrue()
+				_, err = e.Encrypt("foo")
+				g.Assert(errors.Is(err, ErrInvalidKeyLength)).IsTrue()
+				var out string
+				g.Assert(errors.Is(e.DecryptAndVerify("foo--bar", &out), ErrInvalidKeyLength)).IsTrue()
+				g.Assert(errors.Is(e.Decrypt("foo--bar", &out), ErrInvalidKeyLength)).IsTrue()
+			}
+		})
+
+		g.It("are reported before the signature", func() {
+			signed := &MessageEncryptor{Key: GenerateRandomKey(32)}
+			msg, _ := signed.EncryptAndSign("foo")
+			e := &MessageEncryptor{Key: signed.Key[:20], SignKey: signed.Key}
+			var out string
+			g.Assert(errors.Is(e.DecryptAndVerify(msg, &out), ErrInvalidKeyLength)).IsTrue()
+		})
+	})
+}
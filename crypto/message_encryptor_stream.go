@@ -0,0 +1,336 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Streams are a Go extension which Rails can't read: a header followed by
+// the data split in chunks, each sealed by the encryptor's AEAD cipher (GCM
+// for aes-cbc encryptors) with a nonce made of a random prefix, the chunk
+// counter and a flag set on the last chunk, so chunks can't be reordered,
+// dropped or truncated. The header is authenticated with every chunk.
+// Like Tink's streaming AEAD, each stream is encrypted with a key of its
+// own, derived with HKDF from the encryptor's key and a random salt, so
+// the short nonce prefixes don't have to be unique across the streams.
+//
+// header: magic | version | cipher | chunk size (uint32) | salt | nonce prefix
+const (
+	streamMagic        = "GRYE"
+	streamVersion      = 2
+	streamChunkSize    = 64 * 1024
+	maxStreamChunkSize = 16 << 20
+	// streamHeaderLen is the length of the header without the salt and the
+	// nonce prefix.
+	streamHeaderLen = len(streamMagic) + 1 + 1 + 4
+	streamSaltLen   = 32
+	// the counter and the last chunk flag.
+	streamNonceSuffixLen = 4 + 1
+)
+
+// streamKeyInfo is the HKDF info the stream keys are derived with, followed
+// by the beginning of the header.
+const streamKeyInfo = "goRailsYourself stream key"
+
+// streamCipherID returns the id in the stream header of the AEAD used to
+// encrypt streams.
+func (crypt *MessageEncryptor) streamCipherID() (byte, error) {
+	switch crypt.Cipher {
+	case AESCBC, AES256GCM, AES128GCM, AES256CTRHMAC, "":
+		return 1, nil
+	case ChaCha20Poly1305:
+		return 2, nil
+	case XChaCha20Poly1305:
+		return 3, nil
+	}
+	return 0, errors.New("cipher not set or not supported")
+}
+
+// streamAEAD returns the AEAD of the stream whose header, up to its nonce
+// prefix, is passed: the encryptor's cipher keyed with a key derived from
+// the encryptor's key and the salt of the header.
+func (crypt *MessageEncryptor) streamAEAD(header []byte) (cipher.AEAD, error) {
+	k, err := crypt.cipherKey()
+	if err != nil {
+		return nil, err
+	}
+	salt := header[streamHeaderLen : streamHeaderLen+streamSaltLen]
+	info := append([]byte(streamKeyInfo), header[:streamHeaderLen]...)
+	key := make([]byte, len(k))
+	defer wipe(key)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, k, salt, info), key); err != nil {
+		return nil, err
+	}
+	switch crypt.Cipher {
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return newGCM(key)
+}
+
+// streamNonceSize returns the nonce size of the encryptor's stream AEAD.
+func (crypt *MessageEncryptor) streamNonceSize() int {
+	if crypt.Cipher == XChaCha20Poly1305 {
+		return chacha20poly1305.NonceSizeX
+	}
+	return 12
+}
+
+// streamNonce sets the counter and last chunk flag of a chunk's nonce.
+func streamNonce(nonce []byte, counter uint64, last bool) error {
+	if counter > math.MaxUint32 {
+		return errors.New("stream too long")
+	}
+	suffix := nonce[len(nonce)-streamNonceSuffixLen:]
+	binary.BigEndian.PutUint32(suffix, uint32(counter))
+	suffix[4] = 0
+	if last {
+		suffix[4] = 1
+	}
+	return nil
+}
+
+// NewEncryptWriter returns a writer encrypting the data written to it in
+// chunks and writing them to dst, so large payloads (ie: report files) can
+// be encrypted without holding them in memory. Close encrypts the last
+// chunk, it doesn't close dst. The data isn't serialized.
+//
+// Streams use the encryptor's Key and Cipher, the streams of aes-cbc and
+// aes-256-ctr-hmac encryptors being encrypted with AES-GCM. Their format is
+// specific to this package, they can only be decrypted by NewDecryptReader.
+func (crypt *MessageEncryptor) NewEncryptWriter(dst io.Writer) (io.WriteCloser, error) {
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	id, err := crypt.streamCipherID()
+	if err != nil {
+		return nil, err
+	}
+	prefixLen := crypt.streamNonceSize() - streamNonceSuffixLen
+	header := make([]byte, streamHeaderLen+streamSaltLen+prefixLen)
+	copy(header, streamMagic)
+	header[len(streamMagic)] = streamVersion
+	header[len(streamMagic)+1] = id
+	binary.BigEndian.PutUint32(header[len(streamMagic)+2:], streamChunkSize)
+	r := crypt.RandReader
+	if r == nil {
+		r = rand.Reader
+	}
+	if _, err := io.ReadFull(r, header[streamHeaderLen:streamHeaderLen+streamSaltLen]); err != nil {
+		return nil, err
+	}
+	aead, err := crypt.streamAEAD(header)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := crypt.newNonce(prefixLen)
+	if err != nil {
+		return nil, err
+	}
+	copy(header[streamHeaderLen+streamSaltLen:], prefix)
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, prefix)
+	return &encryptWriter{
+		dst:    dst,
+		aead:   aead,
+		header: header,
+		nonce:  nonce,
+		buf:    make([]byte, 0, streamChunkSize),
+	}, nil
+}
+
+type encryptWriter struct {
+	dst           io.Writer
+	aead          cipher.AEAD
+	header        []byte
+	headerWritten bool
+	nonce         []byte
+	counter       uint64
+	// buf is the plaintext of the current chunk.
+	buf    []byte
+	sealed []byte
+	err    error
+	closed bool
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to a closed encrypt writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		if w.err != nil {
+			return n, w.err
+		}
+		// a full chunk is only sealed once we know it isn't the last.
+		if len(w.buf) == cap(w.buf) {
+			w.err = w.flush(false)
+			continue
+		}
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Close encrypts and writes the last chunk.
+func (w *encryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	return w.flush(true)
+}
+
+func (w *encryptWriter) flush(last bool) error {
+	if err := streamNonce(w.nonce, w.counter, last); err != nil {
+		return err
+	}
+	w.counter++
+	w.sealed = w.aead.Seal(w.sealed[:0], w.nonce, w.buf, w.header)
+	w.buf = w.buf[:0]
+	if !w.headerWritten {
+		w.headerWritten = true
+		if _, err := w.dst.Write(w.header); err != nil {
+			return err
+		}
+	}
+	_, err := w.dst.Write(w.sealed)
+	return err
+}
+
+// NewDecryptReader returns a reader decrypting a stream written by
+// NewEncryptWriter and read from src. Each chunk is authenticated before
+// its data is returned and a stream which was tampered with, truncated or
+// encrypted with another key or cipher fails with ErrInvalidMessage. The
+// Rotations aren't tried.
+func (crypt *MessageEncryptor) NewDecryptReader(src io.Reader) (io.Reader, error) {
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := crypt.cipherKey(); err != nil {
+		return nil, err
+	}
+	id, err := crypt.streamCipherID()
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{crypt: crypt, src: src, id: id}, nil
+}
+
+type decryptReader struct {
+	crypt  *MessageEncryptor
+	src    io.Reader
+	aead   cipher.AEAD
+	id     byte
+	header []byte
+	nonce  []byte
+	// buf holds a sealed chunk and the first byte of the next one, which
+	// tells whether the chunk is the last.
+	buf     []byte
+	n       int
+	counter uint64
+	// plain is the data of the current chunk not returned yet.
+	plain []byte
+	out   []byte
+	done  bool
+	err   error
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads the header or decrypts the next chunk.
+func (r *decryptReader) next() error {
+	if r.header == nil {
+		return r.readHeader()
+	}
+	if r.done {
+		return io.EOF
+	}
+	m, err := io.ReadFull(r.src, r.buf[r.n:])
+	r.n += m
+	var chunk []byte
+	last := false
+	switch err {
+	case nil:
+		chunk = r.buf[:len(r.buf)-1]
+	case io.EOF, io.ErrUnexpectedEOF:
+		chunk = r.buf[:r.n]
+		last = true
+	default:
+		return err
+	}
+	if err := streamNonce(r.nonce, r.counter, last); err != nil {
+		return err
+	}
+	r.counter++
+	r.out, err = r.aead.Open(r.out[:0], r.nonce, chunk, r.header)
+	if err != nil {
+		return ErrInvalidMessage
+	}
+	r.plain = r.out
+	if last {
+		r.done = true
+		return nil
+	}
+	r.buf[0] = r.buf[len(r.buf)-1]
+	r.n = 1
+	return nil
+}
+
+func (r *decryptReader) readHeader() error {
+	prefixLen := r.crypt.streamNonceSize() - streamNonceSuffixLen
+	header := make([]byte, streamHeaderLen+streamSaltLen+prefixLen)
+	if _, err := io.ReadFull(r.src, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrInvalidMessage
+		}
+		return err
+	}
+	chunkSize := binary.BigEndian.Uint32(header[len(streamMagic)+2:])
+	if !bytes.Equal(header[:len(streamMagic)], []byte(streamMagic)) ||
+		header[len(streamMagic)] != streamVersion ||
+		header[len(streamMagic)+1] != r.id ||
+		chunkSize == 0 || chunkSize > maxStreamChunkSize {
+		return ErrInvalidMessage
+	}
+	aead, err := r.crypt.streamAEAD(header)
+	if err != nil {
+		return err
+	}
+	r.aead = aead
+	r.header = header
+	r.nonce = make([]byte, aead.NonceSize())
+	copy(r.nonce, header[streamHeaderLen+streamSaltLen:])
+	r.buf = make([]byte, int(chunkSize)+aead.Overhead()+1)
+	return nil
+}
@@ -0,0 +1,181 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+// encryptStream encrypts size bytes streamed in small chunks and returns a
+// reader of the encrypted stream.
+func encryptStream(e *MessageEncryptor, size int) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := e.NewEncryptWriter(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err = io.CopyBuffer(w, &patternReader{n: size, chunk: 4096}, make([]byte, 4096)); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return pr
+}
+
+func TestMessageEncryptorStream(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Encrypted streams", func() {
+		e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+		encrypt := func(e *MessageEncryptor, data []byte) []byte {
+			var buf bytes.Buffer
+			w, err := e.NewEncryptWriter(&buf)
+			g.Assert(err).Eql(nil)
+			w.Write(data)
+			g.Assert(w.Close()).Eql(nil)
+			return buf.Bytes()
+		}
+		decrypt := func(e *MessageEncryptor, stream []byte) ([]byte, error) {
+			r, err := e.NewDecryptReader(bytes.NewReader(stream))
+			g.Assert(err).Eql(nil)
+			return io.ReadAll(r)
+		}
+		// data spanning 3 chunks.
+		data := bytes.Repeat([]byte("0123456789abcdef"), 3*streamChunkSize/16)
+
+		g.It("round trip with all the ciphers", func() {
+			for _, cipher := range []string{"", AESCBC, AES256GCM, AES128GCM, ChaCha20Poly1305, XChaCha20Poly1305} {
+				ee := &MessageEncryptor{Key: e.Key, Cipher: cipher}
+				if cipher == AES128GCM {
+					ee.Key = e.Key[:16]
+				}
+				for _, size := range []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, len(data)} {
+					out, err := decrypt(ee, encrypt(ee, data[:size]))
+					g.Assert(err).Eql(nil)
+					g.Assert(bytes.Equal(out, data[:size])).IsTrue()
+				}
+			}
+		})
+
+		g.It("can be written in small pieces", func() {
+			var buf bytes.Buffer
+			w, _ := e.NewEncryptWriter(&buf)
+			for i := 0; i < len(data); i += 1000 {
+				end := i + 1000
+				if end > len(data) {
+					end = len(data)
+				}
+				w.Write(data[i:end])
+			}
+			g.Assert(w.Close()).Eql(nil)
+			_, err := w.Write([]byte("more"))
+			g.Assert(err != nil).IsTrue()
+			out, err := decrypt(e, buf.Bytes())
+			g.Assert(err).Eql(nil)
+			g.Assert(bytes.Equal(out, data)).IsTrue()
+		})
+
+		g.It("detect tampering mid-stream", func() {
+			stream := encrypt(e, data)
+			tampered := append([]byte{}, stream...)
+			tampered[len(tampered)/2] ^= 0x01
+			r, _ := e.NewDecryptReader(bytes.NewReader(tampered))
+			out, err := io.ReadAll(r)
+			g.Assert(err).Eql(ErrInvalidMessage)
+			// only the authenticated chunk was returned.
+			g.Assert(bytes.Equal(out, data[:streamChunkSize])).IsTrue()
+		})
+
+		g.It("detect truncated, reordered and dropped chunks", func() {
+			stream := encrypt(e, data)
+			header := len(stream) - 3*(streamChunkSize+16)
+			chunk := func(i int) []byte {
+				start := header + i*(streamChunkSize+16)
+				return stream[start : start+streamChunkSize+16]
+			}
+			join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+			for _, bad := range [][]byte{
+				nil,
+				stream[:3],
+				stream[:header],
+				stream[:header+streamChunkSize+16],
+				stream[:len(stream)-1],
+				join(stream[:header], chunk(1), chunk(0), chunk(2)),
+				join(stream[:header], chunk(0), chunk(2)),
+				append(append([]byte{}, stream...), 0),
+			} {
+				_, err := decrypt(e, bad)
+				g.Assert(err).Eql(ErrInvalidMessage)
+			}
+		})
+
+		g.It("use a key of their own", func() {
+			// two streams whose nonce prefixes are the same, only the salts
+			// the keys are derived with differ.
+			rand := func(salt byte) io.Reader {
+				return io.MultiReader(bytes.NewReader(bytes.Repeat([]byte{salt}, streamSaltLen)), bytes.NewReader(make([]byte, 7)))
+			}
+			a := encrypt(&MessageEncryptor{Key: e.Key, Cipher: AES256GCM, RandReader: rand(1)}, data)
+			b := encrypt(&MessageEncryptor{Key: e.Key, Cipher: AES256GCM, RandReader: rand(2)}, data)
+			header := streamHeaderLen + streamSaltLen + 7
+			g.Assert(bytes.Equal(a[header-7:header], b[header-7:header])).IsTrue()
+			g.Assert(bytes.Equal(a[header:header+64], b[header:header+64])).IsFalse()
+			for _, stream := range [][]byte{a, b} {
+				out, err := decrypt(e, stream)
+				g.Assert(err).Eql(nil)
+				g.Assert(bytes.Equal(out, data)).IsTrue()
+			}
+		})
+
+		g.It("check the header", func() {
+			stream := encrypt(e, []byte("foo"))
+			for _, i := range []int{0, 4, 5, 6, 9, 10} {
+				tampered := append([]byte{}, stream...)
+				tampered[i] ^= 0x01
+				_, err := decrypt(e, tampered)
+				g.Assert(err).Eql(ErrInvalidMessage)
+			}
+		})
+
+		g.It("can't be decrypted with another key or cipher", func() {
+			stream := encrypt(e, []byte("foo"))
+			_, err := decrypt(&MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}, stream)
+			g.Assert(err).Eql(ErrInvalidMessage)
+			_, err = decrypt(&MessageEncryptor{Key: e.Key, Cipher: ChaCha20Poly1305}, stream)
+			g.Assert(err).Eql(ErrInvalidMessage)
+		})
+
+		g.It("need a valid configuration", func() {
+			_, err := (&MessageEncryptor{Key: e.Key[:20]}).NewEncryptWriter(io.Discard)
+			g.Assert(err != nil).IsTrue()
+			_, err = (&MessageEncryptor{Key: e.Key, Cipher: "rot13"}).NewDecryptReader(strings.NewReader(""))
+			g.Assert(err != nil).IsTrue()
+		})
+
+		g.It("can be large with a bounded memory use", func() {
+			const size = 100 << 20
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			r, err := e.NewDecryptReader(encryptStream(e, size))
+			g.Assert(err).Eql(nil)
+			h := sha256.New()
+			n, err := io.CopyBuffer(h, r, make([]byte, 4096))
+			g.Assert(err).Eql(nil)
+			g.Assert(n).Eql(int64(size))
+			runtime.ReadMemStats(&after)
+			g.Assert(after.TotalAlloc-before.TotalAlloc < 4<<20).IsTrue()
+
+			expected := sha256.New()
+			io.Copy(expected, &patternReader{n: size, chunk: 4096})
+			g.Assert(h.Sum(nil)).Eql(expected.Sum(nil))
+		})
+	})
+}
@@ -1,8 +1,12 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -34,6 +38,61 @@ func TestMessageEncryptorDefaultSettings(t *testing.T) {
 			g.Assert(newMsg).Eql("my secret data")
 		})
 
+		g.It("defaults to JSON without setting the Serializer", func() {
+			msg, err := e.EncryptAndSign(map[string]int{"id": 1})
+			g.Assert(err).Eql(nil)
+			var output map[string]int
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql(map[string]int{"id": 1})
+			g.Assert(e.Serializer == nil).IsTrue()
+
+			json := &MessageEncryptor{Key: k, SignKey: signKey, Serializer: JsonMsgSerializer{}}
+			output = nil
+			g.Assert(json.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql(map[string]int{"id": 1})
+		})
+
+		g.It("can be used concurrently without a Serializer", func() {
+			shared := &MessageEncryptor{Key: k, Cipher: AES256GCM}
+			errs := make(chan error, 20)
+			for i := 0; i < 20; i++ {
+				go func(i int) {
+					msg, err := shared.EncryptAndSign(i)
+					if err == nil {
+						var output int
+						err = shared.DecryptAndVerify(msg, &output)
+						if err == nil && output != i {
+							err = fmt.Errorf("got %d instead of %d", output, i)
+						}
+					}
+					errs <- err
+				}(i)
+			}
+			for i := 0; i < 20; i++ {
+				g.Assert(<-errs).Eql(nil)
+			}
+		})
+
+		g.It("needs a Serializer when Strict", func() {
+			strict := &MessageEncryptor{Key: k, SignKey: signKey, Strict: true}
+			_, err := strict.EncryptAndSign("foo")
+			g.Assert(err).Eql(ErrNoSerializer)
+			msg, _ := e.EncryptAndSign("foo")
+			var output string
+			g.Assert(strict.DecryptAndVerify(msg, &output)).Eql(ErrNoSerializer)
+
+			// the bytes API doesn't use the Serializer.
+			msg, err = strict.EncryptAndSignBytes([]byte("foo"))
+			g.Assert(err).Eql(nil)
+			data, err := strict.DecryptAndVerifyBytes(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(string(data)).Eql("foo")
+
+			strict.Serializer = JsonMsgSerializer{}
+			msg, err = strict.EncryptAndSign("foo")
+			g.Assert(err).Eql(nil)
+			g.Assert(strict.DecryptAndVerify(msg, &output)).Eql(nil)
+		})
 	})
 
 }
@@ -111,6 +170,129 @@ func TestMessageEncryptor(t *testing.T) {
 		})
 	})
 
+	g.Describe("MessageEncryptor decrypting tampered aes-256-gcm messages", func() {
+		e := MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+		msg, _ := e.Encrypt("my secret data")
+		vectors := strings.Split(msg, "--")
+		decode := func(s string) []byte {
+			b, _ := base64.StdEncoding.DecodeString(s)
+			return b
+		}
+		encode := base64.StdEncoding.EncodeToString
+
+		g.It("rejects flipped ciphertext bits", func() {
+			ct := decode(vectors[0])
+			for i := range ct {
+				flipped := append([]byte{}, ct...)
+				flipped[i] ^= 0x01
+				var output string
+				err := e.Decrypt(encode(flipped)+"--"+vectors[1]+"--"+vectors[2], &output)
+				g.Assert(err).Eql(ErrDecryptFail)
+			}
+		})
+
+		g.It("rejects truncated tags", func() {
+			tag := decode(vectors[2])
+			for _, n := range []int{0, 1, 4, 8, 12, 15} {
+				var output string
+				err := e.Decrypt(vectors[0]+"--"+vectors[1]+"--"+encode(tag[:n]), &output)
+				g.Assert(err).Eql(ErrDecryptFail)
+			}
+		})
+
+		g.It("rejects other nonce sizes", func() {
+			nonce := decode(vectors[1])
+			for _, n := range [][]byte{nil, nonce[:8], append(nonce, 0)} {
+				var output string
+				err := e.Decrypt(vectors[0]+"--"+encode(n)+"--"+vectors[2], &output)
+				g.Assert(err).Eql(ErrDecryptFail)
+			}
+		})
+
+		g.It("rejects malformed messages", func() {
+			for _, bad := range []string{"", "garbage", vectors[0] + "--" + vectors[1], "!!--" + vectors[1] + "--" + vectors[2]} {
+				var output string
+				g.Assert(e.Decrypt(bad, &output)).Eql(ErrDecryptFail)
+			}
+		})
+
+		g.It("rejects other encodings of the same parts", func() {
+			tag := vectors[2]
+			// the last character of the tag only has zeroes in its 4 low bits.
+			const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+			last := strings.IndexByte(alphabet, tag[21])
+			trailingBits := tag[:21] + string(alphabet[last|1]) + "=="
+			for _, bad := range []string{
+				vectors[0] + "--" + vectors[1] + "--" + tag + "=",
+				vectors[0] + "--" + vectors[1] + "--" + tag[:12] + " " + tag[12:],
+				vectors[0] + "--" + vectors[1] + "--" + tag[:22] + "=",
+				vectors[0] + "--" + vectors[1] + "--" + trailingBits,
+				vectors[0] + "--" + vectors[1] + "\n--" + tag,
+			} {
+				var output string
+				g.Assert(e.Decrypt(bad, &output)).Eql(ErrDecryptFail)
+			}
+		})
+
+		g.It("rejects messages encrypted with another key", func() {
+			other := MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+			var output string
+			g.Assert(other.Decrypt(msg, &output)).Eql(ErrDecryptFail)
+			g.Assert(e.Decrypt(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("my secret data")
+		})
+	})
+
+	g.Describe("MessageEncryptor with additional authenticated data", func() {
+		e := MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+		aad := []byte("user:42")
+		testData := testStruct{Foo: "foo", Bar: 42}
+
+		g.It("round trips", func() {
+			msg, err := e.EncryptAndSignWithAAD(testData, aad)
+			g.Assert(err).Eql(nil)
+			var output testStruct
+			g.Assert(e.DecryptAndVerifyWithAAD(msg, &output, aad)).Eql(nil)
+			g.Assert(output).Eql(testData)
+		})
+
+		g.It("rejects another aad like any tampering", func() {
+			msg, _ := e.EncryptAndSignWithAAD(testData, aad)
+			var output testStruct
+			g.Assert(e.DecryptAndVerifyWithAAD(msg, &output, []byte("user:43"))).Eql(ErrDecryptFail)
+			g.Assert(e.DecryptAndVerifyWithAAD(msg, &output, nil)).Eql(ErrDecryptFail)
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(ErrDecryptFail)
+		})
+
+		g.It("is the same as the other methods when empty", func() {
+			msg, _ := e.EncryptAndSign(testData)
+			var output testStruct
+			g.Assert(e.DecryptAndVerifyWithAAD(msg, &output, []byte{})).Eql(nil)
+			g.Assert(output).Eql(testData)
+
+			msg, _ = e.EncryptAndSignWithAAD(testData, nil)
+			output = testStruct{}
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql(testData)
+
+			cbc := MessageEncryptor{Key: e.Key}
+			msg, err := cbc.EncryptAndSignWithAAD(testData, nil)
+			g.Assert(err).Eql(nil)
+			output = testStruct{}
+			g.Assert(cbc.DecryptAndVerifyWithAAD(msg, &output, nil)).Eql(nil)
+			g.Assert(output).Eql(testData)
+		})
+
+		g.It("isn't supported by aes-cbc", func() {
+			cbc := MessageEncryptor{Key: e.Key}
+			_, err := cbc.EncryptAndSignWithAAD(testData, aad)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			g.Assert(err.Error()).Eql("Invalid configuration - aes-cbc doesn't support additional authenticated data")
+			var output testStruct
+			g.Assert(errors.Is(cbc.DecryptAndVerifyWithAAD("foo--bar", &output, aad), ErrInvalidConfig)).IsTrue()
+		})
+	})
+
 	g.Describe("MessageEncryptor properly setup using aes cbc", func() {
 		newCrypt := func() MessageEncryptor {
 			return MessageEncryptor{Key: GenerateRandomKey(32),
@@ -186,6 +368,359 @@ func TestMessageEncryptor(t *testing.T) {
 			g.Assert(output).Eql(testData)
 		})
 	})
+
+	g.Describe("MessageEncryptor signing aes cbc messages", func() {
+		key := GenerateRandomKey(32)
+		signKey := GenerateRandomKey(64)
+
+		g.It("uses separate encryption and signing keys", func() {
+			e := MessageEncryptor{Key: key, SignKey: signKey}
+			msg, err := e.EncryptAndSign("my secret data")
+			g.Assert(err).Eql(nil)
+			var output string
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("my secret data")
+
+			wrongKey := MessageEncryptor{Key: GenerateRandomKey(32), SignKey: signKey}
+			g.Assert(wrongKey.DecryptAndVerify(msg, &output) != nil).IsTrue()
+			wrongSignKey := MessageEncryptor{Key: key, SignKey: GenerateRandomKey(64)}
+			err = wrongSignKey.DecryptAndVerify(msg, &output)
+			g.Assert(err).Eql(ErrInvalidMessage)
+		})
+
+		g.It("signs with the encryption key when no signing key is set", func() {
+			e := MessageEncryptor{Key: key}
+			msg, err := e.EncryptAndSign("my secret data")
+			g.Assert(err).Eql(nil)
+			var output string
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("my secret data")
+
+			same := MessageEncryptor{Key: key, SignKey: key}
+			g.Assert(same.DecryptAndVerify(msg, &output)).Eql(nil)
+			other := MessageEncryptor{Key: key, SignKey: signKey}
+			g.Assert(other.DecryptAndVerify(msg, &output) != nil).IsTrue()
+		})
+
+		g.It("uses the verifier when set", func() {
+			v := &MessageVerifier{Secret: signKey, Hasher: sha1.New, Serializer: NullMsgSerializer{}}
+			e := MessageEncryptor{Key: key, Verifier: v}
+			msg, err := e.EncryptAndSign("my secret data")
+			g.Assert(err).Eql(nil)
+			withSignKey := MessageEncryptor{Key: key, SignKey: signKey}
+			var output string
+			g.Assert(withSignKey.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("my secret data")
+		})
+
+		g.It("doesn't modify the encryptor", func() {
+			e := MessageEncryptor{Key: key, SignKey: signKey}
+			msg, _ := e.EncryptAndSign("my secret data")
+			var output string
+			e.DecryptAndVerify(msg, &output)
+			g.Assert(e.Verifier == nil).IsTrue()
+		})
+	})
+
+	g.Describe("MessageEncryptor authenticity failures", func() {
+		key, signKey := GenerateRandomKey(32), GenerateRandomKey(64)
+		flip := func(s string, i int) string {
+			b := []byte(s)
+			if b[i] == 'a' {
+				b[i] = 'b'
+			} else {
+				b[i] = 'a'
+			}
+			return string(b)
+		}
+
+		g.It("are all ErrInvalidMessage with aes-cbc", func() {
+			e := &MessageEncryptor{Key: key, SignKey: signKey}
+			msg, _ := e.EncryptAndSign("my secret data")
+			sep := strings.LastIndex(msg, "--")
+			data, digest := msg[:sep], msg[sep+2:]
+			// messages signed with the right key but which can't be decrypted.
+			signed := func(data string) string {
+				m, _ := e.verifier().GenerateRaw([]byte(data))
+				return m
+			}
+			iv := base64.StdEncoding.EncodeToString(make([]byte, 16))
+			other := &MessageEncryptor{Key: key, SignKey: GenerateRandomKey(64)}
+			otherMsg, _ := other.EncryptAndSign("my secret data")
+
+			tampered := []string{
+				"",
+				"garbage",
+				data,
+				data + "--",
+				"--" + digest,
+				flip(msg, 0),
+				flip(msg, len(msg)-1),
+				data + "--" + digest[:20],
+				"!!" + msg,
+				otherMsg,
+				signed("garbage"),
+				signed("!!--" + iv),
+				signed(base64.StdEncoding.EncodeToString(make([]byte, 20)) + "--" + iv),
+				signed(base64.StdEncoding.EncodeToString(make([]byte, 16)) + "--AAAA"),
+			}
+			for _, bad := range tampered {
+				var output string
+				g.Assert(e.DecryptAndVerify(bad, &output)).Eql(ErrInvalidMessage)
+			}
+		})
+
+		g.It("are all ErrInvalidMessage with aes-256-gcm", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM}
+			msg, _ := e.EncryptAndSign("my secret data")
+			vectors := strings.Split(msg, "--")
+			other := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+			otherMsg, _ := other.EncryptAndSign("my secret data")
+
+			tampered := []string{
+				"",
+				"garbage",
+				vectors[0] + "--" + vectors[1],
+				flip(msg, 0),
+				vectors[0] + "--" + flip(vectors[1], 0) + "--" + vectors[2],
+				vectors[0] + "--" + vectors[1] + "--" + flip(vectors[2], 0),
+				vectors[0] + "--" + vectors[1] + "--" + vectors[2][:12],
+				"!!" + msg,
+				msg + "--",
+				otherMsg,
+			}
+			for _, bad := range tampered {
+				var output string
+				g.Assert(e.DecryptAndVerify(bad, &output)).Eql(ErrInvalidMessage)
+			}
+		})
+
+		g.It("are told apart from configuration and serializer errors", func() {
+			e := &MessageEncryptor{Key: key, SignKey: signKey}
+			msg, _ := e.EncryptAndSign("my secret data")
+			var output string
+			short := &MessageEncryptor{Key: key[:20], SignKey: signKey}
+			g.Assert(errors.Is(short.DecryptAndVerify(msg, &output), ErrInvalidKeyLength)).IsTrue()
+			noSecret := &MessageEncryptor{Key: key, Verifier: &MessageVerifier{Serializer: NullMsgSerializer{}}}
+			g.Assert(errors.Is(noSecret.DecryptAndVerify(msg, &output), ErrNoSecret)).IsTrue()
+			var number int
+			err := e.DecryptAndVerify(msg, &number)
+			g.Assert(err != nil && err != ErrInvalidMessage).IsTrue()
+		})
+
+		g.It("include the deprecated ErrDecryptFail", func() {
+			g.Assert(ErrDecryptFail).Eql(ErrInvalidMessage)
+		})
+	})
+
+	g.Describe("MessageEncryptor bytes API", func() {
+		key := GenerateRandomKey(32)
+		block := []byte("exactly16bytes!\x05")
+		payloads := [][]byte{
+			nil,
+			{},
+			{0x00},
+			{0xff, 0x00, 0x10},
+			block,
+			append([]byte("exactly16bytes!"), 0x10),
+			append(append([]byte{}, block...), block...),
+			GenerateRandomKey(1000),
+		}
+
+		g.It("round trip binary payloads with all the ciphers", func() {
+			for _, cipher := range []string{"", AESCBC, AES256GCM, ChaCha20Poly1305, XChaCha20Poly1305} {
+				e := &MessageEncryptor{Key: key, Cipher: cipher}
+				for _, data := range payloads {
+					msg, err := e.EncryptAndSignBytes(data)
+					g.Assert(err).Eql(nil)
+					out, err := e.DecryptAndVerifyBytes(msg)
+					g.Assert(err).Eql(nil)
+					g.Assert(bytes.Equal(out, data)).IsTrue()
+				}
+				g.Assert(e.Serializer == nil).IsTrue()
+			}
+		})
+
+		g.It("share the framing of the other methods", func() {
+			e := &MessageEncryptor{Key: key, Serializer: NullMsgSerializer{}}
+			msg, _ := e.EncryptAndSignBytes(block)
+			var output string
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql(string(block))
+
+			msg, _ = e.EncryptAndSign(string(block))
+			out, err := e.DecryptAndVerifyBytes(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(out).Eql(block)
+		})
+
+		g.It("pad payloads a block long like OpenSSL", func() {
+			e := &MessageEncryptor{Key: key}
+			msg, _ := e.Encrypt(nil)
+			ct, _ := base64.StdEncoding.DecodeString(strings.Split(msg, "--")[0])
+			g.Assert(len(ct)).Eql(16)
+			raw, _ := e.EncryptAndSignBytes(block)
+			encrypted, _ := e.verifier().VerifyRaw(raw)
+			ct, _ = base64.StdEncoding.DecodeString(strings.Split(string(encrypted), "--")[0])
+			g.Assert(len(ct)).Eql(32)
+		})
+
+		g.It("don't modify the payload", func() {
+			data := make([]byte, 16, 64)
+			copy(data, block)
+			spare := data[:64]
+			e := &MessageEncryptor{Key: key, Compress: true}
+			_, err := e.EncryptAndSignBytes(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(spare[16:]).Eql(make([]byte, 48))
+		})
+
+		g.It("compress and rotate like the other methods", func() {
+			old := &MessageEncryptor{Key: GenerateRandomKey(32), Compress: true}
+			data := bytes.Repeat([]byte("compress me "), 100)
+			msg, _ := old.EncryptAndSignBytes(data)
+			g.Assert(len(msg) < len(data)).IsTrue()
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM, Rotations: []*MessageEncryptor{old}}
+			out, err := e.DecryptAndVerifyBytes(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(out).Eql(data)
+		})
+
+		g.It("reject tampered messages", func() {
+			for _, cipher := range []string{AESCBC, AES256GCM} {
+				e := &MessageEncryptor{Key: key, Cipher: cipher}
+				msg, _ := e.EncryptAndSignBytes(block)
+				out, err := e.DecryptAndVerifyBytes("x" + msg)
+				g.Assert(err).Eql(ErrInvalidMessage)
+				g.Assert(out == nil).IsTrue()
+			}
+		})
+	})
+
+	g.Describe("MessageEncryptor rotations", func() {
+		data := testStruct{Foo: "foo", Bar: 42}
+		oldKey, oldSignKey := GenerateRandomKey(32), GenerateRandomKey(64)
+		old := &MessageEncryptor{Key: oldKey, SignKey: oldSignKey, Cipher: AESCBC}
+		newCrypt := func() *MessageEncryptor {
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+			e.Rotate(oldKey, oldSignKey, AESCBC)
+			return e
+		}
+
+		g.It("decrypt old aes-cbc messages with a new aes-256-gcm encryptor", func() {
+			msg, err := old.EncryptAndSign(data)
+			g.Assert(err).Eql(nil)
+			e := newCrypt()
+			rotated := -1
+			e.OnRotation = func(i int) { rotated = i }
+			var output testStruct
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql(data)
+			g.Assert(rotated).Eql(0)
+		})
+
+		g.It("encrypt with the primary configuration", func() {
+			e := newCrypt()
+			rotated := false
+			e.OnRotation = func(int) { rotated = true }
+			msg, _ := e.EncryptAndSign(data)
+			g.Assert(len(strings.Split(msg, "--"))).Eql(3)
+			var output testStruct
+			g.Assert(old.DecryptAndVerify(msg, &output) != nil).IsTrue()
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(rotated).IsFalse()
+		})
+
+		g.It("are tried in order", func() {
+			e := newCrypt()
+			older := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: ChaCha20Poly1305}
+			e.Rotations = append(e.Rotations, older)
+			var rotated []int
+			e.OnRotation = func(i int) { rotated = append(rotated, i) }
+			msg, _ := older.EncryptAndSign(data)
+			var output testStruct
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql(data)
+			g.Assert(rotated).Eql([]int{1})
+		})
+
+		g.It("report the primary error when none match", func() {
+			e := newCrypt()
+			other := &MessageEncryptor{Key: GenerateRandomKey(32)}
+			msg, _ := other.EncryptAndSign(data)
+			var output testStruct
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(ErrDecryptFail)
+		})
+
+		g.It("aren't tried on configuration errors", func() {
+			msg, _ := old.EncryptAndSign(data)
+			e := newCrypt()
+			e.Key = e.Key[:20]
+			var output testStruct
+			g.Assert(errors.Is(e.DecryptAndVerify(msg, &output), ErrInvalidKeyLength)).IsTrue()
+		})
+
+		g.It("default to the encryptor's cipher and serializer", func() {
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: XChaCha20Poly1305, Serializer: XMLMsgSerializer{}}
+			e.Rotate(oldKey, nil, "")
+			g.Assert(e.Rotations[0].Cipher).Eql(XChaCha20Poly1305)
+			g.Assert(e.Rotations[0].Serializer).Eql(XMLMsgSerializer{})
+		})
+
+		g.It("share the encryptor's configuration", func() {
+			oldURLSafe := &MessageEncryptor{Key: oldKey, Cipher: AES256GCM, URLSafe: true, Compress: true}
+			msg, err := oldURLSafe.EncryptAndSign(strings.Repeat("compressible ", 64))
+			g.Assert(err).Eql(nil)
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM, URLSafe: true, Compress: true}
+			e.Rotate(oldKey, nil, "")
+			g.Assert(e.Rotations[0].URLSafe).IsTrue()
+			g.Assert(e.Rotations[0].Compress).IsTrue()
+			var output string
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql(strings.Repeat("compressible ", 64))
+
+			oldCTR := &MessageEncryptor{Key: oldKey, Cipher: AES256CTRHMAC, MACHasher: sha512.New}
+			msg, _ = oldCTR.EncryptAndSign(data)
+			e = &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256CTRHMAC, MACHasher: sha512.New}
+			e.Rotate(oldKey, nil, "")
+			var ctrOutput testStruct
+			g.Assert(e.DecryptAndVerify(msg, &ctrOutput)).Eql(nil)
+			g.Assert(ctrOutput).Eql(data)
+		})
+
+		g.It("are used with additional authenticated data", func() {
+			aad := []byte("user:42")
+			older := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+			msg, _ := older.EncryptAndSignWithAAD(data, aad)
+			e := newCrypt()
+			e.Rotations = append(e.Rotations, older)
+			var output testStruct
+			g.Assert(e.DecryptAndVerifyWithAAD(msg, &output, aad)).Eql(nil)
+			g.Assert(output).Eql(data)
+			g.Assert(e.DecryptAndVerifyWithAAD(msg, &output, []byte("user:43"))).Eql(ErrDecryptFail)
+		})
+	})
+
+	g.Describe("MessageEncryptor with oversized messages", func() {
+		garbage := strings.Repeat("A", 10<<20) + "--" + strings.Repeat("A", 24)
+
+		g.It("rejects them before decoding", func() {
+			for _, cipher := range []string{"aes-cbc", "aes-256-gcm"} {
+				e := MessageEncryptor{Key: GenerateRandomKey(32), SignKey: []byte("this is a secret!"), Cipher: cipher}
+				var output string
+				g.Assert(e.DecryptAndVerify(garbage, &output)).Eql(ErrMessageTooLarge)
+				g.Assert(e.Decrypt(garbage, &output)).Eql(ErrMessageTooLarge)
+			}
+		})
+
+		g.It("uses the set limit", func() {
+			e := MessageEncryptor{Key: GenerateRandomKey(32), Cipher: "aes-256-gcm", MaxMessageLen: 64}
+			msg, err := e.EncryptAndSign(strings.Repeat("long", 20))
+			g.Assert(err).Eql(nil)
+			var output string
+			g.Assert(e.DecryptAndVerify(msg, &output)).Eql(ErrMessageTooLarge)
+		})
+	})
 }
 
 func TestDecryptingRailsSession(t *testing.T) {
@@ -297,7 +832,7 @@ func ExampleMessageEncryptor_DecryptAndVerify() {
 	// crypto.Person{Id:12, FirstName:"John", LastName:"Doe", Age:42}
 }
 
-func ExampleMessageEncryptor_EncryptAndSignGCM() {
+func ExampleMessageEncryptor_EncryptAndSign_gcm() {
 	type Person struct {
 		Id        int    `json:"id"`
 		FirstName string `json:"firstName"`
@@ -324,7 +859,7 @@ func ExampleMessageEncryptor_EncryptAndSignGCM() {
 	fmt.Println(msg)
 }
 
-func ExampleMessageEncryptor_DecryptAndVerifyGCM() {
+func ExampleMessageEncryptor_DecryptAndVerify_gcm() {
 
 	type Person struct {
 		Id        int    `json:"id"`
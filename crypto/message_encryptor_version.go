@@ -0,0 +1,95 @@
+package crypto
+
+import "strings"
+
+// Versioned messages are a Go extension which Rails can't read: the message
+// is prefixed with a header naming the cipher and serializer used, ie:
+// "v2:aes-256-gcm:json:", so they can be decrypted whatever the encryptor's
+// Cipher and Serializer are. The header is authenticated along with the
+// message, as additional data with the AEAD ciphers and signed with aes-cbc.
+// Legacy messages never contain a colon, base64 and hex don't use it.
+const messageVersion = "v2"
+
+// versionHeader returns the header of the messages generated by the
+// encryptor, empty unless EmitVersion is set.
+func (crypt *MessageEncryptor) versionHeader() string {
+	if !crypt.EmitVersion {
+		return ""
+	}
+	cipher := crypt.Cipher
+	if cipher == "" {
+		cipher = AESCBC
+	}
+	serializer, _ := crypt.serializer()
+	return messageVersion + ":" + cipher + ":" + serializerName(serializer) + ":"
+}
+
+// serializerName returns the name of the serializer in the version header,
+// empty for custom serializers which are left to the decrypting encryptor.
+func serializerName(s MsgSerializer) string {
+	switch s.(type) {
+	case JsonMsgSerializer, *JsonMsgSerializer, HybridMsgSerializer, *HybridMsgSerializer:
+		return "json"
+	case XMLMsgSerializer, *XMLMsgSerializer:
+		return "xml"
+	case NullMsgSerializer, *NullMsgSerializer:
+		return "null"
+	case MsgpackMsgSerializer, *MsgpackMsgSerializer:
+		return "msgpack"
+	case GobMsgSerializer, *GobMsgSerializer:
+		return "gob"
+	case RubyMarshalSerializer, *RubyMarshalSerializer:
+		return "marshal"
+	case CborMsgSerializer, *CborMsgSerializer:
+		return "cbor"
+	case ProtoMsgSerializer, *ProtoMsgSerializer:
+		return "proto"
+	case YamlMsgSerializer, *YamlMsgSerializer:
+		return "yaml"
+	case RawMsgSerializer, *RawMsgSerializer:
+		return "raw"
+	}
+	return ""
+}
+
+// openMessage verifies and decrypts a message, versioned or not, and returns
+// its plaintext and the encryptor set with the cipher and serializer it was
+// encrypted with.
+func (crypt *MessageEncryptor) openMessage(msg string) (*MessageEncryptor, []byte, error) {
+	if !strings.Contains(msg, ":") {
+		plaintext, err := crypt.decryptAndVerifyPlaintext(msg, "")
+		return crypt, plaintext, err
+	}
+	if err := checkMessageLen(msg, crypt.MaxMessageLen); err != nil {
+		return nil, nil, err
+	}
+	fields := strings.SplitN(msg, ":", 4)
+	if len(fields) != 4 {
+		return nil, nil, ErrInvalidMessage
+	}
+	if fields[0] != messageVersion {
+		return nil, nil, &messageError{msg: "Invalid message - unknown version " + fields[0], kind: ErrInvalidMessage}
+	}
+	e := *crypt
+	switch fields[1] {
+	case AESCBC, AES256GCM, AES128GCM, ChaCha20Poly1305, XChaCha20Poly1305, AES256CTRHMAC:
+		e.Cipher = fields[1]
+	default:
+		return nil, nil, ErrInvalidMessage
+	}
+	// a key which doesn't fit the cipher can't have encrypted the message.
+	if _, err := e.cipherKey(); err != nil {
+		return nil, nil, ErrInvalidMessage
+	}
+	if name := fields[2]; name != "" && name != serializerName(crypt.Serializer) {
+		if e.Serializer = lookupSerializer(name); e.Serializer == nil {
+			return nil, nil, ErrInvalidMessage
+		}
+	}
+	header := msg[:len(msg)-len(fields[3])]
+	plaintext, err := e.decryptAndVerifyPlaintext(fields[3], header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &e, plaintext, nil
+}
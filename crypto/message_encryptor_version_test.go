@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestMessageEncryptorVersion(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Versioned messages", func() {
+		key := GenerateRandomKey(32)
+		ciphers := []string{AESCBC, AES256GCM, ChaCha20Poly1305, XChaCha20Poly1305}
+
+		g.It("aren't emitted unless EmitVersion is set", func() {
+			msg, _ := (&MessageEncryptor{Key: key, Cipher: AES256GCM}).EncryptAndSign("foo")
+			g.Assert(strings.Contains(msg, ":")).IsFalse()
+		})
+
+		g.It("name their cipher and serializer", func() {
+			e := &MessageEncryptor{Key: key, EmitVersion: true}
+			msg, _ := e.EncryptAndSign("foo")
+			g.Assert(strings.HasPrefix(msg, "v2:aes-cbc:json:")).IsTrue()
+			e = &MessageEncryptor{Key: key, Cipher: AES256GCM, Serializer: XMLMsgSerializer{}, EmitVersion: true}
+			msg, _ = e.EncryptAndSign("foo")
+			g.Assert(strings.HasPrefix(msg, "v2:aes-256-gcm:xml:")).IsTrue()
+		})
+
+		g.It("round trip with all the ciphers", func() {
+			for _, cipher := range ciphers {
+				e := &MessageEncryptor{Key: key, Cipher: cipher, EmitVersion: true}
+				msg, err := e.EncryptAndSign(map[string]string{"user": "matt"})
+				g.Assert(err).Eql(nil)
+				var output map[string]string
+				g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+				g.Assert(output).Eql(map[string]string{"user": "matt"})
+
+				msg, err = e.EncryptAndSignBytes([]byte("raw"))
+				g.Assert(err).Eql(nil)
+				data, err := e.DecryptAndVerifyBytes(msg)
+				g.Assert(err).Eql(nil)
+				g.Assert(string(data)).Eql("raw")
+			}
+		})
+
+		g.It("are decrypted with the cipher and serializer they name", func() {
+			current := &MessageEncryptor{Key: key, Cipher: XChaCha20Poly1305, Serializer: NullMsgSerializer{}}
+			for _, cipher := range ciphers {
+				for _, serializer := range []MsgSerializer{JsonMsgSerializer{}, XMLMsgSerializer{}, MsgpackMsgSerializer{}, GobMsgSerializer{}, RubyMarshalSerializer{}, CborMsgSerializer{}, YamlMsgSerializer{}, RawMsgSerializer{}} {
+					old := &MessageEncryptor{Key: key, Cipher: cipher, Serializer: serializer, EmitVersion: true}
+					msg, _ := old.EncryptAndSign("foo")
+					var output string
+					g.Assert(current.DecryptAndVerify(msg, &output)).Eql(nil)
+					g.Assert(output).Eql("foo")
+				}
+			}
+		})
+
+		g.It("don't break the legacy messages", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM, EmitVersion: true}
+			for _, cipher := range []string{"", AES256GCM} {
+				msg, _ := (&MessageEncryptor{Key: key, Cipher: cipher}).EncryptAndSign("foo")
+				e.Cipher = cipher
+				var output string
+				g.Assert(e.DecryptAndVerify(msg, &output)).Eql(nil)
+				g.Assert(output).Eql("foo")
+			}
+
+			// a Rails 5.2 session cookie.
+			cookie := "Co+XxC9PK1ptoHftqua6C3PNrlvk4EA09IpKho+wk5qbMi4jrl6SS2g6xexK68b8kjKWqXzCcT/ZjkbAO/0Sxm01JIK0zY/qGa56ogFaVViZKgaCGlSQYDWrVDm3mCSTlTzHDl3nrIjMffwNEn2x5IPHaQQoR0skkv3A17zejE4d18pRqRYaCuZLg2H04HWYv0Y/s88Kurmevw8w/8xUwLIV8P3SpszfMHEU--Cs17rTBCsResqqC5--ym0c0ZE+ts7wExyw/t35QA=="
+			kg := KeyGenerator{Secret: "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"}
+			rails := &MessageEncryptor{Key: kg.Generate([]byte("authenticated encrypted cookie"), 32), Cipher: AES256GCM, EmitVersion: true}
+			var session map[string]interface{}
+			g.Assert(rails.DecryptAndVerify(cookie, &session)).Eql(nil)
+			g.Assert(session["session_id"]).Eql("b2d63c07ea7a9d58e415e3672e3f31a2")
+		})
+
+		g.It("reject unknown versions", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM, EmitVersion: true}
+			msg, _ := e.EncryptAndSign("foo")
+			var output string
+			err := e.DecryptAndVerify("v3"+msg[2:], &output)
+			g.Assert(errors.Is(err, ErrInvalidMessage)).IsTrue()
+			g.Assert(err.Error()).Eql("Invalid message - unknown version v3")
+		})
+
+		g.It("authenticate their header", func() {
+			for _, cipher := range []string{AESCBC, AES256GCM} {
+				e := &MessageEncryptor{Key: key, Cipher: cipher, EmitVersion: true}
+				msg, _ := e.EncryptAndSign("foo")
+				tampered := strings.Replace(msg, ":json:", ":null:", 1)
+				var output string
+				g.Assert(e.DecryptAndVerify(tampered, &output)).Eql(ErrInvalidMessage)
+			}
+			msg, _ := (&MessageEncryptor{Key: key, Cipher: ChaCha20Poly1305, EmitVersion: true}).EncryptAndSign("foo")
+			tampered := strings.Replace(msg, ChaCha20Poly1305, XChaCha20Poly1305, 1)
+			var output string
+			g.Assert((&MessageEncryptor{Key: key}).DecryptAndVerify(tampered, &output)).Eql(ErrInvalidMessage)
+		})
+
+		g.It("reject malformed headers", func() {
+			e := &MessageEncryptor{Key: key, Cipher: AES256GCM}
+			for _, msg := range []string{"v2:", "v2:aes-256-gcm:json", "v2:rot13:json:foo", "v2:aes-256-gcm:yaml:foo"} {
+				var output string
+				g.Assert(errors.Is(e.DecryptAndVerify(msg, &output), ErrInvalidMessage)).IsTrue()
+			}
+			// a 16 bytes key can't have encrypted an aes-256-gcm message.
+			short := &MessageEncryptor{Key: key[:16]}
+			var output string
+			g.Assert(short.DecryptAndVerify("v2:aes-256-gcm:json:foo", &output)).Eql(ErrInvalidMessage)
+		})
+	})
+}
@@ -1,33 +1,230 @@
 package crypto
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
-	"encoding/hex"
 	"errors"
-	"fmt"
 	"hash"
 	"strings"
+	"time"
 )
 
+var (
+	// ErrNoSecret is returned when a MessageVerifier is used without a Secret.
+	ErrNoSecret = errors.New("Secret not set")
+	// ErrNoSerializer is returned when a MessageVerifier, or a Strict
+	// MessageEncryptor, is used without a Serializer.
+	ErrNoSerializer = errors.New("Serializer not set")
+	// ErrInvalidSignature is returned when a message's digest doesn't match
+	// its data, meaning it was tampered with or signed with another secret.
+	ErrInvalidSignature = errors.New("Invalid signature - bad data (compare)")
+	// ErrMalformedMessage is returned when a message can't be split into its
+	// data and digest, or when its data isn't valid base64.
+	ErrMalformedMessage = errors.New("Invalid signature - bad data --")
+	// ErrMessageTooLarge is returned when a message is longer than the
+	// MaxMessageLen of the verifier or encryptor checking it.
+	ErrMessageTooLarge = errors.New("Message too large")
+)
+
+// DefaultMaxMessageLen is the length over which messages are rejected
+// without being decoded when MaxMessageLen isn't set. That's way more than
+// what fits in a cookie.
+const DefaultMaxMessageLen = 1 << 20
+
+// messageError is an error with its own message that still matches one of
+// the sentinel errors above with errors.Is, and optionally wraps the
+// underlying cause.
+type messageError struct {
+	msg  string
+	kind error
+	err  error
+}
+
+func (e *messageError) Error() string        { return e.msg }
+func (e *messageError) Is(target error) bool { return target == e.kind }
+func (e *messageError) Unwrap() error        { return e.err }
+
+var (
+	// ErrMissingSeparator is returned when a message doesn't contain the
+	// separator between its data and its digest. It matches
+	// ErrMalformedMessage.
+	ErrMissingSeparator error = &messageError{msg: "Invalid signature - bad data --", kind: ErrMalformedMessage}
+	// ErrEmptyPayload is returned when a message has no data before its
+	// digest. It matches ErrMalformedMessage.
+	ErrEmptyPayload error = &messageError{msg: "Invalid signature - empty data", kind: ErrMalformedMessage}
+	// ErrEmptyDigest is returned when a message has no digest after its data.
+	// It matches ErrMalformedMessage.
+	ErrEmptyDigest error = &messageError{msg: "Invalid signature - empty digest", kind: ErrMalformedMessage}
+)
+
+// ParseSignedMessage splits a message generated by a MessageVerifier (using
+// the default separator) into its decoded payload and its digest.
+// The signature is NOT checked, use a MessageVerifier for that.
+func ParseSignedMessage(msg string) (payload []byte, digest string, err error) {
+	data, digest, err := splitSignedMessage(msg, "--")
+	if err != nil {
+		return nil, "", err
+	}
+	payload, err = decodeBase64(data)
+	if err != nil {
+		return nil, "", &messageError{msg: "Invalid signature - bad base64: " + err.Error(), kind: ErrMalformedMessage, err: err}
+	}
+	return payload, digest, nil
+}
+
+// InsecureDecode decodes the payload of a message generated by a
+// MessageVerifier (using the default separator and the JSON serializer) into
+// dest, unwrapping the Rails metadata envelope if any, WITHOUT CHECKING ITS
+// SIGNATURE, PURPOSE OR EXPIRY.
+//
+// THE DECODED DATA IS NOT AUTHENTIC AND CAN'T BE TRUSTED: anyone can forge
+// a message that decodes to whatever they want. This is only meant to
+// inspect messages when debugging, never use it in place of Verify.
+func InsecureDecode(msg string, dest interface{}) error {
+	payload, _, err := ParseSignedMessage(msg)
+	if err != nil {
+		return err
+	}
+	data, _, err := extractMetadata(payload)
+	if err != nil {
+		return err
+	}
+	return JsonMsgSerializer{}.Unserialize(string(data), dest)
+}
+
+// splitSignedMessage splits a message into its encoded data and its digest.
+func splitSignedMessage(msg, sep string) (data, digest string, err error) {
+	if msg == "" {
+		return "", "", &messageError{msg: "Invalid signature - empty message", kind: ErrMalformedMessage}
+	}
+	// The digest is hex encoded and never contains the separator, while the
+	// data might (URL safe data can end with a dash for instance).
+	i := strings.LastIndex(msg, sep)
+	if i < 0 {
+		return "", "", ErrMissingSeparator
+	}
+	data, digest = msg[:i], msg[i+len(sep):]
+	if data == "" {
+		return "", "", ErrEmptyPayload
+	}
+	if digest == "" {
+		return "", "", ErrEmptyDigest
+	}
+	return data, digest, nil
+}
+
 // MessageVerifier makes it easy to generate and verify messages which are
 // signed to prevent tampering.
 //
 // This is useful for cases like remember-me tokens and auto-unsubscribe links
 // where the session store isn't suitable or available.
+//
+// A MessageVerifier is safe for concurrent use by multiple goroutines as long
+// as its fields aren't modified once it's in use.
 type MessageVerifier struct {
 	// Secret of 32-bytes if using the default hashing.
 	Secret []byte
+	// KeyProvider, if set, provides the secret for each operation instead
+	// of Secret.
+	KeyProvider KeyProvider
 	// Hasher defaults to sha1 if not set.
 	Hasher func() hash.Hash
+	// MACFactory, if set, is used to compute the digests instead of HMAC with
+	// the Hasher. It is meant for hash functions with a native keyed mode,
+	// see Blake2bMAC and Blake2sMAC.
+	MACFactory func(key []byte) (hash.Hash, error)
+	// MACer, if set, computes the digests instead of Secret and KeyProvider,
+	// see MACer.
+	MACer MACer
 	// Serializer defines the way the data is serializer/deserialized.
 	Serializer MsgSerializer
+	// URLSafe makes Generate encode the data using the URL safe base64
+	// alphabet without padding so messages can be used in URLs as is.
+	// Messages using either alphabet are accepted by Verify regardless.
+	URLSafe bool
+	// UseMessageSerializerForMetadata makes GenerateWithOptions serialize
+	// the value and its metadata together with the Serializer, like Rails
+	// 7.1 does by default, rather than wrap the serialized value in the
+	// legacy JSON envelope. Verify accepts both regardless.
+	UseMessageSerializerForMetadata bool
+	// Separator joins the data and its digest, defaults to "--" like Rails.
+	Separator string
+	// DigestEncoding sets how Generate and DigestFor encode digests, in
+	// hexadecimal by default like Rails. Verify accepts digests using any of
+	// the encodings regardless.
+	DigestEncoding DigestEncoding
+	// MaxMessageLen is the length over which messages are rejected with
+	// ErrMessageTooLarge before being decoded. It defaults to
+	// DefaultMaxMessageLen, a negative value disables the limit.
+	MaxMessageLen int
+	// Now returns the current time used to set and check message expiry,
+	// defaults to time.Now. It can be replaced in tests or wrapped to allow
+	// for some clock skew.
+	Now func() time.Time
+	// ErrorPreviewLen is the number of bytes of the payload an
+	// UnserializeError previews. It defaults to DefaultErrorPreviewLen, a
+	// negative value disables the preview.
+	ErrorPreviewLen int
+	// Rotations are verifiers set with previous secrets (and/or hashers and
+	// serializers) that are tried in order when a message doesn't verify
+	// with Secret. Messages are always generated using Secret.
+	// See Rotate().
+	Rotations []*MessageVerifier
+	// OnRotation is called, if set, with the index in Rotations of the
+	// verifier that verified a message so it can be reissued with Secret.
+	OnRotation func(index int)
+	// InvalidateBefore, if set, makes the verifier reject the messages
+	// issued before it with ErrMessageExpired, ie: to invalidate all the
+	// tokens issued before a security incident. Only the messages with an
+	// issue time can be proven to be issued after it, the others (such as
+	// those generated by Rails) are rejected too. When it is set the
+	// messages generated by the verifier embed their issue time, see
+	// MessageOptions.IssuedAt.
+	InvalidateBefore time.Time
+}
+
+// Rotate adds a verifier for an old secret to the verifier's Rotations, like
+// Rails' `verifier.rotate old_secret`. A nil hasher or serializer defaults to
+// the one set on the verifier (and so does the MACFactory when hasher is nil).
+func (crypt *MessageVerifier) Rotate(secret []byte, hasher func() hash.Hash, serializer MsgSerializer) {
+	var macFactory func(key []byte) (hash.Hash, error)
+	if hasher == nil {
+		hasher = crypt.Hasher
+		macFactory = crypt.MACFactory
+	}
+	if serializer == nil {
+		serializer = crypt.Serializer
+	}
+	crypt.Rotations = append(crypt.Rotations, &MessageVerifier{
+		Secret:          secret,
+		Hasher:          hasher,
+		MACFactory:      macFactory,
+		Serializer:      serializer,
+		URLSafe:         crypt.URLSafe,
+		Separator:       crypt.Separator,
+		DigestEncoding:  crypt.DigestEncoding,
+		ErrorPreviewLen: crypt.ErrorPreviewLen,
+
+		UseMessageSerializerForMetadata: crypt.UseMessageSerializerForMetadata,
+	})
+}
+
+func (crypt *MessageVerifier) errorPreviewLen() int {
+	if crypt.ErrorPreviewLen == 0 {
+		return DefaultErrorPreviewLen
+	}
+	return crypt.ErrorPreviewLen
 }
 
 // Checks that the struct is properly set and ready for use.
 func (crypt *MessageVerifier) IsValid() (bool, error) {
-	err := crypt.checkInit()
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return false, err
+	}
+	err = crypt.checkInit()
 	if err != nil {
 		return false, err
 	}
@@ -35,34 +232,239 @@ func (crypt *MessageVerifier) IsValid() (bool, error) {
 }
 
 // Verify() takes a base64 encoded message string joined to a digest by a double dash "--"
-// and returns an error if anything wrong happen.
+// (or the Separator if set) and returns an error if anything wrong happen.
 // If the verification worked, the target interface object passed is populated.
+// Failures can be matched with errors.Is against ErrNoSecret, ErrNoSerializer,
+// ErrMalformedMessage and ErrInvalidSignature, errors returned by the
+// Serializer are passed through untouched.
 func (crypt *MessageVerifier) Verify(msg string, target interface{}) error {
+	return crypt.verifyWithOptions(context.Background(), msg, target, MessageOptions{})
+}
+
+// VerifyContext is like Verify, ctx being passed to the KeyProvider of the
+// verifier and of the Rotations tried.
+func (crypt *MessageVerifier) VerifyContext(ctx context.Context, msg string, target interface{}) error {
+	return crypt.verifyWithOptions(ctx, msg, target, MessageOptions{})
+}
+
+// VerifyWithOptions works like Verify but also checks the metadata embedded
+// in the message by GenerateWithOptions (or by Rails) against opts.
+// A message generated for a purpose fails with ErrInvalidPurpose unless
+// opts.Purpose matches it, and so does a message generated without a purpose
+// when opts.Purpose is set. A message past its expiry fails with
+// ErrMessageExpired, the expiry is only checked once the signature is valid.
+// Messages with an invalid signature are verified against the Rotations.
+func (crypt *MessageVerifier) VerifyWithOptions(msg string, target interface{}, opts MessageOptions) error {
+	return crypt.verifyWithOptions(context.Background(), msg, target, opts)
+}
+
+func (crypt *MessageVerifier) verifyWithOptions(ctx context.Context, msg string, target interface{}, opts MessageOptions) error {
+	var now time.Time
+	if crypt != nil {
+		now = crypt.now()
+		opts.invalidateBefore = crypt.InvalidateBefore
+	}
+	return crypt.withRotations(ctx, func(v *MessageVerifier) error {
+		return v.verify(nil, msg, target, opts, now)
+	})
+}
+
+// VerifyWithMetadata works like Verify but accepts messages whatever their
+// purpose and returns their metadata, so it can be inspected: the purpose,
+// the expiry and the issue time of the message if it has some, as well as
+// its serialized envelope.
+// Messages past their expiry, or issued before InvalidateBefore, fail with
+// ErrMessageExpired along with their metadata.
+func (crypt *MessageVerifier) VerifyWithMetadata(msg string, target interface{}) (Metadata, error) {
+	var meta Metadata
+	var now time.Time
+	opts := MessageOptions{anyPurpose: true}
+	if crypt != nil {
+		now = crypt.now()
+		opts.invalidateBefore = crypt.InvalidateBefore
+	}
+	err := crypt.withRotations(context.Background(), func(v *MessageVerifier) error {
+		if err := v.checkInit(); err != nil {
+			return err
+		}
+		data, err := v.verifiedData(nil, msg)
+		if err != nil {
+			return err
+		}
+		defer wipe(data)
+		meta, err = v.decodeMetadata(data, target, opts, now)
+		// the data is wiped once decoded.
+		meta.Envelope = append([]byte(nil), meta.Envelope...)
+		return err
+	})
+	return meta, err
+}
+
+// Valid reports whether the message was signed with the verifier's secret
+// (or one of its Rotations) without deserializing it, the Serializer doesn't
+// need to be set. Metadata such as the purpose or expiry isn't checked.
+func (crypt *MessageVerifier) Valid(msg string) bool {
+	err := crypt.withRotations(context.Background(), func(v *MessageVerifier) error {
+		_, err := v.verifiedData(nil, msg)
+		return err
+	})
+	return err == nil
+}
+
+// Resign verifies a message generated by the old verifier and generates the
+// same message signed by this verifier, keeping its purpose and expiry. The
+// payload isn't deserialized. This is useful to migrate messages when
+// rotating secrets.
+// It fails like Verify if the message isn't valid or has expired.
+func (crypt *MessageVerifier) Resign(oldMsg string, old *MessageVerifier) (string, error) {
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return "", err
+	}
+	err = crypt.checkSecret()
+	if err != nil {
+		return "", err
+	}
+	data, err := old.VerifyRaw(oldMsg)
+	if err != nil {
+		return "", err
+	}
+	payload, opts, err := extractMetadata(data)
+	if err != nil {
+		return "", err
+	}
+	now := crypt.now()
+	if opts.expired(now) {
+		return "", ErrMessageExpired
+	}
+	wrapped, err := wrapMetadata(payload, opts, now)
+	if err != nil {
+		return "", err
+	}
+	return crypt.sign(wrapped)
+}
+
+// VerifyRaw checks the signature of a message (against the Rotations too)
+// and returns its payload as it was signed, without deserializing it or
+// looking at its metadata. The Serializer doesn't need to be set.
+func (crypt *MessageVerifier) VerifyRaw(msg string) ([]byte, error) {
+	var data []byte
+	err := crypt.withRotations(context.Background(), func(v *MessageVerifier) error {
+		var err error
+		data, err = v.verifiedData(nil, msg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// withRotations calls fn with the verifier and, as long as it fails with
+// ErrInvalidSignature, with each of its Rotations, set with the secret of
+// their KeyProvider.
+func (crypt *MessageVerifier) withRotations(ctx context.Context, fn func(v *MessageVerifier) error) error {
+	v, err := crypt.withKeys(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(v)
+	if !errors.Is(err, ErrInvalidSignature) {
+		return err
+	}
+	for i, rotation := range crypt.Rotations {
+		r, rerr := rotation.withKeys(ctx)
+		if rerr != nil {
+			return rerr
+		}
+		rerr = fn(r)
+		if errors.Is(rerr, ErrInvalidSignature) {
+			continue
+		}
+		if rerr == nil && crypt.OnRotation != nil {
+			crypt.OnRotation(i)
+		}
+		return rerr
+	}
+	return err
+}
+
+func (crypt *MessageVerifier) verify(d *digester, msg string, target interface{}, opts MessageOptions, now time.Time) error {
 	// TODO: check that the target is a pointer.
 	err := crypt.checkInit()
 	if err != nil {
 		return err
 	}
-
-	invalid := func(msg string) error {
-		return errors.New("Invalid signature - " + msg)
+	data, err := crypt.verifiedData(d, msg)
+	if err != nil {
+		return err
 	}
-	if msg == "" {
-		return invalid("empty message")
+	defer wipe(data)
+	return crypt.decode(data, target, opts, now)
+}
+
+// decode checks the metadata of verified data and unserializes it into
+// target.
+func (crypt *MessageVerifier) decode(data []byte, target interface{}, opts MessageOptions, now time.Time) error {
+	_, err := crypt.decodeMetadata(data, target, opts, now)
+	return err
+}
+
+// decodeMetadata is decode returning the metadata of the data, its Envelope
+// being data itself.
+func (crypt *MessageVerifier) decodeMetadata(data []byte, target interface{}, opts MessageOptions, now time.Time) (Metadata, error) {
+	if opts.invalidateBefore.IsZero() {
+		opts.invalidateBefore = crypt.InvalidateBefore
 	}
+	return unserializeWithMetadata(crypt.Serializer, data, target, opts, now, crypt.errorPreviewLen())
+}
 
-	dataDigest := strings.Split(msg, "--")
-	if len(dataDigest) != 2 {
-		return invalid("bad data --")
+// verifiedData checks the signature of a message and returns its decoded
+// data. The digest is computed with d, or a new digester if nil.
+func (crypt *MessageVerifier) verifiedData(d *digester, msg string) ([]byte, error) {
+	err := crypt.checkSecret()
+	if err != nil {
+		return nil, err
 	}
 
-	data, digest := dataDigest[0], dataDigest[1]
-	if crypt.secureCompare(digest, crypt.DigestFor(data)) == false {
-		return invalid("bad data (compare)")
+	err = checkMessageLen(msg, crypt.MaxMessageLen)
+	if err != nil {
+		return nil, err
 	}
-	decodedData, err := base64.StdEncoding.DecodeString(data)
-	err = crypt.Serializer.Unserialize(string(decodedData), target)
-	return err
+	sep := crypt.separator()
+	data, digest, err := splitSignedMessage(msg, sep)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		d, err = crypt.newDigester()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !crypt.matchDigest(d, data, digest) {
+		// base64 digests can contain the separator (ie: "--" in URL safe
+		// ones), split the message by the length of the digests instead.
+		var matched bool
+		for _, enc := range digestEncodings {
+			i := len(msg) - enc.encodedLen(len(d.sum)) - len(sep)
+			if i <= 0 || i == len(data) || msg[i:i+len(sep)] != sep {
+				continue
+			}
+			if crypt.matchDigest(d, msg[:i], msg[i+len(sep):]) {
+				data, matched = msg[:i], true
+				break
+			}
+		}
+		if !matched {
+			return nil, ErrInvalidSignature
+		}
+	}
+	decodedData, err := decodeBase64(data)
+	if err != nil {
+		return nil, &messageError{msg: "Invalid signature - bad base64: " + err.Error(), kind: ErrMalformedMessage, err: err}
+	}
+	return decodedData, nil
 }
 
 // Generate() Converts an interface into a string containing the serialized data
@@ -70,45 +472,219 @@ func (crypt *MessageVerifier) Verify(msg string, target interface{}) error {
 // The string can be passed around and tampering can be checked using the digest.
 // See Verify() to extract the data out of the signed string.
 func (crypt *MessageVerifier) Generate(value interface{}) (string, error) {
-	err := crypt.checkInit()
+	return crypt.generateWithOptions(context.Background(), value, MessageOptions{})
+}
+
+// GenerateContext is like Generate, ctx being passed to the KeyProvider.
+func (crypt *MessageVerifier) GenerateContext(ctx context.Context, value interface{}) (string, error) {
+	return crypt.generateWithOptions(ctx, value, MessageOptions{})
+}
+
+// GenerateWithOptions works like Generate but embeds the passed options in
+// the message using the same metadata envelope as Rails 5.2+, so messages can
+// be exchanged with a Rails app sharing the same secret.
+// See VerifyWithOptions() to verify such a message.
+func (crypt *MessageVerifier) GenerateWithOptions(value interface{}, opts MessageOptions) (string, error) {
+	return crypt.generateWithOptions(context.Background(), value, opts)
+}
+
+func (crypt *MessageVerifier) generateWithOptions(ctx context.Context, value interface{}, opts MessageOptions) (string, error) {
+	crypt, err := crypt.withKeys(ctx)
 	if err != nil {
 		return "", err
 	}
+	err = crypt.checkInit()
+	if err != nil {
+		return "", err
+	}
+
+	now := crypt.now()
+	if !crypt.InvalidateBefore.IsZero() && opts.IssuedAt.IsZero() {
+		opts.IssuedAt = now
+	}
+	data, err := serializeWithMetadata(crypt.Serializer, value, opts, now, crypt.UseMessageSerializerForMetadata)
+	if err != nil {
+		return "", err
+	}
+	defer wipe(data)
+	return crypt.sign(data)
+}
 
-	data, err := crypt.Serializer.Serialize(value)
+// GenerateRaw signs an already serialized payload, the Serializer doesn't
+// need to be set. See VerifyRaw() to get the payload back.
+func (crypt *MessageVerifier) GenerateRaw(payload []byte) (string, error) {
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return "", err
+	}
+	err = crypt.checkSecret()
 	if err != nil {
 		return "", err
 	}
-	str := base64.StdEncoding.EncodeToString([]byte(data))
-	digest := crypt.DigestFor(str)
-	return fmt.Sprintf("%s--%s", str, digest), nil
+	return crypt.sign(payload)
+}
+
+// sign encodes the data and joins it to its digest.
+func (crypt *MessageVerifier) sign(data []byte) (string, error) {
+	var b strings.Builder
+	w, err := crypt.NewSignWriter(&b)
+	if err != nil {
+		return "", err
+	}
+	if _, err = w.Write(data); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// signWith is like sign but computes the digest with d, reusing its buffers.
+func (crypt *MessageVerifier) signWith(d *digester, data []byte) string {
+	encoding := base64.StdEncoding
+	if crypt.URLSafe {
+		encoding = base64.RawURLEncoding
+	}
+	d.buf = append(d.buf[:0], make([]byte, encoding.EncodedLen(len(data)))...)
+	encoding.Encode(d.buf, data)
+	digest := d.digest(d.buf, crypt.DigestEncoding)
+
+	sep := crypt.separator()
+	var b strings.Builder
+	b.Grow(len(d.buf) + len(sep) + len(digest))
+	b.Write(d.buf)
+	b.WriteString(sep)
+	b.Write(digest)
+	return b.String()
+}
+
+// hasher returns the Hasher or the default one, without setting it so the
+// verifier can be shared between goroutines.
+func (crypt *MessageVerifier) hasher() func() hash.Hash {
+	if crypt.Hasher == nil {
+		return sha1.New
+	}
+	return crypt.Hasher
+}
+
+func (crypt *MessageVerifier) now() time.Time {
+	if crypt.Now == nil {
+		return time.Now()
+	}
+	return crypt.Now()
+}
+
+// checkMessageLen rejects messages longer than max, using
+// DefaultMaxMessageLen when max is 0 and no limit when it's negative.
+func checkMessageLen(msg string, max int) error {
+	if max == 0 {
+		max = DefaultMaxMessageLen
+	}
+	if max > 0 && len(msg) > max {
+		return ErrMessageTooLarge
+	}
+	return nil
+}
+
+func (crypt *MessageVerifier) separator() string {
+	if crypt.Separator == "" {
+		return "--"
+	}
+	return crypt.Separator
 }
 
 // DigestFor returns the digest form of a string after hashing it via
-// the verifier's digest and secret.
+// the verifier's digest and secret, encoded using DigestEncoding.
+// An empty string is returned if the MACFactory or the KeyProvider fails.
 func (crypt *MessageVerifier) DigestFor(data string) string {
-	if crypt.Secret == nil {
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return ""
+	}
+	if crypt.MACer == nil && crypt.Secret == nil {
 		return "Y U SET NO SECRET???!"
 	}
 
-	mac := hmac.New(crypt.Hasher, crypt.Secret)
-	mac.Write([]byte(data))
-	return hex.EncodeToString(mac.Sum(nil))
+	digest, _ := crypt.digest(data)
+	return digest
 }
 
-// constant-time comparison algorithm to prevent timing attacks
-func (crypt *MessageVerifier) secureCompare(strA, strB string) bool {
-	a := []byte(strA)
-	b := []byte(strB)
+func (crypt *MessageVerifier) digest(data string) (string, error) {
+	d, err := crypt.newDigester()
+	if err != nil {
+		return "", err
+	}
+	return string(d.digestString(data, crypt.DigestEncoding)), nil
+}
 
-	if len(a) != len(b) {
-		return false
+// newMAC returns the keyed hash used to compute digests.
+func (crypt *MessageVerifier) newMAC() (hash.Hash, error) {
+	if crypt.MACer != nil {
+		return &macerHash{macer: crypt.MACer}, nil
 	}
-	res := 0
-	for i := 0; i < len(a); i++ {
-		res |= int(b[i]) ^ int(a[i])
+	if crypt.MACFactory != nil {
+		return crypt.MACFactory(crypt.Secret)
 	}
-	return res == 0
+	return hmac.New(crypt.hasher(), crypt.Secret), nil
+}
+
+// digester computes digests reusing the same MAC and buffers, which saves
+// setting up a new MAC for each message when processing messages in batches.
+// It isn't safe for concurrent use.
+type digester struct {
+	mac hash.Hash
+	buf []byte
+	sum []byte
+	enc []byte
+}
+
+func (crypt *MessageVerifier) newDigester() (*digester, error) {
+	mac, err := crypt.newMAC()
+	if err != nil {
+		return nil, err
+	}
+	return &digester{mac: mac}, nil
+}
+
+// digest returns the digest of data encoded with enc, which is only valid
+// until the next call.
+func (d *digester) digest(data []byte, enc DigestEncoding) []byte {
+	d.mac.Reset()
+	d.mac.Write(data)
+	d.sum = d.mac.Sum(d.sum[:0])
+	return d.encode(enc)
+}
+
+func (d *digester) digestString(data string, enc DigestEncoding) []byte {
+	d.buf = append(d.buf[:0], data...)
+	return d.digest(d.buf, enc)
+}
+
+// encode encodes the last digest with enc.
+func (d *digester) encode(enc DigestEncoding) []byte {
+	d.enc = append(d.enc[:0], make([]byte, enc.encodedLen(len(d.sum)))...)
+	enc.encode(d.enc, d.sum)
+	return d.enc
+}
+
+// matchDigest checks that digest is the digest of data, in any of the
+// digest encodings, the verifier's one being tried first.
+func (crypt *MessageVerifier) matchDigest(d *digester, data, digest string) bool {
+	if crypt.secureCompare(digest, d.digestString(data, crypt.DigestEncoding)) {
+		return true
+	}
+	for _, enc := range digestEncodings {
+		if enc != crypt.DigestEncoding && crypt.secureCompare(digest, d.encode(enc)) {
+			return true
+		}
+	}
+	return false
+}
+
+// constant-time comparison algorithm to prevent timing attacks
+func (crypt *MessageVerifier) secureCompare(digest string, expected []byte) bool {
+	return SecureCompareBytes([]byte(digest), expected)
 }
 
 func (crypt *MessageVerifier) checkInit() error {
@@ -116,16 +692,20 @@ func (crypt *MessageVerifier) checkInit() error {
 		return errors.New("MessageVerifier not set")
 	}
 	if crypt.Serializer == nil {
-		return errors.New("Serializer not set")
+		return ErrNoSerializer
 	}
+	return crypt.checkSecret()
+}
 
-	if crypt.Hasher == nil {
-		// set a default hasher
-		crypt.Hasher = sha1.New
+// checkSecret checks that the verifier is set to sign messages, the
+// serializer isn't required to do so.
+func (crypt *MessageVerifier) checkSecret() error {
+	if crypt == nil {
+		return errors.New("MessageVerifier not set")
 	}
 
-	if crypt.Secret == nil {
-		return errors.New("Secret not set")
+	if crypt.MACer == nil && crypt.Secret == nil {
+		return ErrNoSecret
 	}
 
 	return nil
@@ -0,0 +1,119 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// BatchError is returned by GenerateAll and VerifyAll when some of the
+// messages failed, and by the DeriveAll functions when some of the keys
+// couldn't be derived. Errors holds the error of each message (or salt) by
+// index, nil for the ones that went through.
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	failed, first := 0, -1
+	for i, err := range e.Errors {
+		if err != nil {
+			failed++
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	if failed == 0 {
+		return "no failed messages"
+	}
+	return fmt.Sprintf("%d of %d messages failed, first at index %d: %v", failed, len(e.Errors), first, e.Errors[first])
+}
+
+// batchError returns a *BatchError if any of errs is set, nil otherwise.
+func batchError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return &BatchError{Errors: errs}
+		}
+	}
+	return nil
+}
+
+// GenerateAll works like calling Generate for each value, but reuses the
+// same MAC and buffers for all of them which is noticeably faster for large
+// batches.
+// Values that can't be serialized are reported by index in a *BatchError,
+// their message being left empty, while the others are still generated.
+func (crypt *MessageVerifier) GenerateAll(values []interface{}) ([]string, error) {
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	err = crypt.checkInit()
+	if err != nil {
+		return nil, err
+	}
+	d, err := crypt.newDigester()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]string, len(values))
+	errs := make([]error, len(values))
+	for i, value := range values {
+		data, err := serializeWith(crypt.Serializer, value)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		msgs[i] = crypt.signWith(d, data)
+	}
+	return msgs, batchError(errs)
+}
+
+// VerifyAll works like calling Verify for each message, but reuses the same
+// MACs and buffers for all of them which is noticeably faster for large
+// batches. results must be a pointer to a slice, which is set to a slice
+// holding the value of each message by index.
+// Messages that don't verify are reported by index in a *BatchError, their
+// value being left to its zero value, while the others are still verified.
+func (crypt *MessageVerifier) VerifyAll(msgs []string, results interface{}) error {
+	// the keys are resolved once for the whole batch.
+	crypt, err := crypt.withRotationKeys(context.Background())
+	if err != nil {
+		return err
+	}
+	err = crypt.checkInit()
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(results)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("VerifyAll results must be a pointer to a slice")
+	}
+
+	values := reflect.MakeSlice(rv.Elem().Type(), len(msgs), len(msgs))
+	// one digester per verifier, the rotations being set with other secrets.
+	digesters := map[*MessageVerifier]*digester{}
+	now := crypt.now()
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		target := values.Index(i).Addr().Interface()
+		errs[i] = crypt.withRotations(context.Background(), func(v *MessageVerifier) error {
+			d := digesters[v]
+			if d == nil {
+				var err error
+				d, err = v.newDigester()
+				if err != nil {
+					return err
+				}
+				digesters[v] = d
+			}
+			return v.verify(d, msg, target, MessageOptions{invalidateBefore: crypt.InvalidateBefore}, now)
+		})
+	}
+	rv.Elem().Set(values)
+	return batchError(errs)
+}
@@ -0,0 +1,166 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestMessageVerifierBatch(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Batches of messages", func() {
+		v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+		values := []interface{}{
+			testStruct{Foo: "foo", Bar: 42},
+			testStruct{Foo: "bar", Bar: 1},
+			testStruct{Foo: "baz", Baz: []string{"a", "b"}},
+		}
+
+		g.It("are generated like single messages", func() {
+			msgs, err := v.GenerateAll(values)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(msgs)).Eql(len(values))
+			g.Assert(msgs[0]).Eql("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5")
+			for i, value := range values {
+				expected, _ := v.Generate(value)
+				g.Assert(msgs[i]).Eql(expected)
+			}
+		})
+
+		g.It("are generated with keyed BLAKE2 and URL safe encoding", func() {
+			vv := &MessageVerifier{Secret: v.Secret, MACFactory: Blake2bMAC, URLSafe: true, Separator: ".", Serializer: v.Serializer}
+			msgs, err := vv.GenerateAll(values)
+			g.Assert(err).Eql(nil)
+			for i, value := range values {
+				expected, _ := vv.Generate(value)
+				g.Assert(msgs[i]).Eql(expected)
+			}
+		})
+
+		g.It("are verified", func() {
+			msgs, err := v.GenerateAll(values)
+			g.Assert(err).Eql(nil)
+			var results []testStruct
+			err = v.VerifyAll(msgs, &results)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(results)).Eql(len(values))
+			for i, value := range values {
+				g.Assert(results[i]).Eql(value)
+			}
+		})
+
+		g.It("report failures by index", func() {
+			msgs, err := v.GenerateAll(values)
+			g.Assert(err).Eql(nil)
+			msgs[1] = reverse(msgs[1])
+			msgs = append(msgs, "garbage")
+			var results []testStruct
+			err = v.VerifyAll(msgs, &results)
+			var batchErr *BatchError
+			g.Assert(errors.As(err, &batchErr)).IsTrue()
+			g.Assert(len(batchErr.Errors)).Eql(4)
+			g.Assert(batchErr.Errors[0]).Eql(nil)
+			g.Assert(errors.Is(batchErr.Errors[1], ErrInvalidSignature)).IsTrue()
+			g.Assert(batchErr.Errors[2]).Eql(nil)
+			g.Assert(errors.Is(batchErr.Errors[3], ErrMalformedMessage)).IsTrue()
+			g.Assert(err.Error()).Eql("2 of 4 messages failed, first at index 1: Invalid signature - bad data (compare)")
+
+			g.Assert(results[0]).Eql(values[0])
+			g.Assert(results[1]).Eql(testStruct{})
+			g.Assert(results[2]).Eql(values[2])
+		})
+
+		g.It("report serialization failures by index", func() {
+			msgs, err := v.GenerateAll([]interface{}{"foo", make(chan int)})
+			var batchErr *BatchError
+			g.Assert(errors.As(err, &batchErr)).IsTrue()
+			g.Assert(batchErr.Errors[0]).Eql(nil)
+			g.Assert(batchErr.Errors[1] != nil).IsTrue()
+			expected, _ := v.Generate("foo")
+			g.Assert(msgs).Eql([]string{expected, ""})
+		})
+
+		g.It("are verified against the rotations", func() {
+			old := &MessageVerifier{Secret: []byte("Hey, I'm an old secret!"), Serializer: JsonMsgSerializer{}}
+			oldMsgs, err := old.GenerateAll(values)
+			g.Assert(err).Eql(nil)
+			newMsgs, err := v.GenerateAll(values)
+			g.Assert(err).Eql(nil)
+
+			var rotated []int
+			vv := &MessageVerifier{Secret: v.Secret, Serializer: v.Serializer, OnRotation: func(i int) { rotated = append(rotated, i) }}
+			vv.Rotate(old.Secret, nil, nil)
+			var results []testStruct
+			err = vv.VerifyAll(append(oldMsgs, newMsgs...), &results)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(rotated)).Eql(len(values))
+			for i, value := range values {
+				g.Assert(results[i]).Eql(value)
+				g.Assert(results[i+len(values)]).Eql(value)
+			}
+		})
+
+		g.It("need a pointer to a slice to verify", func() {
+			var result testStruct
+			g.Assert(v.VerifyAll(nil, &result) != nil).IsTrue()
+			g.Assert(v.VerifyAll(nil, []testStruct{}) != nil).IsTrue()
+		})
+	})
+}
+
+func benchmarkValues(n int) []interface{} {
+	values := make([]interface{}, n)
+	for i := range values {
+		values[i] = fmt.Sprintf("recipient-%d@example.com", i)
+	}
+	return values
+}
+
+func BenchmarkGenerateLoop(b *testing.B) {
+	v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+	values := benchmarkValues(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, value := range values {
+			v.Generate(value)
+		}
+	}
+}
+
+func BenchmarkGenerateAll(b *testing.B) {
+	v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+	values := benchmarkValues(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.GenerateAll(values)
+	}
+}
+
+func BenchmarkVerifyLoop(b *testing.B) {
+	v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+	msgs, _ := v.GenerateAll(benchmarkValues(1000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		results := make([]string, len(msgs))
+		for j, msg := range msgs {
+			v.Verify(msg, &results[j])
+		}
+	}
+}
+
+func BenchmarkVerifyAll(b *testing.B) {
+	v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+	msgs, _ := v.GenerateAll(benchmarkValues(1000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var results []string
+		v.VerifyAll(msgs, &results)
+	}
+}
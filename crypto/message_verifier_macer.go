@@ -0,0 +1,49 @@
+package crypto
+
+// MACer computes the digests of a MessageVerifier in place of its Secret,
+// ie: by calling out to an HSM through PKCS#11 or to a KMS so the secret
+// never is in memory. Sum returns the MAC of data.
+//
+// When a verifier's MACer is set, its Secret, KeyProvider, Hasher and
+// MACFactory are ignored.
+type MACer interface {
+	Sum(data []byte) []byte
+}
+
+// macerHash adapts a MACer to hash.Hash, buffering the data written until
+// Sum is called. Streamed messages are thus held in memory when signed or
+// verified with a MACer.
+type macerHash struct {
+	macer MACer
+	buf   []byte
+	size  int
+}
+
+func (h *macerHash) Write(p []byte) (int, error) {
+	h.buf = append(h.buf, p...)
+	return len(p), nil
+}
+
+func (h *macerHash) Sum(b []byte) []byte {
+	sum := h.macer.Sum(h.buf)
+	h.size = len(sum)
+	return append(b, sum...)
+}
+
+func (h *macerHash) Reset() {
+	wipe(h.buf)
+	h.buf = h.buf[:0]
+}
+
+// Size returns the length of the digests, the MACer being called once on
+// empty data if no digest was computed yet.
+func (h *macerHash) Size() int {
+	if h.size == 0 {
+		h.size = len(h.macer.Sum(nil))
+	}
+	return h.size
+}
+
+func (h *macerHash) BlockSize() int {
+	return 1
+}
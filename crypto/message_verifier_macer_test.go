@@ -0,0 +1,100 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+// countingMACer computes HMAC-SHA256 digests with a key of its own, like an
+// HSM would, and counts how many it computed.
+type countingMACer struct {
+	key   []byte
+	calls int
+}
+
+func (m *countingMACer) Sum(data []byte) []byte {
+	m.calls++
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func TestMessageVerifierMACer(t *testing.T) {
+	g := Goblin(t)
+	key := []byte("a key which never leaves the HSM")
+
+	g.Describe("MessageVerifier MACer", func() {
+		g.It("computes the digests of Generate, Verify and DigestFor", func() {
+			m := &countingMACer{key: key}
+			v := &MessageVerifier{MACer: m, Serializer: JsonMsgSerializer{}}
+			msg, err := v.Generate("foo")
+			g.Assert(err).Eql(nil)
+			g.Assert(m.calls).Eql(1)
+			var output string
+			g.Assert(v.Verify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("foo")
+			g.Assert(m.calls).Eql(2)
+			g.Assert(v.DigestFor("foo")).Eql((&MessageVerifier{Secret: key, Hasher: sha256.New}).DigestFor("foo"))
+			g.Assert(m.calls).Eql(3)
+		})
+
+		g.It("signs messages like the secret would", func() {
+			v := &MessageVerifier{MACer: &countingMACer{key: key}, Serializer: JsonMsgSerializer{}}
+			static := &MessageVerifier{Secret: key, Hasher: sha256.New, Serializer: JsonMsgSerializer{}}
+			msg, _ := v.Generate("foo")
+			expected, _ := static.Generate("foo")
+			g.Assert(msg).Eql(expected)
+			var output string
+			msg, _ = static.Generate("bar")
+			g.Assert(v.Verify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("bar")
+		})
+
+		g.It("never uses the secret or the key provider", func() {
+			m := &countingMACer{key: key}
+			v := &MessageVerifier{
+				MACer:       m,
+				Secret:      []byte("not the HSM key"),
+				KeyProvider: failingKeyProvider{errors.New("KMS unavailable")},
+				Serializer:  JsonMsgSerializer{},
+			}
+			msg, err := v.Generate("foo")
+			g.Assert(err).Eql(nil)
+			var output string
+			g.Assert((&MessageVerifier{MACer: m, Serializer: JsonMsgSerializer{}}).Verify(msg, &output)).Eql(nil)
+			g.Assert((&MessageVerifier{Secret: v.Secret, Serializer: JsonMsgSerializer{}}).Verify(msg, &output)).Eql(ErrInvalidSignature)
+		})
+
+		g.It("rejects tampered messages", func() {
+			v := &MessageVerifier{MACer: &countingMACer{key: key}, Serializer: JsonMsgSerializer{}}
+			msg, _ := v.Generate("foo")
+			var output string
+			g.Assert(v.Verify("x"+msg, &output)).Eql(ErrInvalidSignature)
+			other := &MessageVerifier{MACer: &countingMACer{key: []byte("another key")}, Serializer: JsonMsgSerializer{}}
+			g.Assert(other.Verify(msg, &output)).Eql(ErrInvalidSignature)
+		})
+
+		g.It("signs streamed messages", func() {
+			v := &MessageVerifier{MACer: &countingMACer{key: key}, Serializer: JsonMsgSerializer{}}
+			var b strings.Builder
+			w, err := v.NewSignWriter(&b)
+			g.Assert(err).Eql(nil)
+			w.Write([]byte("streamed data"))
+			g.Assert(w.Close()).Eql(nil)
+			data, err := v.VerifyRaw(b.String())
+			g.Assert(err).Eql(nil)
+			g.Assert(string(data)).Eql("streamed data")
+		})
+
+		g.It("is a valid configuration without a secret", func() {
+			v, err := NewMessageVerifier(nil, WithMACer(&countingMACer{key: key}))
+			g.Assert(err).Eql(nil)
+			g.Assert(v.Secret == nil).IsTrue()
+		})
+	})
+}
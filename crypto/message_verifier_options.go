@@ -0,0 +1,197 @@
+package crypto
+
+import (
+	"errors"
+	"hash"
+	"strconv"
+)
+
+// ErrInvalidConfig is returned by NewMessageVerifier when the verifier it
+// would return can't be used.
+var ErrInvalidConfig = errors.New("Invalid configuration")
+
+func configError(msg string) error {
+	return &messageError{msg: "Invalid configuration - " + msg, kind: ErrInvalidConfig}
+}
+
+// VerifierOption configures a MessageVerifier built by NewMessageVerifier.
+type VerifierOption func(v *MessageVerifier) error
+
+// WithHasher sets the hash used to compute the HMAC digests.
+func WithHasher(hasher func() hash.Hash) VerifierOption {
+	return func(v *MessageVerifier) error {
+		if hasher == nil {
+			return configError("nil hasher")
+		}
+		v.Hasher = hasher
+		return nil
+	}
+}
+
+// WithMACFactory sets the keyed hash used to compute the digests instead of
+// HMAC, see Blake2bMAC and Blake2sMAC.
+func WithMACFactory(factory func(key []byte) (hash.Hash, error)) VerifierOption {
+	return func(v *MessageVerifier) error {
+		if factory == nil {
+			return configError("nil MAC factory")
+		}
+		v.MACFactory = factory
+		return nil
+	}
+}
+
+// WithMACer sets the MACer computing the digests instead of the secret, which
+// can then be nil.
+func WithMACer(m MACer) VerifierOption {
+	return func(v *MessageVerifier) error {
+		if m == nil {
+			return configError("nil MACer")
+		}
+		v.MACer = m
+		return nil
+	}
+}
+
+// WithSerializer sets the serializer, JSON being used by default.
+func WithSerializer(serializer MsgSerializer) VerifierOption {
+	return func(v *MessageVerifier) error {
+		if serializer == nil {
+			return configError("nil serializer")
+		}
+		v.Serializer = serializer
+		return nil
+	}
+}
+
+// WithURLSafe makes the verifier generate URL safe messages, see URLSafe.
+func WithURLSafe() VerifierOption {
+	return func(v *MessageVerifier) error {
+		v.URLSafe = true
+		return nil
+	}
+}
+
+// WithMessageSerializerForMetadata makes the verifier embed the metadata of
+// its messages like Rails 7.1, see UseMessageSerializerForMetadata.
+func WithMessageSerializerForMetadata() VerifierOption {
+	return func(v *MessageVerifier) error {
+		v.UseMessageSerializerForMetadata = true
+		return nil
+	}
+}
+
+// WithSeparator sets the separator between the data and the digest.
+func WithSeparator(sep string) VerifierOption {
+	return func(v *MessageVerifier) error {
+		if sep == "" {
+			return configError("empty separator")
+		}
+		v.Separator = sep
+		return nil
+	}
+}
+
+// WithDigestEncoding sets how digests are encoded, see DigestEncoding.
+func WithDigestEncoding(enc DigestEncoding) VerifierOption {
+	return func(v *MessageVerifier) error {
+		v.DigestEncoding = enc
+		return nil
+	}
+}
+
+// WithRotations sets verifiers for previous secrets, see Rotations. Like with
+// Rotate, the rotations without a hasher or serializer use the ones of the
+// verifier being built (as well as its URLSafe, Separator and DigestEncoding
+// settings). The
+// passed verifiers aren't modified.
+func WithRotations(rotations ...*MessageVerifier) VerifierOption {
+	return func(v *MessageVerifier) error {
+		for _, r := range rotations {
+			if r == nil {
+				return configError("nil rotation")
+			}
+			rotation := *r
+			v.Rotations = append(v.Rotations, &rotation)
+		}
+		return nil
+	}
+}
+
+// NewMessageVerifier returns a verifier using the passed secret, configured
+// with the passed options and checked up front so a misconfiguration is
+// caught when the app starts rather than at the first message.
+// The serializer defaults to JSON and the hasher to SHA1, like Rails.
+// Errors match ErrInvalidConfig.
+//
+// The returned verifier must not be modified once in use, constructing a
+// MessageVerifier as a struct literal still works the same.
+func NewMessageVerifier(secret []byte, opts ...VerifierOption) (*MessageVerifier, error) {
+	v := &MessageVerifier{Secret: secret, Serializer: JsonMsgSerializer{}}
+	for _, opt := range opts {
+		if err := opt(v); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range v.Rotations {
+		if r.Hasher == nil && r.MACFactory == nil {
+			r.Hasher, r.MACFactory = v.Hasher, v.MACFactory
+		}
+		if r.Serializer == nil {
+			r.Serializer = v.Serializer
+		}
+		r.URLSafe = v.URLSafe
+		r.Separator = v.Separator
+		r.DigestEncoding = v.DigestEncoding
+	}
+
+	if err := v.checkConfig(""); err != nil {
+		return nil, err
+	}
+	for i, r := range v.Rotations {
+		if err := r.checkConfig("rotation " + strconv.Itoa(i) + ": "); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// VerifierFor returns a verifier whose secret is derived from a Rails app's
+// secret_key_base and the passed salt (ie: "signed cookie") the way Rails
+// derives its keys: PBKDF2-SHA1 with 1000 iterations and a 64 bytes key (see
+// KeyGenerator). Options are applied like with NewMessageVerifier.
+func VerifierFor(secretKeyBase, salt string, opts ...VerifierOption) (*MessageVerifier, error) {
+	if secretKeyBase == "" {
+		return nil, configError("empty secret_key_base")
+	}
+	return VerifierFrom(&KeyGenerator{Secret: secretKeyBase}, salt, opts...)
+}
+
+// VerifierFrom returns a verifier whose 64 bytes secret is derived by kd
+// from the passed salt. Options are applied like with NewMessageVerifier.
+func VerifierFrom(kd KeyDeriver, salt string, opts ...VerifierOption) (*MessageVerifier, error) {
+	if kd == nil {
+		return nil, configError("nil key deriver")
+	}
+	return NewMessageVerifier(kd.Generate([]byte(salt), 64), opts...)
+}
+
+// checkConfig checks that the verifier can generate and verify messages,
+// the error message being prefixed with prefix.
+func (crypt *MessageVerifier) checkConfig(prefix string) error {
+	switch {
+	case crypt.MACer == nil && len(crypt.Secret) == 0:
+		return configError(prefix + "empty secret")
+	case crypt.MACer == nil && crypt.MACFactory == nil && crypt.hasher()() == nil:
+		return configError(prefix + "the hasher returned a nil hash")
+	case !crypt.DigestEncoding.valid():
+		return configError(prefix + "unknown digest encoding")
+	}
+	mac, err := crypt.newMAC()
+	if err != nil {
+		return &messageError{msg: "Invalid configuration - " + prefix + err.Error(), kind: ErrInvalidConfig, err: err}
+	}
+	if mac == nil {
+		return configError(prefix + "the MAC factory returned a nil hash")
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestNewMessageVerifier(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("NewMessageVerifier", func() {
+		secret := []byte("Hey, I'm a secret!")
+		data := testStruct{Foo: "foo", Bar: 42}
+
+		g.It("returns a verifier with Rails' defaults", func() {
+			v, err := NewMessageVerifier(secret)
+			g.Assert(err).Eql(nil)
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5")
+			var verified testStruct
+			g.Assert(v.Verify(msg, &verified)).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("composes options", func() {
+			v, err := NewMessageVerifier(secret,
+				WithHasher(sha256.New),
+				WithSerializer(XMLMsgSerializer{}),
+				WithURLSafe(),
+				WithSeparator("."),
+			)
+			g.Assert(err).Eql(nil)
+			expected := &MessageVerifier{Secret: secret, Hasher: sha256.New, Serializer: XMLMsgSerializer{}, URLSafe: true, Separator: "."}
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			expectedMsg, _ := expected.Generate(data)
+			g.Assert(msg).Eql(expectedMsg)
+			g.Assert(strings.Contains(msg, "--")).IsFalse()
+		})
+
+		g.It("uses the last of the same option", func() {
+			v, err := NewMessageVerifier(secret, WithSeparator("."), WithSeparator("|"))
+			g.Assert(err).Eql(nil)
+			g.Assert(v.Separator).Eql("|")
+		})
+
+		g.It("sets up the rotations like Rotate", func() {
+			old := &MessageVerifier{Secret: []byte("Hey, I'm an old secret!")}
+			v, err := NewMessageVerifier(secret, WithHasher(sha256.New), WithRotations(old), WithSeparator("."))
+			g.Assert(err).Eql(nil)
+			g.Assert(old.Serializer == nil).IsTrue()
+
+			rotated := &MessageVerifier{Secret: old.Secret, Hasher: sha256.New, Serializer: JsonMsgSerializer{}, Separator: "."}
+			msg, _ := rotated.Generate(data)
+			var verified testStruct
+			g.Assert(v.Verify(msg, &verified)).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("supports keyed BLAKE2", func() {
+			v, err := NewMessageVerifier(secret, WithMACFactory(Blake2bMAC))
+			g.Assert(err).Eql(nil)
+			g.Assert(v.DigestFor("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==")).Eql("046154c2a557057ea1c2dd7a6acdfc282ed93f97e8d684f889419d7e45aef5c3")
+		})
+
+		g.It("rejects invalid configurations", func() {
+			nilHasher := func() hash.Hash { return nil }
+			cases := []struct {
+				secret []byte
+				opts   []VerifierOption
+				msg    string
+			}{
+				{nil, nil, "Invalid configuration - empty secret"},
+				{[]byte{}, nil, "Invalid configuration - empty secret"},
+				{secret, []VerifierOption{WithHasher(nil)}, "Invalid configuration - nil hasher"},
+				{secret, []VerifierOption{WithHasher(nilHasher)}, "Invalid configuration - the hasher returned a nil hash"},
+				{secret, []VerifierOption{WithMACFactory(nil)}, "Invalid configuration - nil MAC factory"},
+				{make([]byte, 65), []VerifierOption{WithMACFactory(Blake2bMAC)}, "Invalid configuration - blake2b: invalid key size"},
+				{secret, []VerifierOption{WithSerializer(nil)}, "Invalid configuration - nil serializer"},
+				{secret, []VerifierOption{WithSeparator("")}, "Invalid configuration - empty separator"},
+				{secret, []VerifierOption{WithRotations(nil)}, "Invalid configuration - nil rotation"},
+				{secret, []VerifierOption{WithRotations(&MessageVerifier{Secret: secret}, &MessageVerifier{})}, "Invalid configuration - rotation 1: empty secret"},
+			}
+			for _, c := range cases {
+				v, err := NewMessageVerifier(c.secret, c.opts...)
+				g.Assert(v == nil).IsTrue()
+				g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+				g.Assert(err.Error()).Eql(c.msg)
+			}
+		})
+	})
+
+	g.Describe("VerifierFor", func() {
+		railsSecret := "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"
+
+		g.It("derives the secret like Rails' key generator", func() {
+			v, err := VerifierFor(railsSecret, "signed cookie")
+			g.Assert(err).Eql(nil)
+			g.Assert(hex.EncodeToString(v.Secret)).Eql("f389ee2fa5134ba6d76f909b705d8761c8d8880d0289ad5331cc4c50ca0c72f6733393d2d2581e585154a9e8b217fcd9af01ea209eb020a8bc741ddbd8325a7c")
+			msg, err := v.Generate("foo")
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql("ImZvbyI=--ac70e150ba0e1d489b5f0c006b34acddce9d89d4")
+		})
+
+		g.It("uses a different secret per salt", func() {
+			v, _ := VerifierFor(railsSecret, "signed cookie")
+			other, _ := VerifierFor(railsSecret, "remember_me")
+			msg, _ := v.Generate("foo")
+			g.Assert(other.Valid(msg)).IsFalse()
+		})
+
+		g.It("applies the options", func() {
+			v, err := VerifierFor(railsSecret, "signed cookie", WithHasher(sha256.New), WithURLSafe())
+			g.Assert(err).Eql(nil)
+			g.Assert(v.URLSafe).IsTrue()
+			_, err = VerifierFor(railsSecret, "signed cookie", WithSerializer(nil))
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+
+		g.It("needs a secret_key_base", func() {
+			_, err := VerifierFor("", "signed cookie")
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,196 @@
+package crypto
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+)
+
+// NewSignWriter returns a writer signing the data written to it as it flows
+// through and writing the message to dst: the data is base64 encoded on the
+// fly and the separator and digest are appended on Close, which doesn't close
+// dst. The message is the same GenerateRaw would return for the whole data,
+// so large payloads (ie: serialized exports) can be signed without holding
+// them in memory.
+func (crypt *MessageVerifier) NewSignWriter(dst io.Writer) (io.WriteCloser, error) {
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	err = crypt.checkSecret()
+	if err != nil {
+		return nil, err
+	}
+	mac, err := crypt.newMAC()
+	if err != nil {
+		return nil, err
+	}
+	encoding := base64.StdEncoding
+	if crypt.URLSafe {
+		encoding = base64.RawURLEncoding
+	}
+	return &signWriter{
+		dst:       dst,
+		mac:       mac,
+		sep:       crypt.separator(),
+		digestEnc: crypt.DigestEncoding,
+		enc:       base64.NewEncoder(encoding, io.MultiWriter(dst, mac)),
+	}, nil
+}
+
+type signWriter struct {
+	dst       io.Writer
+	mac       hash.Hash
+	sep       string
+	digestEnc DigestEncoding
+	enc       io.WriteCloser
+	closed    bool
+}
+
+func (w *signWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to a closed sign writer")
+	}
+	return w.enc.Write(p)
+}
+
+// Close flushes the encoded data and writes the digest.
+func (w *signWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.enc.Close(); err != nil {
+		return err
+	}
+	sum := w.mac.Sum(nil)
+	digest := make([]byte, w.digestEnc.encodedLen(len(sum)))
+	w.digestEnc.encode(digest, sum)
+	_, err := io.WriteString(w.dst, w.sep+string(digest))
+	return err
+}
+
+// NewVerifyReader returns a reader decoding the data of a message read from
+// src, like VerifyRaw does, checking its digest once the end of the message
+// is reached. The reader only returns io.EOF if the digest is valid and fails
+// with ErrInvalidSignature otherwise.
+//
+// The data is returned as it is read, BEFORE the signature is checked: it
+// must not be trusted or acted upon until Read returned io.EOF.
+//
+// Unlike Verify, the data must be encoded using the alphabet set by URLSafe,
+// the digest using DigestEncoding, and the message is read whatever its
+// length.
+func (crypt *MessageVerifier) NewVerifyReader(src io.Reader) (io.Reader, error) {
+	crypt, err := crypt.withKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	err = crypt.checkSecret()
+	if err != nil {
+		return nil, err
+	}
+	mac, err := crypt.newMAC()
+	if err != nil {
+		return nil, err
+	}
+	encoding := base64.StdEncoding
+	if crypt.URLSafe {
+		encoding = base64.RawURLEncoding
+	}
+	sep := crypt.separator()
+	tail := len(sep) + crypt.DigestEncoding.encodedLen(mac.Size())
+	data := &signedDataReader{
+		crypt: crypt,
+		src:   src,
+		mac:   mac,
+		sep:   sep,
+		tail:  tail,
+		buf:   make([]byte, tail+32*1024),
+	}
+	return &verifyReader{dec: base64.NewDecoder(encoding, data)}, nil
+}
+
+type verifyReader struct {
+	dec io.Reader
+}
+
+func (r *verifyReader) Read(p []byte) (int, error) {
+	n, err := r.dec.Read(p)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	var b64Err base64.CorruptInputError
+	switch {
+	case errors.As(err, &b64Err):
+		err = &messageError{msg: "Invalid signature - bad base64: " + err.Error(), kind: ErrMalformedMessage, err: err}
+	case err == io.ErrUnexpectedEOF:
+		err = &messageError{msg: "Invalid signature - truncated data", kind: ErrMalformedMessage, err: err}
+	}
+	return n, err
+}
+
+// signedDataReader reads the encoded data out of a message, holding back the
+// trailing separator and digest which are checked when src is exhausted.
+type signedDataReader struct {
+	crypt *MessageVerifier
+	src   io.Reader
+	mac   hash.Hash
+	sep   string
+	// tail is the length of the separator and digest.
+	tail int
+	// buf[start:end] is what was read from src but not returned yet.
+	buf        []byte
+	start, end int
+	read       int
+	err        error
+}
+
+func (r *signedDataReader) Read(p []byte) (int, error) {
+	for r.end-r.start <= r.tail && r.err == nil {
+		if r.start > 0 {
+			r.end = copy(r.buf, r.buf[r.start:r.end])
+			r.start = 0
+		}
+		n, err := r.src.Read(r.buf[r.end:])
+		r.end += n
+		r.err = err
+	}
+	if avail := r.end - r.start - r.tail; avail > 0 {
+		if avail > len(p) {
+			avail = len(p)
+		}
+		n := copy(p, r.buf[r.start:r.start+avail])
+		r.mac.Write(p[:n])
+		r.start += n
+		r.read += n
+		return n, nil
+	}
+	if r.err != io.EOF {
+		return 0, r.err
+	}
+	return 0, r.check()
+}
+
+// check verifies the digest left in the buffer once src is exhausted.
+func (r *signedDataReader) check() error {
+	pending := r.buf[r.start:r.end]
+	if r.read == 0 && len(pending) == 0 {
+		return &messageError{msg: "Invalid signature - empty message", kind: ErrMalformedMessage}
+	}
+	if len(pending) < r.tail || string(pending[:len(r.sep)]) != r.sep {
+		return ErrMissingSeparator
+	}
+	if r.read == 0 {
+		return ErrEmptyPayload
+	}
+	sum := r.mac.Sum(nil)
+	expected := make([]byte, r.crypt.DigestEncoding.encodedLen(len(sum)))
+	r.crypt.DigestEncoding.encode(expected, sum)
+	if !r.crypt.secureCompare(string(pending[len(r.sep):]), expected) {
+		return ErrInvalidSignature
+	}
+	return io.EOF
+}
@@ -0,0 +1,150 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+// patternReader returns n bytes of a repeating pattern, in chunks of at most
+// chunk bytes.
+type patternReader struct {
+	n, chunk int
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	for i := range p {
+		p[i] = byte(r.n - i)
+	}
+	r.n -= len(p)
+	return len(p), nil
+}
+
+// streamMessage signs size bytes streamed in small chunks and returns a
+// reader of the message.
+func streamMessage(v *MessageVerifier, size int) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := v.NewSignWriter(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err = io.CopyBuffer(w, &patternReader{n: size, chunk: 4096}, make([]byte, 4096)); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return pr
+}
+
+func TestMessageVerifierStream(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Streamed messages", func() {
+		v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+		payload := []byte(`{"Foo":"foo","Bar":42}`)
+
+		g.It("are the same as generated ones", func() {
+			for _, vv := range []*MessageVerifier{
+				v,
+				{Secret: v.Secret, URLSafe: true, Separator: "."},
+				{Secret: v.Secret, MACFactory: Blake2bMAC},
+			} {
+				var buf bytes.Buffer
+				w, err := vv.NewSignWriter(&buf)
+				g.Assert(err).Eql(nil)
+				for _, b := range payload {
+					w.Write([]byte{b})
+				}
+				g.Assert(w.Close()).Eql(nil)
+				expected, _ := vv.GenerateRaw(payload)
+				g.Assert(buf.String()).Eql(expected)
+			}
+			msg, _ := v.GenerateRaw(payload)
+			g.Assert(msg).Eql("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5")
+		})
+
+		g.It("can be verified", func() {
+			for _, vv := range []*MessageVerifier{v, {Secret: v.Secret, URLSafe: true, Separator: "."}} {
+				msg, _ := vv.GenerateRaw(payload)
+				r, err := vv.NewVerifyReader(strings.NewReader(msg))
+				g.Assert(err).Eql(nil)
+				data, err := io.ReadAll(r)
+				g.Assert(err).Eql(nil)
+				g.Assert(data).Eql(payload)
+			}
+		})
+
+		g.It("fail at the end when tampered with", func() {
+			msg, _ := v.GenerateRaw(payload)
+			r, _ := v.NewVerifyReader(strings.NewReader(reverse(msg[:len(msg)-1]) + msg[len(msg)-1:]))
+			_, err := io.ReadAll(r)
+			g.Assert(err != nil).IsTrue()
+
+			r, _ = v.NewVerifyReader(strings.NewReader(msg[:len(msg)-1] + "0"))
+			_, err = io.ReadAll(r)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+		})
+
+		g.It("fail when malformed", func() {
+			msg, _ := v.GenerateRaw(payload)
+			for _, bad := range []string{"", "--", msg[len(msg)-42:], "deadbeef", msg[1:], strings.Replace(msg, "--", "-_", 1)} {
+				r, _ := v.NewVerifyReader(strings.NewReader(bad))
+				_, err := io.ReadAll(r)
+				g.Assert(errors.Is(err, ErrMalformedMessage) || errors.Is(err, ErrInvalidSignature)).IsTrue()
+			}
+		})
+
+		g.It("need a secret", func() {
+			vv := &MessageVerifier{}
+			_, err := vv.NewSignWriter(io.Discard)
+			g.Assert(err).Eql(ErrNoSecret)
+			_, err = vv.NewVerifyReader(strings.NewReader(""))
+			g.Assert(err).Eql(ErrNoSecret)
+		})
+
+		g.It("can be large", func() {
+			// many times the reader's buffer, BenchmarkStream streams 50 MB.
+			const size = 4 << 20
+			r, err := v.NewVerifyReader(streamMessage(v, size))
+			g.Assert(err).Eql(nil)
+			h := sha256.New()
+			n, err := io.CopyBuffer(h, r, make([]byte, 4096))
+			g.Assert(err).Eql(nil)
+			g.Assert(n).Eql(int64(size))
+
+			expected := sha256.New()
+			io.Copy(expected, &patternReader{n: size, chunk: 4096})
+			g.Assert(h.Sum(nil)).Eql(expected.Sum(nil))
+		})
+	})
+}
+
+// BenchmarkStream streams a 50 MB message through a sign writer and a verify
+// reader, the memory used doesn't depend on the size of the message.
+func BenchmarkStream(b *testing.B) {
+	v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!")}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r, _ := v.NewVerifyReader(streamMessage(v, 50<<20))
+		if _, err := io.CopyBuffer(io.Discard, r, make([]byte, 4096)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
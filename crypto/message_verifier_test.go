@@ -5,10 +5,16 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	. "github.com/franela/goblin"
+	"net/url"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type testStruct struct {
@@ -17,6 +23,17 @@ type testStruct struct {
 	Baz []string `json:",omitempty"`
 }
 
+// countingSerializer counts how many times it unserializes data.
+type countingSerializer struct {
+	MsgSerializer
+	calls int
+}
+
+func (s *countingSerializer) Unserialize(data string, v interface{}) error {
+	s.calls++
+	return s.MsgSerializer.Unserialize(data, v)
+}
+
 func reverse(s string) string {
 	runes := []rune(s)
 	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
@@ -146,6 +163,31 @@ func TestMessageVerifier(t *testing.T) {
 				err = v.Verify("gargabe data", &verified)
 				g.Assert(err.Error()).Eql("Invalid signature - bad data --")
 			})
+
+			g.It("rejects tampered digests the same way wherever they differ", func() {
+				msg, err := v.Generate(testStruct{Foo: "foo", Bar: 42})
+				g.Assert(err).Eql(nil)
+				dh := strings.Split(msg, "--")
+				d, h := dh[0], dh[1]
+				flip := func(c byte) string {
+					if c == '0' {
+						return "1"
+					}
+					return "0"
+				}
+				tampered := []string{
+					flip(h[0]) + h[1:],
+					h[:len(h)-1] + flip(h[len(h)-1]),
+					h[:len(h)-1],
+					h + "0",
+				}
+				for _, th := range tampered {
+					var verified testStruct
+					err = v.Verify(d+"--"+th, &verified)
+					g.Assert(err.Error()).Eql("Invalid signature - bad data (compare)")
+					g.Assert(verified).Eql(testStruct{})
+				}
+			})
 		})
 
 		g.Describe("and using SHA256", func() {
@@ -204,6 +246,808 @@ func TestMessageVerifier(t *testing.T) {
 
 	})
 
+	g.Describe("MessageVerifier with a purpose", func() {
+		v := MessageVerifier{
+			Secret:     []byte("Hey, I'm a secret!"),
+			Serializer: JsonMsgSerializer{},
+		}
+		// What ActiveSupport 5.2's MessageVerifier generates for "hello" with the
+		// same secret, the JSON serializer and `purpose: :login`.
+		railsMsg := "eyJfcmFpbHMiOnsibWVzc2FnZSI6IkltaGxiR3h2SWc9PSIsImV4cCI6bnVsbCwicHVyIjoibG9naW4ifX0=--2db19251ea3ffbd7534320eb13beee242b5ce6c7"
+
+		g.It("generates the same message as Rails", func() {
+			msg, err := v.GenerateWithOptions("hello", MessageOptions{Purpose: "login"})
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql(railsMsg)
+		})
+
+		g.It("verifies a message generated by Rails", func() {
+			var verified string
+			err := v.VerifyWithOptions(railsMsg, &verified, MessageOptions{Purpose: "login"})
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql("hello")
+		})
+
+		g.It("can do a round trip verification", func() {
+			data := testStruct{Foo: "foo", Bar: 42}
+			msg, err := v.GenerateWithOptions(data, MessageOptions{Purpose: "login"})
+			g.Assert(err).Eql(nil)
+			var verified testStruct
+			err = v.VerifyWithOptions(msg, &verified, MessageOptions{Purpose: "login"})
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("rejects a message generated for another purpose", func() {
+			var verified string
+			err := v.VerifyWithOptions(railsMsg, &verified, MessageOptions{Purpose: "shipping"})
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+			g.Assert(verified).Eql("")
+		})
+
+		g.It("rejects a message with a purpose when none is expected", func() {
+			var verified string
+			err := v.Verify(railsMsg, &verified)
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+		})
+
+		g.It("rejects a message without a purpose when one is expected", func() {
+			msg, err := v.Generate("hello")
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = v.VerifyWithOptions(msg, &verified, MessageOptions{Purpose: "login"})
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+		})
+
+		g.It("doesn't wrap messages generated without a purpose", func() {
+			msg, err := v.GenerateWithOptions("hello", MessageOptions{})
+			g.Assert(err).Eql(nil)
+			plain, _ := v.Generate("hello")
+			g.Assert(msg).Eql(plain)
+			var verified string
+			err = v.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql("hello")
+		})
+	})
+
+	g.Describe("MessageVerifier with an expiry", func() {
+		v := MessageVerifier{
+			Secret:     []byte("Hey, I'm a secret!"),
+			Serializer: JsonMsgSerializer{},
+		}
+
+		g.It("embeds the expiry like Rails does", func() {
+			exp := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+			msg, err := v.GenerateWithOptions("hello", MessageOptions{ExpiresAt: exp})
+			g.Assert(err).Eql(nil)
+			// What ActiveSupport 5.2's MessageVerifier generates for "hello" with
+			// `expires_at: Time.utc(2000)`.
+			g.Assert(msg).Eql("eyJfcmFpbHMiOnsibWVzc2FnZSI6IkltaGxiR3h2SWc9PSIsImV4cCI6IjIwMDAtMDEtMDFUMDA6MDA6MDAuMDAwWiIsInB1ciI6bnVsbH19--6759c53a248add12932b79d304ea0577015576c8")
+		})
+
+		g.It("rejects a message that expired", func() {
+			msg, err := v.GenerateWithOptions("hello", MessageOptions{ExpiresAt: time.Now().Add(-time.Minute)})
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = v.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+			g.Assert(verified).Eql("")
+
+			msg, err = v.GenerateWithOptions("hello", MessageOptions{ExpiresIn: -time.Minute})
+			g.Assert(err).Eql(nil)
+			err = v.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+		})
+
+		g.It("verifies a message that expires in the future", func() {
+			msg, err := v.GenerateWithOptions("hello", MessageOptions{ExpiresIn: time.Hour})
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = v.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql("hello")
+
+			msg, err = v.GenerateWithOptions("hello", MessageOptions{ExpiresAt: time.Now().Add(time.Hour), Purpose: "login"})
+			g.Assert(err).Eql(nil)
+			err = v.VerifyWithOptions(msg, &verified, MessageOptions{Purpose: "login"})
+			g.Assert(err).Eql(nil)
+		})
+
+		g.It("verifies a message without expiry", func() {
+			msg, err := v.GenerateWithOptions("hello", MessageOptions{})
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = v.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql("hello")
+		})
+
+		g.It("uses the verifier's clock", func() {
+			now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+			vv := MessageVerifier{
+				Secret:     []byte("Hey, I'm a secret!"),
+				Serializer: JsonMsgSerializer{},
+				Now:        func() time.Time { return now },
+			}
+			msg, err := vv.GenerateWithOptions("hello", MessageOptions{ExpiresIn: time.Second})
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = vv.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql("hello")
+
+			now = now.Add(999 * time.Millisecond)
+			g.Assert(vv.Verify(msg, &verified)).Eql(nil)
+			now = now.Add(time.Millisecond)
+			err = vv.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+		})
+
+		g.It("uses the verifier's clock for rotated messages", func() {
+			now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+			old := MessageVerifier{Secret: []byte("Hey, I'm an old secret!"), Serializer: JsonMsgSerializer{}}
+			msg, err := old.GenerateWithOptions("hello", MessageOptions{ExpiresAt: now.Add(time.Second)})
+			g.Assert(err).Eql(nil)
+			vv := &MessageVerifier{
+				Secret:     []byte("Hey, I'm a secret!"),
+				Serializer: JsonMsgSerializer{},
+				Now:        func() time.Time { return now },
+			}
+			vv.Rotate(old.Secret, nil, nil)
+			var verified string
+			g.Assert(vv.Verify(msg, &verified)).Eql(nil)
+			now = now.Add(time.Second)
+			err = vv.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+		})
+
+		g.It("checks the signature before the expiry", func() {
+			msg, err := v.GenerateWithOptions("hello", MessageOptions{ExpiresIn: -time.Minute})
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = v.Verify(msg+"0", &verified)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+		})
+	})
+
+	g.Describe("MessageVerifier with rotations", func() {
+		oldSecret := []byte("Hey, I'm an old secret!")
+		older := MessageVerifier{Secret: []byte("Hey, I'm an older secret!"), Hasher: sha256.New, Serializer: JsonMsgSerializer{}}
+		old := MessageVerifier{Secret: oldSecret, Serializer: JsonMsgSerializer{}}
+		newVerifier := func(rotated *[]int) *MessageVerifier {
+			v := &MessageVerifier{
+				Secret:     []byte("Hey, I'm a secret!"),
+				Serializer: JsonMsgSerializer{},
+				OnRotation: func(i int) { *rotated = append(*rotated, i) },
+			}
+			v.Rotate([]byte("Hey, I'm an older secret!"), sha256.New, nil)
+			v.Rotate(oldSecret, nil, nil)
+			return v
+		}
+
+		g.It("verifies messages signed with the current secret without rotating", func() {
+			var rotated []int
+			v := newVerifier(&rotated)
+			msg, err := v.Generate("hello")
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = v.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql("hello")
+			g.Assert(len(rotated)).Eql(0)
+		})
+
+		g.It("verifies messages signed with old secrets", func() {
+			var rotated []int
+			v := newVerifier(&rotated)
+			msg, err := old.Generate("hello")
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = v.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql("hello")
+
+			msg, err = older.Generate("hello again")
+			g.Assert(err).Eql(nil)
+			err = v.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql("hello again")
+			g.Assert(rotated).Eql([]int{1, 0})
+		})
+
+		g.It("never generates messages with old secrets", func() {
+			var rotated []int
+			v := newVerifier(&rotated)
+			msg, err := v.Generate("hello")
+			g.Assert(err).Eql(nil)
+			var verified string
+			g.Assert(errors.Is(old.Verify(msg, &verified), ErrInvalidSignature)).IsTrue()
+			g.Assert(errors.Is(older.Verify(msg, &verified), ErrInvalidSignature)).IsTrue()
+		})
+
+		g.It("rejects messages signed with an unknown secret", func() {
+			var rotated []int
+			v := newVerifier(&rotated)
+			other := MessageVerifier{Secret: []byte("Hey, I'm not a secret!"), Serializer: JsonMsgSerializer{}}
+			msg, err := other.Generate("hello")
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = v.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+			g.Assert(len(rotated)).Eql(0)
+		})
+
+		g.It("checks the purpose of rotated messages", func() {
+			var rotated []int
+			v := newVerifier(&rotated)
+			msg, err := old.GenerateWithOptions("hello", MessageOptions{Purpose: "login"})
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = v.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+			g.Assert(len(rotated)).Eql(0)
+		})
+	})
+
+	g.Describe("Checking if a message is valid", func() {
+		v := MessageVerifier{
+			Secret:     []byte("Hey, I'm a secret!"),
+			Serializer: JsonMsgSerializer{},
+		}
+		msg, _ := v.Generate(testStruct{Foo: "foo", Bar: 42})
+		dh := strings.Split(msg, "--")
+		d, h := dh[0], dh[1]
+
+		g.It("doesn't require a serializer", func() {
+			vv := MessageVerifier{Secret: []byte("Hey, I'm a secret!")}
+			g.Assert(vv.Valid(msg)).IsTrue()
+		})
+
+		g.It("accepts a properly signed message", func() {
+			g.Assert(v.Valid(msg)).IsTrue()
+		})
+
+		g.It("rejects a tampered digest", func() {
+			g.Assert(v.Valid(d + "--" + reverse(h))).IsFalse()
+			g.Assert(v.Valid(d + "--" + h[1:])).IsFalse()
+		})
+
+		g.It("rejects a tampered payload", func() {
+			g.Assert(v.Valid(reverse(d) + "--" + h)).IsFalse()
+			g.Assert(v.Valid("x" + d + "--" + h)).IsFalse()
+		})
+
+		g.It("rejects garbage", func() {
+			g.Assert(v.Valid("")).IsFalse()
+			g.Assert(v.Valid("garbage without separator")).IsFalse()
+			g.Assert(v.Valid("--")).IsFalse()
+		})
+
+		g.It("rejects messages when no secret is set", func() {
+			vv := MessageVerifier{Serializer: JsonMsgSerializer{}}
+			g.Assert(vv.Valid(msg)).IsFalse()
+		})
+	})
+
+	g.Describe("Signing raw payloads", func() {
+		v := MessageVerifier{Secret: []byte("Hey, I'm a secret!")}
+		payload := []byte{0x00, 0xff, 0xfe, 'a', 0x00, 0xc3, 0x28, 0x80}
+
+		g.It("can do a round trip without a serializer", func() {
+			msg, err := v.GenerateRaw(payload)
+			g.Assert(err).Eql(nil)
+			data, err := v.VerifyRaw(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(data).Eql(payload)
+		})
+
+		g.It("signs the payload like Generate signs serialized data", func() {
+			vv := MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+			msg, err := vv.Generate(testStruct{Foo: "foo", Bar: 42})
+			g.Assert(err).Eql(nil)
+			raw, err := v.GenerateRaw([]byte(`{"Foo":"foo","Bar":42}`))
+			g.Assert(err).Eql(nil)
+			g.Assert(raw).Eql(msg)
+			data, err := v.VerifyRaw(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(string(data)).Eql(`{"Foo":"foo","Bar":42}`)
+		})
+
+		g.It("rejects tampered messages", func() {
+			msg, err := v.GenerateRaw(payload)
+			g.Assert(err).Eql(nil)
+			data, err := v.VerifyRaw("A" + msg)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+			g.Assert(data == nil).IsTrue()
+		})
+
+		g.It("requires a secret", func() {
+			vv := MessageVerifier{}
+			_, err := vv.GenerateRaw(payload)
+			g.Assert(errors.Is(err, ErrNoSecret)).IsTrue()
+			_, err = vv.VerifyRaw("foo--bar")
+			g.Assert(errors.Is(err, ErrNoSecret)).IsTrue()
+		})
+	})
+
+	g.Describe("MessageVerifier generating URL safe messages", func() {
+		v := MessageVerifier{
+			Secret:     []byte("Hey, I'm a secret!"),
+			Serializer: NullMsgSerializer{},
+			URLSafe:    true,
+		}
+		// encodes to "-_--_-__" in the URL safe alphabet, "+/++/+//" otherwise.
+		data := string([]byte{0xfb, 0xff, 0xbe, 0xff, 0xef, 0xff})
+
+		g.It("uses the URL safe alphabet without padding", func() {
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(strings.HasPrefix(msg, "-_--_-__--")).IsTrue()
+			msg, err = v.Generate("a")
+			g.Assert(err).Eql(nil)
+			g.Assert(strings.HasPrefix(msg, "YQ--")).IsTrue()
+		})
+
+		g.It("survives being escaped in a query string", func() {
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(url.QueryEscape(msg)).Eql(msg)
+			unescaped, err := url.QueryUnescape(url.QueryEscape(msg))
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = v.Verify(unescaped, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("still verifies standard messages", func() {
+			std := MessageVerifier{Secret: v.Secret, Serializer: NullMsgSerializer{}}
+			msg, err := std.Generate(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(strings.HasPrefix(msg, "+/++/+//--")).IsTrue()
+			var verified string
+			err = v.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("can be verified by a verifier generating standard messages", func() {
+			std := MessageVerifier{Secret: v.Secret, Serializer: NullMsgSerializer{}}
+			msg, err := v.Generate("a")
+			g.Assert(err).Eql(nil)
+			var verified string
+			err = std.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql("a")
+		})
+	})
+
+	g.Describe("A message with newline wrapped base64", func() {
+		v := MessageVerifier{
+			Secret:     []byte("Hey, I'm a secret!"),
+			Serializer: JsonMsgSerializer{},
+		}
+		// Data encoded with Ruby's Base64.encode64 (as older Rails versions did),
+		// the digest is computed over the wrapped data.
+		legacyMsg := "eyJzZXNzaW9uX2lkIjoiYjJkNjNjMDdlYTdhOWQ1OGU0MTVlMzY3MmUzZjMx\n" +
+			"YTIiLCJ1c2VyX2lkIjo0MiwiZmxhc2giOiJXZWxjb21lIGJhY2shIn0=\n" +
+			"--c81263cc0d3a925180aa506e61920b93d69523d4"
+
+		g.It("can be verified", func() {
+			var session map[string]interface{}
+			err := v.Verify(legacyMsg, &session)
+			g.Assert(err).Eql(nil)
+			g.Assert(session["session_id"]).Eql("b2d63c07ea7a9d58e415e3672e3f31a2")
+			g.Assert(session["user_id"]).Eql(float64(42))
+			g.Assert(session["flash"]).Eql("Welcome back!")
+		})
+
+		g.It("can't be tampered with by rewrapping", func() {
+			var session map[string]interface{}
+			err := v.Verify(strings.Replace(legacyMsg, "\n", "", 1), &session)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+		})
+
+		g.It("isn't generated", func() {
+			msg, err := v.Generate(map[string]interface{}{"session_id": "b2d63c07ea7a9d58e415e3672e3f31a2", "user_id": 42, "flash": "Welcome back!"})
+			g.Assert(err).Eql(nil)
+			g.Assert(strings.Contains(msg, "\n")).IsFalse()
+		})
+	})
+
+	g.Describe("MessageVerifier with a custom separator", func() {
+		v := MessageVerifier{
+			Secret:     []byte("Hey, I'm a secret!"),
+			Serializer: JsonMsgSerializer{},
+			Separator:  "|",
+		}
+
+		g.It("joins the data and digest with the separator", func() {
+			msg, err := v.Generate(testStruct{Foo: "foo", Bar: 42})
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==|b1bdb9d2b372f19dcca800e5989ee7502f1b72a5")
+			var verified testStruct
+			err = v.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(testStruct{Foo: "foo", Bar: 42})
+		})
+
+		g.It("rejects messages using the default separator", func() {
+			var verified testStruct
+			err := v.Verify("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5", &verified)
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+		})
+	})
+
+	g.Describe("MessageVerifier with data containing the separator", func() {
+		v := MessageVerifier{
+			Secret:     []byte("Hey, I'm a secret!"),
+			Serializer: NullMsgSerializer{},
+			URLSafe:    true,
+		}
+		// encodes to "-_--_-__" in the URL safe alphabet.
+		data := string([]byte{0xfb, 0xff, 0xbe, 0xff, 0xef, 0xff})
+
+		g.It("can do a round trip verification", func() {
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(strings.Count(msg, "--")).Eql(2)
+			var verified string
+			err = v.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("splits the digest from the last separator", func() {
+			for _, payload := range [][]byte{
+				{0xfb, 0xef, 0xbe},                   // "----"
+				{0xff, 0xff, 0xfe},                   // "___-"
+				{0xf8},                               // "-A"
+				{0xfb, 0xef, 0xbf, 0xfb, 0xef, 0xbe}, // "---_----"
+			} {
+				msg, err := v.GenerateRaw(payload)
+				g.Assert(err).Eql(nil)
+				data, err := v.VerifyRaw(msg)
+				g.Assert(err).Eql(nil)
+				g.Assert(data).Eql(payload)
+
+				i := strings.LastIndex(msg, "--")
+				g.Assert(msg[i+2:]).Eql(v.DigestFor(msg[:i]))
+				g.Assert(msg[:i]).Eql(base64.RawURLEncoding.EncodeToString(payload))
+			}
+		})
+	})
+
+	g.Describe("Parsing a signed message", func() {
+		msg := "eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5"
+
+		g.It("returns the payload and digest", func() {
+			payload, digest, err := ParseSignedMessage(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(string(payload)).Eql(`{"Foo":"foo","Bar":42}`)
+			g.Assert(digest).Eql("b1bdb9d2b372f19dcca800e5989ee7502f1b72a5")
+		})
+
+		g.It("doesn't check the signature", func() {
+			payload, digest, err := ParseSignedMessage("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--bad")
+			g.Assert(err).Eql(nil)
+			g.Assert(string(payload)).Eql(`{"Foo":"foo","Bar":42}`)
+			g.Assert(digest).Eql("bad")
+		})
+
+		g.It("reports each framing problem with its own error", func() {
+			cases := []struct {
+				msg string
+				err error
+			}{
+				{"eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==", ErrMissingSeparator},
+				{"--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5", ErrEmptyPayload},
+				{"eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--", ErrEmptyDigest},
+			}
+			for _, c := range cases {
+				_, _, err := ParseSignedMessage(c.msg)
+				g.Assert(errors.Is(err, c.err)).IsTrue()
+				g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+				for _, other := range cases {
+					if other.err != c.err {
+						g.Assert(errors.Is(err, other.err)).IsFalse()
+					}
+				}
+			}
+		})
+
+		g.It("rejects bad base64", func() {
+			_, _, err := ParseSignedMessage("not base64!--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5")
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+		})
+
+		g.It("is used by Verify", func() {
+			v := MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+			var verified testStruct
+			g.Assert(errors.Is(v.Verify("--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5", &verified), ErrEmptyPayload)).IsTrue()
+			g.Assert(errors.Is(v.Verify("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--", &verified), ErrEmptyDigest)).IsTrue()
+			g.Assert(errors.Is(v.Verify("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==", &verified), ErrMissingSeparator)).IsTrue()
+		})
+	})
+
+	g.Describe("A MessageVerifier shared between goroutines", func() {
+		v := MessageVerifier{
+			Secret:     []byte("Hey, I'm a secret!"),
+			Serializer: JsonMsgSerializer{},
+		}
+
+		g.It("can generate and verify messages concurrently", func() {
+			var wg sync.WaitGroup
+			errs := make(chan error, 50)
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					data := testStruct{Foo: "foo", Bar: i}
+					msg, err := v.Generate(data)
+					if err != nil {
+						errs <- err
+						return
+					}
+					var verified testStruct
+					if err := v.Verify(msg, &verified); err != nil {
+						errs <- err
+						return
+					}
+					if verified.Foo != data.Foo || verified.Bar != data.Bar {
+						errs <- fmt.Errorf("got %#v, want %#v", verified, data)
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				g.Fail(err)
+			}
+			g.Assert(v.Hasher == nil).IsTrue()
+		})
+	})
+
+	g.Describe("Resigning a message", func() {
+		old := &MessageVerifier{Secret: []byte("Hey, I'm an old secret!"), Serializer: JsonMsgSerializer{}}
+		v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Hasher: sha256.New, Serializer: JsonMsgSerializer{}}
+		data := testStruct{Foo: "foo", Bar: 42}
+
+		g.It("signs the message with the new secret", func() {
+			oldMsg, err := old.Generate(data)
+			g.Assert(err).Eql(nil)
+			msg, err := v.Resign(oldMsg, old)
+			g.Assert(err).Eql(nil)
+
+			var verified testStruct
+			err = v.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(data)
+			err = old.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+
+			expected, _ := v.Generate(data)
+			g.Assert(msg).Eql(expected)
+		})
+
+		g.It("keeps the purpose and expiry", func() {
+			exp := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+			opts := MessageOptions{Purpose: "login", ExpiresAt: exp}
+			oldMsg, err := old.GenerateWithOptions(data, opts)
+			g.Assert(err).Eql(nil)
+			msg, err := v.Resign(oldMsg, old)
+			g.Assert(err).Eql(nil)
+
+			expected, _ := v.GenerateWithOptions(data, opts)
+			g.Assert(msg).Eql(expected)
+			var verified testStruct
+			err = v.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+			err = v.VerifyWithOptions(msg, &verified, MessageOptions{Purpose: "login"})
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("doesn't need a serializer", func() {
+			oldMsg, err := old.Generate(data)
+			g.Assert(err).Eql(nil)
+			vv := &MessageVerifier{Secret: v.Secret, Hasher: sha256.New}
+			msg, err := vv.Resign(oldMsg, old)
+			g.Assert(err).Eql(nil)
+			var verified testStruct
+			g.Assert(v.Verify(msg, &verified)).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("rejects invalid messages", func() {
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			_, err = v.Resign(msg, old)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+		})
+
+		g.It("rejects expired messages", func() {
+			oldMsg, err := old.GenerateWithOptions(data, MessageOptions{ExpiresIn: -time.Second})
+			g.Assert(err).Eql(nil)
+			_, err = v.Resign(oldMsg, old)
+			g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+		})
+	})
+
+	g.Describe("MessageVerifier errors", func() {
+		v := MessageVerifier{
+			Secret:     []byte("Hey, I'm a secret!"),
+			Serializer: JsonMsgSerializer{},
+		}
+
+		g.It("reports a missing secret with ErrNoSecret", func() {
+			vv := MessageVerifier{Serializer: JsonMsgSerializer{}}
+			_, err := vv.Generate("foo")
+			g.Assert(errors.Is(err, ErrNoSecret)).IsTrue()
+			var foo string
+			err = vv.Verify("foo--bar", &foo)
+			g.Assert(errors.Is(err, ErrNoSecret)).IsTrue()
+		})
+
+		g.It("reports a missing serializer with ErrNoSerializer", func() {
+			vv := MessageVerifier{Secret: []byte("Hey, I'm a secret!")}
+			_, err := vv.Generate("foo")
+			g.Assert(errors.Is(err, ErrNoSerializer)).IsTrue()
+			var foo string
+			err = vv.Verify("foo--bar", &foo)
+			g.Assert(errors.Is(err, ErrNoSerializer)).IsTrue()
+		})
+
+		g.It("reports malformed messages with ErrMalformedMessage", func() {
+			var foo string
+			for _, msg := range []string{"", "garbage"} {
+				err := v.Verify(msg, &foo)
+				g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+				g.Assert(errors.Is(err, ErrInvalidSignature)).IsFalse()
+			}
+			g.Assert(v.Verify("", &foo).Error()).Eql("Invalid signature - empty message")
+		})
+
+		g.It("reports empty segments with ErrMalformedMessage without unserializing", func() {
+			serializer := &countingSerializer{MsgSerializer: JsonMsgSerializer{}}
+			vv := MessageVerifier{Secret: v.Secret, Serializer: serializer}
+			cases := []struct {
+				msg string
+				err error
+			}{
+				{"--", ErrEmptyPayload},
+				{"----", ErrEmptyDigest},
+				{"abc--", ErrEmptyDigest},
+				{"abc----", ErrEmptyDigest},
+				{"--deadbeef", ErrEmptyPayload},
+				{"--" + v.DigestFor(""), ErrEmptyPayload},
+				{"-", ErrMissingSeparator},
+			}
+			for _, c := range cases {
+				var foo string
+				err := vv.Verify(c.msg, &foo)
+				g.Assert(err).Eql(c.err)
+				g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+				_, err = vv.VerifyRaw(c.msg)
+				g.Assert(err).Eql(c.err)
+				g.Assert(vv.Valid(c.msg)).IsFalse()
+			}
+			g.Assert(serializer.calls).Eql(0)
+		})
+
+		g.It("reports bad digests with ErrInvalidSignature", func() {
+			msg, _ := v.Generate("foo")
+			var foo string
+			err := v.Verify(msg+"0", &foo)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsFalse()
+		})
+
+		g.It("wraps base64 errors in ErrMalformedMessage", func() {
+			data := "not base64!"
+			var foo string
+			err := v.Verify(data+"--"+v.DigestFor(data), &foo)
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+			var b64Err base64.CorruptInputError
+			g.Assert(errors.As(err, &b64Err)).IsTrue()
+		})
+
+		g.It("passes serializer errors through", func() {
+			data := base64.StdEncoding.EncodeToString([]byte("{not json"))
+			var foo string
+			err := v.Verify(data+"--"+v.DigestFor(data), &foo)
+			var syntaxErr *json.SyntaxError
+			g.Assert(errors.As(err, &syntaxErr)).IsTrue()
+		})
+	})
+
+	g.Describe("InsecureDecode", func() {
+		data := testStruct{Foo: "foo", Bar: 42}
+
+		g.It("decodes messages without a secret", func() {
+			var decoded testStruct
+			err := InsecureDecode("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5", &decoded)
+			g.Assert(err).Eql(nil)
+			g.Assert(decoded).Eql(data)
+		})
+
+		g.It("ignores the digest", func() {
+			var decoded testStruct
+			err := InsecureDecode("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--deadbeef", &decoded)
+			g.Assert(err).Eql(nil)
+			g.Assert(decoded).Eql(data)
+		})
+
+		g.It("unwraps the metadata without checking it", func() {
+			v := MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+			msg, err := v.GenerateWithOptions(data, MessageOptions{Purpose: "login", ExpiresIn: -time.Hour})
+			g.Assert(err).Eql(nil)
+			var decoded testStruct
+			err = InsecureDecode(msg, &decoded)
+			g.Assert(err).Eql(nil)
+			g.Assert(decoded).Eql(data)
+		})
+
+		g.It("decodes URL safe messages", func() {
+			v := MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}, URLSafe: true}
+			msg, _ := v.Generate(data)
+			var decoded testStruct
+			g.Assert(InsecureDecode(msg, &decoded)).Eql(nil)
+			g.Assert(decoded).Eql(data)
+		})
+
+		g.It("reports malformed messages", func() {
+			var decoded testStruct
+			err := InsecureDecode("garbage", &decoded)
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+		})
+	})
+
+	g.Describe("Oversized messages", func() {
+		v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+		garbage := strings.Repeat("A", 10<<20) + "--deadbeef"
+
+		g.It("are rejected before being decoded", func() {
+			var target interface{}
+			err := v.Verify(garbage, &target)
+			g.Assert(err).Eql(ErrMessageTooLarge)
+			_, err = v.VerifyRaw(garbage)
+			g.Assert(err).Eql(ErrMessageTooLarge)
+			g.Assert(v.Valid(garbage)).IsFalse()
+		})
+
+		g.It("are rejected without allocating", func() {
+			var target interface{}
+			allocs := testing.AllocsPerRun(10, func() {
+				v.Verify(garbage, &target)
+			})
+			g.Assert(allocs).Eql(0.0)
+		})
+
+		g.It("use the set limit", func() {
+			small := &MessageVerifier{Secret: v.Secret, Serializer: v.Serializer, MaxMessageLen: 64}
+			msg, err := small.Generate("short")
+			g.Assert(err).Eql(nil)
+			var str string
+			g.Assert(small.Verify(msg, &str)).Eql(nil)
+			msg, err = small.Generate(strings.Repeat("long", 20))
+			g.Assert(err).Eql(nil)
+			g.Assert(small.Verify(msg, &str)).Eql(ErrMessageTooLarge)
+		})
+
+		g.It("can be accepted by disabling the limit", func() {
+			unlimited := &MessageVerifier{Secret: v.Secret, Serializer: v.Serializer, MaxMessageLen: -1}
+			big := strings.Repeat("a", DefaultMaxMessageLen)
+			msg, err := unlimited.Generate(big)
+			g.Assert(err).Eql(nil)
+			var str string
+			g.Assert(unlimited.Verify(msg, &str)).Eql(nil)
+			g.Assert(str).Eql(big)
+			g.Assert(v.Verify(msg, &str)).Eql(ErrMessageTooLarge)
+		})
+	})
+
 	g.Describe("A MessageVerifier with a secret and a XML serializer", func() {
 
 		v := MessageVerifier{
@@ -252,3 +1096,46 @@ func ExampleMessageVerifier_Verify() {
 	// eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5
 	// crypto.testStruct{Foo:"foo", Bar:42, Baz:[]string(nil)}
 }
+
+func BenchmarkVerifyOversizedMessage(b *testing.B) {
+	v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+	garbage := strings.Repeat("A", 10<<20) + "--deadbeef"
+	var target interface{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.Verify(garbage, &target)
+	}
+}
+
+func FuzzVerify(f *testing.F) {
+	v := &MessageVerifier{Secret: []byte("Hey, I'm a secret!"), Serializer: JsonMsgSerializer{}}
+	valid := []interface{}{"foo", 42, map[string]string{"foo": "bar"}, testStruct{Foo: "foo", Bar: 42}}
+	for _, value := range valid {
+		msg, err := v.Generate(value)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(msg)
+	}
+	msg, _ := v.GenerateWithOptions("foo", MessageOptions{Purpose: "login", ExpiresIn: time.Hour})
+	f.Add(msg)
+	for _, msg := range []string{"", "--", "abc--", "--deadbeef", "eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5"} {
+		f.Add(msg)
+	}
+
+	f.Fuzz(func(t *testing.T, msg string) {
+		serializer := &countingSerializer{MsgSerializer: JsonMsgSerializer{}}
+		vv := &MessageVerifier{Secret: v.Secret, Serializer: serializer}
+		var target interface{}
+		err := vv.Verify(msg, &target)
+		if errors.Is(err, ErrMalformedMessage) || errors.Is(err, ErrInvalidSignature) {
+			if serializer.calls != 0 {
+				t.Fatalf("unserialized a rejected message %q", msg)
+			}
+		}
+		if err == nil && !vv.Valid(msg) {
+			t.Fatalf("verified message %q isn't valid", msg)
+		}
+	})
+}
@@ -0,0 +1,411 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInvalidPurpose is returned when a message was generated for another
+	// purpose than the one it is being verified for.
+	ErrInvalidPurpose = errors.New("Invalid purpose")
+	// ErrMessageExpired is returned when a message is authentic but its
+	// expiry time has passed.
+	ErrMessageExpired = errors.New("Message expired")
+)
+
+// railsTimeFormat is how Rails serializes expiry times: ISO8601 in UTC with
+// millisecond precision.
+const railsTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// MessageOptions holds the metadata that can be embedded in a message,
+// mirroring the options Rails 5.2+ accepts when generating messages
+// (ActiveSupport::Messages::Metadata).
+// Only the purpose is used when verifying, the expiry is read from the
+// message itself.
+type MessageOptions struct {
+	// Purpose scopes a message to a given use (ie: "login"). A message
+	// generated with a purpose only verifies when the same purpose is
+	// expected, and the other way around.
+	Purpose string
+	// ExpiresAt sets the time after which the message won't verify anymore.
+	ExpiresAt time.Time
+	// ExpiresIn sets the time after which the message won't verify anymore
+	// relatively to its generation. ExpiresAt wins when both are set.
+	ExpiresIn time.Duration
+	// IssuedAt, if set, is embedded in the message as the time it was
+	// issued at, see MessageVerifier.InvalidateBefore. It isn't a Rails
+	// option but Rails ignores it.
+	IssuedAt time.Time
+
+	// anyPurpose and invalidateBefore are set by the verifier when
+	// verifying, see VerifyWithMetadata and InvalidateBefore.
+	anyPurpose       bool
+	invalidateBefore time.Time
+}
+
+// Metadata is the metadata of a verified message, see VerifyWithMetadata.
+type Metadata struct {
+	// Purpose is the purpose of the message, empty if it has none.
+	Purpose string
+	// ExpiresAt is when the message expires, nil if it never does.
+	ExpiresAt *time.Time
+	// IssuedAt is when the message was issued, nil if it isn't embedded,
+	// which is always the case for messages generated by Rails.
+	IssuedAt *time.Time
+	// Envelope is the serialized envelope of the metadata and the data as
+	// it was signed, nil for messages without metadata.
+	Envelope []byte
+}
+
+// newMetadata returns the Metadata of opts, as extracted from a message.
+func newMetadata(opts MessageOptions, envelope []byte) Metadata {
+	meta := Metadata{Purpose: opts.Purpose, Envelope: envelope}
+	if !opts.ExpiresAt.IsZero() {
+		exp := opts.ExpiresAt
+		meta.ExpiresAt = &exp
+	}
+	if !opts.IssuedAt.IsZero() {
+		iat := opts.IssuedAt
+		meta.IssuedAt = &iat
+	}
+	return meta
+}
+
+// errIssuedBeforeInvalidation is returned for the messages issued before the
+// verifier's InvalidateBefore.
+var errIssuedBeforeInvalidation = &messageError{msg: "Message expired - issued before the verifier's InvalidateBefore", kind: ErrMessageExpired}
+
+// check checks meta, the metadata of a message, against the expected
+// options and its expiry against now.
+func (expected MessageOptions) check(meta MessageOptions, now time.Time) error {
+	if !expected.anyPurpose && meta.Purpose != expected.Purpose {
+		return ErrInvalidPurpose
+	}
+	if meta.expired(now) {
+		return ErrMessageExpired
+	}
+	if !expected.invalidateBefore.IsZero() && (meta.IssuedAt.IsZero() || meta.IssuedAt.Before(expected.invalidateBefore)) {
+		return errIssuedBeforeInvalidation
+	}
+	return nil
+}
+
+// expired reports whether ExpiresAt is set and passed.
+func (opts MessageOptions) expired(now time.Time) bool {
+	return !opts.ExpiresAt.IsZero() && !now.Before(opts.ExpiresAt)
+}
+
+// expiry returns the expiry time set by the options, if any.
+func (opts MessageOptions) expiry(now time.Time) *time.Time {
+	var exp time.Time
+	switch {
+	case !opts.ExpiresAt.IsZero():
+		exp = opts.ExpiresAt
+	case opts.ExpiresIn != 0:
+		exp = now.Add(opts.ExpiresIn)
+	default:
+		return nil
+	}
+	return &exp
+}
+
+// railsMetadata is the content of the "_rails" key Rails wraps messages
+// carrying metadata in.
+type railsMetadata struct {
+	Message string  `json:"message"`
+	Exp     *string `json:"exp"`
+	Pur     *string `json:"pur"`
+	Iat     *string `json:"iat,omitempty"`
+}
+
+type railsEnvelope struct {
+	Rails *railsMetadata `json:"_rails"`
+}
+
+// wrapMetadata embeds the serialized data in a Rails metadata envelope:
+//
+//	{"_rails":{"message":"<base64 data>","exp":null,"pur":"login"}}
+//
+// The data is returned untouched when there is no metadata to add, which is
+// also what Rails does.
+func wrapMetadata(data []byte, opts MessageOptions, now time.Time) ([]byte, error) {
+	exp := opts.expiry(now)
+	if opts.Purpose == "" && exp == nil && opts.IssuedAt.IsZero() {
+		return data, nil
+	}
+	meta := &railsMetadata{Message: base64.StdEncoding.EncodeToString(data)}
+	if exp != nil {
+		s := exp.UTC().Format(railsTimeFormat)
+		meta.Exp = &s
+	}
+	if opts.Purpose != "" {
+		meta.Pur = &opts.Purpose
+	}
+	if !opts.IssuedAt.IsZero() {
+		s := opts.IssuedAt.UTC().Format(railsTimeFormat)
+		meta.Iat = &s
+	}
+	return json.Marshal(railsEnvelope{Rails: meta})
+}
+
+// unwrapMetadata extracts the serialized data out of a Rails metadata
+// envelope and checks it against the passed options and its expiry against
+// now. Data that isn't wrapped is returned as is as long as no purpose is
+// expected.
+// This must only be called on authenticated data.
+func unwrapMetadata(data []byte, opts MessageOptions, now time.Time) ([]byte, error) {
+	msg, meta, err := extractMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.check(meta, now); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// extractMetadata splits data into the serialized data it wraps and its
+// metadata, with the expiry set as ExpiresAt. Data that isn't a Rails
+// envelope is returned as is with empty metadata.
+func extractMetadata(data []byte) ([]byte, MessageOptions, error) {
+	var opts MessageOptions
+	meta := parseMetadata(data)
+	if meta == nil {
+		return data, opts, nil
+	}
+
+	if meta.Pur != nil {
+		opts.Purpose = *meta.Pur
+	}
+	if meta.Exp != nil {
+		exp, err := time.Parse(time.RFC3339Nano, *meta.Exp)
+		if err != nil {
+			return nil, opts, &messageError{msg: "Invalid signature - bad expiry: " + err.Error(), kind: ErrMalformedMessage, err: err}
+		}
+		opts.ExpiresAt = exp
+	}
+	if meta.Iat != nil {
+		iat, err := time.Parse(time.RFC3339Nano, *meta.Iat)
+		if err != nil {
+			return nil, opts, &messageError{msg: "Invalid signature - bad issue time: " + err.Error(), kind: ErrMalformedMessage, err: err}
+		}
+		opts.IssuedAt = iat
+	}
+
+	msg, err := base64.StdEncoding.DecodeString(meta.Message)
+	if err != nil {
+		return nil, opts, &messageError{msg: "Invalid signature - bad base64: " + err.Error(), kind: ErrMalformedMessage, err: err}
+	}
+	return msg, opts, nil
+}
+
+// parseMetadata returns the metadata of a wrapped message, or nil if the
+// data isn't a Rails envelope.
+func parseMetadata(data []byte) *railsMetadata {
+	// Only JSON objects can be envelopes, skip decoding anything else.
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return nil
+	}
+	var env railsEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil
+	}
+	// the Rails 7.1 envelopes have a data key instead of the message.
+	if env.Rails == nil || env.Rails.Message == "" {
+		return nil
+	}
+	return env.Rails
+}
+
+// serializeWithMetadata serializes value with s and embeds the metadata of
+// opts in it. With inline set the value and its metadata are serialized
+// together, like Rails 7.1 does when use_message_serializer_for_metadata is
+// enabled:
+//
+//	{"_rails":{"data":{"user_id":42},"exp":"2100-01-01T00:00:00.000Z","pur":"login"}}
+//
+// otherwise the serialized value is wrapped in the legacy envelope, see
+// wrapMetadata. Either way the value is serialized alone when there is no
+// metadata to add.
+func serializeWithMetadata(s MsgSerializer, value interface{}, opts MessageOptions, now time.Time, inline bool) ([]byte, error) {
+	exp := opts.expiry(now)
+	if !inline || (opts.Purpose == "" && exp == nil && opts.IssuedAt.IsZero()) {
+		data, err := serializeWith(s, value)
+		if err != nil {
+			return nil, err
+		}
+		return wrapMetadata(data, opts, now)
+	}
+	// the keys are in the order Rails sets them, the serializers sorting
+	// map keys.
+	meta := map[string]interface{}{"data": value}
+	if exp != nil {
+		meta["exp"] = exp.UTC().Format(railsTimeFormat)
+	}
+	if opts.Purpose != "" {
+		meta["pur"] = opts.Purpose
+	}
+	if !opts.IssuedAt.IsZero() {
+		meta["iat"] = opts.IssuedAt.UTC().Format(railsTimeFormat)
+	}
+	return serializeWith(s, map[string]interface{}{"_rails": meta})
+}
+
+// unserializeWithMetadata checks the metadata of authenticated data against
+// opts and now, and unserializes its value with s into target. The data can
+// be wrapped in the legacy envelope, in the Rails 7.1 one (see
+// serializeWithMetadata) or not at all, in which case no purpose must be
+// expected. The metadata is returned as soon as it's extracted, its
+// Envelope being data itself.
+func unserializeWithMetadata(s MsgSerializer, data []byte, target interface{}, opts MessageOptions, now time.Time, previewLen int) (Metadata, error) {
+	var value []byte
+	var meta MessageOptions
+	var wrapped bool
+	var err error
+	if bytes.Contains(data, []byte("_rails")) {
+		value, meta, wrapped, err = extractInlineMetadata(s, data, previewLen)
+		if err != nil {
+			return Metadata{}, err
+		}
+	}
+	if !wrapped {
+		wrapped = parseMetadata(data) != nil
+		if value, meta, err = extractMetadata(data); err != nil {
+			return Metadata{}, err
+		}
+	}
+	var envelope []byte
+	if wrapped {
+		envelope = data
+		defer wipe(value)
+	}
+	metadata := newMetadata(meta, envelope)
+	if err := opts.check(meta, now); err != nil {
+		return metadata, err
+	}
+	return metadata, unserializeWith(s, value, target, previewLen)
+}
+
+// railsInlineMetadata is the content of the "_rails" key of the Rails 7.1
+// envelope when the serializer is JSON.
+type railsInlineMetadata struct {
+	Data json.RawMessage `json:"data"`
+	Exp  *string         `json:"exp"`
+	Pur  *string         `json:"pur"`
+	Iat  *string         `json:"iat"`
+}
+
+// extractInlineMetadata splits data serialized in the Rails 7.1 envelope
+// into its serialized value and its metadata, ok being false if data isn't
+// such an envelope. JSON envelopes are split as is, the others are
+// unserialized and their value serialized again.
+func extractInlineMetadata(s MsgSerializer, data []byte, previewLen int) (value []byte, opts MessageOptions, ok bool, err error) {
+	var exp, pur, iat interface{}
+	var env struct {
+		Rails *railsInlineMetadata `json:"_rails"`
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) && json.Unmarshal(data, &env) == nil {
+		if env.Rails == nil || env.Rails.Data == nil {
+			return nil, opts, false, nil
+		}
+		value = env.Rails.Data
+		if env.Rails.Exp != nil {
+			exp = *env.Rails.Exp
+		}
+		if env.Rails.Pur != nil {
+			pur = *env.Rails.Pur
+		}
+		if env.Rails.Iat != nil {
+			iat = *env.Rails.Iat
+		}
+	} else {
+		var decoded interface{}
+		if unserializeWith(s, data, &decoded, previewLen) != nil {
+			return nil, opts, false, nil
+		}
+		meta, found := inlineEnvelope(decoded)
+		if !found {
+			return nil, opts, false, nil
+		}
+		if value, err = serializeWith(s, meta["data"]); err != nil {
+			return nil, opts, false, err
+		}
+		exp, pur, iat = meta["exp"], meta["pur"], meta["iat"]
+	}
+
+	if pur != nil {
+		str, isString := pur.(string)
+		if !isString {
+			return nil, opts, false, &messageError{msg: "Invalid message - bad purpose", kind: ErrMalformedMessage}
+		}
+		opts.Purpose = str
+	}
+	if opts.ExpiresAt, err = inlineMetadataTime(exp, "expiry"); err != nil {
+		return nil, opts, false, err
+	}
+	if opts.IssuedAt, err = inlineMetadataTime(iat, "issue time"); err != nil {
+		return nil, opts, false, err
+	}
+	return value, opts, true, nil
+}
+
+// inlineMetadataTime parses the time of the Rails 7.1 envelope named name,
+// which is the zero time when v is nil.
+func inlineMetadataTime(v interface{}, name string) (time.Time, error) {
+	if v == nil {
+		return time.Time{}, nil
+	}
+	str, isString := v.(string)
+	if !isString {
+		return time.Time{}, &messageError{msg: "Invalid message - bad " + name, kind: ErrMalformedMessage}
+	}
+	at, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return time.Time{}, &messageError{msg: "Invalid message - bad " + name + ": " + err.Error(), kind: ErrMalformedMessage, err: err}
+	}
+	return at, nil
+}
+
+// inlineEnvelope returns the content of the "_rails" key of an unserialized
+// Rails 7.1 envelope: a hash with this key only, holding a hash with a data
+// key.
+func inlineEnvelope(decoded interface{}) (map[string]interface{}, bool) {
+	rails, ok := stringKeyed(decoded)
+	if !ok || len(rails) != 1 {
+		return nil, false
+	}
+	meta, ok := stringKeyed(rails["_rails"])
+	if !ok {
+		return nil, false
+	}
+	if _, ok := meta["data"]; !ok {
+		return nil, false
+	}
+	return meta, true
+}
+
+// stringKeyed returns the hashes the serializers unserialize into
+// interface{} as a map[string]interface{}.
+func stringKeyed(v interface{}) (map[string]interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v, true
+	case HashWithIndifferentAccess:
+		return v, true
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			s, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			m[s] = e
+		}
+		return m, true
+	}
+	return nil, false
+}
@@ -0,0 +1,432 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestRailsMessageMetadata(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Messages with metadata generated by Rails 6.1", func() {
+		// The messages below are what
+		//   ActiveSupport::MessageVerifier.new(Rails.application.key_generator.generate_key("remember_me"), serializer: JSON)
+		// generates for the Rails secret, ie: keys derived with 1000 iterations of PBKDF2/SHA1.
+		railsSecret := "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"
+		kg := KeyGenerator{Secret: railsSecret}
+		v := MessageVerifier{
+			Secret:     kg.CacheGenerate([]byte("remember_me"), 64),
+			Serializer: JsonMsgSerializer{},
+		}
+		// verifier.generate({user_id: 42}, purpose: :remember_me, expires_at: Time.utc(2100))
+		valid := "eyJfcmFpbHMiOnsibWVzc2FnZSI6ImV5SjFjMlZ5WDJsa0lqbzBNbjA9IiwiZXhwIjoiMjEwMC0wMS0wMVQwMDowMDowMC4wMDBaIiwicHVyIjoicmVtZW1iZXJfbWUifX0=--2f52414e9c0705a5df7129eea9d029273246538f"
+		// verifier.generate({user_id: 42}, purpose: :remember_me, expires_at: Time.utc(2020))
+		expired := "eyJfcmFpbHMiOnsibWVzc2FnZSI6ImV5SjFjMlZ5WDJsa0lqbzBNbjA9IiwiZXhwIjoiMjAyMC0wMS0wMVQwMDowMDowMC4wMDBaIiwicHVyIjoicmVtZW1iZXJfbWUifX0=--63b8024ec8ec9f6f1b1a63fd48d3ab1ed2dc15fd"
+
+		type rememberMe struct {
+			UserID int `json:"user_id"`
+		}
+
+		g.It("unwraps the message before deserializing it", func() {
+			var verified rememberMe
+			err := v.VerifyWithOptions(valid, &verified, MessageOptions{Purpose: "remember_me"})
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(rememberMe{UserID: 42})
+		})
+
+		g.It("enforces the purpose", func() {
+			var verified rememberMe
+			err := v.VerifyWithOptions(valid, &verified, MessageOptions{Purpose: "login"})
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+			err = v.Verify(valid, &verified)
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+			g.Assert(verified).Eql(rememberMe{})
+		})
+
+		g.It("enforces the expiry", func() {
+			var verified rememberMe
+			err := v.VerifyWithOptions(expired, &verified, MessageOptions{Purpose: "remember_me"})
+			g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+			g.Assert(verified).Eql(rememberMe{})
+		})
+
+		g.It("doesn't check the metadata of tampered messages", func() {
+			var verified rememberMe
+			err := v.VerifyWithOptions(expired[:len(expired)-1]+"0", &verified, MessageOptions{Purpose: "remember_me"})
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+		})
+	})
+
+	g.Describe("Messages with metadata and a non JSON serializer", func() {
+		// Rails always encodes the metadata envelope in JSON, the message it
+		// wraps is serialized with the verifier's serializer.
+		kg := KeyGenerator{Secret: "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"}
+		v := MessageVerifier{
+			Secret:     kg.Generate([]byte("remember_me"), 64),
+			Serializer: XMLMsgSerializer{},
+		}
+		msg := "eyJfcmFpbHMiOnsibWVzc2FnZSI6IlBIUmxjM1JUZEhKMVkzUStQRVp2Yno1bWIyODhMMFp2Yno0OFFtRnlQalF5UEM5Q1lYSStQQzkwWlhOMFUzUnlkV04wUGc9PSIsImV4cCI6bnVsbCwicHVyIjoieG1sIn19--b32ef7f6ae831e38923b14ace228d7941dcf24b4"
+
+		g.It("can be verified", func() {
+			var verified testStruct
+			err := v.VerifyWithOptions(msg, &verified, MessageOptions{Purpose: "xml"})
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(testStruct{Foo: "foo", Bar: 42})
+		})
+
+		g.It("can be generated", func() {
+			generated, err := v.GenerateWithOptions(testStruct{Foo: "foo", Bar: 42}, MessageOptions{Purpose: "xml"})
+			g.Assert(err).Eql(nil)
+			g.Assert(generated).Eql(msg)
+		})
+	})
+
+	g.Describe("Encrypted messages with metadata", func() {
+		railsSecret := "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"
+		kg := KeyGenerator{Secret: railsSecret}
+		// A Rails 5.2 aes-256-cbc encrypted cookie set with
+		//   cookies.encrypted[:user_id] = { value: 42, expires: Time.utc(2030, 6, 1, 12) }
+		// computed following Rails' algorithm (openssl aes-256-cbc and
+		// HMAC-SHA1 with the keys derived from the secret).
+		cookie := "NzZEOGtPVCtnVHJxYVB0NFdRblJ2UWJNRUEvY2VhUHZHMlpodlZvK3NLR01GTG01dk9JeW5FYlNweGJPMXQ4em5tZElmUCtla1ZVZU5NSzFLSE0vK3Uxc21lZFFucDlWZGVtYVorNjhhUWRrS2xnYkpuejNFRkhSMDhHTDlqMFotLWpCODZXMzJlRHlGR1dIcWJ6ZThCSXc9PQ==--d437618bc72f4d125d785e8cde4da12647ef3fe9"
+		expiry := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+		clock := expiry.Add(-time.Hour)
+		e := &MessageEncryptor{
+			Key:     kg.Generate([]byte("encrypted cookie"), 32),
+			SignKey: kg.Generate([]byte("signed encrypted cookie"), 64),
+			Now:     func() time.Time { return clock },
+		}
+
+		g.It("decrypts Rails cookies with an expiry", func() {
+			var userID int
+			err := e.DecryptAndVerifyWithOptions(cookie, &userID, MessageOptions{Purpose: "cookie.user_id"})
+			g.Assert(err).Eql(nil)
+			g.Assert(userID).Eql(42)
+
+			clock = expiry
+			err = e.DecryptAndVerifyWithOptions(cookie, &userID, MessageOptions{Purpose: "cookie.user_id"})
+			g.Assert(err).Eql(ErrMessageExpired)
+			clock = expiry.Add(-time.Hour)
+		})
+
+		g.It("round trip with a purpose and expiry", func() {
+			for _, cipher := range []string{AESCBC, AES256GCM} {
+				ee := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: cipher, Now: e.Now}
+				msg, err := ee.EncryptAndSignWithOptions("foo", MessageOptions{Purpose: "cart", ExpiresIn: time.Minute})
+				g.Assert(err).Eql(nil)
+				var output string
+				g.Assert(ee.DecryptAndVerifyWithOptions(msg, &output, MessageOptions{Purpose: "cart"})).Eql(nil)
+				g.Assert(output).Eql("foo")
+
+				err = ee.DecryptAndVerifyWithOptions(msg, &output, MessageOptions{Purpose: "login"})
+				g.Assert(err).Eql(ErrInvalidPurpose)
+				g.Assert(errors.Is(err, ErrMessageExpired)).IsFalse()
+				g.Assert(ee.DecryptAndVerify(msg, &output)).Eql(ErrInvalidPurpose)
+
+				clock = clock.Add(time.Minute)
+				err = ee.DecryptAndVerifyWithOptions(msg, &output, MessageOptions{Purpose: "cart"})
+				g.Assert(err).Eql(ErrMessageExpired)
+				g.Assert(errors.Is(err, ErrInvalidPurpose)).IsFalse()
+				clock = expiry.Add(-time.Hour)
+			}
+		})
+
+		g.It("embed the metadata like the verifier", func() {
+			ee := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM, Serializer: JsonMsgSerializer{}}
+			msg, _ := ee.EncryptAndSignWithOptions(42, MessageOptions{Purpose: "cart", ExpiresAt: expiry})
+			raw := &MessageEncryptor{Key: ee.Key, Cipher: AES256GCM, Serializer: NullMsgSerializer{}}
+			plaintext, err := raw.DecryptAndVerifyBytes(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(string(plaintext)).Eql(`{"_rails":{"message":"NDI=","exp":"2030-06-01T12:00:00.000Z","pur":"cart"}}`)
+		})
+
+		g.It("are left as is without metadata", func() {
+			ee := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+			msg, _ := ee.EncryptAndSignWithOptions(42, MessageOptions{})
+			plaintext, _ := ee.DecryptAndVerifyBytes(msg)
+			g.Assert(string(plaintext)).Eql("42")
+		})
+	})
+
+	g.Describe("Messages with metadata generated by Rails 7.1", func() {
+		// With the 7.1 defaults (use_message_serializer_for_metadata) the
+		// metadata is serialized along with the data by the serializer, and
+		// url_safe: true encodes the messages in URL safe base64. The
+		// messages below were computed following Rails 7.1's algorithm with
+		// the keys of the 6.1 messages above.
+		railsSecret := "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"
+		kg := KeyGenerator{Secret: railsSecret}
+		v := MessageVerifier{
+			Secret:                          kg.CacheGenerate([]byte("remember_me"), 64),
+			Serializer:                      JsonMsgSerializer{},
+			UseMessageSerializerForMetadata: true,
+		}
+		// verifier.generate({user_id: 42}, purpose: :remember_me, expires_at: Time.utc(2100))
+		valid := "eyJfcmFpbHMiOnsiZGF0YSI6eyJ1c2VyX2lkIjo0Mn0sImV4cCI6IjIxMDAtMDEtMDFUMDA6MDA6MDAuMDAwWiIsInB1ciI6InJlbWVtYmVyX21lIn19--3d3452d18a7b10baa0ab2a46b45c02e9c3d74b5e"
+		// verifier.generate({user_id: 42}, purpose: :remember_me, expires_at: Time.utc(2020))
+		expired := "eyJfcmFpbHMiOnsiZGF0YSI6eyJ1c2VyX2lkIjo0Mn0sImV4cCI6IjIwMjAtMDEtMDFUMDA6MDA6MDAuMDAwWiIsInB1ciI6InJlbWVtYmVyX21lIn19--0a806cda82f2cc9eb80ed5b2d4deeec73a64a852"
+		// with the Marshal serializer: verifier.generate("hello", purpose: :login)
+		marshaled := "BAh7BkkiC19yYWlscwY6BkVUewdJIglkYXRhBjsAVEkiCmhlbGxvBjsAVEkiCHB1cgY7AFRJIgpsb2dpbgY7AFQ=--7ae15b3b2bef1980bbd7bcded57a88147dc79ca7"
+		// the same with url_safe: true
+		marshaledURLSafe := "BAh7BkkiC19yYWlscwY6BkVUewdJIglkYXRhBjsAVEkiCmhlbGxvBjsAVEkiCHB1cgY7AFRJIgpsb2dpbgY7AFQ--013f600c276e19c7038fc99bded4c5e33e2ee157"
+
+		type rememberMe struct {
+			UserID int `json:"user_id"`
+		}
+
+		g.It("are generated with UseMessageSerializerForMetadata", func() {
+			msg, err := v.GenerateWithOptions(map[string]int{"user_id": 42}, MessageOptions{Purpose: "remember_me", ExpiresAt: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)})
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql(valid)
+
+			mv := MessageVerifier{Secret: v.Secret, Serializer: RubyMarshalSerializer{}, UseMessageSerializerForMetadata: true}
+			msg, err = mv.GenerateWithOptions("hello", MessageOptions{Purpose: "login"})
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql(marshaled)
+			mv.URLSafe = true
+			msg, err = mv.GenerateWithOptions("hello", MessageOptions{Purpose: "login"})
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql(marshaledURLSafe)
+		})
+
+		g.It("are verified whatever UseMessageSerializerForMetadata", func() {
+			legacy := v
+			legacy.UseMessageSerializerForMetadata = false
+			for _, v := range []MessageVerifier{v, legacy} {
+				var verified rememberMe
+				g.Assert(v.VerifyWithOptions(valid, &verified, MessageOptions{Purpose: "remember_me"})).Eql(nil)
+				g.Assert(verified).Eql(rememberMe{UserID: 42})
+
+				v.Serializer = RubyMarshalSerializer{}
+				for _, msg := range []string{marshaled, marshaledURLSafe} {
+					var s string
+					g.Assert(v.VerifyWithOptions(msg, &s, MessageOptions{Purpose: "login"})).Eql(nil)
+					g.Assert(s).Eql("hello")
+				}
+			}
+		})
+
+		g.It("enforce the purpose and expiry", func() {
+			var verified rememberMe
+			g.Assert(v.VerifyWithOptions(valid, &verified, MessageOptions{Purpose: "login"})).Eql(ErrInvalidPurpose)
+			g.Assert(v.Verify(valid, &verified)).Eql(ErrInvalidPurpose)
+			g.Assert(v.VerifyWithOptions(expired, &verified, MessageOptions{Purpose: "remember_me"})).Eql(ErrMessageExpired)
+			g.Assert(verified).Eql(rememberMe{})
+
+			mv := MessageVerifier{Secret: v.Secret, Serializer: RubyMarshalSerializer{}}
+			var s string
+			g.Assert(mv.Verify(marshaled, &s)).Eql(ErrInvalidPurpose)
+		})
+
+		g.It("are left as is without metadata", func() {
+			legacy := v
+			legacy.UseMessageSerializerForMetadata = false
+			msg, _ := v.Generate(map[string]int{"user_id": 42})
+			legacyMsg, _ := legacy.Generate(map[string]int{"user_id": 42})
+			g.Assert(msg).Eql(legacyMsg)
+		})
+
+		g.Describe("encrypted", func() {
+			// encryptor = ActiveSupport::MessageEncryptor.new(key, url_safe: true)
+			// encryptor.encrypt_and_sign("hello", purpose: :login), with a
+			// fixed IV whose URL safe base64 is all dashes.
+			key := kg.Generate([]byte("login"), 32)
+			iv := []byte{0xfb, 0xef, 0xbe, 0xfb, 0xef, 0xbe, 0xfb, 0xef, 0xbe, 0xfb, 0xef, 0xbe}
+			urlSafe := "ouFR4p4wDNg3Wa22uHRGGM9YnS_mAwtahGlFL9rGDO6fz2d3pF7iAPw--------------------HaNtatTJElmA8dre9NSdIw"
+			// the same with the legacy flags: url_safe: false and
+			// use_message_serializer_for_metadata = false.
+			legacy := "ouFR4p4wDNg3Wa22sXBBCowF2mW5TS5YzikFGNfCZ7OAnio871W4DaNSfE+PXKefVDW3NDLbluX7rtIHIfg=--++++++++++++++++--wCi50o+3DkV0kCw4Gv+ZTw=="
+
+			g.It("are encrypted like Rails", func() {
+				e := &MessageEncryptor{Key: key, Cipher: AES256GCM, RandReader: bytes.NewReader(iv), URLSafe: true, UseMessageSerializerForMetadata: true}
+				msg, err := e.EncryptAndSignWithOptions("hello", MessageOptions{Purpose: "login"})
+				g.Assert(err).Eql(nil)
+				g.Assert(msg).Eql(urlSafe)
+
+				e = &MessageEncryptor{Key: key, Cipher: AES256GCM, RandReader: bytes.NewReader(iv)}
+				msg, err = e.EncryptAndSignWithOptions("hello", MessageOptions{Purpose: "login"})
+				g.Assert(err).Eql(nil)
+				g.Assert(msg).Eql(legacy)
+			})
+
+			g.It("are decrypted whatever the encoding and metadata", func() {
+				for _, e := range []*MessageEncryptor{{Key: key, Cipher: AES256GCM}, {Key: key, Cipher: AES256GCM, URLSafe: true, UseMessageSerializerForMetadata: true}} {
+					for _, msg := range []string{urlSafe, legacy} {
+						var s string
+						g.Assert(e.DecryptAndVerifyWithOptions(msg, &s, MessageOptions{Purpose: "login"})).Eql(nil)
+						g.Assert(s).Eql("hello")
+						g.Assert(e.DecryptAndVerify(msg, &s)).Eql(ErrInvalidPurpose)
+					}
+				}
+			})
+
+			g.It("round trip URL safe with aes-cbc", func() {
+				e := &MessageEncryptor{Key: GenerateRandomKey(32), URLSafe: true, UseMessageSerializerForMetadata: true}
+				for i := 0; i < 50; i++ {
+					msg, err := e.EncryptAndSignWithOptions(strings.Repeat("?", i), MessageOptions{Purpose: "cart"})
+					g.Assert(err).Eql(nil)
+					g.Assert(strings.ContainsAny(msg, "+/=")).IsFalse()
+					var s string
+					g.Assert(e.DecryptAndVerifyWithOptions(msg, &s, MessageOptions{Purpose: "cart"})).Eql(nil)
+					g.Assert(s).Eql(strings.Repeat("?", i))
+				}
+			})
+		})
+	})
+
+	g.Describe("Verifying with metadata", func() {
+		railsSecret := "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"
+		kg := KeyGenerator{Secret: railsSecret}
+		clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		v := &MessageVerifier{
+			Secret:     kg.CacheGenerate([]byte("remember_me"), 64),
+			Serializer: JsonMsgSerializer{},
+			Now:        func() time.Time { return clock },
+		}
+		// the Rails 6.1 and 7.1 messages above, with the remember_me purpose
+		// and expiring in 2100.
+		rails61 := "eyJfcmFpbHMiOnsibWVzc2FnZSI6ImV5SjFjMlZ5WDJsa0lqbzBNbjA9IiwiZXhwIjoiMjEwMC0wMS0wMVQwMDowMDowMC4wMDBaIiwicHVyIjoicmVtZW1iZXJfbWUifX0=--2f52414e9c0705a5df7129eea9d029273246538f"
+		rails71 := "eyJfcmFpbHMiOnsiZGF0YSI6eyJ1c2VyX2lkIjo0Mn0sImV4cCI6IjIxMDAtMDEtMDFUMDA6MDA6MDAuMDAwWiIsInB1ciI6InJlbWVtYmVyX21lIn19--3d3452d18a7b10baa0ab2a46b45c02e9c3d74b5e"
+		expiry := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		g.It("returns the purpose and expiry of Rails messages", func() {
+			for msg, envelope := range map[string]string{
+				rails61: `{"_rails":{"message":"eyJ1c2VyX2lkIjo0Mn0=","exp":"2100-01-01T00:00:00.000Z","pur":"remember_me"}}`,
+				rails71: `{"_rails":{"data":{"user_id":42},"exp":"2100-01-01T00:00:00.000Z","pur":"remember_me"}}`,
+			} {
+				var verified map[string]int
+				meta, err := v.VerifyWithMetadata(msg, &verified)
+				g.Assert(err).Eql(nil)
+				g.Assert(verified).Eql(map[string]int{"user_id": 42})
+				g.Assert(meta).Eql(Metadata{Purpose: "remember_me", ExpiresAt: &expiry, Envelope: []byte(envelope)})
+			}
+		})
+
+		g.It("returns the metadata set, if any", func() {
+			for _, inline := range []bool{false, true} {
+				v.UseMessageSerializerForMetadata = inline
+				for _, opts := range []MessageOptions{
+					{},
+					{Purpose: "login"},
+					{ExpiresAt: expiry},
+					{Purpose: "login", ExpiresIn: time.Hour},
+					{IssuedAt: clock},
+					{Purpose: "login", ExpiresAt: expiry, IssuedAt: clock},
+				} {
+					msg, err := v.GenerateWithOptions("hello", opts)
+					g.Assert(err).Eql(nil)
+					var s string
+					meta, err := v.VerifyWithMetadata(msg, &s)
+					g.Assert(err).Eql(nil)
+					g.Assert(s).Eql("hello")
+					g.Assert(meta.Purpose).Eql(opts.Purpose)
+					if exp := opts.expiry(clock); exp != nil {
+						g.Assert(*meta.ExpiresAt).Eql(*exp)
+					} else {
+						g.Assert(meta.ExpiresAt == nil).IsTrue()
+					}
+					if opts.IssuedAt.IsZero() {
+						g.Assert(meta.IssuedAt == nil).IsTrue()
+					} else {
+						g.Assert(*meta.IssuedAt).Eql(clock)
+					}
+					g.Assert(meta.Envelope == nil).Eql(opts == MessageOptions{})
+				}
+			}
+			v.UseMessageSerializerForMetadata = false
+		})
+
+		g.It("returns the metadata of expired messages", func() {
+			msg, _ := v.GenerateWithOptions("hello", MessageOptions{Purpose: "login", ExpiresIn: time.Minute})
+			clock = clock.Add(time.Minute)
+			defer func() { clock = clock.Add(-time.Minute) }()
+			var s string
+			meta, err := v.VerifyWithMetadata(msg, &s)
+			g.Assert(err).Eql(ErrMessageExpired)
+			g.Assert(meta.Purpose).Eql("login")
+			g.Assert(*meta.ExpiresAt).Eql(clock)
+			g.Assert(s).Eql("")
+		})
+
+		g.It("rejects tampered messages without metadata", func() {
+			var s string
+			meta, err := v.VerifyWithMetadata(rails61[:len(rails61)-1]+"0", &s)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+			g.Assert(meta).Eql(Metadata{})
+		})
+
+		g.Describe("InvalidateBefore", func() {
+			incident := clock.Add(time.Hour)
+
+			g.It("rejects the messages issued before it", func() {
+				iv := *v
+				iv.InvalidateBefore = clock
+				before, _ := iv.GenerateWithOptions("hello", MessageOptions{Purpose: "login"})
+				iv.InvalidateBefore = incident
+				iv.Now = func() time.Time { return incident }
+				after, _ := iv.GenerateWithOptions("hello", MessageOptions{Purpose: "login"})
+
+				var s string
+				err := iv.VerifyWithOptions(before, &s, MessageOptions{Purpose: "login"})
+				g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+				meta, err := iv.VerifyWithMetadata(before, &s)
+				g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+				g.Assert(*meta.IssuedAt).Eql(clock)
+
+				g.Assert(iv.VerifyWithOptions(after, &s, MessageOptions{Purpose: "login"})).Eql(nil)
+				g.Assert(s).Eql("hello")
+				meta, err = iv.VerifyWithMetadata(after, &s)
+				g.Assert(err).Eql(nil)
+				g.Assert(*meta.IssuedAt).Eql(incident)
+			})
+
+			g.It("rejects the messages without an issue time", func() {
+				iv := *v
+				iv.InvalidateBefore = incident
+				var verified map[string]int
+				err := iv.VerifyWithOptions(rails61, &verified, MessageOptions{Purpose: "remember_me"})
+				g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+				msg, _ := v.Generate("hello")
+				var s string
+				g.Assert(errors.Is(iv.Verify(msg, &s), ErrMessageExpired)).IsTrue()
+				g.Assert(v.Verify(msg, &s)).Eql(nil)
+			})
+
+			g.It("embeds the issue time in the envelope, which Rails ignores", func() {
+				iv := *v
+				iv.InvalidateBefore = clock
+				msg, _ := iv.Generate("hello")
+				data, err := iv.VerifyRaw(msg)
+				g.Assert(err).Eql(nil)
+				g.Assert(string(data)).Eql(`{"_rails":{"message":"ImhlbGxvIg==","exp":null,"pur":null,"iat":"2024-03-01T12:00:00.000Z"}}`)
+			})
+		})
+	})
+
+	g.Describe("Parsing metadata", func() {
+		g.It("ignores data that isn't an envelope", func() {
+			for _, data := range []string{`"hello"`, `{"user_id":42}`, `{"_rails":"hello"}`, `<xml/>`, `{not json`} {
+				unwrapped, err := unwrapMetadata([]byte(data), MessageOptions{}, time.Now())
+				g.Assert(err).Eql(nil)
+				g.Assert(string(unwrapped)).Eql(data)
+			}
+		})
+
+		g.It("rejects envelopes with a bad expiry", func() {
+			data := `{"_rails":{"message":"ImhlbGxvIg==","exp":"tomorrow","pur":null}}`
+			_, err := unwrapMetadata([]byte(data), MessageOptions{}, time.Now())
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+		})
+
+		g.It("rejects envelopes with a bad message", func() {
+			data := `{"_rails":{"message":"not base64!","exp":null,"pur":null}}`
+			_, err := unwrapMetadata([]byte(data), MessageOptions{}, time.Now())
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+		})
+	})
+}
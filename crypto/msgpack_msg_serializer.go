@@ -0,0 +1,250 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackSignature prefixes the messages serialized by
+// ActiveSupport::MessagePack, it is the MessagePack encoding of 128.
+const msgpackSignature = "\xcc\x80"
+
+// The extension types times are encoded with: the MessagePack timestamp used
+// by the msgpack library and the one ActiveSupport::MessagePack registers.
+// ActiveSupport also registers Symbol as extension 0.
+const (
+	msgpackTimestampExt = -1
+	railsSymbolExt      = 0
+	railsTimeExt        = 7
+)
+
+// ErrMsgpackSignature is returned when unserializing data which wasn't
+// serialized by a MsgpackMsgSerializer or ActiveSupport::MessagePack.
+var ErrMsgpackSignature = errors.New("msgpack: invalid serialization format")
+
+// MsgpackMsgSerializer serializes values with MessagePack the way the
+// :message_pack serializer of Rails 7.1+ (ActiveSupport::MessagePack) does,
+// which is more compact than JSON.
+// time.Time values are encoded with ActiveSupport's Time extension so they
+// round trip with Rails, the UTC offset written is always 0 and the times
+// read are in the local time zone. Symbols are read as strings, the other
+// Ruby extensions (Date, BigDecimal...) aren't supported and fail to
+// unserialize.
+type MsgpackMsgSerializer struct {
+	// StructTag is the struct tag naming the fields, "msgpack" if not set.
+	// Set it to "json" to reuse the json tags of existing structs.
+	StructTag string
+}
+
+func (s MsgpackMsgSerializer) Serialize(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseCompactInts(true)
+	enc.SetSortMapKeys(true)
+	if s.StructTag != "" {
+		enc.SetCustomStructTag(s.StructTag)
+	}
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	b, err := rewriteMsgpackExts([]byte(msgpackSignature), buf.Bytes(), toRailsExt)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (s MsgpackMsgSerializer) Unserialize(data string, v interface{}) error {
+	if len(data) < len(msgpackSignature) || data[:len(msgpackSignature)] != msgpackSignature {
+		return ErrMsgpackSignature
+	}
+	b, err := rewriteMsgpackExts(nil, []byte(data[len(msgpackSignature):]), fromRailsExt)
+	if err != nil {
+		return err
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	if s.StructTag != "" {
+		dec.SetCustomStructTag(s.StructTag)
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.PeekCode(); err == nil {
+		return errors.New("msgpack: invalid data after top-level value")
+	}
+	return nil
+}
+
+// rewriteMsgpackExts appends src to dst, passing its extension values through
+// rewrite. MessagePack containers are only headers followed by their
+// elements so the values can be walked through linearly.
+func rewriteMsgpackExts(dst, src []byte, rewrite func(dst []byte, typ int8, payload []byte) ([]byte, error)) ([]byte, error) {
+	for len(src) > 0 {
+		head, size, ext := msgpackToken(src)
+		if head == 0 || head+size > len(src) {
+			return nil, errors.New("msgpack: truncated or invalid data")
+		}
+		if ext {
+			var err error
+			dst, err = rewrite(dst, int8(src[head-1]), src[head:head+size])
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			dst = append(dst, src[:head+size]...)
+		}
+		src = src[head+size:]
+	}
+	return dst, nil
+}
+
+// msgpackToken returns the length of the header of the value starting b and
+// of the data following it, with ext set for extensions whose header ends
+// with their type. It returns a 0 header length on invalid or truncated
+// headers.
+func msgpackToken(b []byte) (head, size int, ext bool) {
+	length := func(n int) int {
+		if len(b) < 1+n {
+			return -1
+		}
+		switch n {
+		case 1:
+			return int(b[1])
+		case 2:
+			return int(binary.BigEndian.Uint16(b[1:]))
+		}
+		return int(binary.BigEndian.Uint32(b[1:]))
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f || c >= 0xe0, c >= 0x80 && c <= 0x9f, c == 0xc0, c == 0xc2, c == 0xc3:
+		return 1, 0, false
+	case c >= 0xa0 && c <= 0xbf:
+		return 1, int(c & 0x1f), false
+	case c == 0xca:
+		return 1, 4, false
+	case c == 0xcb:
+		return 1, 8, false
+	case c >= 0xcc && c <= 0xcf:
+		return 1, 1 << (c - 0xcc), false
+	case c >= 0xd0 && c <= 0xd3:
+		return 1, 1 << (c - 0xd0), false
+	case c >= 0xd4 && c <= 0xd8:
+		if len(b) < 2 {
+			return 0, 0, false
+		}
+		return 2, 1 << (c - 0xd4), true
+	case c == 0xdc, c == 0xde:
+		return 3, 0, false
+	case c == 0xdd, c == 0xdf:
+		return 5, 0, false
+	}
+
+	var n int
+	switch c {
+	case 0xc4, 0xc7, 0xd9:
+		n = 1
+	case 0xc5, 0xc8, 0xda:
+		n = 2
+	case 0xc6, 0xc9, 0xdb:
+		n = 4
+	default:
+		return 0, 0, false
+	}
+	size = length(n)
+	if size < 0 {
+		return 0, 0, false
+	}
+	if c >= 0xc7 && c <= 0xc9 {
+		if len(b) < 2+n {
+			return 0, 0, false
+		}
+		return 2 + n, size, true
+	}
+	return 1 + n, size, false
+}
+
+// toRailsExt rewrites the MessagePack timestamps into ActiveSupport's Time
+// extension: the seconds, nanoseconds and UTC offset of the time.
+func toRailsExt(dst []byte, typ int8, payload []byte) ([]byte, error) {
+	if typ != msgpackTimestampExt {
+		return appendMsgpackExt(dst, typ, payload), nil
+	}
+	var sec, nsec int64
+	switch len(payload) {
+	case 4:
+		sec = int64(binary.BigEndian.Uint32(payload))
+	case 8:
+		n := binary.BigEndian.Uint64(payload)
+		sec, nsec = int64(n&0x3ffffffff), int64(n>>34)
+	case 12:
+		nsec, sec = int64(binary.BigEndian.Uint32(payload)), int64(binary.BigEndian.Uint64(payload[4:]))
+	default:
+		return nil, errors.New("msgpack: invalid timestamp length " + strconv.Itoa(len(payload)))
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseCompactInts(true)
+	for _, n := range []int64{sec, nsec, 0} {
+		if err := enc.EncodeInt(n); err != nil {
+			return nil, err
+		}
+	}
+	return appendMsgpackExt(dst, railsTimeExt, buf.Bytes()), nil
+}
+
+// fromRailsExt rewrites ActiveSupport's Time extension into MessagePack
+// timestamps and its Symbol extension into strings.
+func fromRailsExt(dst []byte, typ int8, payload []byte) ([]byte, error) {
+	switch typ {
+	case railsSymbolExt:
+		var buf bytes.Buffer
+		if err := msgpack.NewEncoder(&buf).EncodeString(string(payload)); err != nil {
+			return nil, err
+		}
+		return append(dst, buf.Bytes()...), nil
+	case railsTimeExt:
+		dec := msgpack.NewDecoder(bytes.NewReader(payload))
+		var fields [3]int64
+		for i := range fields {
+			n, err := dec.DecodeInt64()
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = n
+		}
+		// the UTC offset doesn't change the instant, only how Ruby
+		// displays it.
+		ts := make([]byte, 12)
+		binary.BigEndian.PutUint32(ts, uint32(fields[1]))
+		binary.BigEndian.PutUint64(ts[4:], uint64(fields[0]))
+		return appendMsgpackExt(dst, msgpackTimestampExt, ts), nil
+	}
+	return nil, errors.New("msgpack: unsupported Ruby extension type " + strconv.Itoa(int(typ)))
+}
+
+// appendMsgpackExt appends an extension value to dst using the smallest
+// header, like the msgpack library and msgpack-ruby do.
+func appendMsgpackExt(dst []byte, typ int8, payload []byte) []byte {
+	switch n := len(payload); {
+	case n == 1 || n == 2 || n == 4 || n == 8 || n == 16:
+		code := byte(0xd4)
+		for n > 1 {
+			code++
+			n >>= 1
+		}
+		dst = append(dst, code)
+	case n <= 0xff:
+		dst = append(dst, 0xc7, byte(n))
+	case n <= 0xffff:
+		dst = append(dst, 0xc8, byte(n>>8), byte(n))
+	default:
+		dst = append(dst, 0xc9, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	dst = append(dst, byte(typ))
+	return append(dst, payload...)
+}
@@ -0,0 +1,109 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestMsgpackMsgSerializer(t *testing.T) {
+	g := Goblin(t)
+	serializer := MsgpackMsgSerializer{}
+
+	type Session struct {
+		UserID int       `msgpack:"user_id" json:"user_id"`
+		At     time.Time `msgpack:"at" json:"at"`
+	}
+	at := time.Unix(1700000000, 123456789)
+
+	g.Describe("a msgpack serialized struct", func() {
+		// {"user_id"=>42, "at"=>Time.at(1700000000, 123456789, :nsec)}
+		// serialized by ActiveSupport::MessagePack, computed by hand
+		// following its format: the signature then the map, the time
+		// being the extension 7 holding its seconds, nanoseconds and UTC
+		// offset.
+		const fixture = "cc8082a7757365725f69642aa26174c70b07ce6553f100ce075bcd1500"
+
+		g.It("is encoded like Rails does", func() {
+			output, err := serializer.Serialize(Session{UserID: 42, At: at})
+			g.Assert(err).Eql(nil)
+			g.Assert(hex.EncodeToString([]byte(output))).Eql(fixture)
+		})
+
+		g.It("can be deserialized", func() {
+			data, _ := hex.DecodeString(fixture)
+			var o Session
+			g.Assert(serializer.Unserialize(string(data), &o)).Eql(nil)
+			g.Assert(o.UserID).Eql(42)
+			g.Assert(o.At.Equal(at)).IsTrue()
+		})
+
+		g.It("reads the times with a UTC offset", func() {
+			// the same time at +01:00
+			data, _ := hex.DecodeString("cc8081a26174c70d07ce6553f100ce075bcd15cd0e10")
+			var o Session
+			g.Assert(serializer.Unserialize(string(data), &o)).Eql(nil)
+			g.Assert(o.At.Equal(at)).IsTrue()
+		})
+
+		g.It("reads symbols as strings", func() {
+			// {user_id: 42}
+			data, _ := hex.DecodeString("cc8081c70700757365725f69642a")
+			var o Session
+			g.Assert(serializer.Unserialize(string(data), &o)).Eql(nil)
+			g.Assert(o.UserID).Eql(42)
+		})
+
+		g.It("is smaller than JSON", func() {
+			data := map[string]interface{}{"user_id": 1234567, "csrf": "8f14e45fceea167a5a36dedd4bea2543", "flash": []string{"Welcome back!"}, "at": at.UTC()}
+			packed, err := serializer.Serialize(data)
+			g.Assert(err).Eql(nil)
+			json, err := JsonMsgSerializer{}.Serialize(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(packed) < len(json)).IsTrue()
+			g.Assert(len(packed)).Eql(93)
+			g.Assert(len(json)).Eql(125)
+		})
+
+		g.It("can use the json tags", func() {
+			type Person struct {
+				Name string `json:"name"`
+			}
+			s := MsgpackMsgSerializer{StructTag: "json"}
+			output, err := s.Serialize(Person{Name: "John"})
+			g.Assert(err).Eql(nil)
+			g.Assert(hex.EncodeToString([]byte(output))).Eql("cc8081a46e616d65a44a6f686e")
+			var o Person
+			g.Assert(s.Unserialize(output, &o)).Eql(nil)
+			g.Assert(o.Name).Eql("John")
+		})
+	})
+
+	g.Describe("invalid data", func() {
+		g.It("is rejected", func() {
+			var o string
+			g.Assert(serializer.Unserialize(`"foo"`, &o)).Eql(ErrMsgpackSignature)
+			g.Assert(serializer.Unserialize("", &o)).Eql(ErrMsgpackSignature)
+			g.Assert(serializer.Unserialize("\xcc\x80\xa3fo", &o) != nil).IsTrue()
+			g.Assert(serializer.Unserialize("\xcc\x80\xa3foo\x01", &o) != nil).IsTrue()
+			// a Date, extension 6
+			g.Assert(serializer.Unserialize("\xcc\x80\xd4\x06\x01", &o) != nil).IsTrue()
+		})
+	})
+
+	g.Describe("a msgpack encryptor", func() {
+		g.It("round trips", func() {
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM, Serializer: serializer}
+			msg, err := e.EncryptAndSign(Session{UserID: 42, At: at})
+			g.Assert(err).Eql(nil)
+			var o Session
+			g.Assert(e.DecryptAndVerify(msg, &o)).Eql(nil)
+			g.Assert(o.UserID).Eql(42)
+			g.Assert(o.At.Equal(at)).IsTrue()
+			g.Assert(errors.Is(e.DecryptAndVerify(msg+"x", &o), ErrInvalidMessage)).IsTrue()
+		})
+	})
+}
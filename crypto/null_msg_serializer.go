@@ -6,19 +6,44 @@ import (
 	"reflect"
 )
 
+// ErrNullSerializerType is returned when NullMsgSerializer is given a value
+// or target of a type it doesn't support.
+var ErrNullSerializerType = errors.New("Unsupported type for the null serializer")
+
+// NullMsgSerializer doesn't serialize anything: it passes strings, []byte
+// (binary data included) and the string of fmt.Stringer values through and
+// unserializes data into *string or *[]byte targets.
 type NullMsgSerializer struct{}
 
 func (s NullMsgSerializer) Serialize(vptr interface{}) (string, error) {
-	return fmt.Sprint(vptr), nil
+	switch v := vptr.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+	return "", nullSerializerTypeError("serialize", vptr)
 }
 
-// Can only deserialize to a string.
+// Unserialize sets the data in the string or []byte vptr points to.
 func (s NullMsgSerializer) Unserialize(data string, vptr interface{}) error {
-	typ := reflect.TypeOf(vptr)
-	if typ.Kind() != reflect.Ptr {
-		errors.New("You passed an interface which isn't a pointer")
+	v := reflect.ValueOf(vptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nullSerializerTypeError("unserialize into", vptr)
+	}
+	switch v = v.Elem(); {
+	case v.Kind() == reflect.String:
+		v.SetString(data)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes([]byte(data))
+	default:
+		return nullSerializerTypeError("unserialize into", vptr)
 	}
-	v := reflect.ValueOf(vptr).Elem()
-	v.SetString(data)
 	return nil
 }
+
+func nullSerializerTypeError(op string, v interface{}) error {
+	return &messageError{msg: fmt.Sprintf("%s - can't %s %T", ErrNullSerializerType, op, v), kind: ErrNullSerializerType}
+}
@@ -1,6 +1,9 @@
 package crypto
 
 import (
+	"errors"
+	"net"
+	"strings"
 	"testing"
 
 	. "github.com/franela/goblin"
@@ -24,20 +27,55 @@ func TestNullSerializerSerializer(t *testing.T) {
 	})
 
 	g.Describe("a null serialized struct", func() {
-		data := map[string]string{"foo": "matt", "bar": "aimonetti"}
-		output, err := serializer.Serialize(data)
+		g.It("is rejected", func() {
+			data := map[string]string{"foo": "matt", "bar": "aimonetti"}
+			_, err := serializer.Serialize(data)
+			g.Assert(errors.Is(err, ErrNullSerializerType)).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "map[string]string")).IsTrue()
+		})
 
-		g.It("serializes properly", func() {
-			g.Assert(err).Eql(err)
-			g.Assert(output).Eql("map[bar:aimonetti foo:matt]")
+		g.It("can't be deserialized into", func() {
+			var o map[string]string
+			g.Assert(errors.Is(serializer.Unserialize("foo", &o), ErrNullSerializerType)).IsTrue()
+			var s string
+			g.Assert(errors.Is(serializer.Unserialize("foo", s), ErrNullSerializerType)).IsTrue()
+			var p *string
+			g.Assert(errors.Is(serializer.Unserialize("foo", p), ErrNullSerializerType)).IsTrue()
 		})
+	})
 
-		g.It("can be deserialized", func() {
-			var o string
-			err := serializer.Unserialize(output, &o)
+	g.Describe("null serialized binary data", func() {
+		// NUL bytes and invalid UTF-8.
+		data := []byte{'a', 0, 0xff, 0xfe, 0, 'z'}
+
+		g.It("is kept as is", func() {
+			output, err := serializer.Serialize(data)
 			g.Assert(err).Eql(nil)
-			g.Assert(o).Eql("map[bar:aimonetti foo:matt]")
+			g.Assert(output).Eql(string(data))
+
+			var b []byte
+			g.Assert(serializer.Unserialize(output, &b)).Eql(nil)
+			g.Assert(b).Eql(data)
+			var s string
+			g.Assert(serializer.Unserialize(output, &s)).Eql(nil)
+			g.Assert(s).Eql(string(data))
+		})
+
+		g.It("can be signed", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: serializer}
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			var b []byte
+			g.Assert(v.Verify(msg, &b)).Eql(nil)
+			g.Assert(b).Eql(data)
 		})
 	})
 
+	g.Describe("a null serialized fmt.Stringer", func() {
+		g.It("is its string", func() {
+			output, err := serializer.Serialize(net.IPv4(10, 0, 0, 1))
+			g.Assert(err).Eql(nil)
+			g.Assert(output).Eql("10.0.0.1")
+		})
+	})
 }
@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The default parameters of Argon2idKeyGenerator and ScryptKeyGenerator,
+// the ones golang.org/x/crypto recommends. Each derivation takes about
+// 64 MiB and 32 MiB of memory respectively.
+const (
+	DefaultArgon2Time    = 1
+	DefaultArgon2Memory  = 64 * 1024
+	DefaultArgon2Threads = 4
+
+	DefaultScryptN = 1 << 15
+	DefaultScryptR = 8
+	DefaultScryptP = 1
+)
+
+var (
+	_ KeyDeriver = (*Argon2idKeyGenerator)(nil)
+	_ KeyDeriver = (*ScryptKeyGenerator)(nil)
+)
+
+// Argon2idKeyGenerator derives keys from a passphrase with Argon2id (RFC
+// 9106), which unlike PBKDF2 is memory hard, for the apps whose secret is
+// chosen by a human (ie: CLI tools) rather than a random secret_key_base.
+// Rails can't derive the same keys.
+// The derivations being expensive on purpose, derive the keys once and keep
+// them rather than on every request.
+type Argon2idKeyGenerator struct {
+	Secret string
+	// Time is the number of passes over the memory, DefaultArgon2Time if 0.
+	Time uint32
+	// Memory is the memory used in KiB, DefaultArgon2Memory if 0.
+	Memory uint32
+	// Threads is the parallelism, DefaultArgon2Threads if 0.
+	Threads uint8
+}
+
+// Generate derives a key of keySize bytes from the passphrase and the salt.
+func (g *Argon2idKeyGenerator) Generate(salt []byte, keySize int) []byte {
+	time, memory, threads := g.Time, g.Memory, g.Threads
+	if time == 0 {
+		time = DefaultArgon2Time
+	}
+	if memory == 0 {
+		memory = DefaultArgon2Memory
+	}
+	if threads == 0 {
+		threads = DefaultArgon2Threads
+	}
+	return argon2.IDKey([]byte(g.Secret), salt, time, memory, threads, uint32(keySize))
+}
+
+// ScryptKeyGenerator derives keys from a passphrase with scrypt (RFC 7914),
+// see Argon2idKeyGenerator.
+type ScryptKeyGenerator struct {
+	Secret string
+	// N is the CPU and memory cost, a power of 2, DefaultScryptN if 0.
+	N int
+	// R is the block size, DefaultScryptR if 0.
+	R int
+	// P is the parallelism, DefaultScryptP if 0.
+	P int
+}
+
+// Generate derives a key of keySize bytes from the passphrase and the salt.
+// It panics if the parameters are invalid, ie: N isn't a power of 2.
+func (g *ScryptKeyGenerator) Generate(salt []byte, keySize int) []byte {
+	n, r, p := g.N, g.R, g.P
+	if n == 0 {
+		n = DefaultScryptN
+	}
+	if r == 0 {
+		r = DefaultScryptR
+	}
+	if p == 0 {
+		p = DefaultScryptP
+	}
+	key, err := scrypt.Key([]byte(g.Secret), salt, n, r, p, keySize)
+	if err != nil {
+		panic("crypto: invalid ScryptKeyGenerator parameters: " + err.Error())
+	}
+	return key
+}
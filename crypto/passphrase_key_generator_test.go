@@ -0,0 +1,98 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"strconv"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestPassphraseKeyGenerators(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("an Argon2id key generator", func() {
+		g.It("matches the reference implementation", func() {
+			// the argon2id test of the reference implementation's test.c:
+			// t=2, m=2^16, p=1, "password" and "somesalt".
+			gen := &Argon2idKeyGenerator{Secret: "password", Time: 2, Memory: 1 << 16, Threads: 1}
+			g.Assert(hex.EncodeToString(gen.Generate([]byte("somesalt"), 32))).Eql("09316115d5cf24ed5a15a31a3ba326e5cf32edc24702987c02b6566f61913cf7")
+		})
+
+		g.It("has defaults", func() {
+			gen := &Argon2idKeyGenerator{Secret: "correct horse battery staple"}
+			explicit := &Argon2idKeyGenerator{Secret: gen.Secret, Time: DefaultArgon2Time, Memory: DefaultArgon2Memory, Threads: DefaultArgon2Threads}
+			g.Assert(gen.Generate([]byte("signed cookie"), 64)).Eql(explicit.Generate([]byte("signed cookie"), 64))
+		})
+	})
+
+	g.Describe("a scrypt key generator", func() {
+		g.It("matches the RFC 7914 test vectors", func() {
+			for _, tc := range []struct {
+				gen  ScryptKeyGenerator
+				salt string
+				key  string
+			}{
+				{ScryptKeyGenerator{Secret: "", N: 16, R: 1, P: 1}, "",
+					"77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+				{ScryptKeyGenerator{Secret: "password", N: 1024, R: 8, P: 16}, "NaCl",
+					"fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+			} {
+				g.Assert(hex.EncodeToString(tc.gen.Generate([]byte(tc.salt), 64))).Eql(tc.key)
+			}
+		})
+
+		g.It("has defaults", func() {
+			// computed with Python's hashlib.scrypt (OpenSSL).
+			gen := &ScryptKeyGenerator{Secret: "correct horse battery staple"}
+			g.Assert(hex.EncodeToString(gen.Generate([]byte("signed cookie"), 64))).Eql("089eedabb66a9b9f1b1060afc4a5b1744d86b0e32faa65667eb5d72bfc1e5475a0d66cd926641d4c769178d6852e3f9379a5e3ad4f8f0039b28b4b21748703bd")
+		})
+
+		g.It("panics with invalid parameters", func() {
+			defer func() { g.Assert(recover() != nil).IsTrue() }()
+			(&ScryptKeyGenerator{Secret: "password", N: 1000}).Generate([]byte("salt"), 32)
+		})
+	})
+
+	g.Describe("a passphrase key generator", func() {
+		g.It("can derive the keys of verifiers and encryptors", func() {
+			for _, kd := range []KeyDeriver{
+				&Argon2idKeyGenerator{Secret: "correct horse battery staple", Memory: 1024},
+				&ScryptKeyGenerator{Secret: "correct horse battery staple", N: 1024},
+			} {
+				e, err := EncryptorFrom(kd, "authenticated encrypted cookie", WithCipher(AES256GCM))
+				g.Assert(err).Eql(nil)
+				msg, _ := e.EncryptAndSign("hello")
+				var o string
+				g.Assert(e.DecryptAndVerify(msg, &o)).Eql(nil)
+				g.Assert(o).Eql("hello")
+			}
+		})
+	})
+}
+
+// The benchmarks show how the cost parameters change the time each
+// derivation takes.
+func BenchmarkArgon2idKeyGenerator(b *testing.B) {
+	for _, memory := range []uint32{16 * 1024, DefaultArgon2Memory} {
+		for _, time := range []uint32{1, 3} {
+			gen := &Argon2idKeyGenerator{Secret: "correct horse battery staple", Time: time, Memory: memory}
+			b.Run("m="+strconv.Itoa(int(memory))+",t="+strconv.Itoa(int(time)), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					gen.Generate([]byte("signed cookie"), 32)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkScryptKeyGenerator(b *testing.B) {
+	for _, n := range []int{1 << 14, DefaultScryptN, 1 << 17} {
+		gen := &ScryptKeyGenerator{Secret: "correct horse battery staple", N: n}
+		b.Run("N="+strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				gen.Generate([]byte("signed cookie"), 32)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package crypto
 
 // PKCS7Pad() pads an byte array to be a multiple of 16
+// Note that data already a multiple of 16 isn't padded, the MessageEncryptor
+// pads such data with a whole block like OpenSSL does.
 // http://tools.ietf.org/html/rfc5652#section-6.3
 func PKCS7Pad(data []byte) []byte {
 	dataLen := len(data)
@@ -0,0 +1,39 @@
+package crypto
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtoMsgSerializer serializes protocol buffers messages with their binary
+// wire format. Serialize only accepts a proto.Message and Unserialize a
+// non-nil pointer to one, other values fail with an error.
+// The wire format doesn't name the message types: data unserialized into
+// another message type than the one it was serialized from can succeed,
+// the fields which don't match being kept as unknown fields.
+type ProtoMsgSerializer struct{}
+
+func (s ProtoMsgSerializer) Serialize(v interface{}) (string, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return "", &messageError{msg: fmt.Sprintf("proto: can't serialize %T, it isn't a proto.Message", v)}
+	}
+	// deterministic so a given message always generates the same data.
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (s ProtoMsgSerializer) Unserialize(data string, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return &messageError{msg: fmt.Sprintf("proto: can't unserialize into %T, it isn't a proto.Message", v)}
+	}
+	if !m.ProtoReflect().IsValid() {
+		return &messageError{msg: fmt.Sprintf("proto: can't unserialize into a nil %T", v)}
+	}
+	return proto.Unmarshal([]byte(data), m)
+}
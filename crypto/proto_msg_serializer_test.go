@@ -0,0 +1,65 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+	"github.com/mattetti/goRailsYourself/crypto/internal/testpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProtoMsgSerializer(t *testing.T) {
+	g := Goblin(t)
+	serializer := ProtoMsgSerializer{}
+	token := &testpb.Token{UserId: 42, Scope: "read", Nonce: []byte{0, 1, 0xff}}
+
+	g.Describe("a proto serialized message", func() {
+		g.It("can be deserialized", func() {
+			output, err := serializer.Serialize(token)
+			g.Assert(err).Eql(nil)
+			g.Assert([]byte(output)).Eql([]byte("\x08\x2a\x12\x04read\x1a\x03\x00\x01\xff"))
+			var o testpb.Token
+			g.Assert(serializer.Unserialize(output, &o)).Eql(nil)
+			g.Assert(proto.Equal(&o, token)).IsTrue()
+		})
+
+		g.It("can be signed and encrypted", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: serializer}
+			msg, err := v.Generate(token)
+			g.Assert(err).Eql(nil)
+			var o testpb.Token
+			g.Assert(v.Verify(msg, &o)).Eql(nil)
+			g.Assert(proto.Equal(&o, token)).IsTrue()
+
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM, Serializer: serializer}
+			msg, err = e.EncryptAndSign(token)
+			g.Assert(err).Eql(nil)
+			var decrypted testpb.Token
+			g.Assert(e.DecryptAndVerify(msg, &decrypted)).Eql(nil)
+			g.Assert(proto.Equal(&decrypted, token)).IsTrue()
+		})
+	})
+
+	g.Describe("values which aren't messages", func() {
+		g.It("can't be serialized", func() {
+			_, err := serializer.Serialize("foo")
+			g.Assert(err != nil).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "string, it isn't a proto.Message")).IsTrue()
+			_, err = serializer.Serialize(map[string]int{"user_id": 42})
+			g.Assert(err != nil).IsTrue()
+		})
+
+		g.It("can't be unserialized into", func() {
+			output, _ := serializer.Serialize(token)
+			var s string
+			err := serializer.Unserialize(output, &s)
+			g.Assert(err != nil).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "*string, it isn't a proto.Message")).IsTrue()
+			var nilToken *testpb.Token
+			err = serializer.Unserialize(output, nilToken)
+			g.Assert(err != nil).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "nil *testpb.Token")).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/url"
+	"strings"
+)
+
+// RackSessionCookie reads and writes the session cookies of
+// Rack::Session::Cookie (the rack.session cookie of Sinatra and plain Rack
+// apps): `base64(Marshal.dump(session))--hex(HMAC(secret, base64))`.
+// Unlike the Rails cookies the secret is used as is, without key derivation.
+//
+//	c := &RackSessionCookie{Secret: secret}
+//	session, err := c.Decode(cookie.Value)
+//	...
+//	cookie.Value, err = c.Encode(session)
+//
+// Rack silently starts a new session when the cookie can't be verified,
+// Decode returns an error instead.
+type RackSessionCookie struct {
+	// Secret is the :secret of the app and OldSecret its :old_secret, if
+	// set the cookies signed with either are read, only Secret signs them.
+	Secret    string
+	OldSecret string
+	// Hasher is the app's :hmac digest, sha1 if not set.
+	Hasher func() hash.Hash
+	// Serializer is the app's :coder, a RubyMarshalSerializer (the default
+	// Rack::Session::Cookie::Base64::Marshal) if not set.
+	Serializer MsgSerializer
+	// LineWrapped writes the base64 wrapped every 60 characters like Rack
+	// before 2.2 (Array#pack("m")), which the apps of these versions
+	// require to verify the cookies. Both forms are read.
+	LineWrapped bool
+}
+
+// Decode verifies the value of the session cookie, still escaped as it is
+// in the Cookie header, and returns the session it holds.
+// It fails with ErrInvalidConfig if Secret isn't set, ErrInvalidSignature
+// if the cookie isn't signed with one of the secrets and
+// ErrMalformedMessage if it can't be decoded.
+func (c *RackSessionCookie) Decode(value string) (SessionHash, error) {
+	if c.Secret == "" {
+		return nil, configError("empty secret")
+	}
+	msg, err := unescapeCookie(value)
+	if err != nil {
+		return nil, err
+	}
+	i := strings.LastIndex(msg, "--")
+	if i < 0 {
+		return nil, ErrInvalidSignature
+	}
+	data, digest := msg[:i], msg[i+2:]
+	if !c.verify(data, digest, c.Secret) && (c.OldSecret == "" || !c.verify(data, digest, c.OldSecret)) {
+		return nil, ErrInvalidSignature
+	}
+
+	// like Ruby's unpack("m"), the line breaks are ignored.
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, &messageError{msg: "Invalid session cookie - bad base64", kind: ErrMalformedMessage, err: err}
+	}
+	var session SessionHash
+	if err := c.serializer().Unserialize(string(decoded), &session); err != nil {
+		return nil, &messageError{msg: "Invalid session cookie - can't unserialize", kind: ErrMalformedMessage, err: err}
+	}
+	return session, nil
+}
+
+// Encode signs session as the value of the session cookie, escaped to be
+// set as is in a Set-Cookie header.
+func (c *RackSessionCookie) Encode(session SessionHash) (string, error) {
+	if c.Secret == "" {
+		return "", configError("empty secret")
+	}
+	serialized, err := c.serializer().Serialize(session)
+	if err != nil {
+		return "", err
+	}
+	data := base64.StdEncoding.EncodeToString([]byte(serialized))
+	if c.LineWrapped {
+		data = wrapBase64(data)
+	}
+	return url.QueryEscape(data + "--" + hex.EncodeToString(c.hmac(data, c.Secret))), nil
+}
+
+func (c *RackSessionCookie) verify(data, digest, secret string) bool {
+	expected := hex.EncodeToString(c.hmac(data, secret))
+	return SecureCompare(digest, expected)
+}
+
+func (c *RackSessionCookie) hmac(data, secret string) []byte {
+	hasher := c.Hasher
+	if hasher == nil {
+		hasher = sha1.New
+	}
+	mac := hmac.New(hasher, []byte(secret))
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func (c *RackSessionCookie) serializer() MsgSerializer {
+	if c.Serializer == nil {
+		return RubyMarshalSerializer{}
+	}
+	return c.Serializer
+}
+
+// wrapBase64 breaks base64 data into lines of 60 characters, each ended by
+// a line feed, like Ruby's Array#pack("m").
+func wrapBase64(data string) string {
+	var b strings.Builder
+	for len(data) > 0 {
+		n := 60
+		if len(data) < n {
+			n = len(data)
+		}
+		b.WriteString(data[:n])
+		b.WriteByte('\n')
+		data = data[n:]
+	}
+	return b.String()
+}
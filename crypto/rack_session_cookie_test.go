@@ -0,0 +1,98 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+// Rack::Session::Cookie cookies of the session rackFixtureSession with the
+// default Marshal coder. They were computed following Rack 2.x's algorithm
+// with python rather than by this package.
+const (
+	rackFixtureSecret    = "4a7c1e9b2d8f6035e1c7a9b4d2f80e6c3b5a7d9f1e2c4b6a8d0f2e4c6b8a0d2f4e6c8b0a2d4f6e8c0b2a4d6f8e0c2b4a6d8f0e2c4b6a8d0f2e4c6b8a0d2f4e6c"
+	rackFixtureOldSecret = "old_secret_0123456789abcdef0123456789abcdef0123456789abcdef012345"
+	rackFixtureSessionID = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	// signed with HMAC-SHA1, like Rack 2.2.
+	rackSHA1Cookie = "BAh7B0kiD3Nlc3Npb25faWQGOgZFVEkiRTlmODZkMDgxODg0YzdkNjU5YTJmZWFhMGM1NWFkMDE1YTNiZjRmMWIyYjBiODIyY2QxNWQ2YzE1YjBmMDBhMDgGOwBUSSIMdXNlcl9pZAY7AFRpLw%3D%3D--1ffb51f16acb1046cc17aa5503cd96d48f923c7c"
+	// signed with HMAC-SHA256 and the base64 wrapped like Rack 2.0.
+	rackSHA256WrappedCookie = "BAh7B0kiD3Nlc3Npb25faWQGOgZFVEkiRTlmODZkMDgxODg0YzdkNjU5YTJm%0AZWFhMGM1NWFkMDE1YTNiZjRmMWIyYjBiODIyY2QxNWQ2YzE1YjBmMDBhMDgG%0AOwBUSSIMdXNlcl9pZAY7AFRpLw%3D%3D%0A--72645d0db4d1796a4140aa719875aeb390e5065ae851d9d62f56debd19d6cf0c"
+	rackSHA512Cookie        = "BAh7B0kiD3Nlc3Npb25faWQGOgZFVEkiRTlmODZkMDgxODg0YzdkNjU5YTJmZWFhMGM1NWFkMDE1YTNiZjRmMWIyYjBiODIyY2QxNWQ2YzE1YjBmMDBhMDgGOwBUSSIMdXNlcl9pZAY7AFRpLw%3D%3D--6b57f31e7f4569a6a61fa61395d3ceef85d6ae31aebfbb972e50cfc5885941149984becc785ce3bcdf591d027dbd51f6a195d64c163bf6315a9c1e9359e434a3"
+	// signed with rackFixtureOldSecret.
+	rackOldSecretCookie = "BAh7B0kiD3Nlc3Npb25faWQGOgZFVEkiRTlmODZkMDgxODg0YzdkNjU5YTJmZWFhMGM1NWFkMDE1YTNiZjRmMWIyYjBiODIyY2QxNWQ2YzE1YjBmMDBhMDgGOwBUSSIMdXNlcl9pZAY7AFRpLw%3D%3D--c7af045164745468b22ae2b8be0845c56ee1085c"
+)
+
+var rackFixtureSession = SessionHash{"session_id": rackFixtureSessionID, "user_id": int64(42)}
+
+func TestRackSessionCookie(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Rack session cookies", func() {
+		g.It("are read and written like Rack does", func() {
+			for _, tc := range []struct {
+				cookie *RackSessionCookie
+				value  string
+			}{
+				{&RackSessionCookie{Secret: rackFixtureSecret}, rackSHA1Cookie},
+				{&RackSessionCookie{Secret: rackFixtureSecret, Hasher: sha256.New, LineWrapped: true}, rackSHA256WrappedCookie},
+				{&RackSessionCookie{Secret: rackFixtureSecret, Hasher: sha512.New}, rackSHA512Cookie},
+			} {
+				session, err := tc.cookie.Decode(tc.value)
+				g.Assert(err).Eql(nil)
+				g.Assert(session).Eql(rackFixtureSession)
+
+				value, err := tc.cookie.Encode(session)
+				g.Assert(err).Eql(nil)
+				g.Assert(value).Eql(tc.value)
+			}
+		})
+
+		g.It("fall back to the old secret", func() {
+			c := &RackSessionCookie{Secret: rackFixtureSecret, OldSecret: rackFixtureOldSecret}
+			session, err := c.Decode(rackOldSecretCookie)
+			g.Assert(err).Eql(nil)
+			g.Assert(session).Eql(rackFixtureSession)
+
+			value, err := c.Encode(session)
+			g.Assert(err).Eql(nil)
+			g.Assert(value).Eql(rackSHA1Cookie)
+
+			_, err = (&RackSessionCookie{Secret: rackFixtureSecret}).Decode(rackOldSecretCookie)
+			g.Assert(err).Eql(ErrInvalidSignature)
+		})
+
+		g.It("reject the cookies which aren't theirs", func() {
+			c := &RackSessionCookie{Secret: rackFixtureSecret}
+			for _, value := range []string{"", "BAh7AA==", rackSHA256WrappedCookie, rackSHA1Cookie[:len(rackSHA1Cookie)-1] + "d"} {
+				_, err := c.Decode(value)
+				g.Assert(err).Eql(ErrInvalidSignature)
+			}
+
+			value, err := (&RackSessionCookie{Secret: rackFixtureSecret, Serializer: JsonMsgSerializer{}}).Encode(SessionHash{"a": 1})
+			g.Assert(err).Eql(nil)
+			_, err = c.Decode(value)
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+		})
+
+		g.It("require a secret", func() {
+			_, err := (&RackSessionCookie{}).Decode(rackSHA1Cookie)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			_, err = (&RackSessionCookie{}).Encode(rackFixtureSession)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+
+		g.It("wrap the base64 like Array#pack", func() {
+			g.Assert(wrapBase64("")).Eql("")
+			g.Assert(wrapBase64("QQ==")).Eql("QQ==\n")
+			line := "QUFB" + "QUFB"
+			long := ""
+			for i := 0; i < 8; i++ {
+				long += line
+			}
+			g.Assert(wrapBase64(long)).Eql(long[:60] + "\n" + long[60:] + "\n")
+		})
+	})
+}
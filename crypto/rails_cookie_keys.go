@@ -0,0 +1,149 @@
+package crypto
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// The salts Rails derives the keys of its cookie jars from, the defaults of
+// config.action_dispatch.*_cookie_salt.
+const (
+	SignedCookieSalt                 = "signed cookie"
+	EncryptedCookieSalt              = "encrypted cookie"
+	EncryptedSignedCookieSalt        = "signed encrypted cookie"
+	AuthenticatedEncryptedCookieSalt = "authenticated encrypted cookie"
+)
+
+// RailsCookieKeys are the keys of the cookie jars of a Rails app, derived
+// from its secret_key_base by DeriveRailsCookieKeys.
+type RailsCookieKeys struct {
+	// SignedCookieKey is the secret of the MessageVerifier of signed
+	// cookies.
+	SignedCookieKey []byte
+	// EncryptedCookieCipherKey and EncryptedCookieSignKey are the Key and
+	// SignKey of the aes-cbc MessageEncryptor of encrypted cookies.
+	EncryptedCookieCipherKey []byte
+	EncryptedCookieSignKey   []byte
+	// AEADKey is the Key of the aes-256-gcm MessageEncryptor of encrypted
+	// cookies since Rails 5.2, nil before.
+	AEADKey []byte
+}
+
+// RailsCookieOption configures DeriveRailsCookieKeys.
+type RailsCookieOption func(o *railsCookieOptions) error
+
+type railsCookieOptions struct {
+	major, minor int
+	digest       func() hash.Hash
+	// versioned is set when the version was set by WithRailsVersion.
+	versioned bool
+}
+
+// WithRailsVersion sets the version of Rails ("4.2", "7.1"...) to derive the
+// keys of, 5.2 by default. Rails 4 used 64 bytes aes-cbc keys, truncated by
+// OpenSSL, and authenticated encryption came with 5.2. Rails 7.0+ derive
+// their keys with SHA-256 (key_generator_hash_digest_class in the 7.0
+// defaults) unless WithKeyDigest says otherwise.
+func WithRailsVersion(version string) RailsCookieOption {
+	return func(o *railsCookieOptions) error {
+		major, minor, ok := strings.Cut(version, ".")
+		o.versioned = true
+		var err error
+		if o.major, err = strconv.Atoi(major); err != nil || !ok {
+			return configError("invalid Rails version " + strconv.Quote(version))
+		}
+		if minor, _, _ = strings.Cut(minor, "."); minor != "" {
+			if o.minor, err = strconv.Atoi(minor); err != nil {
+				return configError("invalid Rails version " + strconv.Quote(version))
+			}
+		}
+		return nil
+	}
+}
+
+// railsVersion parses version like WithRailsVersion, 5.2 if empty.
+func railsVersion(version string) (railsCookieOptions, error) {
+	o := railsCookieOptions{major: 5, minor: 2}
+	if version == "" {
+		return o, nil
+	}
+	return o, WithRailsVersion(version)(&o)
+}
+
+func (o railsCookieOptions) atLeast(major, minor int) bool {
+	return o.major > major || o.major == major && o.minor >= minor
+}
+
+// cookiePurpose returns the purpose Rails 6.0+ bind the cookies to.
+func (o railsCookieOptions) cookiePurpose(name string) string {
+	if !o.atLeast(6, 0) {
+		return ""
+	}
+	return "cookie." + name
+}
+
+// WithKeyDigest sets the hash the keys are derived with, for the apps
+// whose key_generator_hash_digest_class isn't their version's default.
+func WithKeyDigest(digest func() hash.Hash) RailsCookieOption {
+	return func(o *railsCookieOptions) error {
+		if digest == nil {
+			return configError("nil key digest")
+		}
+		o.digest = digest
+		return nil
+	}
+}
+
+// DeriveRailsCookieKeys derives the keys the cookie jars of a Rails app use
+// from its secret_key_base, with the lengths of the app's Rails version:
+//
+//	keys, err := DeriveRailsCookieKeys(secretKeyBase, WithRailsVersion("6.1"))
+//	encryptor := &MessageEncryptor{Key: keys.AEADKey, Cipher: AES256GCM, Serializer: JsonMsgSerializer{}}
+func DeriveRailsCookieKeys(secretKeyBase string, opts ...RailsCookieOption) (RailsCookieKeys, error) {
+	o, err := applyRailsCookieOptions(opts)
+	if err != nil {
+		return RailsCookieKeys{}, err
+	}
+	return deriveRailsCookieKeys(secretKeyBase, o)
+}
+
+// applyRailsCookieOptions returns the options set by opts, with the digest
+// defaulting to the version's one.
+func applyRailsCookieOptions(opts []RailsCookieOption) (railsCookieOptions, error) {
+	o := railsCookieOptions{major: 5, minor: 2}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return o, err
+		}
+	}
+	if o.digest == nil {
+		o.digest = sha1.New
+		if o.major >= 7 {
+			o.digest = sha256.New
+		}
+	}
+	return o, nil
+}
+
+func deriveRailsCookieKeys(secretKeyBase string, o railsCookieOptions) (RailsCookieKeys, error) {
+	if secretKeyBase == "" {
+		return RailsCookieKeys{}, configError("empty secret_key_base")
+	}
+	kg := KeyGenerator{Secret: secretKeyBase, Digest: o.digest}
+	keys := RailsCookieKeys{
+		SignedCookieKey:        kg.Generate([]byte(SignedCookieSalt), 64),
+		EncryptedCookieSignKey: kg.Generate([]byte(EncryptedSignedCookieSalt), 64),
+	}
+	if o.major < 5 {
+		keys.EncryptedCookieCipherKey = kg.Generate([]byte(EncryptedCookieSalt), 64)
+	} else {
+		keys.EncryptedCookieCipherKey = kg.Generate([]byte(EncryptedCookieSalt), 32)
+	}
+	if o.atLeast(5, 2) {
+		keys.AEADKey = kg.Generate([]byte(AuthenticatedEncryptedCookieSalt), 32)
+	}
+	return keys, nil
+}
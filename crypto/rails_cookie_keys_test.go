@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestDeriveRailsCookieKeys(t *testing.T) {
+	g := Goblin(t)
+
+	// computed with `openssl kdf -kdfopt iter:1000 PBKDF2`, which
+	// OpenSSL::PKCS5 and so Rails' KeyGenerator use, for railsVectorsSecret.
+	const (
+		signedSHA1      = "f389ee2fa5134ba6d76f909b705d8761c8d8880d0289ad5331cc4c50ca0c72f6733393d2d2581e585154a9e8b217fcd9af01ea209eb020a8bc741ddbd8325a7c"
+		encryptedSHA1   = "1bb23c9f3369a7fb37cd3e9f05a6c7ce061bb1263c50cba30d8754f5757049c8b44ff75747f4242902f734e149af70f75cadaa9ab54193e3efaef73294174b6d"
+		signEncSHA1     = "b51213d931264ba224afe51a9405e1dc8d909268152250bf19dcc259653993ff13b2aa4aced7ecde8fa3e73f266ead16e8d2fee7c60f76a7e4ec54a544835596"
+		aeadSHA1        = "e49d93dd8e7fb3c3a30ca095826fd13df9a219ff0801d3212d1e1e979a8f792a"
+		signedSHA256    = "302350f3c4daede679056ac64821d93a86b967a398974d35843906189cec0099dc049862292fcb38679b10dd57931c53350f8cd0c66b714f3c4c45fb74629bda"
+		encryptedSHA256 = "d1c2947114c76aa1c764cdfd1917bbecdc603d5e9f38fa92cde61e3c823740ac0488b50a2216e90e954363835467f635eb763459f81fb95b3f933db982409848"
+		signEncSHA256   = "284d4f61567c27501b8f5a336620d151bceeed1155bd35366ad9e075a9defcd966744f3f13549519a70d119aa3b1d90dc698511502697e668d0a15835e364826"
+		aeadSHA256      = "22efaebfaed888c30f6e49d6ec39201862c24647d511575dd46649721825b02e"
+	)
+	hexKeys := func(keys RailsCookieKeys) []string {
+		return []string{hex.EncodeToString(keys.SignedCookieKey), hex.EncodeToString(keys.EncryptedCookieCipherKey),
+			hex.EncodeToString(keys.EncryptedCookieSignKey), hex.EncodeToString(keys.AEADKey)}
+	}
+
+	g.Describe("the Rails cookie keys", func() {
+		g.It("are derived for each Rails version", func() {
+			for _, tc := range []struct {
+				opts []RailsCookieOption
+				keys []string
+			}{
+				{nil, []string{signedSHA1, encryptedSHA1[:64], signEncSHA1, aeadSHA1}},
+				{[]RailsCookieOption{WithRailsVersion("4.2")}, []string{signedSHA1, encryptedSHA1, signEncSHA1, ""}},
+				{[]RailsCookieOption{WithRailsVersion("5.1.7")}, []string{signedSHA1, encryptedSHA1[:64], signEncSHA1, ""}},
+				{[]RailsCookieOption{WithRailsVersion("6.1")}, []string{signedSHA1, encryptedSHA1[:64], signEncSHA1, aeadSHA1}},
+				{[]RailsCookieOption{WithRailsVersion("7.1")}, []string{signedSHA256, encryptedSHA256[:64], signEncSHA256, aeadSHA256}},
+				{[]RailsCookieOption{WithRailsVersion("7.0"), WithKeyDigest(sha1.New)}, []string{signedSHA1, encryptedSHA1[:64], signEncSHA1, aeadSHA1}},
+			} {
+				keys, err := DeriveRailsCookieKeys(railsVectorsSecret, tc.opts...)
+				g.Assert(err).Eql(nil)
+				g.Assert(hexKeys(keys)).Eql(tc.keys)
+			}
+		})
+
+		g.It("read the Rails cookies", func() {
+			for _, vec := range RailsVectors {
+				keys, err := DeriveRailsCookieKeys(vec.SecretKeyBase, WithRailsVersion(vec.Rails))
+				g.Assert(err).Eql(nil)
+				var o string
+				switch vec.Kind {
+				case RailsSignedCookie:
+					v := &MessageVerifier{Secret: keys.SignedCookieKey, Hasher: sha1.New, Serializer: NullMsgSerializer{}}
+					err = v.VerifyWithOptions(vec.Message, &o, MessageOptions{Purpose: vec.Purpose})
+				case RailsEncryptedCookie:
+					e := &MessageEncryptor{Key: keys.EncryptedCookieCipherKey, SignKey: keys.EncryptedCookieSignKey, Serializer: NullMsgSerializer{}}
+					err = e.DecryptAndVerifyWithOptions(vec.Message, &o, MessageOptions{Purpose: vec.Purpose})
+				case RailsAuthenticatedCookie:
+					e := &MessageEncryptor{Key: keys.AEADKey, Cipher: AES256GCM, Serializer: NullMsgSerializer{}}
+					err = e.DecryptAndVerifyWithOptions(vec.Message, &o, MessageOptions{Purpose: vec.Purpose})
+				}
+				g.Assert(err).Eql(nil)
+				g.Assert(o).Eql(vec.Plaintext)
+			}
+		})
+
+		g.It("rejects invalid options", func() {
+			_, err := DeriveRailsCookieKeys("")
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			for _, version := range []string{"", "7", "x.1", "7.x"} {
+				_, err = DeriveRailsCookieKeys(railsVectorsSecret, WithRailsVersion(version))
+				g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			}
+			_, err = DeriveRailsCookieKeys(railsVectorsSecret, WithKeyDigest(nil))
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+}
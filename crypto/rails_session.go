@@ -0,0 +1,221 @@
+package crypto
+
+import (
+	"net/url"
+	"sync"
+)
+
+// RailsSessionDecoder reads the session of a Rails app from its signed
+// session cookie (ie: the value of the _myapp_session cookie), so Go
+// services can share the sessions of a Rails app they're being ported from.
+//
+//	d := &RailsSessionDecoder{SecretKeyBase: secretKeyBase}
+//	session, err := d.DecodeSignedCookie(cookie.Value)
+//	userID, ok := session.GetInt("user_id")
+//
+// A RailsSessionDecoder is safe for concurrent use by multiple goroutines as
+// long as its fields aren't modified once it's in use.
+type RailsSessionDecoder struct {
+	// SecretKeyBase is the app's secret_key_base the signing key is derived
+	// from with the "signed cookie" salt.
+	SecretKeyBase string
+	// SecretToken is the secret_token of the apps signing their cookies the
+	// Rails 3 way, which uses it as is. When both secrets are set the
+	// cookies signed with either are read, like Rails 4's
+	// UpgradeLegacySignedCookieJar does.
+	SecretToken string
+	// Serializer is the app's cookies_serializer: RubyMarshalSerializer
+	// for :marshal, JsonMsgSerializer for :json and HybridMsgSerializer for
+	// :hybrid. It defaults to a HybridMsgSerializer which reads the cookies
+	// of the three of them.
+	Serializer MsgSerializer
+	// RailsVersion is the version of the app ("4.2", "6.1"...), which sets
+	// the digest the key is derived with: the Rails 4.x to 6.1 one if not
+	// set, see WithRailsVersion.
+	RailsVersion string
+	// CookieName is the name of the session cookie, ie: "_myapp_session".
+	// Rails 6.0+ embed it in the cookies they write so it's required to
+	// read them, along with RailsVersion.
+	CookieName string
+
+	once     sync.Once
+	verifier *MessageVerifier
+	opts     MessageOptions
+	err      error
+}
+
+// DecodeSignedCookie verifies cookieValue, still escaped as it is in the
+// Cookie header, and returns the session it holds.
+// It fails with ErrInvalidConfig if the decoder isn't set with a secret or
+// with an error of the verifier, ie: ErrInvalidSignature.
+func (d *RailsSessionDecoder) DecodeSignedCookie(cookieValue string) (SessionHash, error) {
+	d.once.Do(d.init)
+	if d.err != nil {
+		return nil, d.err
+	}
+	msg, err := unescapeCookie(cookieValue)
+	if err != nil {
+		return nil, err
+	}
+	var session SessionHash
+	if err = d.verifier.VerifyWithOptions(msg, &session, d.opts); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// init sets up the verifier, deriving its key once.
+func (d *RailsSessionDecoder) init() {
+	serializer := d.Serializer
+	if serializer == nil {
+		serializer = HybridMsgSerializer{}
+	}
+	var secret []byte
+	if d.SecretKeyBase != "" {
+		var opts []RailsCookieOption
+		if d.RailsVersion != "" {
+			opts = append(opts, WithRailsVersion(d.RailsVersion))
+		}
+		keys, err := DeriveRailsCookieKeys(d.SecretKeyBase, opts...)
+		if err != nil {
+			d.err = err
+			return
+		}
+		secret = keys.SignedCookieKey
+	} else if d.SecretToken != "" {
+		secret = []byte(d.SecretToken)
+	} else {
+		d.err = configError("neither secret_key_base nor secret_token set")
+		return
+	}
+	d.verifier = &MessageVerifier{Secret: secret, Serializer: serializer}
+	if d.SecretKeyBase != "" && d.SecretToken != "" {
+		d.verifier.Rotate([]byte(d.SecretToken), nil, nil)
+	}
+
+	if d.CookieName != "" && d.RailsVersion != "" {
+		o, err := railsVersion(d.RailsVersion)
+		if err != nil {
+			d.err = err
+			return
+		}
+		d.opts.Purpose = o.cookiePurpose(d.CookieName)
+	}
+}
+
+// unescapeCookie unescapes a cookie value, which Rack escapes like form
+// values.
+func unescapeCookie(value string) (string, error) {
+	msg, err := url.QueryUnescape(value)
+	if err != nil {
+		return "", &messageError{msg: "Invalid signature - bad cookie escaping: " + err.Error(), kind: ErrMalformedMessage, err: err}
+	}
+	return msg, nil
+}
+
+// RailsSessionCodec reads and writes the encrypted session cookies of Rails
+// 4.0+ apps, so Go services can both read the session of a Rails app and
+// refresh it in a way the app accepts:
+//
+//	c := &RailsSessionCodec{SecretKeyBase: secretKeyBase, RailsVersion: "6.1"}
+//	session, err := c.Decode(cookie.Name, cookie.Value)
+//	...
+//	cookie.Value, err = c.Encode(cookie.Name, session)
+//
+// Rails 5.2+ encrypt the cookies with aes-256-gcm, with a key derived from
+// the app's secret_key_base with the "authenticated encrypted cookie" salt.
+// Rails 4.0 to 5.1 encrypt them with aes-256-cbc and sign them, with keys
+// derived with the "encrypted cookie" and "signed encrypted cookie" salts.
+// The cookies of Rails 6.0+ are bound to their name. Those of Rails 7.1+
+// are read whatever use_message_serializer_for_metadata, see RailsVectors.
+//
+// A RailsSessionCodec is safe for concurrent use by multiple goroutines as
+// long as its fields aren't modified once it's in use.
+type RailsSessionCodec struct {
+	SecretKeyBase string
+	// RailsVersion is the version of the app, 5.2 if not set. It sets the
+	// cookies' encryption, the digest the keys are derived with and whether
+	// the cookies are bound to their name, see WithRailsVersion. Apps
+	// pinned to the aes-256-cbc cookies of Rails 5.1 (with
+	// use_authenticated_cookie_encryption disabled) can set it to 5.1.
+	RailsVersion string
+	// Serializer is the app's cookies_serializer, a HybridMsgSerializer by
+	// default which writes JSON and reads both JSON and Marshal cookies.
+	// Apps still using :marshal can't read JSON cookies.
+	Serializer MsgSerializer
+
+	once      sync.Once
+	encryptor *MessageEncryptor
+	version   railsCookieOptions
+	err       error
+}
+
+// Decode decrypts the value of the name cookie, still escaped as it is in
+// the Cookie header, and returns the session it holds.
+// It fails with ErrInvalidConfig if the codec is misconfigured or with an
+// error of the encryptor, ie: ErrInvalidMessage.
+func (c *RailsSessionCodec) Decode(name, value string) (SessionHash, error) {
+	c.once.Do(c.init)
+	if c.err != nil {
+		return nil, c.err
+	}
+	msg, err := unescapeCookie(value)
+	if err != nil {
+		return nil, err
+	}
+	var session SessionHash
+	opts := MessageOptions{Purpose: c.version.cookiePurpose(name)}
+	if err = c.encryptor.DecryptAndVerifyWithOptions(msg, &session, opts); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Encode encrypts session as the value of the name cookie, escaped to be
+// set as is in a Set-Cookie header.
+func (c *RailsSessionCodec) Encode(name string, session SessionHash) (string, error) {
+	c.once.Do(c.init)
+	if c.err != nil {
+		return "", c.err
+	}
+	opts := MessageOptions{Purpose: c.version.cookiePurpose(name)}
+	msg, err := c.encryptor.EncryptAndSignWithOptions(session, opts)
+	if err != nil {
+		return "", err
+	}
+	return url.QueryEscape(msg), nil
+}
+
+// init sets up the encryptor, deriving its key once.
+func (c *RailsSessionCodec) init() {
+	if c.version, c.err = railsVersion(c.RailsVersion); c.err != nil {
+		return
+	}
+	if !c.version.atLeast(4, 0) {
+		c.err = configError("Rails " + c.RailsVersion + " doesn't have encrypted cookies")
+		return
+	}
+	var opts []RailsCookieOption
+	if c.RailsVersion != "" {
+		opts = append(opts, WithRailsVersion(c.RailsVersion))
+	}
+	keys, err := DeriveRailsCookieKeys(c.SecretKeyBase, opts...)
+	if err != nil {
+		c.err = err
+		return
+	}
+	serializer := c.Serializer
+	if serializer == nil {
+		serializer = HybridMsgSerializer{}
+	}
+	c.encryptor = railsCookieEncryptor(keys, c.version, serializer)
+}
+
+// railsCookieEncryptor returns the encryptor of the encrypted cookies of
+// version: aes-256-gcm since Rails 5.2, aes-256-cbc before.
+func railsCookieEncryptor(keys RailsCookieKeys, version railsCookieOptions, serializer MsgSerializer) *MessageEncryptor {
+	if version.atLeast(5, 2) {
+		return &MessageEncryptor{Key: keys.AEADKey, Cipher: AES256GCM, Serializer: serializer}
+	}
+	return &MessageEncryptor{Key: keys.EncryptedCookieCipherKey, SignKey: keys.EncryptedCookieSignKey, Cipher: AESCBC, Serializer: serializer}
+}
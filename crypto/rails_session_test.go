@@ -0,0 +1,242 @@
+package crypto
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+// The Rails 4.2 session cookies of an app using these secrets, computed
+// following Rails' algorithm (PBKDF2-SHA1 key, HMAC-SHA1 hex digest, CGI
+// escaped value) with python's hashlib and hmac, of the session:
+//
+//	{"session_id" => sessionFixtureID, "user_id" => 42, "_csrf_token" => sessionFixtureCSRF}
+const (
+	sessionFixtureSecretKeyBase = "c7f8a2d9e1b04f6a8d3e5c2b7a9f1e0d4c6b8a2f3e5d7c9b1a0f2e4d6c8b0a1f3e5d7c9b1a0f2e4d6c8b0a1f3e5d7c9b1a0f2e4d6c8b0a1f3e5d7c9b1a0f2e4"
+	sessionFixtureSecretToken   = "3eb6db5a9026c547c72708438d496d942e976b252138db7e4e0ee5edd7539457d3ed0fa02ee5e7179420ce5290462018591adaf5f42adcf855da04877827def2"
+	sessionFixtureID            = "7f2a9c4e1b8d3f6a0c5e2b9d4f7a1c3e"
+	sessionFixtureCSRF          = "Pq3Zx8YtR2wK5mN7bV1cL9dF4gH6jA0sE2uI8oT3yWQ="
+
+	// cookies_serializer :marshal, with secret_key_base.
+	marshalSessionCookie = "BAh7CEkiD3Nlc3Npb25faWQGOgZFVEkiJTdmMmE5YzRlMWI4ZDNmNmEwYzVlMmI5ZDRmN2ExYzNlBjsAVEkiDHVzZXJfaWQGOwBUaS9JIhBfY3NyZl90b2tlbgY7AFRJIjFQcTNaeDhZdFIyd0s1bU43YlYxY0w5ZEY0Z0g2akEwc0UydUk4b1QzeVdRPQY7AFQ%3D--8faed2b575727464c5330a20bf2d314e42a7c3c5"
+	// cookies_serializer :json, with secret_key_base.
+	jsonSessionCookie = "eyJzZXNzaW9uX2lkIjoiN2YyYTljNGUxYjhkM2Y2YTBjNWUyYjlkNGY3YTFjM2UiLCJ1c2VyX2lkIjo0MiwiX2NzcmZfdG9rZW4iOiJQcTNaeDhZdFIyd0s1bU43YlYxY0w5ZEY0Z0g2akEwc0UydUk4b1QzeVdRPSJ9--35c0e3d0350d97dfe5081729d49300e6849d7e3a"
+	// cookies_serializer :marshal, signed with the legacy secret_token.
+	legacySessionCookie = "BAh7CEkiD3Nlc3Npb25faWQGOgZFVEkiJTdmMmE5YzRlMWI4ZDNmNmEwYzVlMmI5ZDRmN2ExYzNlBjsAVEkiDHVzZXJfaWQGOwBUaS9JIhBfY3NyZl90b2tlbgY7AFRJIjFQcTNaeDhZdFIyd0s1bU43YlYxY0w5ZEY0Z0g2akEwc0UydUk4b1QzeVdRPQY7AFQ%3D--d04a5d5bffe710ba4d6d98125896f0ebffd06d99"
+)
+
+func TestRailsSessionDecoder(t *testing.T) {
+	g := Goblin(t)
+
+	checkSession := func(session SessionHash, err error) {
+		g.Assert(err).Eql(nil)
+		id, _ := session.GetString("session_id")
+		g.Assert(id).Eql(sessionFixtureID)
+		userID, ok := session.GetInt("user_id")
+		g.Assert(ok).IsTrue()
+		g.Assert(userID).Eql(int64(42))
+		csrf, _ := session.GetString("_csrf_token")
+		g.Assert(csrf).Eql(sessionFixtureCSRF)
+	}
+
+	g.Describe("RailsSessionDecoder", func() {
+		g.It("decodes Rails 4.2 Marshal and JSON session cookies", func() {
+			d := &RailsSessionDecoder{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "4.2"}
+			checkSession(d.DecodeSignedCookie(marshalSessionCookie))
+			checkSession(d.DecodeSignedCookie(jsonSessionCookie))
+		})
+
+		g.It("uses the app's serializer", func() {
+			d := &RailsSessionDecoder{SecretKeyBase: sessionFixtureSecretKeyBase, Serializer: RubyMarshalSerializer{}}
+			checkSession(d.DecodeSignedCookie(marshalSessionCookie))
+			_, err := d.DecodeSignedCookie(jsonSessionCookie)
+			g.Assert(err != nil).IsTrue()
+
+			d = &RailsSessionDecoder{SecretKeyBase: sessionFixtureSecretKeyBase, Serializer: JsonMsgSerializer{}}
+			checkSession(d.DecodeSignedCookie(jsonSessionCookie))
+			_, err = d.DecodeSignedCookie(marshalSessionCookie)
+			g.Assert(err != nil).IsTrue()
+		})
+
+		g.It("decodes cookies signed with the secret_token", func() {
+			d := &RailsSessionDecoder{SecretToken: sessionFixtureSecretToken}
+			checkSession(d.DecodeSignedCookie(legacySessionCookie))
+			_, err := d.DecodeSignedCookie(marshalSessionCookie)
+			g.Assert(err).Eql(ErrInvalidSignature)
+
+			d = &RailsSessionDecoder{SecretKeyBase: sessionFixtureSecretKeyBase, SecretToken: sessionFixtureSecretToken}
+			checkSession(d.DecodeSignedCookie(legacySessionCookie))
+			checkSession(d.DecodeSignedCookie(marshalSessionCookie))
+		})
+
+		g.It("unescapes the cookie value", func() {
+			d := &RailsSessionDecoder{SecretKeyBase: sessionFixtureSecretKeyBase}
+			_, err := d.DecodeSignedCookie("%zz")
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+			unescaped, _ := url.QueryUnescape(marshalSessionCookie)
+			checkSession(d.DecodeSignedCookie(unescaped))
+		})
+
+		g.It("rejects tampered cookies", func() {
+			d := &RailsSessionDecoder{SecretKeyBase: sessionFixtureSecretKeyBase}
+			_, err := d.DecodeSignedCookie("x" + jsonSessionCookie)
+			g.Assert(err).Eql(ErrInvalidSignature)
+			d = &RailsSessionDecoder{SecretKeyBase: sessionFixtureSecretToken}
+			_, err = d.DecodeSignedCookie(jsonSessionCookie)
+			g.Assert(err).Eql(ErrInvalidSignature)
+		})
+
+		g.It("expects the cookie name of Rails 6.0+ cookies", func() {
+			keys, _ := DeriveRailsCookieKeys(sessionFixtureSecretKeyBase, WithRailsVersion("6.1"))
+			v := &MessageVerifier{Secret: keys.SignedCookieKey, Serializer: JsonMsgSerializer{}}
+			msg, _ := v.GenerateWithOptions(map[string]interface{}{"session_id": sessionFixtureID, "user_id": 42, "_csrf_token": sessionFixtureCSRF}, MessageOptions{Purpose: "cookie._myapp_session"})
+			cookie := url.QueryEscape(msg)
+
+			d := &RailsSessionDecoder{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "6.1", CookieName: "_myapp_session"}
+			checkSession(d.DecodeSignedCookie(cookie))
+			d = &RailsSessionDecoder{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "6.1", CookieName: "_other_session"}
+			_, err := d.DecodeSignedCookie(cookie)
+			g.Assert(err != nil).IsTrue()
+		})
+
+		g.It("fails without a secret", func() {
+			_, err := (&RailsSessionDecoder{}).DecodeSignedCookie(jsonSessionCookie)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			_, err = (&RailsSessionDecoder{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "four"}).DecodeSignedCookie(jsonSessionCookie)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+}
+
+// The encrypted session cookies of sessionFixtureSecretKeyBase's app, with
+// the same session, computed following Rails' algorithm: PBKDF2-SHA1 key
+// from python's hashlib, aes-256-gcm from Go's standard library with the
+// IV c0c1c2c3c4c5c6c7c8c9cacb.
+const (
+	// Rails 5.2, no purpose.
+	gcmSessionCookie52 = "%2BI1aAw%2Fzgx%2FEJzc6cmXWK6J%2Bc9qU4APbyUMmnwE9feLZpoeBgLjKGuXlup8w8X7Ugg5bqJGHkTch4WxgMEIgywVJNi49tJMf60Z5ZLz5qR783RdClJ1of9zfmJSDBFmYjg7L7nlCMiohgZREWXCJ6T4B%2B5eX6W3o2ITi--wMHCw8TFxsfIycrL--TXFq4SP1xRQiMqsFqWZ4Vw%3D%3D"
+	// Rails 6.1, bound to the _myapp_session cookie.
+	gcmSessionCookie61 = "%2BI12FB3phgOIQiV8PTqHb6Urd8HN9gOT4QEY9ClxfYWD5rqBjurnROy7lZwKu0KiwkZgnKGNlzQtqBtmW1xbwCRQCh83uaUe4kMVGceagXngz2IptaYWS93blujiH1ebgSm74FBGGAtggo1GZE%2FY8S1f8ISd4mDu0c%2FQEHDNa6CHxa6qOrcw0Cqua2IwMLGdLIVryxSz6kJJYdLz0bHUI8G9FsAy3G2ebwBoARxM6lM9mos8Ha7rFYVwfaYzxnMjddwDHinZr5MGoJhwSrYSB8Jlqwishx25eTh7G1Zwt5FuBsEllK0%3D--wMHCw8TFxsfIycrL--8rVNkhvPYWfIi%2BKV9k4wLw%3D%3D"
+)
+
+// The aes-256-cbc encrypted session cookies of sessionFixtureSecretKeyBase's
+// app with the same session, computed following Rails' algorithm: PBKDF2-SHA1
+// keys and HMAC-SHA1 digest from python's hashlib and hmac, aes-256-cbc from
+// openssl enc. Rails 4.2 and 5.1 use the same format, with 64 and 32 bytes
+// cipher keys whose first 32 bytes are the same.
+const (
+	cbcSessionCookie42Marshal = "Z2dUSS9nbkc3WFcvczllWld3clFPdC9Lb1FUdXlQeUMxTnVLUkFBRFVIQUM1ZDErM2wyem4weTZTWGVtcE82NlFEaGg0TjErVVo5L21jemRyRHlDdUwrL3NUU0dBVis4a2IvK0ZoYkcwV0tORUU0L3NsYWVKZzBKMmppWXNBUCtsUitSZ1k5dmlSV0xHZlB6TEYxd3FxSHdZQlo5T1Y4YnNKM2hvSjRwSFU5VzN2QjJVaFM2ZXlUZ0NvaWdPelpuMFNJU2tUWlpmdnJpeDJqbWJSeW1zdz09LS1vS0dpbzZTbHBxZW9xYXFycksydXJ3PT0%3D--138382a55e55a9c838d0ecd756c14d22c6631572"
+	cbcSessionCookie42JSON    = "QmxmeFJPOXl2NnM1RHM1YTlveGErNm5JV1NvZ0dsN2hESEtBcFhPekVGcUdvWUIyQlExT2RFTmRCVDdtT0pUeGN3S09PT3FCK2pPdjE4YXJnSCsvMFdNRm5La1U1RjBQRDJqTC9vaXQvbmI5VWNDY2k1Tk1pVHZ3STZ4YWxnRUU4aDJXSFJUUGlhdUtpdjZOUTB6bXJhaElKR1Yvc1JpV0dBY2VBUjczdWFvPS0tc0xHeXM3UzF0cmU0dWJxN3ZMMit2dz09--37bc435fa2fbe6cf366f8b2e7c64bc47f9b218f4"
+	cbcSessionCookie51Marshal = "VUpMMXNleUt4UE9jZDVvd0MzNko1V2VJc0FUQzFOUWl2MERSc05jY0U4eHIvb3oycGMxbjR0eGFVZDhFY1AwUTJoT0c1amtwVXg0VU83M1liNkpQYzI3b2VZSytuTTBBUDREK0lLTktWaitscTBibnZxNnJUaHcyclArTFZ2L0lYNUdVZWxVUVliYUdOa1Fsd1RCTlpybnJuYXlUOXErZk9vcVhwMDk1eDI5aUc2N1BkTHQ2cC9tcHJEVXA0Nk9BTUhEOG54Vi80aTE5T1ZtWVBKZnFZZz09LS0wTkhTMDlUVjF0ZlkyZHJiM04zZTN3PT0%3D--c13409275aa7069e11f063e7deb9486dadcbc327"
+	cbcSessionCookie51JSON    = "NXdLYkYxM0NQSXo2RVFIQVQ0R0JmamFteUtOeFBJZXhCS1Z5RUhzbU1VcTJwTWFxQ0drOVV6ZXZGb242MDBlaDBCa3laaXdJRVVJYzBVejRFbzBDQzNQM0NnT0Z3RGcvZWFjbjVDb3Jyb1FwZjlUYWlRcjhTVXAxZEpoRGhFbjdGcGpYR21oODFuWndFY2ZkMFA4bjBrZk9uOFdDc1RMeXd0SnBTNGJTSlk4PS0tNE9IaTQrVGw1dWZvNmVycjdPM3U3dz09--80897e0dd6c363a6ffab04d1e5f66ba08d8c8b1e"
+)
+
+func TestRailsSessionCodec(t *testing.T) {
+	g := Goblin(t)
+
+	checkSession := func(session SessionHash, err error) {
+		g.Assert(err).Eql(nil)
+		id, _ := session.GetString("session_id")
+		g.Assert(id).Eql(sessionFixtureID)
+		userID, _ := session.GetInt("user_id")
+		g.Assert(userID).Eql(int64(42))
+	}
+
+	g.Describe("RailsSessionCodec", func() {
+		g.It("decodes Rails 5.2 session cookies", func() {
+			c := &RailsSessionCodec{SecretKeyBase: sessionFixtureSecretKeyBase}
+			checkSession(c.Decode("_myapp_session", gcmSessionCookie52))
+			_, err := c.Decode("_myapp_session", gcmSessionCookie61)
+			g.Assert(err != nil).IsTrue()
+		})
+
+		g.It("decodes Rails 6.1 session cookies bound to their name", func() {
+			c := &RailsSessionCodec{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "6.1"}
+			checkSession(c.Decode("_myapp_session", gcmSessionCookie61))
+			_, err := c.Decode("_other_session", gcmSessionCookie61)
+			g.Assert(err != nil).IsTrue()
+			_, err = c.Decode("_myapp_session", gcmSessionCookie52)
+			g.Assert(err != nil).IsTrue()
+		})
+
+		g.It("decodes Rails 4.2 and 5.1 aes-256-cbc session cookies", func() {
+			for _, version := range []string{"4.2", "5.1"} {
+				c := &RailsSessionCodec{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: version}
+				for _, cookie := range []string{cbcSessionCookie42Marshal, cbcSessionCookie42JSON, cbcSessionCookie51Marshal, cbcSessionCookie51JSON} {
+					checkSession(c.Decode("_myapp_session", cookie))
+				}
+				_, err := c.Decode("_myapp_session", gcmSessionCookie52)
+				g.Assert(err != nil).IsTrue()
+			}
+
+			c := &RailsSessionCodec{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "4.2", Serializer: JsonMsgSerializer{}}
+			checkSession(c.Decode("_myapp_session", cbcSessionCookie42JSON))
+			_, err := c.Decode("_myapp_session", cbcSessionCookie42Marshal)
+			g.Assert(err != nil).IsTrue()
+			_, err = (&RailsSessionCodec{SecretKeyBase: sessionFixtureSecretKeyBase}).Decode("_myapp_session", cbcSessionCookie51JSON)
+			g.Assert(err != nil).IsTrue()
+		})
+
+		g.It("encodes aes-256-cbc cookies with the app's serializer", func() {
+			for _, serializer := range []MsgSerializer{nil, RubyMarshalSerializer{}} {
+				c := &RailsSessionCodec{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "4.2", Serializer: serializer}
+				cookie, err := c.Encode("_myapp_session", SessionHash{"session_id": sessionFixtureID, "user_id": 42})
+				g.Assert(err).Eql(nil)
+				checkSession(c.Decode("_myapp_session", cookie))
+				checkSession((&RailsSessionCodec{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "5.1"}).Decode("_myapp_session", cookie))
+
+				msg, _ := url.QueryUnescape(cookie)
+				keys, _ := DeriveRailsCookieKeys(sessionFixtureSecretKeyBase, WithRailsVersion("4.2"))
+				e := &MessageEncryptor{Key: keys.EncryptedCookieCipherKey, SignKey: keys.EncryptedCookieSignKey, Serializer: NullMsgSerializer{}}
+				var plaintext string
+				g.Assert(e.DecryptAndVerify(msg, &plaintext)).Eql(nil)
+				g.Assert(strings.HasPrefix(plaintext, marshalVersion)).Eql(serializer != nil)
+			}
+		})
+
+		g.It("encodes cookies Rails can decode", func() {
+			for _, version := range []string{"5.2", "6.1", "7.0"} {
+				c := &RailsSessionCodec{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: version}
+				session := SessionHash{"session_id": sessionFixtureID, "user_id": 42}
+				cookie, err := c.Encode("_myapp_session", session)
+				g.Assert(err).Eql(nil)
+				checkSession(c.Decode("_myapp_session", cookie))
+
+				// the cookie is the one an encryptor set like Rails'
+				// cookie jar would read.
+				msg, err := url.QueryUnescape(cookie)
+				g.Assert(err).Eql(nil)
+				g.Assert(url.QueryEscape(msg)).Eql(cookie)
+				o, _ := railsVersion(version)
+				keys, _ := DeriveRailsCookieKeys(sessionFixtureSecretKeyBase, WithRailsVersion(version))
+				e := &MessageEncryptor{Key: keys.AEADKey, Cipher: AES256GCM, Serializer: JsonMsgSerializer{}}
+				var decrypted SessionHash
+				g.Assert(e.DecryptAndVerifyWithOptions(msg, &decrypted, MessageOptions{Purpose: o.cookiePurpose("_myapp_session")})).Eql(nil)
+				checkSession(decrypted, nil)
+			}
+		})
+
+		g.It("rejects tampered cookies", func() {
+			c := &RailsSessionCodec{SecretKeyBase: sessionFixtureSecretKeyBase}
+			_, err := c.Decode("_myapp_session", "x"+gcmSessionCookie52)
+			g.Assert(err != nil).IsTrue()
+			_, err = c.Decode("_myapp_session", "%zz")
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+			c = &RailsSessionCodec{SecretKeyBase: sessionFixtureSecretToken}
+			_, err = c.Decode("_myapp_session", gcmSessionCookie52)
+			g.Assert(errors.Is(err, ErrInvalidMessage)).IsTrue()
+		})
+
+		g.It("fails with versions before 4.0 or without a secret", func() {
+			for _, c := range []*RailsSessionCodec{{SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "3.2"}, {}, {SecretKeyBase: sessionFixtureSecretKeyBase, RailsVersion: "x"}} {
+				_, err := c.Decode("_myapp_session", gcmSessionCookie52)
+				g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+				_, err = c.Encode("_myapp_session", SessionHash{})
+				g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			}
+		})
+	})
+}
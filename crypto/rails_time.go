@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RailsTimeFormat is the format of the times in Rails' JSON: ISO 8601 with
+// milliseconds (ActiveSupport::JSON::Encoding.time_precision), ie:
+// "2024-01-02T03:04:05.123Z".
+const RailsTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// railsTimeLayouts are the formats ParseRailsTime accepts.
+var railsTimeLayouts = []string{
+	// as_json, with any precision.
+	time.RFC3339Nano,
+	// as_json with ActiveSupport.use_standard_json_time_format disabled.
+	"2006/01/02 15:04:05 -0700",
+	// to_s.
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 UTC",
+	// Date#as_json.
+	"2006-01-02",
+}
+
+// ParseRailsTime parses a time as Rails writes it in JSON, whatever its
+// JSON time settings: ISO 8601 ("2024-01-02T03:04:05.123Z"), the legacy
+// format ("2024/01/02 03:04:05 +0000"), Time#to_s ("2024-01-02 03:04:05
+// UTC") or a Date ("2024-01-02", midnight UTC).
+func ParseRailsTime(s string) (time.Time, error) {
+	for _, layout := range railsTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("crypto: can't parse Rails time " + strconv.Quote(s))
+}
+
+// RailsTime is a time.Time encoded in JSON like Rails encodes times, with
+// milliseconds, and decoded from any of the formats ParseRailsTime accepts.
+// RubyMarshalSerializer serializes it as a Ruby Time.
+type RailsTime struct {
+	time.Time
+}
+
+func (t RailsTime) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + t.Format(RailsTimeFormat) + `"`), nil
+}
+
+func (t *RailsTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseRailsTime(s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// normalizeRailsTimes rewrites to RFC 3339 the strings of v, a generic JSON
+// value, which are decoded into time.Time values when decoding v into a t.
+func normalizeRailsTimes(v interface{}, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return v
+	}
+	if t == timeType {
+		if s, ok := v.(string); ok {
+			if parsed, err := ParseRailsTime(s); err == nil {
+				return parsed.Format(time.RFC3339Nano)
+			}
+		}
+		return v
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if array, ok := v.([]interface{}); ok {
+			for i := range array {
+				array[i] = normalizeRailsTimes(array[i], t.Elem())
+			}
+		}
+	case reflect.Map:
+		if object, ok := v.(map[string]interface{}); ok {
+			for k := range object {
+				object[k] = normalizeRailsTimes(object[k], t.Elem())
+			}
+		}
+	case reflect.Struct:
+		if object, ok := v.(map[string]interface{}); ok {
+			for k := range object {
+				if f, ok := jsonField(t, k); ok {
+					object[k] = normalizeRailsTimes(object[k], f.Type)
+				}
+			}
+		}
+	}
+	return v
+}
+
+// jsonField returns the field of the struct type t encoding/json decodes
+// the key into, ignoring case.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for _, f := range reflect.VisibleFields(t) {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() || (f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
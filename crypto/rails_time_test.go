@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestRailsTime(t *testing.T) {
+	g := Goblin(t)
+
+	// the same instant as Rails writes it in JSON by default, in the
+	// Asia/Tokyo zone, with use_standard_json_time_format disabled and with
+	// Time#to_s.
+	expected := time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC)
+	fixtures := map[string]time.Time{
+		`"2024-01-02T03:04:05.123Z"`:      expected,
+		`"2024-01-02T12:04:05.123+09:00"`: expected,
+		`"2024/01/02 03:04:05 +0000"`:     expected.Truncate(time.Second),
+		`"2024-01-02 12:04:05 +0900"`:     expected.Truncate(time.Second),
+		`"2024-01-02 03:04:05 UTC"`:       expected.Truncate(time.Second),
+		`"2024-01-02"`:                    expected.Truncate(24 * time.Hour),
+	}
+
+	g.Describe("RailsTime", func() {
+		g.It("is encoded like Rails", func() {
+			b, err := json.Marshal(RailsTime{expected.Add(456789)})
+			g.Assert(err).Eql(nil)
+			g.Assert(string(b)).Eql(`"2024-01-02T03:04:05.123Z"`)
+			b, _ = json.Marshal(RailsTime{expected.In(time.FixedZone("JST", 9*3600))})
+			g.Assert(string(b)).Eql(`"2024-01-02T12:04:05.123+09:00"`)
+		})
+
+		g.It("decodes every Rails format", func() {
+			for data, value := range fixtures {
+				var o RailsTime
+				g.Assert(json.Unmarshal([]byte(data), &o)).Eql(nil)
+				g.Assert(o.Equal(value)).IsTrue()
+			}
+			o := RailsTime{expected}
+			g.Assert(json.Unmarshal([]byte("null"), &o)).Eql(nil)
+			g.Assert(o.Equal(expected)).IsTrue()
+			g.Assert(json.Unmarshal([]byte(`"yesterday"`), &o) != nil).IsTrue()
+		})
+	})
+
+	g.Describe("a JSON serializer with RailsTimes", func() {
+		type Session struct {
+			ExpiresAt time.Time   `json:"expires_at"`
+			Visits    []time.Time `json:"visits"`
+			Name      string      `json:"name"`
+			Nested    *struct {
+				At time.Time
+			} `json:"nested"`
+		}
+		serializer := JsonMsgSerializer{RailsTimes: true}
+
+		g.It("decodes every Rails format into time fields", func() {
+			for data, value := range fixtures {
+				var o Session
+				err := serializer.Unserialize(`{"expires_at":`+data+`,"visits":[`+data+`],"name":`+data+`,"nested":{"at":`+data+`}}`, &o)
+				g.Assert(err).Eql(nil)
+				g.Assert(o.ExpiresAt.Equal(value)).IsTrue()
+				g.Assert(o.Visits[0].Equal(value)).IsTrue()
+				g.Assert(o.Nested.At.Equal(value)).IsTrue()
+				// the other strings are left alone.
+				g.Assert(`"` + o.Name + `"`).Eql(data)
+			}
+		})
+
+		g.It("keeps the other options", func() {
+			var o Session
+			err := JsonMsgSerializer{RailsTimes: true, DisallowUnknownFields: true}.Unserialize(`{"expires_at":"2024-01-02 03:04:05 UTC","admin":true}`, &o)
+			g.Assert(err == nil).IsFalse()
+			err = serializer.Unserialize(`{"expires_at":"2024-01-02 03:04:05 UTC"} {}`, &o)
+			g.Assert(err == nil).IsFalse()
+		})
+
+		g.It("is needed for the non RFC 3339 formats", func() {
+			var o Session
+			g.Assert(JsonMsgSerializer{}.Unserialize(`{"expires_at":"2024/01/02 03:04:05 +0000"}`, &o) == nil).IsFalse()
+		})
+	})
+}
@@ -0,0 +1,82 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+// base58Alphabet is the Bitcoin alphabet Ruby's SecureRandom.base58 and so
+// Rails' has_secure_token use, without 0, O, I and l.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var errNegativeLength = errors.New("crypto: negative random length")
+
+// RandomKey returns n bytes read from crypto/rand, like
+// SecureRandom.random_bytes.
+func RandomKey(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errNegativeLength
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// RandomHex returns n random bytes hex encoded, 2n characters, like
+// SecureRandom.hex.
+func RandomHex(n int) (string, error) {
+	b, err := RandomKey(n)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RandomBase64URL returns n random bytes encoded with the URL safe base64
+// alphabet without padding, like SecureRandom.urlsafe_base64.
+func RandomBase64URL(n int) (string, error) {
+	b, err := RandomKey(n)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// RandomBase58 returns a random string of n characters of the Bitcoin base58
+// alphabet, like SecureRandom.base58. Rails' has_secure_token generates 24
+// characters tokens.
+func RandomBase58(n int) (string, error) {
+	if n < 0 {
+		return "", errNegativeLength
+	}
+	s := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(s) < n {
+		if _, err := io.ReadFull(rand.Reader, buf[:n-len(s)]); err != nil {
+			return "", err
+		}
+		for _, c := range buf[:n-len(s)] {
+			// the bytes over 58 are dropped rather than taken modulo 58
+			// so every character is as likely.
+			if i := c & 63; i < 58 {
+				s = append(s, base58Alphabet[i])
+			}
+		}
+	}
+	return string(s), nil
+}
+
+// GenerateSecretKeyBase returns a new secret_key_base: 64 random bytes hex
+// encoded, like `rails secret` generates. It panics if crypto/rand fails.
+func GenerateSecretKeyBase() string {
+	secret, err := RandomHex(64)
+	if err != nil {
+		panic("crypto: can't read random bytes: " + err.Error())
+	}
+	return secret
+}
@@ -0,0 +1,92 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestRandom(t *testing.T) {
+	g := Goblin(t)
+
+	// unique draws n values and checks they're all different.
+	unique := func(n int, draw func() string) {
+		seen := map[string]bool{}
+		for i := 0; i < n; i++ {
+			s := draw()
+			g.Assert(seen[s]).IsFalse()
+			seen[s] = true
+		}
+	}
+
+	g.Describe("random values", func() {
+		g.It("are keys of the passed length", func() {
+			for _, n := range []int{0, 16, 32, 64} {
+				key, err := RandomKey(n)
+				g.Assert(err).Eql(nil)
+				g.Assert(len(key)).Eql(n)
+			}
+			unique(1000, func() string { key, _ := RandomKey(16); return string(key) })
+		})
+
+		g.It("are hex encoded", func() {
+			s, err := RandomHex(16)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(s)).Eql(32)
+			_, err = hex.DecodeString(s)
+			g.Assert(err).Eql(nil)
+			unique(1000, func() string { s, _ := RandomHex(16); return s })
+		})
+
+		g.It("are URL safe base64 encoded", func() {
+			s, err := RandomBase64URL(16)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(s)).Eql(22)
+			b, err := base64.RawURLEncoding.DecodeString(s)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(b)).Eql(16)
+			unique(1000, func() string { s, _ := RandomBase64URL(16); return s })
+		})
+
+		g.It("are base58 encoded", func() {
+			counts := map[rune]int{}
+			for i := 0; i < 1000; i++ {
+				s, err := RandomBase58(24)
+				g.Assert(err).Eql(nil)
+				g.Assert(len(s)).Eql(24)
+				for _, c := range s {
+					g.Assert(strings.ContainsRune(base58Alphabet, c)).IsTrue()
+					counts[c]++
+				}
+			}
+			// 24000 characters: each is expected about 414 times.
+			g.Assert(len(counts)).Eql(58)
+			for _, n := range counts {
+				g.Assert(n > 250 && n < 600).IsTrue()
+			}
+			unique(1000, func() string { s, _ := RandomBase58(24); return s })
+		})
+
+		g.It("reject negative lengths", func() {
+			_, err := RandomKey(-1)
+			g.Assert(err == nil).IsFalse()
+			_, err = RandomBase58(-1)
+			g.Assert(err == nil).IsFalse()
+		})
+	})
+
+	g.Describe("GenerateSecretKeyBase", func() {
+		g.It("generates 128 hex characters", func() {
+			secret := GenerateSecretKeyBase()
+			g.Assert(len(secret)).Eql(128)
+			_, err := hex.DecodeString(secret)
+			g.Assert(err).Eql(nil)
+			g.Assert(GenerateSecretKeyBase() == secret).IsFalse()
+			_, err = DeriveRailsCookieKeys(secret)
+			g.Assert(err).Eql(nil)
+		})
+	})
+}
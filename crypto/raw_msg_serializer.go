@@ -0,0 +1,46 @@
+package crypto
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RawMsgSerializer passes already serialized payloads through untouched, ie:
+// a JSON document received from another service which must be signed
+// byte for byte. Serialize accepts json.RawMessage, []byte and string
+// values, Unserialize *json.RawMessage, *[]byte and *string targets, other
+// types fail with an error.
+type RawMsgSerializer struct{}
+
+func (s RawMsgSerializer) Serialize(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case json.RawMessage:
+		return string(v), nil
+	case []byte:
+		return string(v), nil
+	case string:
+		return v, nil
+	}
+	return "", &messageError{msg: fmt.Sprintf("raw: can't serialize %T, only json.RawMessage, []byte and string are", v)}
+}
+
+func (s RawMsgSerializer) Unserialize(data string, v interface{}) error {
+	switch v := v.(type) {
+	case *json.RawMessage:
+		if v != nil {
+			*v = json.RawMessage(data)
+			return nil
+		}
+	case *[]byte:
+		if v != nil {
+			*v = []byte(data)
+			return nil
+		}
+	case *string:
+		if v != nil {
+			*v = data
+			return nil
+		}
+	}
+	return &messageError{msg: fmt.Sprintf("raw: can't unserialize into %T, only non-nil *json.RawMessage, *[]byte and *string are", v)}
+}
@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestRawMsgSerializer(t *testing.T) {
+	g := Goblin(t)
+	serializer := RawMsgSerializer{}
+	// key order and whitespace JsonMsgSerializer wouldn't keep.
+	const doc = "{ \"z\": 1,\n  \"a\" : [ 2, 3 ]\t}"
+
+	g.Describe("a raw payload", func() {
+		g.It("is passed through untouched", func() {
+			for _, v := range []interface{}{json.RawMessage(doc), []byte(doc), doc} {
+				output, err := serializer.Serialize(v)
+				g.Assert(err).Eql(nil)
+				g.Assert(output).Eql(doc)
+			}
+
+			var raw json.RawMessage
+			g.Assert(serializer.Unserialize(doc, &raw)).Eql(nil)
+			g.Assert(string(raw)).Eql(doc)
+			var b []byte
+			g.Assert(serializer.Unserialize(doc, &b)).Eql(nil)
+			g.Assert(string(b)).Eql(doc)
+			var s string
+			g.Assert(serializer.Unserialize(doc, &s)).Eql(nil)
+			g.Assert(s).Eql(doc)
+		})
+
+		g.It("is signed byte for byte", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: serializer}
+			msg, err := v.Generate(json.RawMessage(doc))
+			g.Assert(err).Eql(nil)
+			raw, err := v.VerifyRaw(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(string(raw)).Eql(doc)
+			var o json.RawMessage
+			g.Assert(v.Verify(msg, &o)).Eql(nil)
+			g.Assert(string(o)).Eql(doc)
+		})
+	})
+
+	g.Describe("other types", func() {
+		g.It("are rejected", func() {
+			_, err := serializer.Serialize(map[string]int{"a": 1})
+			g.Assert(err != nil).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "map[string]int")).IsTrue()
+
+			var m map[string]interface{}
+			err = serializer.Unserialize(doc, &m)
+			g.Assert(err != nil).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "*map[string]interface {}")).IsTrue()
+			var s *string
+			g.Assert(serializer.Unserialize(doc, s) != nil).IsTrue()
+			g.Assert(serializer.Unserialize(doc, "") != nil).IsTrue()
+		})
+	})
+}
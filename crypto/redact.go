@@ -0,0 +1,162 @@
+package crypto
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Secret is secret material (ie: a key or a secret_key_base) which is
+// redacted when formatted with any verb or encoded to JSON, so the config
+// structs holding one can be logged safely:
+//
+//	type Config struct {
+//		SecretKeyBase crypto.Secret
+//	}
+//	log.Printf("%+v", cfg) // {SecretKeyBase:[REDACTED len=128]}
+//
+// The secret itself is the []byte conversion of the Secret.
+type Secret []byte
+
+// String returns the redacted secret, ie: "[REDACTED len=64]".
+func (s Secret) String() string {
+	return "[REDACTED len=" + strconv.Itoa(len(s)) + "]"
+}
+
+// GoString is String, for the %#v verb.
+func (s Secret) GoString() string { return s.String() }
+
+// Format writes the redacted secret whatever the verb, so it isn't printed
+// as numbers by %d for instance.
+func (s Secret) Format(f fmt.State, verb rune) { io.WriteString(f, s.String()) }
+
+// MarshalJSON encodes the redacted secret as a JSON string.
+func (s Secret) MarshalJSON() ([]byte, error) { return json.Marshal(s.String()) }
+
+// String describes the verifier's configuration with its secret redacted.
+func (crypt MessageVerifier) String() string {
+	return fmt.Sprintf("MessageVerifier{Secret: %s, Hasher: %s, Serializer: %s, URLSafe: %t, Rotations: %d}",
+		Secret(crypt.Secret), hashName(crypt.hasher()), describeSerializer(crypt.Serializer), crypt.URLSafe, len(crypt.Rotations))
+}
+
+// GoString is String, for the %#v verb.
+func (crypt MessageVerifier) GoString() string { return "crypto." + crypt.String() }
+
+// Format writes String, or GoString for %#v, whatever the verb so the
+// secret is never printed.
+func (crypt MessageVerifier) Format(f fmt.State, verb rune) { formatRedacted(f, verb, crypt) }
+
+// MarshalJSON encodes the verifier's configuration with its secret
+// redacted.
+func (crypt MessageVerifier) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Secret     Secret
+		Hasher     string
+		Serializer string
+		URLSafe    bool
+		Rotations  int
+	}{crypt.Secret, hashName(crypt.hasher()), describeSerializer(crypt.Serializer), crypt.URLSafe, len(crypt.Rotations)})
+}
+
+// String describes the encryptor's configuration with its keys redacted.
+func (crypt MessageEncryptor) String() string {
+	return fmt.Sprintf("MessageEncryptor{Key: %s, SignKey: %s, Cipher: %s, Serializer: %s, Rotations: %d}",
+		Secret(crypt.Key), Secret(crypt.SignKey), crypt.cipherName(), describeSerializer(crypt.Serializer), len(crypt.Rotations))
+}
+
+// GoString is String, for the %#v verb.
+func (crypt MessageEncryptor) GoString() string { return "crypto." + crypt.String() }
+
+// Format writes String, or GoString for %#v, whatever the verb so the keys
+// are never printed.
+func (crypt MessageEncryptor) Format(f fmt.State, verb rune) { formatRedacted(f, verb, crypt) }
+
+// MarshalJSON encodes the encryptor's configuration with its keys
+// redacted.
+func (crypt MessageEncryptor) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Key        Secret
+		SignKey    Secret
+		Cipher     string
+		Serializer string
+		Rotations  int
+	}{crypt.Key, crypt.SignKey, crypt.cipherName(), describeSerializer(crypt.Serializer), len(crypt.Rotations)})
+}
+
+func (crypt MessageEncryptor) cipherName() string {
+	if crypt.Cipher == "" {
+		return AESCBC
+	}
+	return crypt.Cipher
+}
+
+// String describes the generator's configuration with its secret redacted.
+func (g KeyGenerator) String() string {
+	return fmt.Sprintf("KeyGenerator{Secret: %s, Iterations: %d, Digest: %s}",
+		Secret(g.Secret), g.iterations(), hashName(g.Digest))
+}
+
+// GoString is String, for the %#v verb.
+func (g KeyGenerator) GoString() string { return "crypto." + g.String() }
+
+// Format writes String, or GoString for %#v, whatever the verb so the
+// secret is never printed.
+func (g KeyGenerator) Format(f fmt.State, verb rune) { formatRedacted(f, verb, g) }
+
+// MarshalJSON encodes the generator's configuration with its secret
+// redacted.
+func (g KeyGenerator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Secret     Secret
+		Iterations int
+		Digest     string
+	}{Secret(g.Secret), g.iterations(), hashName(g.Digest)})
+}
+
+type redactedFormatter interface {
+	fmt.Stringer
+	fmt.GoStringer
+}
+
+func formatRedacted(f fmt.State, verb rune, v redactedFormatter) {
+	if verb == 'v' && f.Flag('#') {
+		io.WriteString(f, v.GoString())
+		return
+	}
+	io.WriteString(f, v.String())
+}
+
+// hashName returns the name of the hash h returns, ie: "sha256", SHA1 being
+// the default when h is nil.
+func hashName(h func() hash.Hash) string {
+	if h == nil {
+		return "sha1"
+	}
+	hh := h()
+	name := strings.TrimPrefix(fmt.Sprintf("%T", hh), "*")
+	name, _, _ = strings.Cut(name, ".")
+	// the truncated variants share the type of the full ones.
+	bits := strconv.Itoa(hh.Size() * 8)
+	switch {
+	case name == "sha256" && bits == "224", name == "sha512" && bits == "384":
+		return "sha" + bits
+	case name == "sha512" && bits != "512":
+		return "sha512/" + bits
+	}
+	return name
+}
+
+// describeSerializer returns the name of s, or its type for the custom
+// serializers.
+func describeSerializer(s MsgSerializer) string {
+	if s == nil {
+		return "default"
+	}
+	if name := serializerName(s); name != "" {
+		return name
+	}
+	return fmt.Sprintf("%T", s)
+}
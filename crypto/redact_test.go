@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestRedactedSecrets(t *testing.T) {
+	g := Goblin(t)
+
+	secret := []byte("super secret key of 32 bytes!!!!")
+	leaks := func(s string) bool {
+		return strings.Contains(s, string(secret)) ||
+			strings.Contains(s, hex.EncodeToString(secret)) ||
+			strings.Contains(s, fmt.Sprint([]byte(secret))) ||
+			strings.Contains(s, "c3VwZXIgc2VjcmV0") // base64
+	}
+	outputs := func(v interface{}) []string {
+		b, err := json.Marshal(v)
+		g.Assert(err).Eql(nil)
+		return []string{fmt.Sprintf("%v", v), fmt.Sprintf("%+v", v), fmt.Sprintf("%#v", v), fmt.Sprintf("%s", v), fmt.Sprintf("%x", v), fmt.Sprintf("%d", v), string(b)}
+	}
+
+	g.Describe("Redacted secrets", func() {
+		v := MessageVerifier{Secret: secret, Hasher: sha256.New, Serializer: JsonMsgSerializer{}}
+		e := MessageEncryptor{Key: secret, SignKey: secret, Cipher: AES256GCM}
+		kg := KeyGenerator{Secret: string(secret)}
+
+		g.It("aren't printed nor encoded", func() {
+			config := struct {
+				Verifier  *MessageVerifier
+				Encryptor MessageEncryptor
+				Keys      KeyGenerator
+				Secret    Secret
+			}{&v, e, kg, secret}
+			for _, value := range []interface{}{v, &v, e, &e, kg, &kg, Secret(secret), config} {
+				for _, out := range outputs(value) {
+					g.Assert(leaks(out)).IsFalse(out)
+				}
+			}
+		})
+
+		g.It("are described with their configuration", func() {
+			g.Assert(fmt.Sprint(v)).Eql("MessageVerifier{Secret: [REDACTED len=32], Hasher: sha256, Serializer: json, URLSafe: false, Rotations: 0}")
+			g.Assert(fmt.Sprintf("%#v", &e)).Eql("crypto.MessageEncryptor{Key: [REDACTED len=32], SignKey: [REDACTED len=32], Cipher: aes-256-gcm, Serializer: default, Rotations: 0}")
+			g.Assert(fmt.Sprintf("%+v", kg)).Eql("KeyGenerator{Secret: [REDACTED len=32], Iterations: 1000, Digest: sha1}")
+			b, _ := json.Marshal(kg)
+			g.Assert(string(b)).Eql(`{"Secret":"[REDACTED len=32]","Iterations":1000,"Digest":"sha1"}`)
+		})
+	})
+}
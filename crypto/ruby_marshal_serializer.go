@@ -0,0 +1,789 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedMarshal is returned when Ruby Marshal data holds a type that
+// RubyMarshalSerializer doesn't support, or when a Go value can't be
+// serialized as one of them.
+var ErrUnsupportedMarshal = errors.New("Unsupported Ruby Marshal data")
+
+// marshalVersion is the Marshal format version Ruby 1.8+ dumps, 4.8.
+const marshalVersion = "\x04\x08"
+
+// hwiaClass is the class of the HashWithIndifferentAccess Rails sessions
+// and flashes are often stored as.
+const hwiaClass = "ActiveSupport::HashWithIndifferentAccess"
+
+// RubySymbol is a Ruby Symbol. RubyMarshalSerializer unserializes symbols as
+// RubySymbol values into interface values and serializes them back as
+// symbols, as well as the keys of maps with RubySymbol keys. Repeated
+// symbols are written once and then referenced, like Ruby does.
+type RubySymbol string
+
+// HashWithIndifferentAccess is an ActiveSupport::HashWithIndifferentAccess,
+// which RubyMarshalSerializer unserializes into interface values and
+// serializes back with its class.
+type HashWithIndifferentAccess map[string]interface{}
+
+// RubyMarshalSerializer reads and writes the Ruby Marshal data (format 4.8)
+// the signed and encrypted cookies of Rails apps predating the JSON cookies
+// serializer hold. Only a subset of Marshal is supported: nil, true, false,
+// Integer (Fixnum and Bignum fitting in an int64), String, Symbol, Array,
+// Hash (with String or Symbol keys), HashWithIndifferentAccess and Time. Any
+// other class fails with an error matching ErrUnsupportedMarshal.
+//
+// Unserializing into an interface value yields nil, bool, int64, string,
+// RubySymbol, []interface{}, map[string]interface{},
+// map[RubySymbol]interface{}, HashWithIndifferentAccess and time.Time
+// values. The Hashes whose keys are all Symbols are read as
+// map[RubySymbol]interface{} so they're serialized back with Symbol keys,
+// the others as map[string]interface{} with their Symbol keys turned into
+// strings. Other targets, ie structs,
+// are filled through their JSON representation so their json tags are used.
+// Serialized maps have their keys sorted, Ruby having no unordered hashes.
+// time.Time and RailsTime values are serialized as Times, except in structs
+// which are serialized as their JSON representation and hold Strings.
+type RubyMarshalSerializer struct{}
+
+func (s RubyMarshalSerializer) Serialize(v interface{}) (string, error) {
+	e := &marshalEncoder{buf: []byte(marshalVersion), symbols: map[string]int{}}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return "", err
+	}
+	return string(e.buf), nil
+}
+
+func (s RubyMarshalSerializer) Unserialize(data string, v interface{}) error {
+	if !strings.HasPrefix(data, marshalVersion) {
+		return errors.New("marshal: not Ruby Marshal 4.8 data")
+	}
+	d := &marshalDecoder{data: data, pos: len(marshalVersion)}
+	value, err := d.decode()
+	if err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return errors.New("marshal: invalid data after top-level value")
+	}
+
+	switch ptr := v.(type) {
+	case *interface{}:
+		*ptr = value
+		return nil
+	case *SessionHash:
+		// keeps the Integers and Times which JSON would turn into
+		// numbers and strings.
+		switch value := value.(type) {
+		case map[string]interface{}:
+			*ptr = value
+			return nil
+		case HashWithIndifferentAccess:
+			*ptr = SessionHash(value)
+			return nil
+		case map[RubySymbol]interface{}:
+			*ptr = make(SessionHash, len(value))
+			for k, v := range value {
+				(*ptr)[string(k)] = v
+			}
+			return nil
+		}
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func unsupportedMarshal(what string) error {
+	return &messageError{msg: ErrUnsupportedMarshal.Error() + " - " + what, kind: ErrUnsupportedMarshal}
+}
+
+var errMarshalTruncated = errors.New("marshal: truncated data")
+
+type marshalDecoder struct {
+	data    string
+	pos     int
+	symbols []string
+	objects []interface{}
+}
+
+func (d *marshalDecoder) byte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errMarshalTruncated
+	}
+	d.pos++
+	return d.data[d.pos-1], nil
+}
+
+func (d *marshalDecoder) bytes(n int) (string, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return "", errMarshalTruncated
+	}
+	d.pos += n
+	return d.data[d.pos-n : d.pos], nil
+}
+
+// long reads an integer in Marshal's packed format: small values are stored
+// in a single byte offset by 5, others as their length (negated for
+// negative values) followed by their little endian bytes.
+func (d *marshalDecoder) long() (int64, error) {
+	b, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+	c := int64(int8(b))
+	switch {
+	case c == 0:
+		return 0, nil
+	case c > 4:
+		return c - 5, nil
+	case c < -4:
+		return c + 5, nil
+	}
+
+	n := c
+	if n < 0 {
+		n = -n
+	}
+	raw, err := d.bytes(int(n))
+	if err != nil {
+		return 0, err
+	}
+	var x int64
+	if c < 0 {
+		x = -1
+	}
+	for i := 0; i < len(raw); i++ {
+		x &^= 0xff << (8 * i)
+		x |= int64(raw[i]) << (8 * i)
+	}
+	return x, nil
+}
+
+func (d *marshalDecoder) length() (int, error) {
+	n, err := d.long()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int64(len(d.data)) {
+		return 0, errors.New("marshal: invalid length " + strconv.FormatInt(n, 10))
+	}
+	return int(n), nil
+}
+
+// symbol reads a Symbol or a link to one read before.
+func (d *marshalDecoder) symbol() (string, error) {
+	c, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+	switch c {
+	case ':':
+		n, err := d.length()
+		if err != nil {
+			return "", err
+		}
+		name, err := d.bytes(n)
+		if err != nil {
+			return "", err
+		}
+		d.symbols = append(d.symbols, name)
+		return name, nil
+	case ';':
+		i, err := d.long()
+		if err != nil {
+			return "", err
+		}
+		if i < 0 || i >= int64(len(d.symbols)) {
+			return "", errors.New("marshal: invalid symbol link")
+		}
+		return d.symbols[i], nil
+	}
+	return "", fmt.Errorf("marshal: expected a symbol, got %q", c)
+}
+
+// register reserves the next index of the objects links refer to.
+func (d *marshalDecoder) register() int {
+	d.objects = append(d.objects, nil)
+	return len(d.objects) - 1
+}
+
+func (d *marshalDecoder) decode() (interface{}, error) {
+	c, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch c {
+	case '0':
+		return nil, nil
+	case 'T':
+		return true, nil
+	case 'F':
+		return false, nil
+	case 'i':
+		return d.long()
+	case 'l':
+		i := d.register()
+		n, err := d.bignum()
+		d.objects[i] = n
+		return n, err
+	case ':', ';':
+		d.pos--
+		name, err := d.symbol()
+		return RubySymbol(name), err
+	case '"':
+		i := d.register()
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.bytes(n)
+		d.objects[i] = s
+		return s, err
+	case 'I':
+		return d.ivars()
+	case '[':
+		i := d.register()
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, 0, n)
+		for j := 0; j < n; j++ {
+			v, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, v)
+		}
+		d.objects[i] = array
+		return array, nil
+	case '{':
+		i := d.register()
+		hash, symbols, err := d.hash()
+		if err != nil {
+			return nil, err
+		}
+		d.objects[i] = hash
+		if symbols {
+			d.objects[i] = symbolKeys(hash)
+		}
+		return d.objects[i], nil
+	case 'C':
+		i := d.register()
+		class, err := d.symbol()
+		if err != nil {
+			return nil, err
+		}
+		if class != hwiaClass {
+			return nil, unsupportedMarshal("subclass " + class)
+		}
+		if c, err := d.byte(); err != nil || c != '{' {
+			return nil, unsupportedMarshal(class + " which isn't a Hash")
+		}
+		hash, _, err := d.hash()
+		d.objects[i] = HashWithIndifferentAccess(hash)
+		return d.objects[i], err
+	case '@':
+		i, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(d.objects)) {
+			return nil, errors.New("marshal: invalid object link")
+		}
+		return d.objects[i], nil
+	case 'u':
+		return d.userdef(false)
+	case 'o', 'S', 'U', 'e', 'c', 'm', 'd':
+		class, err := d.symbol()
+		if err != nil {
+			return nil, unsupportedMarshal(fmt.Sprintf("type %q", c))
+		}
+		return nil, unsupportedMarshal("object of class " + class)
+	case 'f':
+		return nil, unsupportedMarshal("Float")
+	case '}':
+		return nil, unsupportedMarshal("Hash with a default value")
+	case '/':
+		return nil, unsupportedMarshal("Regexp")
+	}
+	return nil, unsupportedMarshal(fmt.Sprintf("type %q", c))
+}
+
+// bignum reads an Integer too large for a Fixnum: its sign, its length in
+// 16 bits words and its little endian bytes.
+func (d *marshalDecoder) bignum() (int64, error) {
+	sign, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+	n, err := d.length()
+	if err != nil {
+		return 0, err
+	}
+	raw, err := d.bytes(2 * n)
+	if err != nil {
+		return 0, err
+	}
+	var x uint64
+	for i := len(raw) - 1; i >= 0; i-- {
+		if x > (1<<63)>>8 {
+			return 0, unsupportedMarshal("Integer overflowing an int64")
+		}
+		x = x<<8 | uint64(raw[i])
+	}
+	switch {
+	case sign == '-' && x <= 1<<63:
+		return -int64(x), nil
+	case sign == '+' && x < 1<<63:
+		return int64(x), nil
+	}
+	return 0, unsupportedMarshal("Integer overflowing an int64")
+}
+
+// hash reads the pairs of a Hash, reporting whether all its keys are
+// Symbols.
+func (d *marshalDecoder) hash() (hash map[string]interface{}, symbols bool, err error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, false, err
+	}
+	hash = make(map[string]interface{}, n)
+	symbols = n > 0
+	for j := 0; j < n; j++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, false, err
+		}
+		var key string
+		switch k := k.(type) {
+		case string:
+			key = k
+			symbols = false
+		case RubySymbol:
+			key = string(k)
+		default:
+			return nil, false, unsupportedMarshal(fmt.Sprintf("Hash key %v", k))
+		}
+		if hash[key], err = d.decode(); err != nil {
+			return nil, false, err
+		}
+	}
+	return hash, symbols, nil
+}
+
+// symbolKeys returns the Hash with Symbol keys hash is.
+func symbolKeys(hash map[string]interface{}) map[RubySymbol]interface{} {
+	symbols := make(map[RubySymbol]interface{}, len(hash))
+	for k, v := range hash {
+		symbols[RubySymbol(k)] = v
+	}
+	return symbols
+}
+
+// ivars reads a String followed by its instance variables, which only hold
+// its encoding: E set to true for UTF-8 or false for US-ASCII, or encoding
+// set to its name. It also reads the Times, dumped with instance variables.
+func (d *marshalDecoder) ivars() (interface{}, error) {
+	c, err := d.byte()
+	if err == nil && c == 'u' {
+		return d.userdef(true)
+	}
+	if err != nil || c != '"' {
+		return nil, unsupportedMarshal("instance variables on something else than a String")
+	}
+	d.pos--
+	v, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	err = d.eachIvar(func(name string, value interface{}) error {
+		switch name {
+		case "E":
+			if _, ok := value.(bool); !ok {
+				return errors.New("marshal: invalid String encoding")
+			}
+		case "encoding":
+			if _, ok := value.(string); !ok {
+				return errors.New("marshal: invalid String encoding")
+			}
+		default:
+			return unsupportedMarshal("String instance variable " + name)
+		}
+		return nil
+	})
+	return v, err
+}
+
+// eachIvar reads a list of instance variables, passing them to fn.
+func (d *marshalDecoder) eachIvar(fn func(name string, value interface{}) error) error {
+	n, err := d.length()
+	if err != nil {
+		return err
+	}
+	for j := 0; j < n; j++ {
+		name, err := d.symbol()
+		if err != nil {
+			return err
+		}
+		value, err := d.decode()
+		if err != nil {
+			return err
+		}
+		if err := fn(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userdef reads an object dumped with its _dump method, of which only Time
+// is supported.
+func (d *marshalDecoder) userdef(withIvars bool) (interface{}, error) {
+	class, err := d.symbol()
+	if err != nil {
+		return nil, err
+	}
+	if class != timeClass {
+		return nil, unsupportedMarshal("object of class " + class)
+	}
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.bytes(n)
+	if err != nil {
+		return nil, err
+	}
+	ivars := map[string]interface{}{}
+	if withIvars {
+		err = d.eachIvar(func(name string, value interface{}) error {
+			ivars[name] = value
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	t, err := loadRubyTime(data, ivars)
+	if err != nil {
+		return nil, err
+	}
+	// Ruby registers the object once its instance variables are read.
+	d.objects = append(d.objects, t)
+	return t, nil
+}
+
+// timeClass is the class of the Ruby Times, which are dumped as 8 bytes
+// holding their UTC date and time, their microseconds and whether they're in
+// UTC, and instance variables holding their offset, zone and nanoseconds.
+// See time_mdump in Ruby's time.c.
+const timeClass = "Time"
+
+// loadRubyTime returns the Time dumped as data and ivars.
+func loadRubyTime(data string, ivars map[string]interface{}) (time.Time, error) {
+	if len(data) != 8 {
+		return time.Time{}, errors.New("marshal: invalid Time data")
+	}
+	p := binary.LittleEndian.Uint32([]byte(data[:4]))
+	s := binary.LittleEndian.Uint32([]byte(data[4:]))
+	var t time.Time
+	if p&(1<<31) == 0 {
+		// the format of Ruby 1.8 and earlier, seconds and microseconds.
+		t = time.Unix(int64(p), int64(s)*1000)
+	} else {
+		t = time.Date(1900+int(p>>14&0xffff), time.Month(p>>10&0xf+1), int(p>>5&0x1f), int(p&0x1f),
+			int(s>>26&0x3f), int(s>>20&0x3f), int(s&0xfffff)*1000, time.UTC)
+	}
+	num, _ := ivars["nano_num"].(int64)
+	den, _ := ivars["nano_den"].(int64)
+	if den > 0 {
+		t = t.Add(time.Duration(num / den))
+	}
+
+	switch offset, ok := ivars["offset"].(int64); {
+	case p&(1<<31) != 0 && p&(1<<30) != 0:
+		return t.UTC(), nil
+	case ok:
+		zone, _ := ivars["zone"].(string)
+		return t.In(time.FixedZone(zone, int(offset))), nil
+	}
+	return t.Local(), nil
+}
+
+type marshalEncoder struct {
+	buf     []byte
+	symbols map[string]int
+}
+
+// long writes an integer in Marshal's packed format.
+func (e *marshalEncoder) long(x int64) {
+	switch {
+	case x == 0:
+		e.buf = append(e.buf, 0)
+		return
+	case x > 0 && x < 123:
+		e.buf = append(e.buf, byte(x+5))
+		return
+	case x < 0 && x > -124:
+		e.buf = append(e.buf, byte(x-5))
+		return
+	}
+	var raw []byte
+	for i := 1; i <= 8; i++ {
+		raw = append(raw, byte(x))
+		x >>= 8
+		if x == 0 {
+			e.buf = append(e.buf, byte(i))
+			break
+		}
+		if x == -1 {
+			e.buf = append(e.buf, byte(-i))
+			break
+		}
+	}
+	e.buf = append(e.buf, raw...)
+}
+
+// symbol writes a Symbol, or a link to it if it was written before.
+func (e *marshalEncoder) symbol(name string) {
+	if i, ok := e.symbols[name]; ok {
+		e.buf = append(e.buf, ';')
+		e.long(int64(i))
+		return
+	}
+	e.symbols[name] = len(e.symbols)
+	e.buf = append(e.buf, ':')
+	e.long(int64(len(name)))
+	e.buf = append(e.buf, name...)
+}
+
+// integer writes a Fixnum, or a Bignum when it doesn't fit in 31 bits like
+// Ruby does.
+func (e *marshalEncoder) integer(x int64) {
+	if x >= -1<<30 && x < 1<<30 {
+		e.buf = append(e.buf, 'i')
+		e.long(x)
+		return
+	}
+	e.buf = append(e.buf, 'l', '+')
+	u := uint64(x)
+	if x < 0 {
+		e.buf[len(e.buf)-1] = '-'
+		u = uint64(-x)
+	}
+	var raw []byte
+	for ; u > 0; u >>= 8 {
+		raw = append(raw, byte(u))
+	}
+	if len(raw)%2 != 0 {
+		raw = append(raw, 0)
+	}
+	e.long(int64(len(raw) / 2))
+	e.buf = append(e.buf, raw...)
+}
+
+// str writes a UTF-8 String.
+func (e *marshalEncoder) str(s string) {
+	e.buf = append(e.buf, 'I', '"')
+	e.long(int64(len(s)))
+	e.buf = append(e.buf, s...)
+	e.long(1)
+	e.symbol("E")
+	e.buf = append(e.buf, 'T')
+}
+
+var (
+	rubySymbolType = reflect.TypeOf(RubySymbol(""))
+	hwiaType       = reflect.TypeOf(HashWithIndifferentAccess{})
+	jsonNumberType = reflect.TypeOf(json.Number(""))
+	railsTimeType  = reflect.TypeOf(RailsTime{})
+)
+
+func (e *marshalEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, '0')
+		return nil
+	}
+	switch v.Type() {
+	case rubySymbolType:
+		e.symbol(v.String())
+		return nil
+	case hwiaType:
+		if v.IsNil() {
+			e.buf = append(e.buf, '0')
+			return nil
+		}
+		e.buf = append(e.buf, 'C')
+		e.symbol(hwiaClass)
+		return e.hash(v)
+	case jsonNumberType:
+		i, err := json.Number(v.String()).Int64()
+		if err != nil {
+			return unsupportedMarshal("Float " + v.String())
+		}
+		e.integer(i)
+		return nil
+	case timeType:
+		return e.time(v.Interface().(time.Time))
+	case railsTimeType:
+		return e.time(v.Interface().(RailsTime).Time)
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			e.buf = append(e.buf, '0')
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 'T')
+		} else {
+			e.buf = append(e.buf, 'F')
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.integer(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > 1<<63-1 {
+			return unsupportedMarshal("integer overflowing an int64")
+		}
+		e.integer(int64(v.Uint()))
+	case reflect.String:
+		e.str(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.buf = append(e.buf, '0')
+			return nil
+		}
+		e.buf = append(e.buf, '[')
+		e.long(int64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, '0')
+			return nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return unsupportedMarshal("map key type " + v.Type().Key().String())
+		}
+		return e.hash(v)
+	case reflect.Struct:
+		// structs are Hashes of their JSON representation.
+		b, err := json.Marshal(v.Interface())
+		if err != nil {
+			return err
+		}
+		var hash map[string]interface{}
+		if err := unmarshalJSONNumbers(b, &hash); err != nil {
+			return err
+		}
+		return e.encode(reflect.ValueOf(hash))
+	default:
+		return unsupportedMarshal("Go type " + v.Type().String())
+	}
+	return nil
+}
+
+// time writes a Time like Ruby's time_mdump, with the instance variables it
+// sets in the same order.
+func (e *marshalEncoder) time(t time.Time) error {
+	u := t.UTC()
+	if u.Year() < 1900 || u.Year() > 1900+0xffff {
+		return unsupportedMarshal("Time out of range " + t.String())
+	}
+	p := 1<<31 | uint32(u.Year()-1900)<<14 | uint32(u.Month()-1)<<10 | uint32(u.Day())<<5 | uint32(u.Hour())
+	if t.Location() == time.UTC {
+		p |= 1 << 30
+	}
+	s := uint32(u.Minute())<<26 | uint32(u.Second())<<20 | uint32(u.Nanosecond()/1000)
+	e.buf = append(e.buf, 'I', 'u')
+	e.symbol(timeClass)
+	e.long(8)
+	e.buf = append(e.buf, byte(p), byte(p>>8), byte(p>>16), byte(p>>24), byte(s), byte(s>>8), byte(s>>16), byte(s>>24))
+
+	nsec := u.Nanosecond() % 1000
+	zone, offset := t.Zone()
+	n := 1
+	if nsec != 0 {
+		n += 3
+	}
+	if p&(1<<30) == 0 {
+		n++
+	}
+	e.long(int64(n))
+	if nsec != 0 {
+		e.symbol("nano_num")
+		e.integer(int64(nsec))
+		e.symbol("nano_den")
+		e.integer(1)
+		// the nanoseconds as binary-coded decimal, for Ruby 1.9.1.
+		submicro := []byte{byte(nsec/100<<4 | nsec/10%10), byte(nsec % 10 << 4)}
+		if submicro[1] == 0 {
+			submicro = submicro[:1]
+		}
+		e.symbol("submicro")
+		e.buf = append(e.buf, '"')
+		e.long(int64(len(submicro)))
+		e.buf = append(e.buf, submicro...)
+	}
+	if p&(1<<30) == 0 {
+		e.symbol("offset")
+		e.integer(int64(offset))
+	}
+	// zone names are US-ASCII Strings, nil for unnamed offsets.
+	e.symbol("zone")
+	if zone == "" {
+		e.buf = append(e.buf, '0')
+	} else {
+		e.buf = append(e.buf, 'I', '"')
+		e.long(int64(len(zone)))
+		e.buf = append(e.buf, zone...)
+		e.long(1)
+		e.symbol("E")
+		e.buf = append(e.buf, 'F')
+	}
+	return nil
+}
+
+// hash writes a map with string keys as a Hash with String keys, or Symbol
+// keys for RubySymbol keys, sorted.
+func (e *marshalEncoder) hash(v reflect.Value) error {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	e.buf = append(e.buf, '{')
+	e.long(int64(len(keys)))
+	for _, k := range keys {
+		if k.Type() == rubySymbolType {
+			e.symbol(k.String())
+		} else {
+			e.str(k.String())
+		}
+		if err := e.encode(v.MapIndex(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalJSONNumbers decodes JSON keeping its numbers as json.Number.
+func unmarshalJSONNumbers(b []byte, v interface{}) error {
+	return JsonMsgSerializer{UseNumber: true}.Unserialize(string(b), v)
+}
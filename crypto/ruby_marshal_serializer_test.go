@@ -0,0 +1,232 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestRubyMarshalSerializer(t *testing.T) {
+	g := Goblin(t)
+	serializer := RubyMarshalSerializer{}
+
+	// The fixtures were written by hand following Ruby 2.x's Marshal 4.8
+	// format, ie: Marshal.dump("foo") for "\x04\bI\"\bfoo\x06:\x06ET".
+	const session = "\x04\b{\aI\"\x0fsession_id\x06:\x06ETI\"\babc\x06;\x00TI\"\fuser_id\x06;\x00Ti/"
+
+	g.Describe("Ruby Marshal data", func() {
+		g.It("is read and written for every supported type", func() {
+			for _, tc := range []struct {
+				data  string
+				value interface{}
+			}{
+				{"\x04\b0", nil},
+				{"\x04\bT", true},
+				{"\x04\bF", false},
+				{"\x04\bi\x00", int64(0)},
+				{"\x04\bi/", int64(42)},
+				{"\x04\bi\xfa", int64(-1)},
+				{"\x04\bi\x02,\x01", int64(300)},
+				{"\x04\bi\xfe\xd4\xfe", int64(-300)},
+				{"\x04\bl+\a\x00\x00\x00@", int64(1 << 30)},
+				{"\x04\bl-\t\x00\x00\x00\x00\x00\x00\x00\x01", int64(-1 << 56)},
+				{"\x04\bI\"\bfoo\x06:\x06ET", "foo"},
+				{"\x04\b:\bfoo", RubySymbol("foo")},
+				{"\x04\b[\bi\x06:\x06a;\x00", []interface{}{int64(1), RubySymbol("a"), RubySymbol("a")}},
+				{session, map[string]interface{}{"session_id": "abc", "user_id": int64(42)}},
+				{"\x04\bC:-ActiveSupport::HashWithIndifferentAccess{\x06I\"\fflashes\x06:\x06ET[\x06I\"\ahi\x06;\x06T",
+					HashWithIndifferentAccess{"flashes": []interface{}{"hi"}}},
+			} {
+				var o interface{}
+				g.Assert(serializer.Unserialize(tc.data, &o)).Eql(nil)
+				g.Assert(o).Eql(tc.value)
+				output, err := serializer.Serialize(tc.value)
+				g.Assert(err).Eql(nil)
+				g.Assert(output).Eql(tc.data)
+			}
+		})
+
+		g.It("reads the strings whatever their encoding", func() {
+			for _, data := range []string{
+				"\x04\b\"\x06\xff",
+				"\x04\bI\"\x06a\x06:\x06EF",
+				"\x04\bI\"\x06a\x06:\rencoding\"\x0eShift_JIS",
+			} {
+				var o interface{}
+				g.Assert(serializer.Unserialize(data, &o)).Eql(nil)
+				g.Assert(len(o.(string))).Eql(1)
+			}
+		})
+
+		g.It("follows the object links", func() {
+			// a = "x"; [a, a]
+			var o interface{}
+			g.Assert(serializer.Unserialize("\x04\b[\aI\"\x06x\x06:\x06ET@\x06", &o)).Eql(nil)
+			g.Assert(o).Eql([]interface{}{"x", "x"})
+		})
+
+		g.It("fills structs", func() {
+			type Session struct {
+				ID     string `json:"session_id"`
+				UserID int    `json:"user_id"`
+			}
+			var o Session
+			g.Assert(serializer.Unserialize(session, &o)).Eql(nil)
+			g.Assert(o).Eql(Session{ID: "abc", UserID: 42})
+			output, err := serializer.Serialize(o)
+			g.Assert(err).Eql(nil)
+			g.Assert(output).Eql(session)
+		})
+
+		g.It("rejects the unsupported types", func() {
+			for data, class := range map[string]string{
+				"\x04\bo:\vObject\x00":                       "Object",
+				"\x04\bf\b1.5":                               "Float",
+				"\x04\bC:\bFoo{\x00":                         "Foo",
+				"\x04\b}\x00i\x00":                           "default",
+				"\x04\b{\x06i\x06i\x06":                      "Hash key",
+				"\x04\bI/\x06a\x00\x06:\x06EF":               "instance variables",
+				"\x04\bu:\tDate\x06a":                        "Date",
+				"\x04\bl+\t\x00\x00\x00\x00\x00\x00\x00\x80": "int64",
+			} {
+				var o interface{}
+				err := serializer.Unserialize(data, &o)
+				g.Assert(errors.Is(err, ErrUnsupportedMarshal)).IsTrue()
+				g.Assert(strings.Contains(err.Error(), class)).IsTrue()
+			}
+			_, err := serializer.Serialize(1.5)
+			g.Assert(errors.Is(err, ErrUnsupportedMarshal)).IsTrue()
+			_, err = serializer.Serialize(map[int]string{1: "a"})
+			g.Assert(errors.Is(err, ErrUnsupportedMarshal)).IsTrue()
+		})
+
+		g.It("rejects invalid data", func() {
+			for _, data := range []string{"", "\x04\x07T", "\x04\bI\"\bfo", "\x04\b[\a0", "\x04\bTT", "\x04\b@\x06", "\x04\b;\x00"} {
+				var o interface{}
+				g.Assert(serializer.Unserialize(data, &o) != nil).IsTrue()
+			}
+		})
+	})
+
+	g.Describe("Hashes with Symbol keys", func() {
+		// [{id: 1, name: "a", role: :admin}, {id: 2, name: "b", role: :admin}]
+		// with its repeated Symbols and E encoding written as links, computed
+		// following Marshal 4.8.
+		const users = "\x04\b[\a{\b:\aidi\x06:\tnameI\"\x06a\x06:\x06ET:\trole:\nadmin{\b;\x00i\a;\x06I\"\x06b\x06;\aT;\b;\t"
+		value := []interface{}{
+			map[RubySymbol]interface{}{"id": int64(1), "name": "a", "role": RubySymbol("admin")},
+			map[RubySymbol]interface{}{"id": int64(2), "name": "b", "role": RubySymbol("admin")},
+		}
+
+		g.It("round trip", func() {
+			var o interface{}
+			g.Assert(serializer.Unserialize(users, &o)).Eql(nil)
+			g.Assert(o).Eql(value)
+			output, err := serializer.Serialize(o)
+			g.Assert(err).Eql(nil)
+			g.Assert(output).Eql(users)
+		})
+
+		g.It("are written from maps with RubySymbol keys", func() {
+			type User struct {
+				ID   int        `json:"id"`
+				Name string     `json:"name"`
+				Role RubySymbol `json:"role"`
+			}
+			output, err := serializer.Serialize([]map[RubySymbol]interface{}{
+				{"id": 1, "name": "a", "role": RubySymbol("admin")},
+				{"id": 2, "name": "b", "role": RubySymbol("admin")},
+			})
+			g.Assert(err).Eql(nil)
+			g.Assert(output).Eql(users)
+
+			var o []User
+			g.Assert(serializer.Unserialize(users, &o)).Eql(nil)
+			g.Assert(o).Eql([]User{{1, "a", "admin"}, {2, "b", "admin"}})
+		})
+
+		g.It("are read with string keys when mixed", func() {
+			var o interface{}
+			g.Assert(serializer.Unserialize("\x04\b{\aI\"\x06a\x06:\x06ETi\x06:\x06bi\a", &o)).Eql(nil)
+			g.Assert(o).Eql(map[string]interface{}{"a": int64(1), "b": int64(2)})
+		})
+	})
+
+	g.Describe("Ruby Times", func() {
+		// computed following time_mdump in Ruby's time.c, for
+		// Time.utc(2024, 1, 2, 3, 4, 5.123r) and the JST time
+		// 2024-01-02 12:04:05.123456789 +09:00.
+		const utc = "\x04\bIu:\tTime\rC\x00\x1f\xc0x\xe0Q\x10\x06:\tzoneI\"\bUTC\x06:\x06EF"
+		const jst = "\x04\bIu:\tTime\rC\x00\x1f\x80@\xe2Q\x10\n:\rnano_numi\x02\x15\x03:\rnano_deni\x06:\rsubmicro\"\ax\x90:\voffseti\x02\x90~:\tzoneI\"\bJST\x06:\x06EF"
+		tokyo := time.FixedZone("JST", 9*3600)
+
+		g.It("are read and written", func() {
+			for data, value := range map[string]time.Time{
+				utc: time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC),
+				jst: time.Date(2024, 1, 2, 12, 4, 5, 123456789, tokyo),
+			} {
+				var o interface{}
+				g.Assert(serializer.Unserialize(data, &o)).Eql(nil)
+				g.Assert(o.(time.Time).Equal(value)).IsTrue()
+				name, offset := o.(time.Time).Zone()
+				expectedName, expectedOffset := value.Zone()
+				g.Assert([]interface{}{name, offset}).Eql([]interface{}{expectedName, expectedOffset})
+
+				output, err := serializer.Serialize(value)
+				g.Assert(err).Eql(nil)
+				g.Assert(output).Eql(data)
+				output, err = serializer.Serialize(RailsTime{value})
+				g.Assert(err).Eql(nil)
+				g.Assert(output).Eql(data)
+			}
+		})
+
+		g.It("fill time fields", func() {
+			type Session struct {
+				ExpiresAt RailsTime `json:"expires_at"`
+				SeenAt    time.Time `json:"seen_at"`
+			}
+			data, err := serializer.Serialize(map[string]interface{}{"expires_at": time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC), "seen_at": RailsTime{time.Date(2024, 1, 2, 3, 4, 5, 123456789, tokyo)}})
+			g.Assert(err).Eql(nil)
+			var o Session
+			g.Assert(serializer.Unserialize(data, &o)).Eql(nil)
+			g.Assert(o.ExpiresAt.Equal(time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC))).IsTrue()
+			g.Assert(o.SeenAt.Equal(time.Date(2024, 1, 2, 3, 4, 5, 123456789, tokyo))).IsTrue()
+		})
+
+		g.It("read unnamed offsets and the Ruby 1.8 format", func() {
+			var o interface{}
+			data := strings.Replace(jst, "I\"\bJST\x06:\x06EF", "0", 1)
+			g.Assert(serializer.Unserialize(data, &o)).Eql(nil)
+			_, offset := o.(time.Time).Zone()
+			g.Assert(offset).Eql(9 * 3600)
+			output, _ := serializer.Serialize(o.(time.Time))
+			g.Assert(output).Eql(data)
+
+			g.Assert(serializer.Unserialize("\x04\bu:\tTime\r\x00\x00\x00\x40\x40\xe2\x01\x00", &o)).Eql(nil)
+			g.Assert(o.(time.Time).Equal(time.Unix(1<<30, 123456000))).IsTrue()
+		})
+
+		g.It("must be in Ruby's dump range", func() {
+			_, err := serializer.Serialize(time.Time{})
+			g.Assert(errors.Is(err, ErrUnsupportedMarshal)).IsTrue()
+		})
+	})
+
+	g.Describe("a legacy Rails signed cookie", func() {
+		g.It("can be verified", func() {
+			// signed like a Rails 3 session cookie, with HMAC-SHA1.
+			v := &MessageVerifier{Secret: []byte("a-long-secret-token-from-config-initializers-secret-token-rb"), Serializer: serializer}
+			const cookie = "BAh7B0kiD3Nlc3Npb25faWQGOgZFVEkiCGFiYwY7AFRJIgx1c2VyX2lkBjsAVGkv--c3707cc9dea14a8ab65761d17b990d5399f09726"
+			var o map[string]interface{}
+			g.Assert(v.Verify(cookie, &o)).Eql(nil)
+			g.Assert(o["user_id"]).Eql(float64(42))
+			msg, err := v.Generate(map[string]interface{}{"session_id": "abc", "user_id": 42})
+			g.Assert(err).Eql(nil)
+			g.Assert(msg).Eql(cookie)
+		})
+	})
+}
@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"errors"
+	"hash"
+)
+
+// ChainSecret is a secret of a SecretChain, with an optional hasher
+// overriding the chain's one.
+type ChainSecret struct {
+	Secret []byte
+	Hasher func() hash.Hash
+}
+
+// SecretChain is an ordered list of secrets: the first one is the current
+// secret used to generate messages, the others are being phased out and
+// are only used to verify messages.
+//
+//	chain := SecretChain{
+//		Secrets:    []ChainSecret{{Secret: current, Hasher: sha256.New}, {Secret: old}},
+//		Serializer: JsonMsgSerializer{},
+//	}
+//	i, err := chain.Verify(msg, &data)
+type SecretChain struct {
+	Secrets []ChainSecret
+	// Hasher is used for the secrets without their own, defaults to sha1.
+	Hasher     func() hash.Hash
+	Serializer MsgSerializer
+}
+
+// Verifier returns a verifier for the current secret with the other
+// secrets set as its Rotations.
+func (c *SecretChain) Verifier() *MessageVerifier {
+	verifiers := c.Verifiers()
+	if len(verifiers) == 0 {
+		return &MessageVerifier{Hasher: c.Hasher, Serializer: c.Serializer}
+	}
+	v := verifiers[0]
+	v.Rotations = verifiers[1:]
+	return v
+}
+
+// Verifiers returns a verifier for each of the secrets, in order.
+func (c *SecretChain) Verifiers() []*MessageVerifier {
+	verifiers := make([]*MessageVerifier, len(c.Secrets))
+	for i := range c.Secrets {
+		v := c.verifier(i)
+		verifiers[i] = &v
+	}
+	return verifiers
+}
+
+func (c *SecretChain) verifier(i int) MessageVerifier {
+	s := c.Secrets[i]
+	v := MessageVerifier{Secret: s.Secret, Hasher: s.Hasher, Serializer: c.Serializer}
+	if v.Hasher == nil {
+		v.Hasher = c.Hasher
+	}
+	return v
+}
+
+// Generate generates a message using the current secret, see
+// MessageVerifier.Generate.
+func (c *SecretChain) Generate(value interface{}) (string, error) {
+	if len(c.Secrets) == 0 {
+		return "", ErrNoSecret
+	}
+	v := c.verifier(0)
+	return v.Generate(value)
+}
+
+// Verify verifies the message with each secret in order, until one of them
+// matches its signature, and returns the index of that secret (0 being the
+// current one) so the use of old secrets can be tracked. The index is -1
+// when none matched, see MessageVerifier.Verify for the errors.
+func (c *SecretChain) Verify(msg string, target interface{}) (int, error) {
+	if len(c.Secrets) == 0 {
+		return -1, ErrNoSecret
+	}
+	var err error
+	for i := range c.Secrets {
+		v := c.verifier(i)
+		if err = v.checkInit(); err != nil {
+			return -1, err
+		}
+		var data []byte
+		data, err = v.verifiedData(nil, msg)
+		if errors.Is(err, ErrInvalidSignature) {
+			continue
+		}
+		if err != nil {
+			return -1, err
+		}
+		return i, v.decode(data, target, MessageOptions{}, v.now())
+	}
+	return -1, err
+}
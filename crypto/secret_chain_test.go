@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestSecretChain(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("SecretChain", func() {
+		current := []byte("Hey, I'm a secret!")
+		old := []byte("Hey, I'm an old secret!")
+		older := []byte("Hey, I'm an older secret!")
+		chain := &SecretChain{
+			Secrets: []ChainSecret{
+				{Secret: current, Hasher: sha256.New},
+				{Secret: old},
+				{Secret: older},
+			},
+			Serializer: JsonMsgSerializer{},
+		}
+		data := testStruct{Foo: "foo", Bar: 42}
+
+		g.It("generates messages with the current secret", func() {
+			msg, err := chain.Generate(data)
+			g.Assert(err).Eql(nil)
+			expected, _ := (&MessageVerifier{Secret: current, Hasher: sha256.New, Serializer: JsonMsgSerializer{}}).Generate(data)
+			g.Assert(msg).Eql(expected)
+		})
+
+		g.It("matches the current secret", func() {
+			msg, _ := chain.Generate(data)
+			var verified testStruct
+			i, err := chain.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(i).Eql(0)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("matches the old secrets", func() {
+			for i, secret := range [][]byte{old, older} {
+				msg, _ := (&MessageVerifier{Secret: secret, Serializer: JsonMsgSerializer{}}).Generate(data)
+				var verified testStruct
+				matched, err := chain.Verify(msg, &verified)
+				g.Assert(err).Eql(nil)
+				g.Assert(matched).Eql(i + 1)
+				g.Assert(verified).Eql(data)
+			}
+		})
+
+		g.It("uses the per secret hasher", func() {
+			msg, _ := (&MessageVerifier{Secret: old, Hasher: sha256.New, Serializer: JsonMsgSerializer{}}).Generate(data)
+			var verified testStruct
+			i, err := chain.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+			g.Assert(i).Eql(-1)
+
+			withHasher := &SecretChain{Secrets: []ChainSecret{{Secret: current}, {Secret: old}}, Hasher: sha256.New, Serializer: JsonMsgSerializer{}}
+			i, err = withHasher.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(i).Eql(1)
+		})
+
+		g.It("reports messages matching no secret", func() {
+			msg, _ := (&MessageVerifier{Secret: []byte("Hey, I'm unknown!"), Serializer: JsonMsgSerializer{}}).Generate(data)
+			var verified testStruct
+			i, err := chain.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+			g.Assert(i).Eql(-1)
+			i, err = chain.Verify("garbage", &verified)
+			g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+			g.Assert(i).Eql(-1)
+		})
+
+		g.It("reports the matching secret of messages failing after their signature", func() {
+			v := &MessageVerifier{Secret: old, Serializer: JsonMsgSerializer{}}
+			msg, _ := v.GenerateWithOptions(data, MessageOptions{Purpose: "login"})
+			var verified testStruct
+			i, err := chain.Verify(msg, &verified)
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+			g.Assert(i).Eql(1)
+		})
+
+		g.It("builds the matching verifiers", func() {
+			verifiers := chain.Verifiers()
+			g.Assert(len(verifiers)).Eql(3)
+			g.Assert(verifiers[2].Secret).Eql(older)
+
+			v := chain.Verifier()
+			g.Assert(v.Secret).Eql(current)
+			g.Assert(len(v.Rotations)).Eql(2)
+			msg, _ := verifiers[2].Generate(data)
+			var verified testStruct
+			g.Assert(v.Verify(msg, &verified)).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("needs a secret", func() {
+			empty := &SecretChain{Serializer: JsonMsgSerializer{}}
+			_, err := empty.Generate(data)
+			g.Assert(err).Eql(ErrNoSecret)
+			i, err := empty.Verify("foo--bar", &data)
+			g.Assert(err).Eql(ErrNoSecret)
+			g.Assert(i).Eql(-1)
+		})
+	})
+}
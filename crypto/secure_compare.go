@@ -0,0 +1,23 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// SecureCompare reports whether a and b are equal in constant time, like
+// ActiveSupport::SecurityUtils.secure_compare, to compare secrets such as
+// API keys or webhook signatures without leaking them through timing.
+// Like Rails 5.2 to 6.0 both are hashed with SHA-256 first, so the
+// comparison doesn't leak their lengths either.
+func SecureCompare(a, b string) bool {
+	return SecureCompareBytes([]byte(a), []byte(b))
+}
+
+// SecureCompareBytes is SecureCompare for byte slices.
+func SecureCompareBytes(a, b []byte) bool {
+	ha, hb := sha256.Sum256(a), sha256.Sum256(b)
+	// the inputs themselves are compared too, since they only get there
+	// with the same digest they have the same length.
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1 && subtle.ConstantTimeCompare(a, b) == 1
+}
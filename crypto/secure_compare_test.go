@@ -0,0 +1,31 @@
+package crypto
+
+import (
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestSecureCompare(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("SecureCompare", func() {
+		g.It("accepts equal inputs", func() {
+			g.Assert(SecureCompare("sk_live_4f8a2c", "sk_live_4f8a2c")).IsTrue()
+			g.Assert(SecureCompare("", "")).IsTrue()
+			g.Assert(SecureCompareBytes([]byte{0, 1, 2}, []byte{0, 1, 2})).IsTrue()
+			g.Assert(SecureCompareBytes(nil, []byte{})).IsTrue()
+		})
+
+		g.It("rejects unequal inputs of the same length", func() {
+			g.Assert(SecureCompare("sk_live_4f8a2c", "sk_live_4f8a2d")).IsFalse()
+			g.Assert(SecureCompareBytes([]byte{0, 1, 2}, []byte{0, 1, 3})).IsFalse()
+		})
+
+		g.It("rejects unequal inputs of different lengths", func() {
+			g.Assert(SecureCompare("sk_live_4f8a2c", "sk_live_4f8a2")).IsFalse()
+			g.Assert(SecureCompare("", "a")).IsFalse()
+			g.Assert(SecureCompareBytes([]byte{0, 1, 2}, []byte{0, 1, 2, 0})).IsFalse()
+		})
+	})
+}
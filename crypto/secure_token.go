@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// SecureTokenLength is the length of the tokens of Rails' has_secure_token,
+// and the minimum it accepts since Rails 6.1.
+const SecureTokenLength = 24
+
+// ErrSecureTokenLength is returned by SecureToken for lengths under
+// SecureTokenLength, like has_secure_token's MinimumLengthError.
+var ErrSecureTokenLength = errors.New("Token too short")
+
+// SecureToken returns a random base58 token like Rails' has_secure_token
+// generates (SecureRandom.base58), of SecureTokenLength characters if length
+// is 0. Shorter lengths are rejected like Rails does.
+func SecureToken(length int) (string, error) {
+	if length == 0 {
+		length = SecureTokenLength
+	}
+	if length < SecureTokenLength {
+		return "", &messageError{msg: "Token too short - " + strconv.Itoa(length) + " characters, the minimum is " + strconv.Itoa(SecureTokenLength), kind: ErrSecureTokenLength}
+	}
+	return RandomBase58(length)
+}
+
+// SignedToken signs a token (ie: one of SecureToken) for the passed purpose
+// so it can travel through emails or URLs and expire after ttl, if not 0.
+func SignedToken(v *MessageVerifier, token, purpose string, ttl time.Duration) (string, error) {
+	return v.GenerateWithOptions(token, MessageOptions{Purpose: purpose, ExpiresIn: ttl})
+}
+
+// VerifySignedToken returns the token signed by SignedToken for the passed
+// purpose. It returns ErrInvalidPurpose for tokens signed for another
+// purpose and ErrMessageExpired for expired ones.
+func VerifySignedToken(v *MessageVerifier, signed, purpose string) (string, error) {
+	var token string
+	if err := v.VerifyWithOptions(signed, &token, MessageOptions{Purpose: purpose}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestSecureToken(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("SecureToken", func() {
+		g.It("generates base58 tokens of 24 characters by default", func() {
+			token, err := SecureToken(0)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(token)).Eql(24)
+			for _, c := range token {
+				g.Assert(strings.ContainsRune(base58Alphabet, c)).IsTrue()
+			}
+			other, _ := SecureToken(0)
+			g.Assert(other == token).IsFalse()
+		})
+
+		g.It("generates longer tokens", func() {
+			token, err := SecureToken(36)
+			g.Assert(err).Eql(nil)
+			g.Assert(len(token)).Eql(36)
+		})
+
+		g.It("rejects lengths under 24", func() {
+			for _, n := range []int{-1, 1, 23} {
+				_, err := SecureToken(n)
+				g.Assert(errors.Is(err, ErrSecureTokenLength)).IsTrue()
+			}
+		})
+	})
+
+	g.Describe("Signed tokens", func() {
+		now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		v := &MessageVerifier{Secret: []byte("secure token secret"), Serializer: JsonMsgSerializer{}, Now: func() time.Time { return now }}
+		token, _ := SecureToken(0)
+
+		g.It("round trip", func() {
+			signed, err := SignedToken(v, token, "email_confirmation", time.Hour)
+			g.Assert(err).Eql(nil)
+			got, err := VerifySignedToken(v, signed, "email_confirmation")
+			g.Assert(err).Eql(nil)
+			g.Assert(got).Eql(token)
+		})
+
+		g.It("are bound to their purpose", func() {
+			signed, _ := SignedToken(v, token, "email_confirmation", 0)
+			_, err := VerifySignedToken(v, signed, "password_reset")
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+		})
+
+		g.It("expire", func() {
+			signed, _ := SignedToken(v, token, "email_confirmation", time.Hour)
+			later := &MessageVerifier{Secret: v.Secret, Serializer: v.Serializer, Now: func() time.Time { return now.Add(2 * time.Hour) }}
+			_, err := VerifySignedToken(later, signed, "email_confirmation")
+			g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+		})
+
+		g.It("reject tampered tokens", func() {
+			signed, _ := SignedToken(v, token, "email_confirmation", 0)
+			_, err := VerifySignedToken(v, signed+"0", "email_confirmation")
+			g.Assert(err).Eql(ErrInvalidSignature)
+		})
+	})
+}
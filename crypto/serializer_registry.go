@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownSerializer is returned when unserializing data tagged with the
+// name of a serializer which isn't registered.
+var ErrUnknownSerializer = errors.New("Unknown serializer")
+
+// serializerTag starts the data of a TaggedSerializer, followed by the name
+// of the serializer and a colon. Text serializers never produce a NUL byte
+// so the untagged data can be told apart, unlike binary ones.
+const serializerTag = "\x00"
+
+var (
+	serializersMu sync.RWMutex
+	serializers   = map[string]MsgSerializer{
+		"json":    JsonMsgSerializer{},
+		"xml":     XMLMsgSerializer{},
+		"null":    NullMsgSerializer{},
+		"msgpack": MsgpackMsgSerializer{},
+		"gob":     GobMsgSerializer{},
+		"marshal": RubyMarshalSerializer{},
+		"cbor":    CborMsgSerializer{},
+		"proto":   ProtoMsgSerializer{},
+		"yaml":    YamlMsgSerializer{},
+		"raw":     RawMsgSerializer{},
+	}
+)
+
+// RegisterSerializer makes a serializer available under name to the
+// TaggedSerializers and to the versioned messages of MessageEncryptor. The
+// built-in serializers are registered under the names serializerName gives
+// them ("json", "xml", "msgpack"...).
+// It panics if the name is already taken, empty or contains a colon, or if
+// s is nil, it's meant to be called from init functions.
+func RegisterSerializer(name string, s MsgSerializer) {
+	if name == "" || strings.ContainsAny(name, ":"+serializerTag) {
+		panic("crypto: invalid serializer name " + strconv.Quote(name))
+	}
+	if s == nil {
+		panic("crypto: RegisterSerializer serializer is nil")
+	}
+	serializersMu.Lock()
+	defer serializersMu.Unlock()
+	if _, dup := serializers[name]; dup {
+		panic("crypto: RegisterSerializer called twice for serializer " + name)
+	}
+	serializers[name] = s
+}
+
+// lookupSerializer returns the serializer registered under name, nil if
+// there's none.
+func lookupSerializer(name string) MsgSerializer {
+	serializersMu.RLock()
+	defer serializersMu.RUnlock()
+	return serializers[name]
+}
+
+// TaggedSerializer prefixes the data it serializes with the name of the
+// serializer used, and unserializes data with the registered serializer it
+// is tagged with. Since the tag is part of the data it's signed or encrypted
+// along with it, and the serializer of an app can be changed without
+// rotating its keys or breaking its messages:
+//
+//	// the messages were serialized with XML until now.
+//	Serializer: TaggedSerializer{Name: "json", Untagged: XMLMsgSerializer{}}
+//
+// Rails can't read tagged data.
+type TaggedSerializer struct {
+	// Name is the name the serializer to serialize with is registered
+	// under.
+	Name string
+	// Untagged unserializes the data without a tag, the data generated
+	// before tagging was enabled. It defaults to the serializer named by
+	// Name. It must be a text serializer (ie: JSON, XML or YAML): binary
+	// data may start with the tag, so the built-in binary serializers
+	// (msgpack, CBOR, gob, Marshal, protobuf and raw) are rejected with
+	// ErrInvalidConfig when reading untagged data.
+	Untagged MsgSerializer
+}
+
+func (s TaggedSerializer) Serialize(v interface{}) (string, error) {
+	serializer := lookupSerializer(s.Name)
+	if serializer == nil {
+		return "", configError("unknown serializer " + strconv.Quote(s.Name))
+	}
+	data, err := serializeWith(serializer, v)
+	if err != nil {
+		return "", err
+	}
+	return serializerTag + s.Name + ":" + string(data), nil
+}
+
+func (s TaggedSerializer) Unserialize(data string, v interface{}) error {
+	if !strings.HasPrefix(data, serializerTag) {
+		serializer := s.Untagged
+		if serializer == nil {
+			if serializer = lookupSerializer(s.Name); serializer == nil {
+				return configError("unknown serializer " + strconv.Quote(s.Name))
+			}
+		}
+		if binarySerializer(serializer) {
+			return configError("binary serializer " + describeSerializer(serializer) + " can't read untagged data")
+		}
+		return serializer.Unserialize(data, v)
+	}
+	tagged := data[len(serializerTag):]
+	i := strings.IndexByte(tagged, ':')
+	if i < 0 {
+		return &messageError{msg: "Unknown serializer - missing tag", kind: ErrUnknownSerializer}
+	}
+	serializer := lookupSerializer(tagged[:i])
+	if serializer == nil {
+		return &messageError{msg: "Unknown serializer " + strconv.Quote(tagged[:i]), kind: ErrUnknownSerializer}
+	}
+	return serializer.Unserialize(tagged[i+1:], v)
+}
+
+// binarySerializer reports whether s is one of the built-in serializers
+// whose data may start with a NUL byte, and so with the tag of a
+// TaggedSerializer.
+func binarySerializer(s MsgSerializer) bool {
+	switch serializerName(s) {
+	case "msgpack", "cbor", "gob", "marshal", "proto", "raw":
+		return true
+	}
+	return false
+}
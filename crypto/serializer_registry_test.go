@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+// upperSerializer is a custom serializer storing strings in upper case.
+type upperSerializer struct{}
+
+func (upperSerializer) Serialize(v interface{}) (string, error) {
+	return strings.ToUpper(v.(string)), nil
+}
+
+func (upperSerializer) Unserialize(data string, v interface{}) error {
+	*v.(*string) = strings.ToLower(data)
+	return nil
+}
+
+// registerTestSerializer registers s under name for the duration of a test.
+func registerTestSerializer(name string, s MsgSerializer) (unregister func()) {
+	RegisterSerializer(name, s)
+	return func() {
+		serializersMu.Lock()
+		defer serializersMu.Unlock()
+		delete(serializers, name)
+	}
+}
+
+func TestTaggedSerializer(t *testing.T) {
+	g := Goblin(t)
+
+	type Token struct {
+		UserId int    `json:"user_id" xml:"user_id"`
+		Scope  string `json:"scope" xml:"scope"`
+	}
+	data := Token{UserId: 42, Scope: "read"}
+
+	g.Describe("a tagged serializer", func() {
+		verifier := func(s MsgSerializer) *MessageVerifier {
+			return &MessageVerifier{Secret: []byte(strings.Repeat("s", 64)), Serializer: s}
+		}
+		migrated := verifier(TaggedSerializer{Name: "json", Untagged: XMLMsgSerializer{}})
+
+		g.It("tags JSON data", func() {
+			output, err := TaggedSerializer{Name: "json"}.Serialize(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(output).Eql("\x00json:" + `{"user_id":42,"scope":"read"}`)
+
+			msg, err := migrated.Generate(data)
+			g.Assert(err).Eql(nil)
+			var o Token
+			g.Assert(migrated.Verify(msg, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+		})
+
+		g.It("reads tagged XML data", func() {
+			msg, err := verifier(TaggedSerializer{Name: "xml"}).Generate(data)
+			g.Assert(err).Eql(nil)
+			var o Token
+			g.Assert(migrated.Verify(msg, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+		})
+
+		g.It("reads untagged data with the Untagged serializer", func() {
+			msg, err := verifier(XMLMsgSerializer{}).Generate(data)
+			g.Assert(err).Eql(nil)
+			var o Token
+			g.Assert(migrated.Verify(msg, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+
+			msg, _ = verifier(JsonMsgSerializer{}).Generate(data)
+			o = Token{}
+			g.Assert(verifier(TaggedSerializer{Name: "json"}).Verify(msg, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+		})
+
+		g.It("uses the registered serializers", func() {
+			defer registerTestSerializer("tagged-serializer-test-upper", upperSerializer{})()
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM, Serializer: TaggedSerializer{Name: "tagged-serializer-test-upper"}}
+			msg, err := e.EncryptAndSign("hello")
+			g.Assert(err).Eql(nil)
+			var o string
+			g.Assert(e.DecryptAndVerify(msg, &o)).Eql(nil)
+			g.Assert(o).Eql("hello")
+		})
+
+		g.It("rejects unknown tags", func() {
+			var o Token
+			msg, _ := verifier(NullMsgSerializer{}).Generate("\x00toml:scope = 'read'")
+			err := migrated.Verify(msg, &o)
+			g.Assert(errors.Is(err, ErrUnknownSerializer)).IsTrue()
+			g.Assert(strings.Contains(err.Error(), `Unknown serializer "toml"`)).IsTrue()
+
+			err = TaggedSerializer{Name: "json"}.Unserialize("\x00json", &o)
+			g.Assert(errors.Is(err, ErrUnknownSerializer)).IsTrue()
+
+			_, err = TaggedSerializer{Name: "toml"}.Serialize(data)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+
+		g.It("rejects binary serializers for untagged data", func() {
+			// msgpack serializes 0 as a NUL byte, the start of the tag.
+			msg, _ := verifier(MsgpackMsgSerializer{}).Generate(0)
+			var o int
+			for _, s := range []TaggedSerializer{{Name: "json", Untagged: MsgpackMsgSerializer{}}, {Name: "cbor"}} {
+				err := verifier(s).Verify(msg, &o)
+				g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			}
+			msg, _ = verifier(MsgpackMsgSerializer{}).Generate(42)
+			g.Assert(errors.Is(verifier(TaggedSerializer{Name: "json", Untagged: &RawMsgSerializer{}}).Verify(msg, &o), ErrInvalidConfig)).IsTrue()
+
+			// tagged binary data is read regardless.
+			msg, _ = verifier(TaggedSerializer{Name: "msgpack"}).Generate(0)
+			o = -1
+			g.Assert(verifier(TaggedSerializer{Name: "msgpack"}).Verify(msg, &o)).Eql(nil)
+			g.Assert(o).Eql(0)
+		})
+	})
+
+	g.Describe("RegisterSerializer", func() {
+		g.It("rejects duplicate and invalid names", func() {
+			defer registerTestSerializer("tagged-serializer-test-dup", upperSerializer{})()
+			for _, name := range []string{"json", "tagged-serializer-test-dup", "", "a:b", "a\x00"} {
+				func() {
+					defer func() { g.Assert(recover() != nil).IsTrue() }()
+					RegisterSerializer(name, JsonMsgSerializer{})
+				}()
+			}
+			func() {
+				defer func() { g.Assert(recover() != nil).IsTrue() }()
+				RegisterSerializer("nil", nil)
+			}()
+		})
+	})
+}
@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// SessionHash is a Rails session, or any Hash, to verify or decrypt
+// messages into when their schema isn't known in advance. Like a
+// HashWithIndifferentAccess it doesn't tell String and Symbol keys apart:
+// session["user_id"] and session[:user_id] are both read as "user_id" from
+// Marshal data, the last one winning if a Hash has both.
+//
+// Numbers are decoded from JSON as json.Number so large ids don't lose
+// precision, and the getters convert the values of the supported formats.
+type SessionHash map[string]interface{}
+
+func (h *SessionHash) UnmarshalJSON(data []byte) error {
+	var m map[string]interface{}
+	if err := unmarshalJSONNumbers(data, &m); err != nil {
+		return err
+	}
+	*h = m
+	return nil
+}
+
+// GetString returns the value of key if it's a string.
+func (h SessionHash) GetString(key string) (string, bool) {
+	s, ok := h[key].(string)
+	return s, ok
+}
+
+// GetInt returns the value of key if it's an integer.
+func (h SessionHash) GetInt(key string) (int64, bool) {
+	return sessionInt(h[key])
+}
+
+// GetTime returns the value of key if it's a time, which JSON holds as a
+// string in one of the formats ParseRailsTime accepts.
+func (h SessionHash) GetTime(key string) (time.Time, bool) {
+	switch v := h[key].(type) {
+	case time.Time:
+		return v, true
+	case RailsTime:
+		return v.Time, true
+	case string:
+		t, err := ParseRailsTime(v)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// Dig returns the value nested under path like Ruby's Hash#dig, the
+// elements of path being string keys of nested hashes or int indexes of
+// nested arrays. For instance the id of the user Devise signed in is:
+//
+//	session.Dig("warden.user.user.key", 0, 0)
+func (h SessionHash) Dig(path ...interface{}) (interface{}, bool) {
+	var v interface{} = h
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			var hash map[string]interface{}
+			switch nested := v.(type) {
+			case SessionHash:
+				hash = nested
+			case map[string]interface{}:
+				hash = nested
+			case HashWithIndifferentAccess:
+				hash = nested
+			case map[RubySymbol]interface{}:
+				var ok bool
+				if v, ok = nested[RubySymbol(step)]; !ok {
+					return nil, false
+				}
+				continue
+			default:
+				return nil, false
+			}
+			var ok bool
+			if v, ok = hash[step]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := v.([]interface{})
+			if step < 0 {
+				step += len(array)
+			}
+			if !ok || step < 0 || step >= len(array) {
+				return nil, false
+			}
+			v = array[step]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// DigInt returns the integer nested under path, see Dig.
+func (h SessionHash) DigInt(path ...interface{}) (int64, bool) {
+	v, ok := h.Dig(path...)
+	if !ok {
+		return 0, false
+	}
+	return sessionInt(v)
+}
+
+// sessionInt returns v if it's an integer, of any of the types JSON and
+// Marshal decode them as.
+func sessionInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case json.Number:
+		i, err := v.Int64()
+		return i, err == nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestSessionHash(t *testing.T) {
+	g := Goblin(t)
+
+	// a Devise session after signing in, with a flash message, as the JSON
+	// cookies serializer and Marshal write it. The Marshal data also has a
+	// user_id Symbol key.
+	const sessionJSON = `{"session_id":"4f1c8a0e2b7d9e35","warden.user.user.key":[[1],"$2a$12$Qm9vYmFyYmF6cXV4cXV1"],` +
+		`"user_id":1,"flash":{"discard":[],"flashes":{"notice":"Signed in successfully."}},` +
+		`"_csrf_token":"c2VjcmV0LWNzcmYtdG9rZW4tdmFsdWU=","last_request_at":"2024-01-02T03:04:05.123Z","big_id":9007199254740993}`
+	const sessionMarshal = "\x04\b{\nI\"\x0fsession_id\x06:\x06ETI\"\x154f1c8a0e2b7d9e35\x06;\x00TI\"\x19warden.user.user.key\x06;\x00T[\a[\x06i\x06I\" $2a$12$Qm9vYmFyYmF6cXV4cXV1\x06;\x00T" +
+		":\fuser_idi\x06I\"\nflash\x06;\x00T{\aI\"\fdiscard\x06;\x00T[\x00I\"\fflashes\x06;\x00T{\x06I\"\vnotice\x06;\x00TI\"\x1cSigned in successfully.\x06;\x00T" +
+		"I\"\x10_csrf_token\x06;\x00TI\"%c2VjcmV0LWNzcmYtdG9rZW4tdmFsdWU=\x06;\x00T"
+
+	check := func(session SessionHash) {
+		id, ok := session.GetString("session_id")
+		g.Assert(ok).IsTrue()
+		g.Assert(id).Eql("4f1c8a0e2b7d9e35")
+		token, _ := session.GetString("_csrf_token")
+		g.Assert(token).Eql("c2VjcmV0LWNzcmYtdG9rZW4tdmFsdWU=")
+		userID, ok := session.GetInt("user_id")
+		g.Assert(ok).IsTrue()
+		g.Assert(userID).Eql(int64(1))
+
+		userID, ok = session.DigInt("warden.user.user.key", 0, 0)
+		g.Assert(ok).IsTrue()
+		g.Assert(userID).Eql(int64(1))
+		salt, ok := session.Dig("warden.user.user.key", -1)
+		g.Assert(ok).IsTrue()
+		g.Assert(salt).Eql("$2a$12$Qm9vYmFyYmF6cXV4cXV1")
+		notice, ok := session.Dig("flash", "flashes", "notice")
+		g.Assert(ok).IsTrue()
+		g.Assert(notice).Eql("Signed in successfully.")
+		discard, _ := session.Dig("flash", "discard")
+		g.Assert(discard).Eql([]interface{}{})
+	}
+
+	g.Describe("a session hash", func() {
+		g.It("is read from JSON", func() {
+			var session SessionHash
+			g.Assert(json.Unmarshal([]byte(sessionJSON), &session)).Eql(nil)
+			check(session)
+			at, ok := session.GetTime("last_request_at")
+			g.Assert(ok).IsTrue()
+			g.Assert(at.Equal(time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC))).IsTrue()
+			id, _ := session.GetInt("big_id")
+			g.Assert(id).Eql(int64(9007199254740993))
+		})
+
+		g.It("is read from Marshal, Symbol keys included", func() {
+			var session SessionHash
+			g.Assert(RubyMarshalSerializer{}.Unserialize(sessionMarshal, &session)).Eql(nil)
+			check(session)
+
+			hwia := "\x04\bC:-ActiveSupport::HashWithIndifferentAccess{\x06:\fuser_idi/"
+			g.Assert(RubyMarshalSerializer{}.Unserialize(hwia, &session)).Eql(nil)
+			g.Assert(session).Eql(SessionHash{"user_id": int64(42)})
+
+			symbols := "\x04\b{\a:\fuser_idi/:\nflash{\x06:\vnoticeI\"\aok\x06:\x06ET"
+			g.Assert(RubyMarshalSerializer{}.Unserialize(symbols, &session)).Eql(nil)
+			userID, _ := session.GetInt("user_id")
+			g.Assert(userID).Eql(int64(42))
+			notice, _ := session.Dig("flash", "notice")
+			g.Assert(notice).Eql("ok")
+		})
+
+		g.It("is the target of Verify and DecryptAndVerify", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: JsonMsgSerializer{}}
+			msg, _ := v.Generate(json.RawMessage(sessionJSON))
+			var session SessionHash
+			g.Assert(v.Verify(msg, &session)).Eql(nil)
+			check(session)
+
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM, Serializer: RubyMarshalSerializer{}}
+			msg, err := e.EncryptAndSign(SessionHash{"user_id": 1, "at": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)})
+			g.Assert(err).Eql(nil)
+			session = nil
+			g.Assert(e.DecryptAndVerify(msg, &session)).Eql(nil)
+			id, _ := session.GetInt("user_id")
+			g.Assert(id).Eql(int64(1))
+			at, _ := session.GetTime("at")
+			g.Assert(at).Eql(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+		})
+
+		g.It("reports missing and mistyped values", func() {
+			var session SessionHash
+			g.Assert(json.Unmarshal([]byte(sessionJSON), &session)).Eql(nil)
+			_, ok := session.GetString("user_id")
+			g.Assert(ok).IsFalse()
+			_, ok = session.GetInt("session_id")
+			g.Assert(ok).IsFalse()
+			_, ok = session.GetTime("session_id")
+			g.Assert(ok).IsFalse()
+			for _, path := range [][]interface{}{{"missing"}, {"session_id", "x"}, {"warden.user.user.key", 2}, {"flash", 0}, {1.5}} {
+				_, ok = session.Dig(path...)
+				g.Assert(ok).IsFalse()
+			}
+			_, ok = session.DigInt("flash", "flashes", "notice")
+			g.Assert(ok).IsFalse()
+		})
+	})
+}
@@ -0,0 +1,134 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+// SignedGlobalIDs of gid://bcx/Person/5 as the globalid gem generates them
+// for sessionFixtureSecretKeyBase's app (Marshal serializer, Rails metadata),
+// computed following its algorithm with python.
+const (
+	// expiring at 2030-01-01T00:00:00.000Z, for the default purpose.
+	sgidFixture = "eyJfcmFpbHMiOnsibWVzc2FnZSI6IkJBaEpJaGRuYVdRNkx5OWlZM2d2VUdWeWMyOXVMelVHT2daRlZBPT0iLCJleHAiOiIyMDMwLTAxLTAxVDAwOjAwOjAwLjAwMFoiLCJwdXIiOiJkZWZhdWx0In19--d42926191f9e1fb6c0a79c8444251ed7e84127b6"
+	// never expiring, for the login purpose.
+	sgidLoginFixture = "eyJfcmFpbHMiOnsibWVzc2FnZSI6IkJBaEpJaGRuYVdRNkx5OWlZM2d2VUdWeWMyOXVMelVHT2daRlZBPT0iLCJleHAiOm51bGwsInB1ciI6ImxvZ2luIn19--c2b9441f1e1457b80a4254c07127ecdc0931d144"
+	// never expiring, for the login purpose, in a Rails 7.0 app deriving
+	// its keys with SHA-256.
+	sgidSHA256KeyFixture = "eyJfcmFpbHMiOnsibWVzc2FnZSI6IkJBaEpJaGRuYVdRNkx5OWlZM2d2VUdWeWMyOXVMelVHT2daRlZBPT0iLCJleHAiOm51bGwsInB1ciI6ImxvZ2luIn19--66035e9a50169d6d264ddaed0219d18abe0a62f4"
+	// expired on 2020-01-01T00:00:00.000Z.
+	sgidExpiredFixture = "eyJfcmFpbHMiOnsibWVzc2FnZSI6IkJBaEpJaGRuYVdRNkx5OWlZM2d2VUdWeWMyOXVMelVHT2daRlZBPT0iLCJleHAiOiIyMDIwLTAxLTAxVDAwOjAwOjAwLjAwMFoiLCJwdXIiOiJkZWZhdWx0In19--e83ae6e7591a1e5e74568ab4af150a95be7349f0"
+)
+
+func TestSignedGlobalID(t *testing.T) {
+	g := Goblin(t)
+	person := GlobalID{App: "bcx", ModelName: "Person", ModelID: "5"}
+
+	g.Describe("GlobalID", func() {
+		g.It("parses gid URIs", func() {
+			gid, err := ParseGlobalID("gid://bcx/Person/5")
+			g.Assert(err).Eql(nil)
+			g.Assert(gid).Eql(person)
+			g.Assert(gid.String()).Eql("gid://bcx/Person/5")
+
+			gid, err = ParseGlobalID("gid://bcx/Admin::User/a%20b?tenant=1")
+			g.Assert(err).Eql(nil)
+			g.Assert(gid.ModelName).Eql("Admin::User")
+			g.Assert(gid.ModelID).Eql("a b")
+			g.Assert(gid.Params).Eql(url.Values{"tenant": {"1"}})
+			g.Assert(gid.String()).Eql("gid://bcx/Admin::User/a%20b?tenant=1")
+		})
+
+		g.It("rejects invalid URIs", func() {
+			for _, s := range []string{"", "bcx/Person/5", "http://bcx/Person/5", "gid:///Person/5", "gid://bcx/Person", "gid://bcx/Person/", "gid://bcx//5", "gid://bcx/Person/5/6", "gid://u@bcx/Person/5", "gid://bcx:80/Person/5", "gid://b cx/Person/5"} {
+				_, err := ParseGlobalID(s)
+				g.Assert(errors.Is(err, ErrInvalidGlobalID)).IsTrue()
+			}
+		})
+	})
+
+	g.Describe("SignedGlobalID", func() {
+		verifier, _ := SignedGlobalIDVerifier(sessionFixtureSecretKeyBase)
+		sgid := SignedGlobalID{Verifier: verifier}
+
+		g.It("parses the tokens of the globalid gem", func() {
+			gid, err := sgid.Parse(sgidFixture, "")
+			g.Assert(err).Eql(nil)
+			g.Assert(gid).Eql(person)
+			gid, err = sgid.Parse(sgidLoginFixture, "login")
+			g.Assert(err).Eql(nil)
+			g.Assert(gid).Eql(person)
+		})
+
+		g.It("rejects the tokens of other purposes", func() {
+			_, err := sgid.Parse(sgidFixture, "login")
+			g.Assert(err).Eql(ErrInvalidPurpose)
+			_, err = sgid.Parse(sgidLoginFixture, "")
+			g.Assert(err).Eql(ErrInvalidPurpose)
+		})
+
+		g.It("rejects expired or tampered tokens", func() {
+			_, err := sgid.Parse(sgidExpiredFixture, "")
+			g.Assert(err).Eql(ErrMessageExpired)
+			_, err = sgid.Parse("x"+sgidFixture, "")
+			g.Assert(err).Eql(ErrInvalidSignature)
+		})
+
+		g.It("generates the tokens of the globalid gem", func() {
+			v, err := SignedGlobalIDVerifier(sessionFixtureSecretKeyBase)
+			g.Assert(err).Eql(nil)
+			v.Now = func() time.Time { return time.Date(2029, 12, 1, 0, 0, 0, 0, time.UTC) }
+			s := SignedGlobalID{Verifier: v}
+			token, err := s.Sign("bcx", "Person", 5, "", 0)
+			g.Assert(err).Eql(nil)
+			g.Assert(token).Eql(sgidFixture)
+			token, _ = s.Sign("bcx", "Person", "5", "login", -1)
+			g.Assert(token).Eql(sgidLoginFixture)
+		})
+
+		g.It("derives its key with the app's key digest", func() {
+			for _, opt := range []RailsCookieOption{WithRailsVersion("7.0"), WithKeyDigest(sha256.New)} {
+				v, err := SignedGlobalIDVerifier(sessionFixtureSecretKeyBase, opt)
+				g.Assert(err).Eql(nil)
+				s := SignedGlobalID{Verifier: v}
+				token, err := s.Sign("bcx", "Person", 5, "login", -1)
+				g.Assert(err).Eql(nil)
+				g.Assert(token).Eql(sgidSHA256KeyFixture)
+				gid, err := s.Parse(sgidSHA256KeyFixture, "login")
+				g.Assert(err).Eql(nil)
+				g.Assert(gid).Eql(person)
+			}
+			_, err := sgid.Parse(sgidSHA256KeyFixture, "login")
+			g.Assert(err).Eql(ErrInvalidSignature)
+		})
+
+		g.It("fails without a secret", func() {
+			_, err := SignedGlobalIDVerifier("")
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+
+		g.It("round trips with an expiry", func() {
+			token, err := sgid.Sign("bcx", "Person", int64(5), "reset", time.Hour)
+			g.Assert(err).Eql(nil)
+			gid, err := sgid.Parse(token, "reset")
+			g.Assert(err).Eql(nil)
+			g.Assert(gid).Eql(person)
+		})
+
+		g.It("rejects invalid GlobalIDs", func() {
+			_, err := sgid.Sign("", "Person", 5, "", 0)
+			g.Assert(errors.Is(err, ErrInvalidGlobalID)).IsTrue()
+			_, err = sgid.Sign("bcx", "", 5, "", 0)
+			g.Assert(errors.Is(err, ErrInvalidGlobalID)).IsTrue()
+
+			token, _ := sgid.Verifier.GenerateWithOptions("not a gid", MessageOptions{Purpose: DefaultGlobalIDPurpose})
+			_, err = sgid.Parse(token, "")
+			g.Assert(errors.Is(err, ErrInvalidGlobalID)).IsTrue()
+		})
+	})
+}
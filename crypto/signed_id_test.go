@@ -0,0 +1,118 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestSignedID(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Signed ids", func() {
+		railsSecret := "f7b5763636f4c1f3ff4bd444eacccca295d87b990cc104124017ad70550edcfd22b8e89465338254e0b608592a9aac29025440bfd9ce53579835ba06a86f85f9"
+		now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		newVerifier := func() *MessageVerifier {
+			v, err := SignedIDVerifier(railsSecret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.Now = func() time.Time { return now }
+			return v
+		}
+
+		// What ActiveRecord generates for `User.find(42).signed_id(purpose:
+		// :password_reset, expires_in: 15.minutes)` at 2021-01-01 00:00 UTC,
+		// computed following ActiveRecord::SignedId.
+		resetToken := "eyJfcmFpbHMiOnsibWVzc2FnZSI6Ik5EST0iLCJleHAiOiIyMDIxLTAxLTAxVDAwOjE1OjAwLjAwMFoiLCJwdXIiOiJ1c2VyL3Bhc3N3b3JkX3Jlc2V0In19--31cd798d7ce5502089cf4607f946e0ca8adb50d5d4372ab8222ad171d79de9d8"
+		// `User.find(42).signed_id`
+		plainToken := "eyJfcmFpbHMiOnsibWVzc2FnZSI6Ik5EST0iLCJleHAiOm51bGwsInB1ciI6InVzZXIifX0=--8f33988c579a7c6f63b9c172fc5a5db33651e15f4274104a8d2820f1d4a22f03"
+		// `Admin::User.find("9b1deb4d-...").signed_id(purpose: :invite)`
+		uuidToken := "eyJfcmFpbHMiOnsibWVzc2FnZSI6IklqbGlNV1JsWWpSa0xUTmlOMlF0TkdKaFpDMDVZbVJrTFRKaU1HUTNZak5rWTJJMlpDST0iLCJleHAiOm51bGwsInB1ciI6ImFkbWluL3VzZXIvaW52aXRlIn19--ff1af65148e4ce1b95ab15841fd34c970f8ed3cd7406a764a66a54b5a6181cda"
+
+		g.It("are generated like Rails", func() {
+			v := newVerifier()
+			token, err := SignedID(v, 42, "User", "password_reset", 15*time.Minute)
+			g.Assert(err).Eql(nil)
+			g.Assert(token).Eql(resetToken)
+			token, err = SignedID(v, 42, "User", "", 0)
+			g.Assert(err).Eql(nil)
+			g.Assert(token).Eql(plainToken)
+			token, err = SignedID(v, "9b1deb4d-3b7d-4bad-9bdd-2b0d7b3dcb6d", "Admin::User", "invite", 0)
+			g.Assert(err).Eql(nil)
+			g.Assert(token).Eql(uuidToken)
+		})
+
+		g.It("verify Rails tokens", func() {
+			v := newVerifier()
+			id, err := VerifySignedID(v, resetToken, "User", "password_reset")
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql("42")
+			id, err = VerifySignedID(v, plainToken, "User", "")
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql("42")
+			id, err = VerifySignedID(v, uuidToken, "Admin::User", "invite")
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql("9b1deb4d-3b7d-4bad-9bdd-2b0d7b3dcb6d")
+		})
+
+		g.It("keep the precision of large ids", func() {
+			v := newVerifier()
+			token, err := SignedID(v, uint64(9007199254740995), "User", "", 0)
+			g.Assert(err).Eql(nil)
+			id, err := VerifySignedID(v, token, "User", "")
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql("9007199254740995")
+		})
+
+		g.It("are scoped to the model and purpose", func() {
+			v := newVerifier()
+			_, err := VerifySignedID(v, resetToken, "User", "")
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+			_, err = VerifySignedID(v, resetToken, "Account", "password_reset")
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+			_, err = VerifySignedID(v, plainToken, "User", "password_reset")
+			g.Assert(errors.Is(err, ErrInvalidPurpose)).IsTrue()
+		})
+
+		g.It("expire", func() {
+			v := newVerifier()
+			now = now.Add(15 * time.Minute)
+			defer func() { now = now.Add(-15 * time.Minute) }()
+			_, err := VerifySignedID(v, resetToken, "User", "password_reset")
+			g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+		})
+
+		g.It("reject tampered tokens", func() {
+			_, err := VerifySignedID(newVerifier(), resetToken[:len(resetToken)-1]+"0", "User", "password_reset")
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+		})
+
+		g.It("derive their key with the app's key digest", func() {
+			// `User.find(42).signed_id` in an app whose
+			// key_generator_hash_digest_class is SHA256, computed the same
+			// way.
+			sha256Token := "eyJfcmFpbHMiOnsibWVzc2FnZSI6Ik5EST0iLCJleHAiOm51bGwsInB1ciI6InVzZXIifX0=--38a95e2da39dee330072b50ffc1241e07c2e62c175e0844700d0ad6d80412661"
+			for _, opt := range []RailsCookieOption{WithRailsVersion("7.0"), WithKeyDigest(sha256.New)} {
+				v, err := SignedIDVerifier(railsSecret, opt)
+				g.Assert(err).Eql(nil)
+				token, err := SignedID(v, 42, "User", "", 0)
+				g.Assert(err).Eql(nil)
+				g.Assert(token).Eql(sha256Token)
+				id, err := VerifySignedID(v, sha256Token, "User", "")
+				g.Assert(err).Eql(nil)
+				g.Assert(id).Eql("42")
+			}
+			_, err := VerifySignedID(newVerifier(), sha256Token, "User", "")
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+		})
+
+		g.It("fail without a secret", func() {
+			_, err := SignedIDVerifier("")
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package crypto
+
+import "crypto/sha256"
+
+// SignedStreamNameSalt is the salt the key of Turbo's signed stream names
+// is derived with, Turbo::StreamsChannel.signed_stream_verifier_key.
+const SignedStreamNameSalt = "turbo/signed_stream_verifier_key"
+
+// StreamNameVerifier returns the verifier of the signed stream names
+// turbo-rails' Turbo::StreamsChannel (the ActionCable channel of
+// turbo_stream_from) subscribes to: its key is derived from secretKeyBase
+// like DeriveRailsCookieKeys derives the cookies' with the same options,
+// names are serialized as JSON and signed with HMAC-SHA256.
+func StreamNameVerifier(secretKeyBase string, opts ...RailsCookieOption) (*MessageVerifier, error) {
+	kg, err := railsKeyGenerator(secretKeyBase, opts)
+	if err != nil {
+		return nil, err
+	}
+	return streamNameVerifier(kg), nil
+}
+
+// SignStreamName signs a stream name (ie: "Z2lkOi8vYXBwL1Jvb20vMQ:messages"
+// for turbo_stream_from(room, :messages)) like Turbo::StreamsChannel's
+// signed_stream_name, for the apps deriving their keys with SHA-256 like
+// Rails 7.0+ do. Use StreamNameVerifier for the other apps.
+func SignStreamName(secretKeyBase, name string) string {
+	signed, err := streamNameVerifier(sha256KeyGenerator(secretKeyBase)).Generate(name)
+	if err != nil {
+		// strings always serialize to JSON.
+		panic("crypto: can't sign stream name: " + err.Error())
+	}
+	return signed
+}
+
+// VerifyStreamName returns the stream name signed by SignStreamName or
+// Turbo, the way Turbo::StreamsChannel verifies the names clients subscribe
+// to. It fails with ErrInvalidSignature if the name was tampered with or
+// signed with another secret.
+func VerifyStreamName(secretKeyBase, signed string) (string, error) {
+	var name string
+	if err := streamNameVerifier(sha256KeyGenerator(secretKeyBase)).Verify(signed, &name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func streamNameVerifier(kg KeyGenerator) *MessageVerifier {
+	return &MessageVerifier{
+		Secret:     kg.Generate([]byte(SignedStreamNameSalt), 64),
+		Hasher:     sha256.New,
+		Serializer: JsonMsgSerializer{},
+	}
+}
+
+// sha256KeyGenerator returns the key generator of a Rails 7.0+ app.
+func sha256KeyGenerator(secretKeyBase string) KeyGenerator {
+	return KeyGenerator{Secret: secretKeyBase, Digest: sha256.New}
+}
@@ -0,0 +1,55 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestSignedStreamName(t *testing.T) {
+	g := Goblin(t)
+	secret := deviseFixtureSecretKeyBase
+
+	// the signed name of turbo_stream_from(room, :messages) for Room 1,
+	// computed following turbo-rails' algorithm with python, for an app
+	// deriving its keys with SHA-256 and one with SHA-1.
+	const (
+		streamName     = "Z2lkOi8vYXBwL1Jvb20vMQ:messages"
+		signedName     = "IloybGtPaTh2WVhCd0wxSnZiMjB2TVE6bWVzc2FnZXMi--5e2919fc45943f8669059c85c294fb3c6af7ed2ab0f810de79987c6d3b30c850"
+		signedNameSHA1 = "IloybGtPaTh2WVhCd0wxSnZiMjB2TVE6bWVzc2FnZXMi--8f09f8f03a130cf352e6165d7454aa8d693cd790cf8f1c881b7c2d7b668a1adf"
+	)
+
+	g.Describe("Signed stream names", func() {
+		g.It("are signed like Turbo does", func() {
+			g.Assert(SignStreamName(secret, streamName)).Eql(signedName)
+			name, err := VerifyStreamName(secret, signedName)
+			g.Assert(err).Eql(nil)
+			g.Assert(name).Eql(streamName)
+		})
+
+		g.It("are signed for the apps deriving their keys with SHA-1", func() {
+			v, err := StreamNameVerifier(secret)
+			g.Assert(err).Eql(nil)
+			signed, err := v.Generate(streamName)
+			g.Assert(err).Eql(nil)
+			g.Assert(signed).Eql(signedNameSHA1)
+
+			v, _ = StreamNameVerifier(secret, WithRailsVersion("7.1"))
+			var name string
+			g.Assert(v.Verify(signedName, &name)).Eql(nil)
+			g.Assert(name).Eql(streamName)
+		})
+
+		g.It("fail with another secret or when tampered", func() {
+			_, err := VerifyStreamName(railsVectorsSecret, signedName)
+			g.Assert(err).Eql(ErrInvalidSignature)
+			_, err = VerifyStreamName(secret, signedNameSHA1)
+			g.Assert(err).Eql(ErrInvalidSignature)
+			// Room 2's name with Room 1's signature.
+			tampered := strings.Replace(signedName, "MjB2TVE6", "MjB2TWc6", 1)
+			_, err = VerifyStreamName(secret, tampered)
+			g.Assert(err).Eql(ErrInvalidSignature)
+		})
+	})
+}
@@ -0,0 +1,142 @@
+package crypto
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The query parameters of the URLs signed by SignURL.
+const (
+	URLSignatureParam = "signature"
+	URLExpiresParam   = "expires"
+)
+
+// SignedURLOption configures how SignURL, VerifySignedURL and
+// RequireSignedURL canonicalize the URLs.
+type SignedURLOption func(*signedURLOptions)
+
+type signedURLOptions struct {
+	hostAgnostic bool
+}
+
+// WithHostAgnostic leaves the scheme and host out of the signature, so the
+// URLs stay valid whichever host serves them (ie: behind a proxy which
+// rewrites the Host). The same option must be passed to verify them.
+func WithHostAgnostic() SignedURLOption {
+	return func(o *signedURLOptions) { o.hostAgnostic = true }
+}
+
+// SignURL returns a copy of u with the expires (Unix time) and signature
+// query parameters added, the signature being the verifier's digest of the
+// canonical URL: its scheme and host, unless WithHostAgnostic is passed,
+// its path and its query sorted by parameter, without the signature. The
+// URL doesn't expire if ttl is 0.
+func SignURL(v *MessageVerifier, u *url.URL, ttl time.Duration, opts ...SignedURLOption) (*url.URL, error) {
+	signed := *u
+	query := u.Query()
+	query.Del(URLSignatureParam)
+	query.Del(URLExpiresParam)
+	if ttl != 0 {
+		query.Set(URLExpiresParam, strconv.FormatInt(v.now().Add(ttl).Unix(), 10))
+	}
+	signed.RawQuery = query.Encode()
+	signature, err := signedURLDigest(v, &signed, applySignedURLOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	query.Set(URLSignatureParam, signature)
+	signed.RawQuery = query.Encode()
+	return &signed, nil
+}
+
+// VerifySignedURL checks the signature of a URL signed by SignURL with the
+// same options, whatever the order of its query parameters. It fails with
+// ErrInvalidSignature if the URL isn't signed or was tampered with (ie: a
+// parameter was added) and ErrMessageExpired if it expired.
+// The URLs of the requests a server receives have no scheme nor host, see
+// RequireSignedURL.
+func VerifySignedURL(v *MessageVerifier, u *url.URL, opts ...SignedURLOption) error {
+	query := u.Query()
+	signature := query.Get(URLSignatureParam)
+	if signature == "" {
+		return ErrInvalidSignature
+	}
+	unsigned := *u
+	query.Del(URLSignatureParam)
+	unsigned.RawQuery = query.Encode()
+	expected, err := signedURLDigest(v, &unsigned, applySignedURLOptions(opts))
+	if err != nil {
+		return err
+	}
+	if !SecureCompare(signature, expected) {
+		return ErrInvalidSignature
+	}
+	if expires := query.Get(URLExpiresParam); expires != "" {
+		at, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil {
+			return &messageError{msg: "Invalid signed URL - bad expires " + strconv.Quote(expires), kind: ErrMalformedMessage, err: err}
+		}
+		if !v.now().Before(time.Unix(at, 0)) {
+			return ErrMessageExpired
+		}
+	}
+	return nil
+}
+
+// RequireSignedURL wraps next so it only serves the requests whose URL was
+// signed by SignURL with the same options, the others getting a 403
+// Forbidden. Unless WithHostAgnostic is passed, the URL is verified with
+// the request's Host and the https scheme if it came over TLS, http
+// otherwise.
+func RequireSignedURL(v *MessageVerifier, next http.Handler, opts ...SignedURLOption) http.Handler {
+	o := applySignedURLOptions(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		if !o.hostAgnostic {
+			u.Scheme, u.Host = "http", r.Host
+			if r.TLS != nil {
+				u.Scheme = "https"
+			}
+		}
+		if err := VerifySignedURL(v, &u, opts...); err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func applySignedURLOptions(opts []SignedURLOption) signedURLOptions {
+	var o signedURLOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// signedURLDigest returns the digest of the canonical form of u, whose
+// query must already be sorted. The fragment is left out since it isn't
+// sent to servers.
+func signedURLDigest(v *MessageVerifier, u *url.URL, o signedURLOptions) (string, error) {
+	v, err := v.withKeys(context.Background())
+	if err != nil {
+		return "", err
+	}
+	if err := v.checkSecret(); err != nil {
+		return "", err
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := path + "?" + u.RawQuery
+	if !o.hostAgnostic {
+		// schemes and hosts are case insensitive.
+		canonical = strings.ToLower(u.Scheme+"://"+u.Host) + canonical
+	}
+	return v.digest(canonical)
+}
@@ -0,0 +1,108 @@
+package crypto
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestSignedURL(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Signed URLs", func() {
+		now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		v := &MessageVerifier{Secret: []byte("signed url secret"), Now: func() time.Time { return now }}
+		sign := func(raw string, ttl time.Duration, opts ...SignedURLOption) *url.URL {
+			u, _ := url.Parse(raw)
+			signed, err := SignURL(v, u, ttl, opts...)
+			g.Assert(err).Eql(nil)
+			return signed
+		}
+
+		g.It("are verified", func() {
+			u := sign("https://example.com/downloads/report.pdf?user=42&format=pdf", time.Hour)
+			g.Assert(u.Query().Get("expires")).Eql("1717203600")
+			g.Assert(u.Query().Get("signature") != "").IsTrue()
+			g.Assert(VerifySignedURL(v, u)).Eql(nil)
+
+			never := sign("https://example.com/downloads", 0)
+			g.Assert(never.Query().Has("expires")).IsFalse()
+			g.Assert(VerifySignedURL(v, never)).Eql(nil)
+		})
+
+		g.It("are verified whatever the order of their parameters", func() {
+			u := sign("https://example.com/downloads?user=42&format=pdf", time.Hour)
+			q := u.Query()
+			reordered := *u
+			reordered.RawQuery = "signature=" + url.QueryEscape(q.Get("signature")) + "&user=42&expires=" + q.Get("expires") + "&format=pdf"
+			g.Assert(VerifySignedURL(v, &reordered)).Eql(nil)
+		})
+
+		g.It("reject tampered URLs", func() {
+			u := sign("https://example.com/downloads?user=42", time.Hour)
+			for _, tamper := range []func(u *url.URL){
+				func(u *url.URL) { u.RawQuery += "&admin=1" },
+				func(u *url.URL) { q := u.Query(); q.Set("user", "43"); u.RawQuery = q.Encode() },
+				func(u *url.URL) { q := u.Query(); q.Set("expires", "1817203600"); u.RawQuery = q.Encode() },
+				func(u *url.URL) { q := u.Query(); q.Del("expires"); u.RawQuery = q.Encode() },
+				func(u *url.URL) { q := u.Query(); q.Del("signature"); u.RawQuery = q.Encode() },
+				func(u *url.URL) { u.Path = "/uploads" },
+				func(u *url.URL) { u.Host = "evil.example.com" },
+			} {
+				tampered := *u
+				tamper(&tampered)
+				g.Assert(VerifySignedURL(v, &tampered)).Eql(ErrInvalidSignature)
+			}
+			other := &MessageVerifier{Secret: []byte("another secret"), Now: v.Now}
+			g.Assert(VerifySignedURL(other, u)).Eql(ErrInvalidSignature)
+		})
+
+		g.It("expire", func() {
+			u := sign("https://example.com/downloads", time.Hour)
+			later := &MessageVerifier{Secret: v.Secret, Now: func() time.Time { return now.Add(time.Hour) }}
+			g.Assert(VerifySignedURL(later, u)).Eql(ErrMessageExpired)
+		})
+
+		g.It("can be host agnostic", func() {
+			u := sign("https://example.com/downloads?user=42", time.Hour, WithHostAgnostic())
+			moved := *u
+			moved.Scheme, moved.Host = "http", "internal:8080"
+			g.Assert(VerifySignedURL(v, &moved, WithHostAgnostic())).Eql(nil)
+			g.Assert(VerifySignedURL(v, &moved)).Eql(ErrInvalidSignature)
+			path := &url.URL{Path: u.Path, RawQuery: u.RawQuery}
+			g.Assert(VerifySignedURL(v, path, WithHostAgnostic())).Eql(nil)
+		})
+
+		g.It("are required by RequireSignedURL", func() {
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("report")) })
+			serve := func(h http.Handler, r *http.Request) int {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, r)
+				return rec.Code
+			}
+
+			u := sign("https://example.com/downloads?user=42", time.Hour)
+			h := RequireSignedURL(v, ok)
+			r := httptest.NewRequest("GET", u.String(), nil)
+			r.TLS = &tls.ConnectionState{}
+			g.Assert(serve(h, r)).Eql(http.StatusOK)
+			r = httptest.NewRequest("GET", u.String(), nil)
+			r.TLS = nil
+			g.Assert(serve(h, r)).Eql(http.StatusForbidden)
+			g.Assert(serve(h, httptest.NewRequest("GET", "https://example.com/downloads?user=42", nil))).Eql(http.StatusForbidden)
+
+			agnostic := sign("https://example.com/downloads?user=42", time.Hour, WithHostAgnostic())
+			h = RequireSignedURL(v, ok, WithHostAgnostic())
+			g.Assert(serve(h, httptest.NewRequest("GET", "http://backend"+agnostic.RequestURI(), nil))).Eql(http.StatusOK)
+
+			q := agnostic.Query()
+			q.Set("user", "1")
+			g.Assert(serve(h, httptest.NewRequest("GET", "/downloads?"+q.Encode(), nil))).Eql(http.StatusForbidden)
+		})
+	})
+}
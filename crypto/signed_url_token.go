@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultURLTokenPurpose is the purpose of the SignedURLTokens which don't
+// set one.
+const DefaultURLTokenPurpose = "unsubscribe"
+
+// urlTokenTrailers are the characters mail clients and auto-linkers wrongly
+// include at the end of links, ie: the period ending a sentence.
+const urlTokenTrailers = ".,;:!?)]}>'\""
+
+// SignedURLToken signs payloads of type T into tokens meant to be embedded
+// in the links of emails, such as one-click unsubscribe links, which must
+// survive the way mail clients mangle URLs:
+//
+//	tokens := &SignedURLToken[int64]{Verifier: v, ExpiresIn: 30 * 24 * time.Hour}
+//	link, err := tokens.AppendToURL("https://example.com/unsubscribe", "token", user.ID)
+//	...
+//	userID, err := tokens.VerifyFromRequest(r, "token")
+//
+// The tokens are generated with the URL safe base64 alphabet, whatever the
+// Verifier's URLSafe, and bound to their purpose.
+type SignedURLToken[T any] struct {
+	Verifier *MessageVerifier
+	// Purpose is the purpose embedded in the tokens, DefaultURLTokenPurpose
+	// if not set.
+	Purpose string
+	// ExpiresIn is how long the tokens are valid for, forever if 0.
+	ExpiresIn time.Duration
+}
+
+// Generate returns the token of payload.
+func (t *SignedURLToken[T]) Generate(payload T) (string, error) {
+	v := *t.Verifier
+	v.URLSafe = true
+	return v.GenerateWithOptions(payload, MessageOptions{Purpose: t.purpose(), ExpiresIn: t.ExpiresIn})
+}
+
+// AppendToURL returns baseURL with the token of payload added as the param
+// query parameter, keeping its other parameters as they are.
+func (t *SignedURLToken[T]) AppendToURL(baseURL, param string, payload T) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	token, err := t.Generate(payload)
+	if err != nil {
+		return "", err
+	}
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += url.QueryEscape(param) + "=" + url.QueryEscape(token)
+	return u.String(), nil
+}
+
+// VerifyFromRequest returns the payload of the token of r's param query
+// parameter. Before it's verified the token is cleaned up from the usual
+// mangling: the '+' turned into spaces, line breaks and the punctuation
+// following the link in the email.
+// It fails with ErrMalformedMessage if the parameter is missing and the
+// errors of the Verifier otherwise, ie: ErrInvalidSignature,
+// ErrMessageExpired or ErrInvalidPurpose.
+func (t *SignedURLToken[T]) VerifyFromRequest(r *http.Request, param string) (payload T, err error) {
+	token := cleanURLToken(r.URL.Query().Get(param))
+	if token == "" {
+		return payload, &messageError{msg: "Invalid token - no " + param + " parameter", kind: ErrMalformedMessage}
+	}
+	err = t.Verifier.VerifyWithOptions(token, &payload, MessageOptions{Purpose: t.purpose()})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return payload, nil
+}
+
+func (t *SignedURLToken[T]) purpose() string {
+	if t.Purpose == "" {
+		return DefaultURLTokenPurpose
+	}
+	return t.Purpose
+}
+
+// cleanURLToken undoes the mangling of the tokens of the links in emails.
+func cleanURLToken(token string) string {
+	token = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ':
+			return '+'
+		case '\r', '\n', '\t':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(token))
+	return strings.TrimRight(token, urlTokenTrailers)
+}
@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestSignedURLToken(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("SignedURLToken", func() {
+		now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		v := &MessageVerifier{Secret: []byte("unsubscribe secret"), Serializer: JsonMsgSerializer{}, Now: func() time.Time { return now }}
+		tokens := &SignedURLToken[int64]{Verifier: v, ExpiresIn: 30 * 24 * time.Hour}
+
+		g.It("appends the token to the URL", func() {
+			link, err := tokens.AppendToURL("https://example.com/unsubscribe?list=news&b=1", "token", 42)
+			g.Assert(err).Eql(nil)
+			u, _ := url.Parse(link)
+			g.Assert(strings.HasPrefix(u.RawQuery, "list=news&b=1&token=")).IsTrue()
+			g.Assert(strings.ContainsAny(u.Query().Get("token"), "+/=")).IsFalse()
+
+			id, err := tokens.VerifyFromRequest(httptest.NewRequest("POST", link, nil), "token")
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql(int64(42))
+		})
+
+		g.It("tolerates mangled tokens", func() {
+			token, err := tokens.Generate(42)
+			g.Assert(err).Eql(nil)
+			// a token of the standard alphabet, whose '+' clients turn into
+			// spaces. The '~' of its purpose make sure it has some.
+			std := &SignedURLToken[string]{Verifier: v, Purpose: "~~~"}
+			payload := "hello"
+			stdToken, _ := v.GenerateWithOptions(payload, MessageOptions{Purpose: std.Purpose})
+			g.Assert(strings.Contains(stdToken, "+")).IsTrue()
+			spaced := "https://example.com/u?token=" + strings.ReplaceAll(stdToken, "+", "%20")
+			s, err := std.VerifyFromRequest(httptest.NewRequest("GET", spaced, nil), "token")
+			g.Assert(err).Eql(nil)
+			g.Assert(s).Eql(payload)
+			plussed := "https://example.com/u?token=" + stdToken
+			s, err = std.VerifyFromRequest(httptest.NewRequest("GET", plussed, nil), "token")
+			g.Assert(err).Eql(nil)
+			g.Assert(s).Eql(payload)
+
+			for _, mangled := range []string{
+				token + ".",
+				token + ").",
+				token + "%22%3E",
+				token[:20] + "%0D%0A" + token[20:],
+				"%20" + token + "%20",
+			} {
+				id, err := tokens.VerifyFromRequest(httptest.NewRequest("GET", "https://example.com/u?token="+mangled, nil), "token")
+				g.Assert(err).Eql(nil)
+				g.Assert(id).Eql(int64(42))
+			}
+		})
+
+		g.It("rejects tampered, expired and other tokens", func() {
+			token, _ := tokens.Generate(42)
+			check := func(token string, kind error) {
+				_, err := tokens.VerifyFromRequest(httptest.NewRequest("GET", "https://example.com/u?token="+url.QueryEscape(token), nil), "token")
+				g.Assert(errors.Is(err, kind)).IsTrue()
+			}
+			check(token[:len(token)-2]+"00", ErrInvalidSignature)
+			check("", ErrMalformedMessage)
+
+			other := &SignedURLToken[int64]{Verifier: v, Purpose: "confirm"}
+			otherToken, _ := other.Generate(42)
+			check(otherToken, ErrInvalidPurpose)
+
+			later := *v
+			later.Now = func() time.Time { return now.Add(31 * 24 * time.Hour) }
+			expired := &SignedURLToken[int64]{Verifier: &later}
+			_, err := expired.VerifyFromRequest(httptest.NewRequest("GET", "https://example.com/u?token="+token, nil), "token")
+			g.Assert(errors.Is(err, ErrMessageExpired)).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFakeVerifier(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("A FakeVerifier", func() {
+		var _ Signer = &FakeVerifier{}
+		var _ Verifier = &FakeVerifier{}
+
+		g.It("can do a round trip without a secret", func() {
+			f := &FakeVerifier{}
+			data := testStruct{Foo: "foo", Bar: 42}
+			msg, err := f.Generate(data)
+			g.Assert(err).Eql(nil)
+			var verified testStruct
+			err = f.Verify(msg, &verified)
+			g.Assert(err).Eql(nil)
+			g.Assert(verified).Eql(data)
+		})
+
+		g.It("rejects messages it didn't generate", func() {
+			f := &FakeVerifier{}
+			var verified testStruct
+			err := f.Verify("eyJGb28iOiJmb28iLCJCYXIiOjQyfQ==--b1bdb9d2b372f19dcca800e5989ee7502f1b72a5", &verified)
+			g.Assert(errors.Is(err, ErrInvalidSignature)).IsTrue()
+		})
+
+		g.It("generates a different message for each value", func() {
+			f := &FakeVerifier{Serializer: NullMsgSerializer{}}
+			msg1, _ := f.Generate("foo")
+			msg2, _ := f.Generate("bar")
+			g.Assert(msg1 != msg2).IsTrue()
+			var verified string
+			g.Assert(f.Verify(msg1, &verified)).Eql(nil)
+			g.Assert(verified).Eql("foo")
+		})
+	})
+}
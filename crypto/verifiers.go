@@ -0,0 +1,106 @@
+package crypto
+
+import "sync"
+
+// Verifiers derives a dedicated verifier (or encryptor) per name, the way
+// Rails.application.message_verifier(name) does, so the tokens of different
+// uses can't be mistaken for one another:
+//
+//	verifiers, err := NewVerifiers(secretKeyBase)
+//	...
+//	token, err := verifiers.For("remember_me").Generate(user.ID)
+//
+// The keys are derived with the name as salt, so with a KeyGenerator set
+// like the app's (see NewVerifiers) the verifiers interoperate with Rails'.
+// Verifiers are created on first use and cached, a Verifiers is safe for
+// concurrent use by multiple goroutines as long as its fields aren't
+// modified once it's in use.
+type Verifiers struct {
+	// KeyDeriver derives the keys of the verifiers and encryptors, it must
+	// be set.
+	KeyDeriver KeyDeriver
+	// Serializer serializes the messages of the verifiers and encryptors,
+	// a RubyMarshalSerializer if not set like the verifiers of Rails before
+	// 7.1.
+	Serializer MsgSerializer
+	// UseMessageSerializerForMetadata sets the verifiers' and encryptors'
+	// UseMessageSerializerForMetadata, like the 7.1 defaults do.
+	UseMessageSerializerForMetadata bool
+
+	mu         sync.Mutex
+	verifiers  map[string]*MessageVerifier
+	encryptors map[string]*MessageEncryptor
+}
+
+// NewVerifiers returns the Verifiers of a Rails app with the passed
+// secret_key_base, its keys being derived like DeriveRailsCookieKeys
+// derives the cookies' with the same options (ie: WithRailsVersion("7.0")
+// for SHA-256). From Rails 7.1 on their messages embed their metadata like
+// the 7.1 defaults, see MessageVerifier.UseMessageSerializerForMetadata.
+func NewVerifiers(secretKeyBase string, opts ...RailsCookieOption) (*Verifiers, error) {
+	kg, err := railsKeyGenerator(secretKeyBase, opts)
+	if err != nil {
+		return nil, err
+	}
+	o, _ := applyRailsCookieOptions(opts)
+	return &Verifiers{KeyDeriver: &kg, UseMessageSerializerForMetadata: o.atLeast(7, 1)}, nil
+}
+
+// VerifiersFrom returns Verifiers whose keys are derived by kd.
+func VerifiersFrom(kd KeyDeriver) (*Verifiers, error) {
+	if kd == nil {
+		return nil, configError("nil key deriver")
+	}
+	return &Verifiers{KeyDeriver: kd}, nil
+}
+
+// For returns the verifier of name, whose 64 bytes secret is derived with
+// name as salt like Rails.application.message_verifier(name)'s. It signs
+// with HMAC-SHA1 like Rails' verifiers.
+func (v *Verifiers) For(name string) *MessageVerifier {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if verifier, ok := v.verifiers[name]; ok {
+		return verifier
+	}
+	if v.verifiers == nil {
+		v.verifiers = make(map[string]*MessageVerifier)
+	}
+	verifier := &MessageVerifier{
+		Secret:                          v.KeyDeriver.Generate([]byte(name), 64),
+		Serializer:                      v.serializer(),
+		UseMessageSerializerForMetadata: v.UseMessageSerializerForMetadata,
+	}
+	v.verifiers[name] = verifier
+	return verifier
+}
+
+// ForEncryptor returns the encryptor of name, which encrypts with
+// aes-256-gcm and a 32 bytes key derived with name as salt, like the
+// encryptors of ActiveSupport::MessageEncryptors. Its messages are
+// different from the verifier of the same name's.
+func (v *Verifiers) ForEncryptor(name string) *MessageEncryptor {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if encryptor, ok := v.encryptors[name]; ok {
+		return encryptor
+	}
+	if v.encryptors == nil {
+		v.encryptors = make(map[string]*MessageEncryptor)
+	}
+	encryptor := &MessageEncryptor{
+		Key:                             v.KeyDeriver.Generate([]byte(name), 32),
+		Cipher:                          AES256GCM,
+		Serializer:                      v.serializer(),
+		UseMessageSerializerForMetadata: v.UseMessageSerializerForMetadata,
+	}
+	v.encryptors[name] = encryptor
+	return encryptor
+}
+
+func (v *Verifiers) serializer() MsgSerializer {
+	if v.Serializer == nil {
+		return RubyMarshalSerializer{}
+	}
+	return v.Serializer
+}
@@ -0,0 +1,77 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestVerifiers(t *testing.T) {
+	g := Goblin(t)
+	secret := deviseFixtureSecretKeyBase
+
+	g.Describe("Verifiers", func() {
+		g.It("derive the keys of Rails' named verifiers", func() {
+			// Rails.application.message_verifier(:remember_me).generate(42),
+			// computed following Rails' algorithm with python.
+			for _, tc := range []struct {
+				opts  []RailsCookieOption
+				token string
+			}{
+				{nil, "BAhpLw==--29c000b57f8daefaded16d760b4e5adfb24df5a8"},
+				{[]RailsCookieOption{WithRailsVersion("7.0")}, "BAhpLw==--e26b69898aa83785003c871259be2826d500aeeb"},
+			} {
+				verifiers, err := NewVerifiers(secret, tc.opts...)
+				g.Assert(err).Eql(nil)
+				var id int
+				g.Assert(verifiers.For("remember_me").Verify(tc.token, &id)).Eql(nil)
+				g.Assert(id).Eql(42)
+				token, err := verifiers.For("remember_me").Generate(42)
+				g.Assert(err).Eql(nil)
+				g.Assert(token).Eql(tc.token)
+			}
+		})
+
+		g.It("don't accept the tokens of other names", func() {
+			verifiers, _ := NewVerifiers(secret)
+			token, err := verifiers.For("remember_me").Generate(42)
+			g.Assert(err).Eql(nil)
+			var id int
+			g.Assert(verifiers.For("password_reset").Verify(token, &id)).Eql(ErrInvalidSignature)
+
+			msg, err := verifiers.ForEncryptor("remember_me").EncryptAndSign(42)
+			g.Assert(err).Eql(nil)
+			g.Assert(verifiers.ForEncryptor("remember_me").DecryptAndVerify(msg, &id)).Eql(nil)
+			g.Assert(id).Eql(42)
+			g.Assert(verifiers.ForEncryptor("password_reset").DecryptAndVerify(msg, &id)).Eql(ErrInvalidMessage)
+		})
+
+		g.It("cache a verifier per name", func() {
+			verifiers, _ := VerifiersFrom(&HKDFKeyGenerator{Secret: []byte(secret), Hash: sha256.New})
+			var wg sync.WaitGroup
+			got := make([]*MessageVerifier, 8)
+			for i := range got {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					got[i] = verifiers.For("remember_me")
+				}(i)
+			}
+			wg.Wait()
+			for _, v := range got {
+				g.Assert(v == got[0]).IsTrue()
+			}
+			g.Assert(verifiers.ForEncryptor("remember_me") == verifiers.ForEncryptor("remember_me")).IsTrue()
+		})
+
+		g.It("require a secret or key deriver", func() {
+			_, err := NewVerifiers("")
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+			_, err = VerifiersFrom(nil)
+			g.Assert(errors.Is(err, ErrInvalidConfig)).IsTrue()
+		})
+	})
+}
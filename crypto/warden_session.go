@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrNotLoggedIn is returned by ExtractWardenUser when no user of the scope
+// is signed in.
+var ErrNotLoggedIn = errors.New("Not logged in")
+
+// WardenUserKey returns the session key Warden stores the user of the scope
+// under, ie: "warden.user.user.key" for Devise's "user" scope.
+func WardenUserKey(scope string) string {
+	return "warden.user." + scope + ".key"
+}
+
+// ExtractWardenUser returns the id and authenticatable_salt of the user of
+// the scope Devise signed in, from the `[[id], salt]` it serializes into
+// the session, or the `["User", [id], salt]` of Devise before 3.2. The id
+// is read whether it was decoded from JSON or Marshal, the salt is empty
+// for the records without one.
+// It returns ErrNotLoggedIn if the session doesn't have the scope's key and
+// an error matching ErrMalformedMessage if its value isn't one of these, ie:
+// for ids which aren't integers.
+func ExtractWardenUser(session SessionHash, scope string) (id int64, salt string, err error) {
+	key := WardenUserKey(scope)
+	value, ok := session[key]
+	if !ok || value == nil {
+		return 0, "", ErrNotLoggedIn
+	}
+	values, _ := value.([]interface{})
+	if len(values) == 3 {
+		// drops the class name of the older layout.
+		if _, ok := values[0].(string); ok {
+			values = values[1:]
+		}
+	}
+	if len(values) != 2 {
+		return 0, "", invalidWardenUser(key, "not [[id], salt]")
+	}
+	ids, _ := values[0].([]interface{})
+	if len(ids) != 1 {
+		return 0, "", invalidWardenUser(key, "bad id")
+	}
+	if id, ok = sessionInt(ids[0]); !ok {
+		return 0, "", invalidWardenUser(key, "id isn't an integer")
+	}
+	switch s := values[1].(type) {
+	case string:
+		salt = s
+	case nil:
+	default:
+		return 0, "", invalidWardenUser(key, "bad salt")
+	}
+	return id, salt, nil
+}
+
+func invalidWardenUser(key, reason string) error {
+	return &messageError{msg: "Invalid session - " + strconv.Quote(key) + ": " + reason, kind: ErrMalformedMessage}
+}
@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestExtractWardenUser(t *testing.T) {
+	g := Goblin(t)
+
+	// the sessions of a Devise app after user 42 signed in, with the JSON
+	// and Marshal cookies serializers, and after admin 7 signed in.
+	const (
+		userJSON     = `{"session_id":"4f1c8a0e2b7d9e35","warden.user.user.key":[[42],"$2a$12$Kz9mQ3vX7pL2nR8sT4wY1u"]}`
+		userMarshal  = "\x04\b{\aI\"\x0fsession_id\x06:\x06ETI\"\x154f1c8a0e2b7d9e35\x06;\x00TI\"\x19warden.user.user.key\x06;\x00T[\a[\x06i/I\"\"$2a$12$Kz9mQ3vX7pL2nR8sT4wY1u\x06;\x00T"
+		adminJSON    = `{"warden.user.admin.key":[[7],"$2a$12$Qm9vYmFyYmF6cXV4cXV1abcde"]}`
+		olderSession = `{"warden.user.user.key":["User",[42],"$2a$12$Kz9mQ3vX7pL2nR8sT4wY1u"]}`
+	)
+	salt := "$2a$12$Kz9mQ3vX7pL2nR8sT4wY1u"
+
+	g.Describe("ExtractWardenUser", func() {
+		g.It("reads the user of JSON and Marshal sessions", func() {
+			var fromJSON, fromMarshal SessionHash
+			g.Assert(JsonMsgSerializer{}.Unserialize(userJSON, &fromJSON)).Eql(nil)
+			g.Assert(RubyMarshalSerializer{}.Unserialize(userMarshal, &fromMarshal)).Eql(nil)
+			// the float64 numbers of plain json.Unmarshal too.
+			var fromFloats map[string]interface{}
+			g.Assert(json.Unmarshal([]byte(userJSON), &fromFloats)).Eql(nil)
+
+			for _, session := range []SessionHash{fromJSON, fromMarshal, fromFloats} {
+				id, s, err := ExtractWardenUser(session, "user")
+				g.Assert(err).Eql(nil)
+				g.Assert(id).Eql(int64(42))
+				g.Assert(s).Eql(salt)
+			}
+		})
+
+		g.It("reads the user of custom scopes", func() {
+			var session SessionHash
+			g.Assert(JsonMsgSerializer{}.Unserialize(adminJSON, &session)).Eql(nil)
+			id, s, err := ExtractWardenUser(session, "admin")
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql(int64(7))
+			g.Assert(s).Eql("$2a$12$Qm9vYmFyYmF6cXV4cXV1abcde")
+
+			_, _, err = ExtractWardenUser(session, "user")
+			g.Assert(err).Eql(ErrNotLoggedIn)
+		})
+
+		g.It("reads the layout of Devise before 3.2", func() {
+			var session SessionHash
+			g.Assert(JsonMsgSerializer{}.Unserialize(olderSession, &session)).Eql(nil)
+			id, s, err := ExtractWardenUser(session, "user")
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql(int64(42))
+			g.Assert(s).Eql(salt)
+		})
+
+		g.It("accepts records without salt", func() {
+			id, s, err := ExtractWardenUser(SessionHash{"warden.user.user.key": []interface{}{[]interface{}{int64(3)}, nil}}, "user")
+			g.Assert(err).Eql(nil)
+			g.Assert(id).Eql(int64(3))
+			g.Assert(s).Eql("")
+		})
+
+		g.It("fails on other values", func() {
+			_, _, err := ExtractWardenUser(SessionHash{}, "user")
+			g.Assert(err).Eql(ErrNotLoggedIn)
+			for _, value := range []interface{}{
+				"42",
+				[]interface{}{42},
+				[]interface{}{[]interface{}{"4f1c8a0e-uuid"}, salt},
+				[]interface{}{[]interface{}{1.5}, salt},
+				[]interface{}{[]interface{}{int64(1), int64(2)}, salt},
+				[]interface{}{[]interface{}{int64(1)}, 12},
+			} {
+				_, _, err := ExtractWardenUser(SessionHash{"warden.user.user.key": value}, "user")
+				g.Assert(errors.Is(err, ErrMalformedMessage)).IsTrue()
+			}
+		})
+	})
+}
@@ -1,20 +1,154 @@
 package crypto
 
 import (
+	"bytes"
 	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
 )
 
+// XMLMsgSerializer serializes values with encoding/xml. Its options let it
+// read and write the XML of Rails' to_xml, for instance:
+//
+//	XMLMsgSerializer{Root: "user", Dasherize: true, NilAsZero: true}
+//
+// The type attributes Rails writes are ignored when unserializing and not
+// written when serializing.
 type XMLMsgSerializer struct {
+	// Root is the name of the root element, the name of the serialized type
+	// or its XMLName if empty. When set the data whose root element has
+	// another name fails to unserialize.
+	Root string
+	// Dasherize replaces the underscores of the element and attribute
+	// names with dashes when serializing, and the dashes with underscores
+	// when unserializing, so the tags of the structs keep Go's naming.
+	Dasherize bool
+	// NilAsZero leaves the values of the elements having a nil="true"
+	// attribute, which Rails writes for nil values, to their zero value
+	// rather than decoding their empty content.
+	NilAsZero bool
 }
 
 func (s XMLMsgSerializer) Serialize(v interface{}) (string, error) {
-	b, err := xml.Marshal(v)
-	if err != nil {
+	var buf bytes.Buffer
+	if err := s.SerializeTo(&buf, v); err != nil {
 		return "", err
 	}
-	return string(b), nil
+	return buf.String(), nil
 }
 
 func (s XMLMsgSerializer) Unserialize(data string, v interface{}) error {
-	return xml.Unmarshal([]byte(data), v)
+	return s.UnserializeFrom(strings.NewReader(data), v)
+}
+
+// SerializeTo writes the same data as Serialize to w.
+func (s XMLMsgSerializer) SerializeTo(w io.Writer, v interface{}) error {
+	if s.Root == "" && !s.Dasherize {
+		return xml.NewEncoder(w).Encode(v)
+	}
+	var buf bytes.Buffer
+	out := w
+	if s.Dasherize {
+		out = &buf
+	}
+	enc := xml.NewEncoder(out)
+	var err error
+	if s.Root != "" {
+		err = enc.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: s.Root}})
+	} else {
+		err = enc.Encode(v)
+	}
+	if err != nil || !s.Dasherize {
+		return err
+	}
+
+	// the names are rewritten once encoded since they come from the
+	// struct tags.
+	dec := xml.NewDecoder(&buf)
+	enc = xml.NewEncoder(w)
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			return enc.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		if err := enc.EncodeToken(renameXMLToken(tok, "_", "-")); err != nil {
+			return err
+		}
+	}
+}
+
+// UnserializeFrom decodes the XML read from r into v.
+func (s XMLMsgSerializer) UnserializeFrom(r io.Reader, v interface{}) error {
+	dec := xml.NewDecoder(r)
+	if s.Root == "" && !s.Dasherize && !s.NilAsZero {
+		return dec.Decode(v)
+	}
+	return xml.NewTokenDecoder(&railsXMLReader{s: s, dec: dec}).Decode(v)
+}
+
+// railsXMLReader applies the options of an XMLMsgSerializer to the tokens of
+// the data being unserialized.
+type railsXMLReader struct {
+	s     XMLMsgSerializer
+	dec   *xml.Decoder
+	depth int
+}
+
+func (r *railsXMLReader) Token() (xml.Token, error) {
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if r.s.Dasherize {
+			tok = renameXMLToken(tok, "-", "_")
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			if r.depth == 0 && r.s.Root != "" && el.Name.Local != r.s.Root {
+				return nil, errors.New("xml: root element is <" + el.Name.Local + ">, expected <" + r.s.Root + ">")
+			}
+			if r.s.NilAsZero && r.depth > 0 && isXMLNil(el) {
+				if err := r.dec.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			r.depth++
+		case xml.EndElement:
+			r.depth--
+		}
+		return tok, nil
+	}
+}
+
+// renameXMLToken replaces old with new in the names of the elements and
+// attributes of tok.
+func renameXMLToken(tok xml.Token, old, new string) xml.Token {
+	switch el := tok.(type) {
+	case xml.StartElement:
+		el = el.Copy()
+		el.Name.Local = strings.ReplaceAll(el.Name.Local, old, new)
+		for i := range el.Attr {
+			el.Attr[i].Name.Local = strings.ReplaceAll(el.Attr[i].Name.Local, old, new)
+		}
+		return el
+	case xml.EndElement:
+		el.Name.Local = strings.ReplaceAll(el.Name.Local, old, new)
+		return el
+	}
+	return tok
+}
+
+func isXMLNil(el xml.StartElement) bool {
+	for _, attr := range el.Attr {
+		if attr.Name.Space == "" && attr.Name.Local == "nil" && attr.Value == "true" {
+			return true
+		}
+	}
+	return false
 }
@@ -1,6 +1,9 @@
 package crypto
 
 import (
+	"bytes"
+	"time"
+
 	. "github.com/franela/goblin"
 	"testing"
 )
@@ -41,4 +44,99 @@ func TestXmlSerializerSerializer(t *testing.T) {
 		})
 	})
 
+	g.Describe("a streamed xml serializer", func() {
+		type Person struct {
+			Id   int    `xml:"id,attr"`
+			Name string `xml:"name"`
+		}
+		data := Person{Id: 13, Name: "John & <Jane>"}
+
+		g.It("round trips like Serialize", func() {
+			output, err := serializer.Serialize(data)
+			g.Assert(err).Eql(nil)
+			var buf bytes.Buffer
+			g.Assert(serializer.SerializeTo(&buf, data)).Eql(nil)
+			g.Assert(buf.String()).Eql(output)
+
+			var o Person
+			g.Assert(serializer.UnserializeFrom(&buf, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+		})
+
+		g.It("is used by encryptors", func() {
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM, Serializer: serializer}
+			msg, err := e.EncryptAndSign(data)
+			g.Assert(err).Eql(nil)
+			var o Person
+			g.Assert((&MessageEncryptor{Key: e.Key, Cipher: AES256GCM, Serializer: stringSerializer{serializer}}).DecryptAndVerify(msg, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+		})
+	})
+
+	g.Describe("Rails' to_xml", func() {
+		type User struct {
+			Id        int       `xml:"id"`
+			FirstName string    `xml:"first_name"`
+			CreatedAt time.Time `xml:"created_at"`
+			UpdatedAt time.Time `xml:"updated_at"`
+			Nickname  *string   `xml:"nickname"`
+			Age       int       `xml:"age"`
+		}
+		// written following the output of ActiveRecord's to_xml for a model
+		// with nil nickname, age and updated_at attributes.
+		fixture := `<?xml version="1.0" encoding="UTF-8"?>
+<user>
+  <id type="integer">42</id>
+  <first-name>John &amp; Jane</first-name>
+  <created-at type="dateTime">2024-01-02T03:04:05Z</created-at>
+  <updated-at type="dateTime" nil="true"/>
+  <nickname nil="true"/>
+  <age type="integer" nil="true"/>
+</user>
+`
+		expected := User{Id: 42, FirstName: "John & Jane", CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+		rails := XMLMsgSerializer{Root: "user", Dasherize: true, NilAsZero: true}
+
+		g.It("can be unserialized", func() {
+			var o User
+			g.Assert(rails.Unserialize(fixture, &o)).Eql(nil)
+			g.Assert(o.CreatedAt.Equal(expected.CreatedAt)).IsTrue()
+			o.CreatedAt = expected.CreatedAt
+			g.Assert(o).Eql(expected)
+		})
+
+		g.It("round trips", func() {
+			nickname := "jd"
+			data := expected
+			data.Nickname = &nickname
+			output, err := rails.Serialize(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(output).Eql(`<user><id>42</id><first-name>John &amp; Jane</first-name><created-at>2024-01-02T03:04:05Z</created-at><updated-at>0001-01-01T00:00:00Z</updated-at><nickname>jd</nickname><age>0</age></user>`)
+			var buf bytes.Buffer
+			g.Assert(rails.SerializeTo(&buf, data)).Eql(nil)
+			g.Assert(buf.String()).Eql(output)
+
+			var o User
+			g.Assert(rails.Unserialize(output, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+		})
+
+		g.It("needs NilAsZero for nil times", func() {
+			var o User
+			err := XMLMsgSerializer{Root: "user", Dasherize: true}.Unserialize(fixture, &o)
+			g.Assert(err == nil).IsFalse()
+		})
+
+		g.It("checks the root element", func() {
+			var o User
+			err := XMLMsgSerializer{Root: "admin", Dasherize: true, NilAsZero: true}.Unserialize(fixture, &o)
+			g.Assert(err.Error()).Eql("xml: root element is <user>, expected <admin>")
+		})
+
+		g.It("names the root element", func() {
+			output, err := XMLMsgSerializer{Root: "count"}.Serialize(42)
+			g.Assert(err).Eql(nil)
+			g.Assert(output).Eql("<count>42</count>")
+		})
+	})
 }
@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"errors"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultMaxAliasExpansion is the default YamlMsgSerializer.MaxAliasExpansion.
+const defaultMaxAliasExpansion = 10000
+
+// maxYamlNodes caps the sizes computed by nodeSize.
+const maxYamlNodes = 1 << 30
+
+// YamlMsgSerializer serializes values with YAML, struct fields being named
+// by their yaml tag. Only the standard YAML types are decoded, tags can't
+// make it instantiate arbitrary types like Ruby's YAML.load used to.
+type YamlMsgSerializer struct {
+	// MaxAliasExpansion is the maximum number of nodes the aliases of a
+	// document can expand to, documents going over it are rejected so
+	// nested aliases ("billion laughs") can't exhaust the memory.
+	// It defaults to 10000, set it to -1 to reject any alias.
+	MaxAliasExpansion int
+}
+
+func (s YamlMsgSerializer) Serialize(v interface{}) (string, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (s YamlMsgSerializer) Unserialize(data string, v interface{}) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+		return err
+	}
+	max := s.MaxAliasExpansion
+	switch {
+	case max == 0:
+		max = defaultMaxAliasExpansion
+	case max < 0:
+		max = 0
+	}
+	if n := aliasExpansion(&doc, map[*yaml.Node]int{}); n > max {
+		return errors.New("yaml: aliases expand to " + strconv.Itoa(n) + " nodes, over the limit of " + strconv.Itoa(max))
+	}
+	return doc.Decode(v)
+}
+
+// aliasExpansion returns the number of nodes the aliases under node expand
+// to, memoizing the size of the aliased nodes in sizes so it doesn't
+// expand them itself.
+func aliasExpansion(node *yaml.Node, sizes map[*yaml.Node]int) int {
+	n := 0
+	for _, child := range node.Content {
+		if child.Kind == yaml.AliasNode && child.Alias != nil {
+			n += nodeSize(child.Alias, sizes)
+		} else {
+			n += aliasExpansion(child, sizes)
+		}
+		if n > maxYamlNodes {
+			return maxYamlNodes
+		}
+	}
+	return n
+}
+
+// nodeSize returns the number of nodes of node once its aliases are
+// expanded, saturating so it can't overflow.
+func nodeSize(node *yaml.Node, sizes map[*yaml.Node]int) int {
+	if n, ok := sizes[node]; ok {
+		return n
+	}
+	// an alias to one of its parents can't be resolved.
+	sizes[node] = maxYamlNodes
+	n := 1
+	for _, child := range node.Content {
+		if child.Kind == yaml.AliasNode && child.Alias != nil {
+			child = child.Alias
+		}
+		if n += nodeSize(child, sizes); n > maxYamlNodes {
+			n = maxYamlNodes
+		}
+	}
+	sizes[node] = n
+	return n
+}
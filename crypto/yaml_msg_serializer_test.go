@@ -0,0 +1,80 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestYamlMsgSerializer(t *testing.T) {
+	g := Goblin(t)
+	serializer := YamlMsgSerializer{}
+
+	type Config struct {
+		Name    string                       `yaml:"name"`
+		Motd    string                       `yaml:"motd"`
+		Servers map[string]map[string]string `yaml:"servers"`
+	}
+	data := Config{
+		Name:    "prod",
+		Motd:    "Welcome!\nMaintenance on Sunday.\n",
+		Servers: map[string]map[string]string{"web": {"host": "10.0.0.1"}, "db": {"host": "10.0.0.2"}},
+	}
+
+	g.Describe("a yaml serialized struct", func() {
+		g.It("can be deserialized", func() {
+			output, err := serializer.Serialize(data)
+			g.Assert(err).Eql(nil)
+			g.Assert(strings.Contains(output, "motd: |\n")).IsTrue()
+			var o Config
+			g.Assert(serializer.Unserialize(output, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+		})
+
+		g.It("can be signed", func() {
+			v := &MessageVerifier{Secret: GenerateRandomKey(64), Serializer: serializer}
+			msg, err := v.Generate(data)
+			g.Assert(err).Eql(nil)
+			var o Config
+			g.Assert(v.Verify(msg, &o)).Eql(nil)
+			g.Assert(o).Eql(data)
+		})
+	})
+
+	g.Describe("aliases", func() {
+		const bomb = `a: &a ["x", "x", "x", "x", "x", "x", "x", "x", "x", "x"]
+b: &b [*a, *a, *a, *a, *a, *a, *a, *a, *a, *a]
+c: &c [*b, *b, *b, *b, *b, *b, *b, *b, *b, *b]
+d: &d [*c, *c, *c, *c, *c, *c, *c, *c, *c, *c]
+e: &e [*d, *d, *d, *d, *d, *d, *d, *d, *d, *d]
+f: &f [*e, *e, *e, *e, *e, *e, *e, *e, *e, *e]
+g: &g [*f, *f, *f, *f, *f, *f, *f, *f, *f, *f]
+h: &h [*g, *g, *g, *g, *g, *g, *g, *g, *g, *g]
+i: &i [*h, *h, *h, *h, *h, *h, *h, *h, *h, *h]
+`
+
+		g.It("are expanded under the limit", func() {
+			var o map[string][]string
+			g.Assert(serializer.Unserialize("a: &a [x, y]\nb: *a\n", &o)).Eql(nil)
+			g.Assert(o["b"]).Eql([]string{"x", "y"})
+		})
+
+		g.It("are rejected over the limit", func() {
+			var o map[string]interface{}
+			err := serializer.Unserialize(bomb, &o)
+			g.Assert(err != nil).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "over the limit of 10000")).IsTrue()
+
+			strict := YamlMsgSerializer{MaxAliasExpansion: -1}
+			err = strict.Unserialize("a: &a [x, y]\nb: *a\n", &o)
+			g.Assert(err != nil).IsTrue()
+			g.Assert(strings.Contains(err.Error(), "over the limit of 0")).IsTrue()
+
+			loose := YamlMsgSerializer{MaxAliasExpansion: 2000}
+			var s map[string][]interface{}
+			g.Assert(loose.Unserialize(strings.Join(strings.Split(bomb, "\n")[:3], "\n"), &s)).Eql(nil)
+			g.Assert(len(s["c"])).Eql(10)
+		})
+	})
+}
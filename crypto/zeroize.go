@@ -0,0 +1,41 @@
+package crypto
+
+// The payload buffers are wiped on a best effort basis only: Go strings
+// can't be overwritten (and the serializers work with strings) and the
+// garbage collector may have copied a buffer before it is wiped. Wiping
+// shortens the time secrets stay in memory, it doesn't guarantee that
+// they're gone. The keys aren't wiped as they are often held elsewhere (ie:
+// by a CachingKeyGenerator or by other verifiers and encryptors).
+
+// wipe overwrites b with zeroes.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Close unsets the Secret of the verifier and of its Rotations, the verifier
+// failing with ErrNoSecret from then on. The secrets aren't overwritten since
+// they might be shared, they are left to the garbage collector once nothing
+// else holds them. It is meant for apps rotating their secrets at runtime
+// and must not be called while the verifier is in use.
+func (crypt *MessageVerifier) Close() error {
+	for _, r := range crypt.Rotations {
+		r.Close()
+	}
+	crypt.Secret = nil
+	return nil
+}
+
+// Close unsets the Key and SignKey of the encryptor and of its Rotations,
+// the encryptor failing with ErrNoSecret from then on. Like with
+// MessageVerifier.Close, the keys aren't overwritten and the Verifier, which
+// might be shared, is left alone. It is meant for apps rotating their keys
+// at runtime and must not be called while the encryptor is in use.
+func (crypt *MessageEncryptor) Close() error {
+	for _, r := range crypt.Rotations {
+		r.Close()
+	}
+	crypt.Key, crypt.SignKey = nil, nil
+	return nil
+}
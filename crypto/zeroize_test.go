@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestZeroize(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("MessageVerifier.Close", func() {
+		g.It("unsets the secrets", func() {
+			secret, oldSecret := GenerateRandomKey(32), GenerateRandomKey(32)
+			v := &MessageVerifier{Secret: secret, Serializer: JsonMsgSerializer{}}
+			v.Rotate(oldSecret, nil, nil)
+			msg, _ := v.Generate("foo")
+
+			g.Assert(v.Close()).Eql(nil)
+			g.Assert(v.Secret == nil).IsTrue()
+			g.Assert(v.Rotations[0].Secret == nil).IsTrue()
+
+			_, err := v.Generate("foo")
+			g.Assert(err).Eql(ErrNoSecret)
+			var output string
+			g.Assert(v.Verify(msg, &output)).Eql(ErrNoSecret)
+		})
+
+		g.It("leaves the keys of a CachingKeyGenerator alone", func() {
+			kg := &CachingKeyGenerator{KeyGenerator: KeyGenerator{Secret: cachingKeyGeneratorSecret}}
+			closed, err := VerifierFrom(kg, "remember_me")
+			g.Assert(err).Eql(nil)
+			v, err := VerifierFrom(kg, "remember_me")
+			g.Assert(err).Eql(nil)
+			msg, _ := v.Generate("foo")
+			g.Assert(closed.Close()).Eql(nil)
+
+			var output string
+			g.Assert(v.Verify(msg, &output)).Eql(nil)
+			g.Assert(output).Eql("foo")
+			zero, _ := NewMessageVerifier(make([]byte, 64))
+			forged, err := zero.Generate("foo")
+			g.Assert(err).Eql(nil)
+			g.Assert(v.Verify(forged, &output)).Eql(ErrInvalidSignature)
+			g.Assert(bytes.Equal(kg.Generate([]byte("remember_me"), 64), kg.KeyGenerator.Generate([]byte("remember_me"), 64))).IsTrue()
+		})
+	})
+
+	g.Describe("MessageEncryptor.Close", func() {
+		g.It("unsets the keys without overwriting them", func() {
+			for _, cipher := range []string{AESCBC, AES256GCM} {
+				key, signKey, oldKey := GenerateRandomKey(32), GenerateRandomKey(64), GenerateRandomKey(32)
+				e := &MessageEncryptor{Key: key, SignKey: signKey, Cipher: cipher}
+				e.Rotate(oldKey, nil, "")
+				msg, _ := e.EncryptAndSign("foo")
+
+				saved := append(append(append([]byte(nil), key...), signKey...), oldKey...)
+				g.Assert(e.Close()).Eql(nil)
+				g.Assert(e.Key == nil && e.SignKey == nil && e.Rotations[0].Key == nil).IsTrue()
+				g.Assert(append(append(append([]byte(nil), key...), signKey...), oldKey...)).Eql(saved)
+
+				_, err := e.EncryptAndSign("foo")
+				g.Assert(errors.Is(err, ErrNoSecret)).IsTrue()
+				g.Assert(errors.Is(err, ErrInvalidKeyLength)).IsTrue()
+				var output string
+				g.Assert(errors.Is(e.DecryptAndVerify(msg, &output), ErrNoSecret)).IsTrue()
+			}
+		})
+	})
+
+	g.Describe("Payload buffers", func() {
+		g.It("are wiped once used", func() {
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+			msg, _ := e.EncryptAndSign("secret data")
+			_, plaintext, err := e.openMessage(msg)
+			g.Assert(err).Eql(nil)
+			g.Assert(string(plaintext)).Eql(`"secret data"`)
+			var output string
+			g.Assert(e.unserialize(plaintext, &output, MessageOptions{}, e.now())).Eql(nil)
+			g.Assert(output).Eql("secret data")
+			g.Assert(bytes.Equal(plaintext, make([]byte, len(plaintext)))).IsTrue()
+		})
+
+		g.It("aren't the caller's", func() {
+			e := &MessageEncryptor{Key: GenerateRandomKey(32), Cipher: AES256GCM}
+			data := []byte("secret data")
+			msg, _ := e.EncryptAndSignBytes(data)
+			g.Assert(string(data)).Eql("secret data")
+			out, _ := e.DecryptAndVerifyBytes(msg)
+			g.Assert(string(out)).Eql("secret data")
+
+			v := &MessageVerifier{Secret: GenerateRandomKey(32)}
+			signed, _ := v.GenerateRaw(data)
+			g.Assert(string(data)).Eql("secret data")
+			raw, _ := v.VerifyRaw(signed)
+			g.Assert(string(raw)).Eql("secret data")
+		})
+	})
+}
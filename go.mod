@@ -1,9 +1,19 @@
 module github.com/mattetti/goRailsYourself
 
-go 1.15
+go 1.18
 
 require (
 	github.com/fiam/gounidecode v0.0.0-20150629112515-8deddbd03fec
 	github.com/franela/goblin v0.0.0-20201006155558-6240afcb2eb7
+	github.com/fxamacker/cbor/v2 v2.7.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	google.golang.org/protobuf v1.34.2
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.0.0-20191026070338-33540a1f6037 // indirect
 )
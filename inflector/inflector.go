@@ -12,6 +12,11 @@ import (
 
 var parameterizeReplacementRegexp = regexp.MustCompile("(?i)[^a-z0-9-_]+")
 
+var (
+	underscoreAcronymRegexp = regexp.MustCompile(`([A-Z\d]+)([A-Z][a-z])`)
+	underscoreWordRegexp    = regexp.MustCompile(`([a-z\d])([A-Z])`)
+)
+
 // Replaces special characters in a string so that it may be used as part of
 // a 'pretty' URL.
 //
@@ -38,3 +43,16 @@ func Parameterize(str, sep string) string {
 func Transliterate(str string) string {
 	return unidecode.Unidecode(str)
 }
+
+// Makes an underscored, lowercase form from the expression in the string.
+// Changes '::' to '/' to convert namespaces to paths.
+// Underscore("ActiveModel::Errors") => "active_model/errors"
+// Custom acronym inflections aren't supported.
+// Rails documentation: http://api.rubyonrails.org/classes/ActiveSupport/Inflector.html#method-i-underscore
+func Underscore(str string) string {
+	str = strings.Replace(str, "::", "/", -1)
+	str = underscoreAcronymRegexp.ReplaceAllString(str, "${1}_${2}")
+	str = underscoreWordRegexp.ReplaceAllString(str, "${1}_${2}")
+	str = strings.Replace(str, "-", "_", -1)
+	return strings.ToLower(str)
+}
@@ -64,3 +64,39 @@ func ExampleTransliterate() {
 	// Output: AEroskobing
 	// Ma soeur va a l'ecole
 }
+
+func ExampleUnderscore() {
+	fmt.Println(Underscore("ActiveModel"))
+	fmt.Println(Underscore("ActiveModel::Errors"))
+	// Output: active_model
+	// active_model/errors
+}
+
+func TestUnderscore(t *testing.T) {
+	g := Goblin(t)
+	g.Describe("Underscore", func() {
+
+		g.It("Should underscore camel cased words", func() {
+			expectations := map[string]string{
+				"Product":               "product",
+				"SpecialGuest":          "special_guest",
+				"ApplicationController": "application_controller",
+				"Area51Controller":      "area51_controller",
+				"HTMLTidy":              "html_tidy",
+				"HTMLTidyGenerator":     "html_tidy_generator",
+				"FreeBSD":               "free_bsd",
+				"HTML":                  "html",
+				"already_underscored":   "already_underscored",
+				"dash-ed":               "dash_ed",
+			}
+			for input, expected := range expectations {
+				g.Assert(Underscore(input)).Equal(expected)
+			}
+		})
+
+		g.It("Should convert namespaces to paths", func() {
+			g.Assert(Underscore("Admin::User")).Equal("admin/user")
+			g.Assert(Underscore("Admin::HTMLPage")).Equal("admin/html_page")
+		})
+	})
+}